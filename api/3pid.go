@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/chanbakjsd/gotrix/api/httputil"
@@ -19,8 +20,13 @@ type ThirdpartyIdentifier struct {
 // ThreePID returns all thirdparty identifiers associated with
 // the current token.
 func (c *Client) ThreePID() ([]ThirdpartyIdentifier, error) {
+	return c.ThreePIDContext(c.Context())
+}
+
+// ThreePIDContext is the same as ThreePID but takes a context.
+func (c *Client) ThreePIDContext(ctx context.Context) ([]ThirdpartyIdentifier, error) {
 	resp := []ThirdpartyIdentifier{}
-	err := c.Request(
+	err := c.RequestContext(ctx,
 		"GET", c.Endpoints.Account3PID(), &resp,
 		httputil.WithToken(),
 	)
@@ -33,6 +39,12 @@ func (c *Client) ThreePID() ([]ThirdpartyIdentifier, error) {
 // ThreePIDAdd adds the third party identifier associated with
 // the client secret and session ID to the current token.
 func (c *Client) ThreePIDAdd(clientSecret string, sessionID string) (*UserInteractiveAuthAPI, error) {
+	return c.ThreePIDAddContext(c.Context(), clientSecret, sessionID)
+}
+
+// ThreePIDAddContext is the same as ThreePIDAdd but takes a context.
+func (c *Client) ThreePIDAddContext(ctx context.Context, clientSecret string,
+	sessionID string) (*UserInteractiveAuthAPI, error) {
 	var req struct {
 		Auth         interface{} `json:"auth,omitempty"`
 		ClientSecret string      `json:"client_secret"`
@@ -41,22 +53,20 @@ func (c *Client) ThreePIDAdd(clientSecret string, sessionID string) (*UserIntera
 	req.ClientSecret = clientSecret
 	req.SessionID = sessionID
 
-	uiaa := &UserInteractiveAuthAPI{}
-	uiaa.Request = func(auth, to interface{}) error {
+	uiaa := c.newUserInteractiveAuthAPI(func(ctx context.Context, auth, to interface{}) error {
 		req.Auth = auth
-		return c.Request(
+		return c.RequestContext(ctx,
 			"POST", c.Endpoints.Account3PIDAdd(), to,
 			httputil.WithToken(), httputil.WithJSONBody(req),
 		)
-	}
-	uiaa.RequestThreePID = func(authType string, auth, to interface{}) error {
-		return c.Request(
+	}, func(ctx context.Context, authType string, auth, to interface{}) error {
+		return c.RequestContext(ctx,
 			"POST", c.Endpoints.Account3PIDRequestToken(authType), to,
 			httputil.WithJSONBody(auth),
 		)
-	}
+	})
 
-	err := uiaa.Auth(nil)
+	err := uiaa.AuthContext(ctx, nil)
 
 	return uiaa, err
 }
@@ -72,7 +82,12 @@ type ThreePIDBindArg struct {
 // ThreePIDBind binds a third party identifier connected to an identity server
 // to the current token.
 func (c *Client) ThreePIDBind(req ThreePIDBindArg) error {
-	err := c.Request(
+	return c.ThreePIDBindContext(c.Context(), req)
+}
+
+// ThreePIDBindContext is the same as ThreePIDBind but takes a context.
+func (c *Client) ThreePIDBindContext(ctx context.Context, req ThreePIDBindArg) error {
+	err := c.RequestContext(ctx,
 		"POST", c.Endpoints.Account3PIDBind(), nil,
 		httputil.WithToken(), httputil.WithJSONBody(req),
 	)
@@ -91,11 +106,17 @@ type ThreePIDDeleteArg struct {
 
 // ThreePIDDelete deletes a third party identifier from the current token.
 func (c *Client) ThreePIDDelete(req ThreePIDDeleteArg) (matrix.IDServerUnbindResult, error) {
+	return c.ThreePIDDeleteContext(c.Context(), req)
+}
+
+// ThreePIDDeleteContext is the same as ThreePIDDelete but takes a context.
+func (c *Client) ThreePIDDeleteContext(ctx context.Context,
+	req ThreePIDDeleteArg) (matrix.IDServerUnbindResult, error) {
 	var resp struct {
 		IDServerUnbindResult matrix.IDServerUnbindResult `json:"id_server_unbind_result"`
 	}
 
-	err := c.Request(
+	err := c.RequestContext(ctx,
 		"POST", "_matrix/client/v3/account/3pid/delete", &resp,
 		httputil.WithToken(), httputil.WithJSONBody(req),
 	)
@@ -115,11 +136,17 @@ type ThreePIDUnbindArg struct {
 
 // ThreePIDUnbind unbinds a third party identifier from the current token.
 func (c *Client) ThreePIDUnbind(req ThreePIDUnbindArg) (matrix.IDServerUnbindResult, error) {
+	return c.ThreePIDUnbindContext(c.Context(), req)
+}
+
+// ThreePIDUnbindContext is the same as ThreePIDUnbind but takes a context.
+func (c *Client) ThreePIDUnbindContext(ctx context.Context,
+	req ThreePIDUnbindArg) (matrix.IDServerUnbindResult, error) {
 	var resp struct {
 		IDServerUnbindResult matrix.IDServerUnbindResult `json:"id_server_unbind_result"`
 	}
 
-	err := c.Request(
+	err := c.RequestContext(ctx,
 		"POST", "_matrix/client/v3/account/3pid/unbind", &resp,
 		httputil.WithToken(), httputil.WithJSONBody(req),
 	)
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 
@@ -11,6 +12,12 @@ import (
 // PasswordChange sends a request to the homeserver to change the password.
 // All devices except the current one will be logged out if logoutDevices is set to true.
 func (c *Client) PasswordChange(newPassword string, logoutDevices bool) (*UserInteractiveAuthAPI, error) {
+	return c.PasswordChangeContext(c.Context(), newPassword, logoutDevices)
+}
+
+// PasswordChangeContext is the same as PasswordChange but takes a context.
+func (c *Client) PasswordChangeContext(ctx context.Context, newPassword string,
+	logoutDevices bool) (*UserInteractiveAuthAPI, error) {
 	var req struct {
 		Auth          interface{} `json:"auth,omitempty"`
 		NewPassword   string      `json:"new_password"`
@@ -20,10 +27,9 @@ func (c *Client) PasswordChange(newPassword string, logoutDevices bool) (*UserIn
 	req.NewPassword = newPassword
 	req.LogoutDevices = logoutDevices
 
-	uiaa := &UserInteractiveAuthAPI{}
-	uiaa.Request = func(auth, to interface{}) error {
+	uiaa := c.newUserInteractiveAuthAPI(func(ctx context.Context, auth, to interface{}) error {
 		req.Auth = auth
-		err := c.Request(
+		err := c.RequestContext(ctx,
 			"POST", c.Endpoints.AccountPassword(), to,
 			httputil.WithToken(), httputil.WithJSONBody(req),
 		)
@@ -31,14 +37,13 @@ func (c *Client) PasswordChange(newPassword string, logoutDevices bool) (*UserIn
 			return fmt.Errorf("error changing password: %w", err)
 		}
 		return nil
-	}
-	uiaa.RequestThreePID = func(authType string, auth, to interface{}) error {
-		return c.Request(
+	}, func(ctx context.Context, authType string, auth, to interface{}) error {
+		return c.RequestContext(ctx,
 			"POST", c.Endpoints.AccountPasswordRequestToken(authType), nil,
 			httputil.WithJSONBody(auth),
 		)
-	}
-	err := uiaa.Auth(nil)
+	})
+	err := uiaa.AuthContext(ctx, nil)
 	return uiaa, err
 }
 
@@ -51,18 +56,21 @@ func (c *Client) PasswordChange(newPassword string, logoutDevices bool) (*UserIn
 // It returns an InteractiveDeactivate object which should be used to interactively
 // fulfill authentication requirements of the server.
 func (c *Client) DeactivateAccount(idServer string) (InteractiveDeactivate, error) {
+	return c.DeactivateAccountContext(c.Context(), idServer)
+}
+
+// DeactivateAccountContext is the same as DeactivateAccount but takes a context.
+func (c *Client) DeactivateAccountContext(ctx context.Context, idServer string) (InteractiveDeactivate, error) {
 	var req struct {
 		Auth     interface{} `json:"auth,omitempty"`
 		IDServer string      `json:"id_server"`
 	}
 
 	req.IDServer = idServer
-	uiaa := InteractiveDeactivate{
-		UserInteractiveAuthAPI: &UserInteractiveAuthAPI{},
-	}
-	uiaa.Request = func(auth, to interface{}) error {
+	var uiaa InteractiveDeactivate
+	uiaa.UserInteractiveAuthAPI = c.newUserInteractiveAuthAPI(func(ctx context.Context, auth, to interface{}) error {
 		req.Auth = auth
-		err := c.Request(
+		err := c.RequestContext(ctx,
 			"POST", c.Endpoints.AccountDeactivate(), to,
 			httputil.WithToken(), httputil.WithJSONBody(req),
 		)
@@ -70,8 +78,8 @@ func (c *Client) DeactivateAccount(idServer string) (InteractiveDeactivate, erro
 			return fmt.Errorf("error deactivating account: %w", err)
 		}
 		return nil
-	}
-	err := uiaa.Auth(nil)
+	}, nil)
+	err := uiaa.AuthContext(ctx, nil)
 	return uiaa, err
 }
 
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/chanbakjsd/gotrix/api/httputil"
@@ -9,13 +10,18 @@ import (
 
 // GetLoginMethods return the login methods supported by the homeserver.
 func (c *Client) GetLoginMethods() ([]matrix.LoginMethod, error) {
+	return c.GetLoginMethodsContext(c.Context())
+}
+
+// GetLoginMethodsContext is the same as GetLoginMethods but takes a context.
+func (c *Client) GetLoginMethodsContext(ctx context.Context) ([]matrix.LoginMethod, error) {
 	var response struct {
 		Flows []struct {
 			Type matrix.LoginMethod `json:"type"`
 		} `json:"flows"`
 	}
 
-	err := c.Request("GET", c.Endpoints.Login(), &response)
+	err := c.RequestContext(ctx, "GET", c.Endpoints.Login(), &response)
 	if err != nil {
 		return nil, fmt.Errorf("error getting login methods: %w", err)
 	}
@@ -45,6 +51,11 @@ type LoginArg struct {
 
 // Login logs the client into the homeserver with the provided arguments.
 func (c *Client) Login(arg LoginArg) error {
+	return c.LoginContext(c.Context(), arg)
+}
+
+// LoginContext is the same as Login but takes a context.
+func (c *Client) LoginContext(ctx context.Context, arg LoginArg) error {
 	var resp struct {
 		UserID      matrix.UserID         `json:"user_id"`
 		AccessToken string                `json:"access_token"`
@@ -52,7 +63,7 @@ func (c *Client) Login(arg LoginArg) error {
 		WellKnown   DiscoveryInfoResponse `json:"well_known"`
 	}
 
-	err := c.Request("POST", c.Endpoints.Login(), &resp, httputil.WithJSONBody(arg))
+	err := c.RequestContext(ctx, "POST", c.Endpoints.Login(), &resp, httputil.WithJSONBody(arg))
 	if err != nil {
 		return fmt.Errorf("error logging in: %w", err)
 	}
@@ -67,7 +78,12 @@ func (c *Client) Login(arg LoginArg) error {
 // Logout clears the AccessToken field in the client and attempts to invalidate the
 // token on the server-side.
 func (c *Client) Logout() error {
-	err := c.Request("POST", c.Endpoints.Logout(), nil, httputil.WithToken())
+	return c.LogoutContext(c.Context())
+}
+
+// LogoutContext is the same as Logout but takes a context.
+func (c *Client) LogoutContext(ctx context.Context) error {
+	err := c.RequestContext(ctx, "POST", c.Endpoints.Logout(), nil, httputil.WithToken())
 	c.AccessToken = ""
 	if err != nil {
 		return fmt.Errorf("error logging out: %w", err)
@@ -78,7 +94,12 @@ func (c *Client) Logout() error {
 // LogoutAll clears the AccessToken field in the client and attempts to invalidate all
 // tokens on the server-side.
 func (c *Client) LogoutAll() error {
-	err := c.Request("POST", c.Endpoints.LogoutAll(), nil, httputil.WithToken())
+	return c.LogoutAllContext(c.Context())
+}
+
+// LogoutAllContext is the same as LogoutAll but takes a context.
+func (c *Client) LogoutAllContext(ctx context.Context) error {
+	err := c.RequestContext(ctx, "POST", c.Endpoints.LogoutAll(), nil, httputil.WithToken())
 	c.AccessToken = ""
 	if err != nil {
 		return fmt.Errorf("error logging out all tokens: %w", err)
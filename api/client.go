@@ -35,12 +35,17 @@ func (c Client) WithUserID(userID matrix.UserID) *Client {
 // Whoami queries the homeserver to check if the token is still valid.
 // The user ID is returned if it's successful.
 func (c *Client) Whoami() (matrix.UserID, matrix.DeviceID, error) {
+	return c.WhoamiContext(c.Context())
+}
+
+// WhoamiContext is the same as Whoami but takes a context.
+func (c *Client) WhoamiContext(ctx context.Context) (matrix.UserID, matrix.DeviceID, error) {
 	var resp struct {
 		UserID   matrix.UserID   `json:"user_id"`
 		DeviceID matrix.DeviceID `json:"device_id"`
 	}
 
-	err := c.Request(
+	err := c.RequestContext(ctx,
 		"GET", c.Endpoints.AccountWhoami(), &resp,
 		httputil.WithToken(), httputil.WithQuery(map[string]string{
 			"user_id": string(c.UserID),
@@ -54,11 +59,16 @@ func (c *Client) Whoami() (matrix.UserID, matrix.DeviceID, error) {
 
 // ServerCapabilities retrieves the homeserver's capabilities.
 func (c *Client) ServerCapabilities() (*matrix.Capabilities, error) {
+	return c.ServerCapabilitiesContext(c.Context())
+}
+
+// ServerCapabilitiesContext is the same as ServerCapabilities but takes a context.
+func (c *Client) ServerCapabilitiesContext(ctx context.Context) (*matrix.Capabilities, error) {
 	var resp struct {
 		Capabilities *matrix.Capabilities `json:"capabilities"`
 	}
 
-	err := c.Request(
+	err := c.RequestContext(ctx,
 		"GET", c.Endpoints.Capabilities(), &resp,
 		httputil.WithToken(),
 	)
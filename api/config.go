@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 
@@ -12,7 +13,12 @@ import (
 // ClientConfig retrieves the client config previously stored with ClientConfigSet.
 // 'v' is a pointer that is directly passed into json.Unmarshal to unmarshal the content of the config.
 func (c *Client) ClientConfig(configType string, v interface{}) error {
-	err := c.Request(
+	return c.ClientConfigContext(c.Context(), configType, v)
+}
+
+// ClientConfigContext is the same as ClientConfig but takes a context.
+func (c *Client) ClientConfigContext(ctx context.Context, configType string, v interface{}) error {
+	err := c.RequestContext(ctx,
 		"GET", c.Endpoints.AccountDataGlobal(c.UserID, configType), v,
 		httputil.WithToken(),
 	)
@@ -29,7 +35,12 @@ func (c *Client) ClientConfig(configType string, v interface{}) error {
 // The provided config will be provided as an event with type 'configType' and content 'config' in AccountData
 // of SyncResponse.
 func (c *Client) ClientConfigSet(configType string, config interface{}) error {
-	err := c.Request(
+	return c.ClientConfigSetContext(c.Context(), configType, config)
+}
+
+// ClientConfigSetContext is the same as ClientConfigSet but takes a context.
+func (c *Client) ClientConfigSetContext(ctx context.Context, configType string, config interface{}) error {
+	err := c.RequestContext(ctx,
 		"PUT", c.Endpoints.AccountDataGlobal(c.UserID, configType), nil,
 		httputil.WithToken(), httputil.WithJSONBody(config),
 	)
@@ -42,7 +53,13 @@ func (c *Client) ClientConfigSet(configType string, config interface{}) error {
 // ClientConfigRoom retrieves the client config previously stored with ClientConfigRoomSet.
 // 'v' is a pointer that is directly passed into json.Unmarshal to unmarshal the content of the config.
 func (c *Client) ClientConfigRoom(roomID matrix.RoomID, configType string, v interface{}) error {
-	err := c.Request(
+	return c.ClientConfigRoomContext(c.Context(), roomID, configType, v)
+}
+
+// ClientConfigRoomContext is the same as ClientConfigRoom but takes a context.
+func (c *Client) ClientConfigRoomContext(ctx context.Context, roomID matrix.RoomID, configType string,
+	v interface{}) error {
+	err := c.RequestContext(ctx,
 		"GET", c.Endpoints.AccountDataRoom(c.UserID, roomID, configType), v,
 		httputil.WithToken(),
 	)
@@ -61,7 +78,13 @@ func (c *Client) ClientConfigRoom(roomID matrix.RoomID, configType string, v int
 // of rooms in SyncResponse.
 func (c *Client) ClientConfigRoomSet(roomID matrix.RoomID, configType string,
 	config interface{}) error {
-	err := c.Request(
+	return c.ClientConfigRoomSetContext(c.Context(), roomID, configType, config)
+}
+
+// ClientConfigRoomSetContext is the same as ClientConfigRoomSet but takes a context.
+func (c *Client) ClientConfigRoomSetContext(ctx context.Context, roomID matrix.RoomID, configType string,
+	config interface{}) error {
+	err := c.RequestContext(ctx,
 		"PUT", c.Endpoints.AccountDataRoom(c.UserID, roomID, configType), nil,
 		httputil.WithToken(), httputil.WithJSONBody(config),
 	)
@@ -77,8 +100,13 @@ type ignoredUsers struct {
 
 // IgnoredUsers returns the list of users configured to be ignored.
 func (c *Client) IgnoredUsers() ([]matrix.UserID, error) {
+	return c.IgnoredUsersContext(c.Context())
+}
+
+// IgnoredUsersContext is the same as IgnoredUsers but takes a context.
+func (c *Client) IgnoredUsersContext(ctx context.Context) ([]matrix.UserID, error) {
 	var resp ignoredUsers
-	err := c.ClientConfig("m.ignored_user_list", &resp)
+	err := c.ClientConfigContext(ctx, "m.ignored_user_list", &resp)
 	if err != nil {
 		return nil, fmt.Errorf("error getting ignored users: %w", err)
 	}
@@ -92,6 +120,11 @@ func (c *Client) IgnoredUsers() ([]matrix.UserID, error) {
 
 // IgnoredUsersSet sets the list of users configured to be ignored.
 func (c *Client) IgnoredUsersSet(newList []matrix.UserID) error {
+	return c.IgnoredUsersSetContext(c.Context(), newList)
+}
+
+// IgnoredUsersSetContext is the same as IgnoredUsersSet but takes a context.
+func (c *Client) IgnoredUsersSetContext(ctx context.Context, newList []matrix.UserID) error {
 	req := ignoredUsers{
 		IgnoredUsers: make(map[matrix.UserID]struct{}),
 	}
@@ -99,7 +132,7 @@ func (c *Client) IgnoredUsersSet(newList []matrix.UserID) error {
 		req.IgnoredUsers[v] = struct{}{}
 	}
 
-	err := c.ClientConfigSet("m.ignored_user_list", req)
+	err := c.ClientConfigSetContext(ctx, "m.ignored_user_list", req)
 	if err != nil {
 		return fmt.Errorf("error setting ignored users: %w", err)
 	}
@@ -108,8 +141,13 @@ func (c *Client) IgnoredUsersSet(newList []matrix.UserID) error {
 
 // DMRooms fetches the list of DM rooms as saved in 'm.direct'.
 func (c *Client) DMRooms() (*event.DirectEvent, error) {
+	return c.DMRoomsContext(c.Context())
+}
+
+// DMRoomsContext is the same as DMRooms but takes a context.
+func (c *Client) DMRoomsContext(ctx context.Context) (*event.DirectEvent, error) {
 	var resp event.RawEvent
-	err := c.ClientConfig("m.direct", &resp)
+	err := c.ClientConfigContext(ctx, "m.direct", &resp)
 	if err != nil {
 		return nil, fmt.Errorf("error fetching DM room list: %w", err)
 	}
@@ -128,11 +166,16 @@ func (c *Client) DMRooms() (*event.DirectEvent, error) {
 
 // DMRoomsSet updates the DM rooms saved in 'm.direct'.
 func (c *Client) DMRoomsSet(newRooms *event.DirectEvent) error {
+	return c.DMRoomsSetContext(c.Context(), newRooms)
+}
+
+// DMRoomsSetContext is the same as DMRoomsSet but takes a context.
+func (c *Client) DMRoomsSetContext(ctx context.Context, newRooms *event.DirectEvent) error {
 	raw, err := json.Marshal(newRooms)
 	if err != nil {
 		return fmt.Errorf("error encoding DM rooms: %w", err)
 	}
-	err = c.ClientConfigSet("m.direct", raw)
+	err = c.ClientConfigSetContext(ctx, "m.direct", raw)
 	if err != nil {
 		return fmt.Errorf("error setting DM rooms: %w", err)
 	}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/chanbakjsd/gotrix/api/httputil"
+	"github.com/chanbakjsd/gotrix/matrix"
+)
+
+// Device is a device belonging to the current user.
+type Device struct {
+	DeviceID    matrix.DeviceID `json:"device_id"`
+	DisplayName string          `json:"display_name,omitempty"`
+	// LastSeenIP and LastSeenTimestamp are omitted if the device has never been seen.
+	LastSeenIP        string           `json:"last_seen_ip,omitempty"`
+	LastSeenTimestamp matrix.Timestamp `json:"last_seen_ts,omitempty"`
+}
+
+// Devices returns all devices of the current user.
+func (c *Client) Devices() ([]Device, error) {
+	return c.DevicesContext(c.Context())
+}
+
+// DevicesContext is the same as Devices but takes a context.
+func (c *Client) DevicesContext(ctx context.Context) ([]Device, error) {
+	var resp struct {
+		Devices []Device `json:"devices"`
+	}
+	err := c.RequestContext(ctx,
+		"GET", c.Endpoints.Devices(), &resp,
+		httputil.WithToken(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching devices: %w", err)
+	}
+	return resp.Devices, nil
+}
+
+// Device returns the information of a single device of the current user.
+func (c *Client) Device(id matrix.DeviceID) (*Device, error) {
+	return c.DeviceContext(c.Context(), id)
+}
+
+// DeviceContext is the same as Device but takes a context.
+func (c *Client) DeviceContext(ctx context.Context, id matrix.DeviceID) (*Device, error) {
+	resp := &Device{}
+	err := c.RequestContext(ctx,
+		"GET", c.Endpoints.Device(id), resp,
+		httputil.WithToken(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching device: %w", err)
+	}
+	return resp, nil
+}
+
+// DeviceRename updates the display name of a device of the current user.
+func (c *Client) DeviceRename(id matrix.DeviceID, name string) error {
+	return c.DeviceRenameContext(c.Context(), id, name)
+}
+
+// DeviceRenameContext is the same as DeviceRename but takes a context.
+func (c *Client) DeviceRenameContext(ctx context.Context, id matrix.DeviceID, name string) error {
+	req := map[string]string{
+		"display_name": name,
+	}
+	err := c.RequestContext(ctx,
+		"PUT", c.Endpoints.Device(id), nil,
+		httputil.WithToken(), httputil.WithJSONBody(req),
+	)
+	if err != nil {
+		return fmt.Errorf("error renaming device: %w", err)
+	}
+	return nil
+}
+
+// DeviceDelete deletes a device of the current user and invalidates its access token.
+//
+// The homeserver requires interactive auth before deleting devices. The returned
+// UserInteractiveAuthAPI should be used to fulfill the requirements.
+func (c *Client) DeviceDelete(id matrix.DeviceID) (*UserInteractiveAuthAPI, error) {
+	return c.DeviceDeleteContext(c.Context(), id)
+}
+
+// DeviceDeleteContext is the same as DeviceDelete but takes a context.
+func (c *Client) DeviceDeleteContext(ctx context.Context, id matrix.DeviceID) (*UserInteractiveAuthAPI, error) {
+	var req struct {
+		Auth interface{} `json:"auth,omitempty"`
+	}
+
+	uiaa := c.newUserInteractiveAuthAPI(func(ctx context.Context, auth, to interface{}) error {
+		req.Auth = auth
+		err := c.RequestContext(ctx,
+			"DELETE", c.Endpoints.Device(id), to,
+			httputil.WithToken(), httputil.WithJSONBody(req),
+		)
+		if err != nil {
+			return fmt.Errorf("error deleting device: %w", err)
+		}
+		return nil
+	}, nil)
+	err := uiaa.AuthContext(ctx, nil)
+	return uiaa, err
+}
+
+// DevicesDelete deletes multiple devices of the current user at once and invalidates their
+// access tokens.
+//
+// The homeserver requires interactive auth before deleting devices. The returned
+// UserInteractiveAuthAPI should be used to fulfill the requirements.
+func (c *Client) DevicesDelete(ids []matrix.DeviceID) (*UserInteractiveAuthAPI, error) {
+	return c.DevicesDeleteContext(c.Context(), ids)
+}
+
+// DevicesDeleteContext is the same as DevicesDelete but takes a context.
+func (c *Client) DevicesDeleteContext(ctx context.Context, ids []matrix.DeviceID) (*UserInteractiveAuthAPI, error) {
+	var req struct {
+		Auth    interface{}       `json:"auth,omitempty"`
+		Devices []matrix.DeviceID `json:"devices"`
+	}
+
+	req.Devices = ids
+	if req.Devices == nil {
+		req.Devices = []matrix.DeviceID{}
+	}
+
+	uiaa := c.newUserInteractiveAuthAPI(func(ctx context.Context, auth, to interface{}) error {
+		req.Auth = auth
+		err := c.RequestContext(ctx,
+			"POST", c.Endpoints.DevicesDelete(), to,
+			httputil.WithToken(), httputil.WithJSONBody(req),
+		)
+		if err != nil {
+			return fmt.Errorf("error deleting devices: %w", err)
+		}
+		return nil
+	}, nil)
+	err := uiaa.AuthContext(ctx, nil)
+	return uiaa, err
+}
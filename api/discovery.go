@@ -1,10 +1,12 @@
 package api
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/chanbakjsd/gotrix/matrix"
 )
@@ -35,9 +37,14 @@ type DiscoveryInfoResponse struct {
 //
 // It implements https://spec.matrix.org/v1.2/client-server-api/#well-known-uri.
 func (c *Client) DiscoveryInfo() (*DiscoveryInfoResponse, error) {
+	return c.DiscoveryInfoContext(c.Context())
+}
+
+// DiscoveryInfoContext is the same as DiscoveryInfo but takes a context.
+func (c *Client) DiscoveryInfoContext(ctx context.Context) (*DiscoveryInfoResponse, error) {
 	// Check well-known URI.
 	var result DiscoveryInfoResponse
-	err := c.Request("GET", ".well-known/matrix/client", &result)
+	err := c.RequestContext(ctx, "GET", ".well-known/matrix/client", &result)
 	if err != nil {
 		switch matrix.StatusCode(err) {
 		case -1:
@@ -68,8 +75,13 @@ type SupportedVersionsResponse struct {
 //
 // The homeserver is inferred from (*Client).HomeServer and should be set before calling this function.
 func (c *Client) SupportedVersions() (SupportedVersionsResponse, error) {
+	return c.SupportedVersionsContext(c.Context())
+}
+
+// SupportedVersionsContext is the same as SupportedVersions but takes a context.
+func (c *Client) SupportedVersionsContext(ctx context.Context) (SupportedVersionsResponse, error) {
 	var result SupportedVersionsResponse
-	err := c.Request("GET", EndpointSupportedVersions, &result)
+	err := c.RequestContext(ctx, "GET", EndpointSupportedVersions, &result)
 	if err != nil {
 		return SupportedVersionsResponse{}, fmt.Errorf("error fetching homeserver supported versions: %w", err)
 	}
@@ -81,7 +93,12 @@ func (c *Client) SupportedVersions() (SupportedVersionsResponse, error) {
 // *Client is returned if the server doesn't have any supported versions or if the server returns
 // invalid versions.
 func (c Client) WithLatestVersion() (*Client, error) {
-	versions, err := c.SupportedVersions()
+	return c.WithLatestVersionContext(c.Context())
+}
+
+// WithLatestVersionContext is the same as WithLatestVersion but takes a context.
+func (c Client) WithLatestVersionContext(ctx context.Context) (*Client, error) {
+	versions, err := c.SupportedVersionsContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -105,9 +122,38 @@ func (c Client) WithLatestVersion() (*Client, error) {
 	}
 
 	c.Endpoints.Version = endpointVer
+	c.Endpoints.AuthenticatedMedia = supportsAuthenticatedMedia(versions)
 	return &c, nil
 }
 
+// supportsAuthenticatedMedia returns true if the homeserver advertises support for the authenticated
+// media endpoints, which were added in v1.11.
+func supportsAuthenticatedMedia(versions SupportedVersionsResponse) bool {
+	if versions.UnstableFeatures["org.matrix.msc3916.stable"] {
+		return true
+	}
+
+	for _, v := range versions.Versions {
+		if !strings.HasPrefix(v, "v") {
+			continue
+		}
+		split := strings.SplitN(v[1:], ".", 2)
+		if len(split) != 2 {
+			continue
+		}
+		major, err1 := strconv.Atoi(split[0])
+		minor, err2 := strconv.Atoi(split[1])
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		if major > 1 || (major == 1 && minor >= 11) {
+			return true
+		}
+	}
+
+	return false
+}
+
 // versionLess returns true if ver1 < ver2 in Matrix endpoint versioning format.
 func versionLess(ver1, ver2 string) bool {
 	if len(ver1) == 0 || len(ver2) == 0 {
@@ -13,6 +13,15 @@ var SupportedVersions = map[string]string{
 	"r0.6.1": "r0",
 	"v1.1":   "v3",
 	"v1.2":   "v3",
+	"v1.3":   "v3",
+	"v1.4":   "v3",
+	"v1.5":   "v3",
+	"v1.6":   "v3",
+	"v1.7":   "v3",
+	"v1.8":   "v3",
+	"v1.9":   "v3",
+	"v1.10":  "v3",
+	"v1.11":  "v3",
 }
 
 const EndpointSupportedVersions = "_matrix/client/versions"
@@ -21,6 +30,9 @@ const EndpointSupportedVersions = "_matrix/client/versions"
 type Endpoints struct {
 	// Version is the Matrix version for these endpoints.
 	Version string
+	// AuthenticatedMedia makes the media endpoints use the authenticated media API (_matrix/client/v1/media)
+	// instead of the legacy one. Uploading media always uses the legacy endpoint.
+	AuthenticatedMedia bool
 }
 
 func (e Endpoints) Base() string { return "_matrix/client/" + e.Version }
@@ -129,19 +141,32 @@ func (e Endpoints) ProfileDisplayName(userID matrix.UserID) string {
 	return e.Profile(userID) + "/displayname"
 }
 
-func (e Endpoints) Media() string           { return "_matrix/media/" + e.Version }
+func (e Endpoints) Media() string {
+	if e.AuthenticatedMedia {
+		return "_matrix/client/v1/media"
+	}
+	return e.LegacyMedia()
+}
+func (e Endpoints) LegacyMedia() string     { return "_matrix/media/" + e.Version }
 func (e Endpoints) MediaConfig() string     { return e.Media() + "/config" }
 func (e Endpoints) MediaPreviewURL() string { return e.Media() + "/preview_url" }
-func (e Endpoints) MediaUpload() string     { return e.Media() + "/upload" }
+func (e Endpoints) MediaUpload() string     { return e.LegacyMedia() + "/upload" }
+func (e Endpoints) MediaCreate() string     { return "_matrix/media/v1/create" }
+func (e Endpoints) MediaUploadTo(serverName string, mediaID string) string {
+	return e.MediaUpload() + "/" + url.PathEscape(serverName) + "/" + url.PathEscape(mediaID)
+}
 func (e Endpoints) MediaDownload(serverName string, mediaID string, fileName string) string {
-	return e.Media() + "/download/" + url.PathEscape(serverName) + "/" + url.PathEscape(mediaID) + "/" +
-		url.PathEscape(fileName)
+	route := e.Media() + "/download/" + url.PathEscape(serverName) + "/" + url.PathEscape(mediaID)
+	if fileName == "" {
+		return route
+	}
+	return route + "/" + url.PathEscape(fileName)
 }
 func (e Endpoints) MediaThumbnail(serverName string, mediaID string) string {
 	return e.Media() + "/thumbnail/" + url.PathEscape(serverName) + "/" + url.PathEscape(mediaID)
 }
 
-func (e Endpoints) VOIPTURNServers() string { return e.Media() + "/voip/turnServer" }
+func (e Endpoints) VOIPTURNServers() string { return e.Base() + "/voip/turnServer" }
 
 func (e Endpoints) PresenceStatus(userID matrix.UserID) string {
 	return e.Base() + "/presence/" + url.PathEscape(string(userID)) + "/status"
@@ -166,6 +191,44 @@ func (e Endpoints) Tag(userID matrix.UserID, roomID matrix.RoomID, name matrix.T
 	return e.Tags(userID, roomID) + "/" + url.PathEscape(string(name))
 }
 
+func (e Endpoints) PushRules() string { return e.Base() + "/pushrules/" }
+func (e Endpoints) PushRule(kind matrix.PushRuleKind, ruleID matrix.PushRuleID) string {
+	return e.PushRules() + "global/" + url.PathEscape(string(kind)) + "/" + url.PathEscape(string(ruleID))
+}
+func (e Endpoints) PushRuleEnabled(kind matrix.PushRuleKind, ruleID matrix.PushRuleID) string {
+	return e.PushRule(kind, ruleID) + "/enabled"
+}
+func (e Endpoints) PushRuleActions(kind matrix.PushRuleKind, ruleID matrix.PushRuleID) string {
+	return e.PushRule(kind, ruleID) + "/actions"
+}
+
 func (e Endpoints) SSOLogin(redirectURL string) string {
 	return e.Base() + "/login/sso/redirect?redirectUrl=" + url.QueryEscape(redirectURL)
 }
+
+func (e Endpoints) Devices() string       { return e.Base() + "/devices" }
+func (e Endpoints) DevicesDelete() string { return e.Base() + "/delete_devices" }
+func (e Endpoints) Device(id matrix.DeviceID) string {
+	return e.Devices() + "/" + url.PathEscape(string(id))
+}
+
+func (e Endpoints) KeysUpload() string  { return e.Base() + "/keys/upload" }
+func (e Endpoints) KeysQuery() string   { return e.Base() + "/keys/query" }
+func (e Endpoints) KeysClaim() string   { return e.Base() + "/keys/claim" }
+func (e Endpoints) KeysChanges() string { return e.Base() + "/keys/changes" }
+func (e Endpoints) KeysDeviceSigningUpload() string {
+	return e.Base() + "/keys/device_signing/upload"
+}
+func (e Endpoints) KeysSignaturesUpload() string { return e.Base() + "/keys/signatures/upload" }
+
+func (e Endpoints) RoomKeysVersion() string { return e.Base() + "/room_keys/version" }
+func (e Endpoints) RoomKeysVersionID(version string) string {
+	return e.RoomKeysVersion() + "/" + url.PathEscape(version)
+}
+func (e Endpoints) RoomKeys() string { return e.Base() + "/room_keys/keys" }
+func (e Endpoints) RoomKeysRoom(roomID matrix.RoomID) string {
+	return e.RoomKeys() + "/" + url.PathEscape(string(roomID))
+}
+func (e Endpoints) RoomKeysSession(roomID matrix.RoomID, sessionID string) string {
+	return e.RoomKeysRoom(roomID) + "/" + url.PathEscape(sessionID)
+}
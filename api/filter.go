@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/chanbakjsd/gotrix/api/httputil"
@@ -10,10 +11,15 @@ import (
 // FilterAdd uploads the provided filter to the homeserver and returns its
 // assigned ID.
 func (c *Client) FilterAdd(filterToUpload event.GlobalFilter) (string, error) {
+	return c.FilterAddContext(c.Context(), filterToUpload)
+}
+
+// FilterAddContext is the same as FilterAdd but takes a context.
+func (c *Client) FilterAddContext(ctx context.Context, filterToUpload event.GlobalFilter) (string, error) {
 	var resp struct {
 		FilterID string `json:"filter_id"`
 	}
-	err := c.Request(
+	err := c.RequestContext(ctx,
 		"POST", c.Endpoints.Filter(c.UserID), &resp,
 		httputil.WithToken(), httputil.WithJSONBody(filterToUpload),
 	)
@@ -25,8 +31,13 @@ func (c *Client) FilterAdd(filterToUpload event.GlobalFilter) (string, error) {
 
 // Filter downloads the requested filter from the homeserver.
 func (c *Client) Filter(filterID string) (*event.GlobalFilter, error) {
+	return c.FilterContext(c.Context(), filterID)
+}
+
+// FilterContext is the same as Filter but takes a context.
+func (c *Client) FilterContext(ctx context.Context, filterID string) (*event.GlobalFilter, error) {
 	resp := &event.GlobalFilter{}
-	err := c.Request(
+	err := c.RequestContext(ctx,
 		"GET", c.Endpoints.FilterGet(c.UserID, filterID), resp,
 		httputil.WithToken(),
 	)
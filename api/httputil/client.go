@@ -7,7 +7,10 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
-	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/chanbakjsd/gotrix/debug"
@@ -26,10 +29,28 @@ type Client struct {
 	// HomeServerScheme is the scheme to talk to homeserver on.
 	// It is https most of the time.
 	HomeServerScheme string
+	// HomeServerPath is the path prefix the homeserver is served under (for example "/matrix").
+	// It is empty if the homeserver is served at the root of HomeServer.
+	HomeServerPath string
+
+	// Logger receives the logs of the requests made by the client.
+	// debug.Default() is used if it is nil.
+	Logger *debug.StructuredLogger
+	// TraceSecrets disables the redaction of tokens, passwords and other secrets in the requests and
+	// responses that are logged at debug.LevelTrace. It should only be set when debugging locally.
+	TraceSecrets bool
 
 	ctx context.Context
 }
 
+// requestCounter is used to assign IDs to requests so their logs can be correlated.
+var requestCounter uint64
+
+// nextRequestID returns a new process-unique request ID.
+func nextRequestID() string {
+	return strconv.FormatUint(atomic.AddUint64(&requestCounter, 1), 10)
+}
+
 // NewClient creates a new Client that uses the default HTTP client.
 func NewClient() Client {
 	return Client{
@@ -47,92 +68,197 @@ func NewCustomClient(d ClientDriver) Client {
 }
 
 // WithContext creates a copy of Client that uses the provided context during request creation.
+//
+// Prefer passing the context to RequestContext or the Context variant of the API method instead as
+// it does not copy the client.
 func (c Client) WithContext(ctx context.Context) Client {
 	c.ctx = ctx
 	return c
 }
 
+// Context returns the context set by WithContext. It returns context.Background() if none is set.
+func (c *Client) Context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// SetBaseURL sets HomeServerScheme, HomeServer and HomeServerPath from the provided base URL.
+// The base URL must include the scheme.
+//
+// A base URL with the unix scheme (such as unix:///run/matrix.sock) replaces the ClientDriver with
+// UnixDriver using the path of the URL as the socket path. Requests are then addressed to
+// http://localhost.
+func (c *Client) SetBaseURL(baseURL string) error {
+	parsed, err := url.Parse(baseURL)
+	if err != nil {
+		return err
+	}
+
+	if parsed.Scheme == "unix" {
+		if parsed.Path == "" {
+			return fmt.Errorf("unix base URL %q has no socket path", baseURL)
+		}
+		c.ClientDriver = UnixDriver(parsed.Path)
+		c.HomeServerScheme = "http"
+		c.HomeServer = "localhost"
+		c.HomeServerPath = ""
+		return nil
+	}
+
+	if parsed.Scheme == "" || parsed.Host == "" {
+		return fmt.Errorf("base URL %q must contain a scheme and a host", baseURL)
+	}
+
+	c.HomeServerScheme = parsed.Scheme
+	c.HomeServer = parsed.Host
+	c.HomeServerPath = strings.TrimSuffix(parsed.EscapedPath(), "/")
+	return nil
+}
+
+// BaseURL returns the URL that every route is appended to. It does not end with a slash.
+func (c *Client) BaseURL() string {
+	path := c.HomeServerPath
+	if path != "" && !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return c.HomeServerScheme + "://" + c.HomeServer + strings.TrimSuffix(path, "/")
+}
+
 // FullRoute creates the full route from the provided route.
 func (c *Client) FullRoute(route string) string {
-	return c.HomeServerScheme + "://" + c.HomeServer + "/" + route
+	return c.BaseURL() + "/" + route
 }
 
-// Request makes the request and returns the result.
+// Request makes the request with the context of the client and returns the result.
 //
 // It may return any HTTP request errors or a matrix.HTTPError which may possibly
 // wrap a matrix.APIError.
 func (c *Client) Request(method, route string, to interface{}, mods ...Modifier) error {
-	// Generate the request.
-	req, err := http.NewRequestWithContext(c.ctx, method, c.FullRoute(route), nil)
+	return c.RequestContext(c.Context(), method, route, to, mods...)
+}
+
+// RequestContext makes the request and returns the result.
+// The context applies to the request itself as well as the time spent waiting when rate-limited.
+//
+// It may return any HTTP request errors or a matrix.HTTPError which may possibly
+// wrap a matrix.APIError.
+func (c *Client) RequestContext(ctx context.Context, method, route string, to interface{}, mods ...Modifier) error {
+	resp, err := c.do(ctx, method, route, to, mods...)
 	if err != nil {
 		return err
 	}
 
+	defer func() {
+		// We honestly don't care about errors closing the body.
+		_ = resp.Body.Close()
+	}()
+
+	if to == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(to)
+}
+
+// RequestStream makes the request and returns the response without reading the body so it can be
+// streamed. The caller is responsible for closing the body of the response.
+//
+// It returns the same errors as RequestContext if the homeserver does not respond with HTTP 200.
+func (c *Client) RequestStream(ctx context.Context, method, route string, mods ...Modifier) (*http.Response, error) {
+	return c.do(ctx, method, route, nil, mods...)
+}
+
+// do makes the request and returns the response if the homeserver responded with HTTP 200.
+// Otherwise, the body is decoded into to (if it is not nil) and the error returned by the homeserver.
+func (c *Client) do(ctx context.Context, method, route string, to interface{},
+	mods ...Modifier) (*http.Response, error) {
+	// Generate the request.
+	req, err := http.NewRequestWithContext(ctx, method, c.FullRoute(route), nil)
+	if err != nil {
+		return nil, err
+	}
+
 	// Apply all the request modifiers.
 	for _, v := range mods {
 		v(c, req)
 	}
 
-	if debug.TraceEnabled {
-		b, err := httputil.DumpRequest(req, true)
-		if err != nil {
-			panic(err)
-		}
-		debug.Trace("<<<<\n" + string(b))
+	requestID := nextRequestID()
+	log := c.Logger.With(debug.RequestID(requestID))
+	dumper := traceDumper{redact: !c.TraceSecrets}
+	if log.Enabled(debug.LevelTrace) {
+		log.Trace("<<<< request " + requestID + "\n" + dumper.dumpRequest(req))
 	}
 
 	// Make the request.
 	resp, err := c.Do(req)
 	if err != nil {
-		debug.Trace(">>>> N/A. Error: " + err.Error())
-		return err
+		log.Trace(">>>> N/A", debug.Err(err))
+		return nil, err
 	}
 
-	if debug.TraceEnabled {
-		b, err := httputil.DumpResponse(resp, true)
-		if err != nil {
-			panic(err)
-		}
-		debug.Trace(">>>>\n" + string(b))
+	if log.Enabled(debug.LevelTrace) {
+		log.Trace(">>>> response " + requestID + "\n" + dumper.dumpResponse(resp))
 	}
 
-	defer func() {
-		// We honestly don't care about errors closing the body.
-		_ = resp.Body.Close()
-	}()
-
-	// HTTP OK. Just return the object.
+	// HTTP OK. Just return the response.
 	if resp.StatusCode == http.StatusOK {
-		if to == nil {
-			return nil
-		}
-		return json.NewDecoder(resp.Body).Decode(to)
+		return resp, nil
 	}
 
 	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Try to decode into target just in case it is expecting the error message.
-	_ = json.NewDecoder(bytes.NewReader(bodyBytes)).Decode(to)
+	if to != nil {
+		_ = json.NewDecoder(bytes.NewReader(bodyBytes)).Decode(to)
+	}
 
 	// Try parsing it as an API error.
 	var apiError matrix.APIError
 
 	err = json.NewDecoder(bytes.NewReader(bodyBytes)).Decode(&apiError)
 	if err != nil {
-		return matrix.NewHTTPError(resp.StatusCode, err)
+		return nil, c.newHTTPError(method, route, resp, err)
 	}
 
+	httpErr := c.newHTTPError(method, route, resp, apiError)
+
 	// If it's a rate-limit, we intercept it and retry after the recommended time.
 	if apiError.Code == matrix.CodeLimitExceeded {
-		debug.Debug(
-			fmt.Sprintf("Being rate-limited by homeserver. Retrying in %dms.", apiError.RetryAfterMillisecond),
+		log.Debug(
+			"being rate-limited by homeserver",
+			debug.Any("route", route), debug.Any("retry_after", httpErr.RetryAfter),
 		)
-		time.Sleep(time.Duration(apiError.RetryAfterMillisecond) * time.Millisecond)
-		return c.Request(method, route, to, mods...)
+		timer := time.NewTimer(httpErr.RetryAfter)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		return c.do(ctx, method, route, to, mods...)
+	}
+
+	return nil, httpErr
+}
+
+// newHTTPError creates a matrix.HTTPError for the failed request. It falls back to the Retry-After
+// header if the error itself does not carry the time to wait.
+func (c *Client) newHTTPError(method, route string, resp *http.Response, underlying error) matrix.HTTPError {
+	httpErr := matrix.NewHTTPError(resp.StatusCode, underlying)
+	httpErr.Method = method
+	httpErr.Route = route
+
+	if httpErr.RetryAfter == 0 {
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+			httpErr.RetryAfter = time.Duration(secs) * time.Second
+		}
 	}
 
-	return matrix.NewHTTPError(resp.StatusCode, apiError)
+	return httpErr
 }
@@ -1,6 +1,8 @@
 package httputil
 
 import (
+	"context"
+	"net"
 	"net/http"
 )
 
@@ -8,3 +10,16 @@ import (
 type ClientDriver interface {
 	Do(req *http.Request) (*http.Response, error)
 }
+
+// UnixDriver returns a ClientDriver that connects to the unix socket at the provided path for
+// every request, regardless of the host the request is addressed to.
+func UnixDriver(socketPath string) ClientDriver {
+	var dialer net.Dialer
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, "unix", socketPath)
+			},
+		},
+	}
+}
@@ -0,0 +1,207 @@
+package httputil
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// redactedValue replaces secrets in trace output.
+const redactedValue = "REDACTED"
+
+// RedactedHeaders is the list of headers whose values are redacted from trace output.
+var RedactedHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "Proxy-Authorization"}
+
+// RedactedFields is the set of JSON object keys and query parameters whose values are redacted
+// from trace output. Nested objects are searched as well, so it covers the auth object of UIAA.
+var RedactedFields = map[string]struct{}{
+	"access_token":    {},
+	"refresh_token":   {},
+	"id_access_token": {},
+	"password":        {},
+	"new_password":    {},
+	"token":           {},
+	"login_token":     {},
+	"client_secret":   {},
+	"session_key":     {},
+	"private_key":     {},
+}
+
+// traceDumper formats requests and responses for tracing.
+type traceDumper struct {
+	// redact is false if secrets should be kept in the output.
+	redact bool
+}
+
+// dumpRequest formats the request line, headers and body of req.
+//
+// JSON bodies are read, pretty-printed and replaced so the request can still be sent.
+// Other bodies are left untouched and only described.
+func (t traceDumper) dumpRequest(req *http.Request) string {
+	var sb strings.Builder
+
+	reqURL := *req.URL
+	reqURL.RawQuery = t.query(reqURL.Query()).Encode()
+	fmt.Fprintf(&sb, "%s %s %s\n", req.Method, reqURL.RequestURI(), req.Proto)
+	fmt.Fprintf(&sb, "Host: %s\n", req.URL.Host)
+	t.writeHeader(&sb, req.Header)
+
+	if req.Body == nil || req.Body == http.NoBody {
+		return sb.String()
+	}
+
+	if !isJSON(req.Header.Get("Content-Type")) {
+		fmt.Fprintf(&sb, "\n<body of type %q omitted>\n", req.Header.Get("Content-Type"))
+		return sb.String()
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	_ = req.Body.Close()
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		fmt.Fprintf(&sb, "\n<error reading body: %v>\n", err)
+		return sb.String()
+	}
+
+	sb.WriteByte('\n')
+	sb.WriteString(t.body(body))
+	return sb.String()
+}
+
+// dumpResponse formats the status line, headers and body of resp.
+//
+// JSON bodies are read, pretty-printed and replaced so the response can still be decoded.
+// Other bodies are left untouched so that they can be streamed.
+func (t traceDumper) dumpResponse(resp *http.Response) string {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "%s %s\n", resp.Proto, resp.Status)
+	t.writeHeader(&sb, resp.Header)
+
+	if !isJSON(resp.Header.Get("Content-Type")) {
+		fmt.Fprintf(&sb, "\n<body of type %q omitted>\n", resp.Header.Get("Content-Type"))
+		return sb.String()
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		fmt.Fprintf(&sb, "\n<error reading body: %v>\n", err)
+		return sb.String()
+	}
+
+	sb.WriteByte('\n')
+	sb.WriteString(t.body(body))
+	return sb.String()
+}
+
+// writeHeader writes the headers in sorted order, redacting secrets.
+func (t traceDumper) writeHeader(w io.Writer, header http.Header) {
+	keys := make([]string, 0, len(header))
+	for k := range header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		for _, v := range header[k] {
+			if t.redact && isRedactedHeader(k) {
+				v = redactHeaderValue(v)
+			}
+			fmt.Fprintf(w, "%s: %s\n", k, v)
+		}
+	}
+}
+
+// query returns a copy of the query with secrets redacted.
+func (t traceDumper) query(q url.Values) url.Values {
+	if !t.redact {
+		return q
+	}
+	for k := range q {
+		if _, ok := RedactedFields[k]; ok {
+			q[k] = []string{redactedValue}
+		}
+	}
+	return q
+}
+
+// body pretty-prints the JSON body with secrets redacted. Bodies that are not valid JSON are
+// returned as-is.
+func (t traceDumper) body(body []byte) string {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return ""
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return string(body) + "\n"
+	}
+	if t.redact {
+		v = redactJSON(v)
+	}
+
+	var pretty bytes.Buffer
+	enc := json.NewEncoder(&pretty)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return string(body) + "\n"
+	}
+	return pretty.String()
+}
+
+// redactJSON replaces the values of RedactedFields in the decoded JSON value.
+func redactJSON(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, field := range v {
+			if _, ok := RedactedFields[k]; ok {
+				v[k] = redactedValue
+				continue
+			}
+			v[k] = redactJSON(field)
+		}
+	case []interface{}:
+		for i, elem := range v {
+			v[i] = redactJSON(elem)
+		}
+	}
+	return v
+}
+
+func isRedactedHeader(key string) bool {
+	for _, h := range RedactedHeaders {
+		if strings.EqualFold(h, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactHeaderValue keeps the authentication scheme (such as Bearer) but redacts the credentials.
+func redactHeaderValue(v string) string {
+	if i := strings.IndexByte(v, ' '); i > 0 {
+		return v[:i+1] + redactedValue
+	}
+	return redactedValue
+}
+
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
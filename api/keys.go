@@ -0,0 +1,341 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/chanbakjsd/gotrix/api/httputil"
+	"github.com/chanbakjsd/gotrix/matrix"
+)
+
+// Signatures are signatures of a JSON object, indexed by the user ID then the key ID ("ed25519:DEVICEID").
+type Signatures map[matrix.UserID]map[string]string
+
+// DeviceKeys are the identity keys of a device.
+type DeviceKeys struct {
+	UserID     matrix.UserID   `json:"user_id"`
+	DeviceID   matrix.DeviceID `json:"device_id"`
+	Algorithms []string        `json:"algorithms"`
+	// Keys are the public keys of the device, indexed by the key ID ("curve25519:DEVICEID").
+	Keys       map[string]string `json:"keys"`
+	Signatures Signatures        `json:"signatures,omitempty"`
+	Unsigned   *struct {
+		DeviceDisplayName string `json:"device_display_name,omitempty"`
+	} `json:"unsigned,omitempty"`
+
+	// Raw is the raw JSON of the device keys if they are received from the homeserver. It is kept as the
+	// signatures also cover fields that are not known to DeviceKeys.
+	Raw json.RawMessage `json:"-"`
+}
+
+// deviceKeysFields are the JSON fields of DeviceKeys.
+var deviceKeysFields = []string{"user_id", "device_id", "algorithms", "keys", "signatures", "unsigned"}
+
+// UnmarshalJSON parses b into d while keeping the raw JSON inside d.Raw.
+func (d *DeviceKeys) UnmarshalJSON(b []byte) error {
+	// Copy the JSON.
+	d.Raw = append(d.Raw[:0], b...)
+
+	type deviceKeys DeviceKeys
+	return json.Unmarshal(b, (*deviceKeys)(d))
+}
+
+// MarshalJSON marshals d, keeping the fields in d.Raw that are not known to DeviceKeys.
+func (d DeviceKeys) MarshalJSON() ([]byte, error) {
+	type deviceKeys DeviceKeys
+	known, err := json.Marshal(deviceKeys(d))
+	if err != nil {
+		return nil, err
+	}
+	return withUnknownFields(known, d.Raw, deviceKeysFields)
+}
+
+// withUnknownFields adds the fields of the raw JSON object that are not in fields to the known JSON object.
+func withUnknownFields(known []byte, raw json.RawMessage, fields []string) ([]byte, error) {
+	if raw == nil {
+		return known, nil
+	}
+	var merged map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &merged); err != nil {
+		return nil, err
+	}
+	for _, v := range fields {
+		delete(merged, v)
+	}
+	if err := json.Unmarshal(known, &merged); err != nil {
+		return nil, err
+	}
+	return json.Marshal(merged)
+}
+
+// OneTimeKey is a signed Curve25519 one-time key or fallback key.
+type OneTimeKey struct {
+	Key        string     `json:"key"`
+	Fallback   bool       `json:"fallback,omitempty"`
+	Signatures Signatures `json:"signatures,omitempty"`
+}
+
+// KeysUploadArg represents all possible arguments to (*Client).KeysUpload.
+//
+// The one-time keys and fallback keys are indexed by "algorithm:key_id".
+type KeysUploadArg struct {
+	DeviceKeys   *DeviceKeys           `json:"device_keys,omitempty"`
+	OneTimeKeys  map[string]OneTimeKey `json:"one_time_keys,omitempty"`
+	FallbackKeys map[string]OneTimeKey `json:"fallback_keys,omitempty"`
+}
+
+// KeysUpload publishes end-to-end encryption keys for the device.
+// It returns the number of unclaimed one-time keys on the server for each algorithm.
+func (c *Client) KeysUpload(req KeysUploadArg) (map[string]int, error) {
+	return c.KeysUploadContext(c.Context(), req)
+}
+
+// KeysUploadContext is the same as KeysUpload but takes a context.
+func (c *Client) KeysUploadContext(ctx context.Context, req KeysUploadArg) (map[string]int, error) {
+	var resp struct {
+		OneTimeKeyCounts map[string]int `json:"one_time_key_counts"`
+	}
+	err := c.RequestContext(ctx,
+		"POST", c.Endpoints.KeysUpload(), &resp,
+		httputil.WithToken(), httputil.WithJSONBody(req),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error uploading keys: %w", err)
+	}
+	return resp.OneTimeKeyCounts, nil
+}
+
+// Usages of cross-signing keys.
+const (
+	CrossSigningUsageMaster      = "master"
+	CrossSigningUsageSelfSigning = "self_signing"
+	CrossSigningUsageUserSigning = "user_signing"
+)
+
+// CrossSigningKey is a cross-signing key of a user.
+type CrossSigningKey struct {
+	UserID matrix.UserID `json:"user_id"`
+	// Usage is what the key is used for. It contains one of the CrossSigningUsage constants.
+	Usage []string `json:"usage"`
+	// Keys contains the Ed25519 public key, indexed by its key ID ("ed25519:PUBLICKEY").
+	Keys       map[string]string `json:"keys"`
+	Signatures Signatures        `json:"signatures,omitempty"`
+
+	// Raw is the raw JSON of the key if it is received from the homeserver. It is kept as the signatures also
+	// cover fields that are not known to CrossSigningKey.
+	Raw json.RawMessage `json:"-"`
+}
+
+// crossSigningKeyFields are the JSON fields of CrossSigningKey.
+var crossSigningKeyFields = []string{"user_id", "usage", "keys", "signatures"}
+
+// UnmarshalJSON parses b into k while keeping the raw JSON inside k.Raw.
+func (k *CrossSigningKey) UnmarshalJSON(b []byte) error {
+	// Copy the JSON.
+	k.Raw = append(k.Raw[:0], b...)
+
+	type crossSigningKey CrossSigningKey
+	return json.Unmarshal(b, (*crossSigningKey)(k))
+}
+
+// MarshalJSON marshals k, keeping the fields in k.Raw that are not known to CrossSigningKey.
+func (k CrossSigningKey) MarshalJSON() ([]byte, error) {
+	type crossSigningKey CrossSigningKey
+	known, err := json.Marshal(crossSigningKey(k))
+	if err != nil {
+		return nil, err
+	}
+	return withUnknownFields(known, k.Raw, crossSigningKeyFields)
+}
+
+// PublicKey returns the Ed25519 public key in base64, or an empty string if there is none.
+func (k CrossSigningKey) PublicKey() string {
+	for id, key := range k.Keys {
+		if strings.HasPrefix(id, "ed25519:") {
+			return key
+		}
+	}
+	return ""
+}
+
+// KeysQueryResponse is the response of (*Client).KeysQuery.
+type KeysQueryResponse struct {
+	// Failures contains the homeservers that could not be reached, indexed by their server name.
+	Failures   map[string]json.RawMessage                       `json:"failures,omitempty"`
+	DeviceKeys map[matrix.UserID]map[matrix.DeviceID]DeviceKeys `json:"device_keys"`
+	// The cross-signing keys of the users. User-signing keys are only returned for the current user.
+	MasterKeys      map[matrix.UserID]CrossSigningKey `json:"master_keys,omitempty"`
+	SelfSigningKeys map[matrix.UserID]CrossSigningKey `json:"self_signing_keys,omitempty"`
+	UserSigningKeys map[matrix.UserID]CrossSigningKey `json:"user_signing_keys,omitempty"`
+}
+
+// KeysQuery returns the device keys of the provided devices. All devices of a user are returned if no
+// device ID is provided for the user.
+func (c *Client) KeysQuery(devices map[matrix.UserID][]matrix.DeviceID, timeout time.Duration) (
+	*KeysQueryResponse, error) {
+	return c.KeysQueryContext(c.Context(), devices, timeout)
+}
+
+// KeysQueryContext is the same as KeysQuery but takes a context.
+func (c *Client) KeysQueryContext(ctx context.Context, devices map[matrix.UserID][]matrix.DeviceID,
+	timeout time.Duration) (*KeysQueryResponse, error) {
+	deviceKeys := make(map[matrix.UserID][]matrix.DeviceID, len(devices))
+	for k, v := range devices {
+		// The spec requires an empty list instead of null to query every device.
+		if v == nil {
+			v = []matrix.DeviceID{}
+		}
+		deviceKeys[k] = v
+	}
+	req := map[string]interface{}{
+		"device_keys": deviceKeys,
+	}
+	if timeout != 0 {
+		req["timeout"] = timeout / time.Millisecond
+	}
+
+	resp := &KeysQueryResponse{}
+	err := c.RequestContext(ctx,
+		"POST", c.Endpoints.KeysQuery(), resp,
+		httputil.WithToken(), httputil.WithJSONBody(req),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying keys: %w", err)
+	}
+	return resp, nil
+}
+
+// KeysClaimResponse is the response of (*Client).KeysClaim.
+type KeysClaimResponse struct {
+	// Failures contains the homeservers that could not be reached, indexed by their server name.
+	Failures map[string]json.RawMessage `json:"failures,omitempty"`
+	// OneTimeKeys are the claimed keys, indexed by the user ID, device ID then "algorithm:key_id".
+	OneTimeKeys map[matrix.UserID]map[matrix.DeviceID]map[string]OneTimeKey `json:"one_time_keys"`
+}
+
+// KeysClaim claims a one-time key of the provided algorithm for each of the provided devices.
+func (c *Client) KeysClaim(devices map[matrix.UserID]map[matrix.DeviceID]string, timeout time.Duration) (
+	*KeysClaimResponse, error) {
+	return c.KeysClaimContext(c.Context(), devices, timeout)
+}
+
+// KeysClaimContext is the same as KeysClaim but takes a context.
+func (c *Client) KeysClaimContext(ctx context.Context, devices map[matrix.UserID]map[matrix.DeviceID]string,
+	timeout time.Duration) (*KeysClaimResponse, error) {
+	req := map[string]interface{}{
+		"one_time_keys": devices,
+	}
+	if timeout != 0 {
+		req["timeout"] = timeout / time.Millisecond
+	}
+
+	resp := &KeysClaimResponse{}
+	err := c.RequestContext(ctx,
+		"POST", c.Endpoints.KeysClaim(), resp,
+		httputil.WithToken(), httputil.WithJSONBody(req),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error claiming keys: %w", err)
+	}
+	return resp, nil
+}
+
+// KeysChangesResponse is the response of (*Client).KeysChanges.
+type KeysChangesResponse struct {
+	// Changed are the users who have updated their device keys.
+	Changed []matrix.UserID `json:"changed,omitempty"`
+	// Left are the users who no longer share an encrypted room with the user.
+	Left []matrix.UserID `json:"left,omitempty"`
+}
+
+// KeysChanges returns the users whose device keys have changed between the two sync tokens.
+func (c *Client) KeysChanges(from, to string) (*KeysChangesResponse, error) {
+	return c.KeysChangesContext(c.Context(), from, to)
+}
+
+// KeysChangesContext is the same as KeysChanges but takes a context.
+func (c *Client) KeysChangesContext(ctx context.Context, from, to string) (*KeysChangesResponse, error) {
+	resp := &KeysChangesResponse{}
+	err := c.RequestContext(ctx,
+		"GET", c.Endpoints.KeysChanges(), resp,
+		httputil.WithToken(), httputil.WithQuery(map[string]string{
+			"from": from,
+			"to":   to,
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching key changes: %w", err)
+	}
+	return resp, nil
+}
+
+// KeysDeviceSigningUploadArg represents all possible arguments to (*Client).KeysDeviceSigningUpload.
+type KeysDeviceSigningUploadArg struct {
+	MasterKey      *CrossSigningKey `json:"master_key,omitempty"`
+	SelfSigningKey *CrossSigningKey `json:"self_signing_key,omitempty"`
+	UserSigningKey *CrossSigningKey `json:"user_signing_key,omitempty"`
+}
+
+// KeysDeviceSigningUpload publishes the cross-signing keys of the current user.
+//
+// The homeserver may require interactive auth before replacing the keys. The returned
+// UserInteractiveAuthAPI should be used to fulfill the requirements.
+func (c *Client) KeysDeviceSigningUpload(req KeysDeviceSigningUploadArg) (*UserInteractiveAuthAPI, error) {
+	return c.KeysDeviceSigningUploadContext(c.Context(), req)
+}
+
+// KeysDeviceSigningUploadContext is the same as KeysDeviceSigningUpload but takes a context.
+func (c *Client) KeysDeviceSigningUploadContext(ctx context.Context,
+	req KeysDeviceSigningUploadArg) (*UserInteractiveAuthAPI, error) {
+	var body struct {
+		KeysDeviceSigningUploadArg
+		Auth interface{} `json:"auth,omitempty"`
+	}
+	body.KeysDeviceSigningUploadArg = req
+
+	uiaa := c.newUserInteractiveAuthAPI(func(ctx context.Context, auth, to interface{}) error {
+		body.Auth = auth
+		err := c.RequestContext(ctx,
+			"POST", c.Endpoints.KeysDeviceSigningUpload(), to,
+			httputil.WithToken(), httputil.WithJSONBody(body),
+		)
+		if err != nil {
+			return fmt.Errorf("error uploading cross-signing keys: %w", err)
+		}
+		return nil
+	}, nil)
+	err := uiaa.AuthContext(ctx, nil)
+	return uiaa, err
+}
+
+// KeysSignaturesUploadResponse is the response of (*Client).KeysSignaturesUpload.
+type KeysSignaturesUploadResponse struct {
+	// Failures contains the signatures that have been rejected, indexed by the user ID then the device ID or
+	// public key of the signed object. Each failure is a standard Matrix error.
+	Failures map[matrix.UserID]map[string]json.RawMessage `json:"failures,omitempty"`
+}
+
+// KeysSignaturesUpload publishes signatures of device keys and cross-signing keys. The signed objects are
+// indexed by the user ID then the device ID or the public key of the cross-signing key, and must contain the
+// new signatures.
+func (c *Client) KeysSignaturesUpload(signed map[matrix.UserID]map[string]interface{}) (
+	*KeysSignaturesUploadResponse, error) {
+	return c.KeysSignaturesUploadContext(c.Context(), signed)
+}
+
+// KeysSignaturesUploadContext is the same as KeysSignaturesUpload but takes a context.
+func (c *Client) KeysSignaturesUploadContext(ctx context.Context,
+	signed map[matrix.UserID]map[string]interface{}) (*KeysSignaturesUploadResponse, error) {
+	resp := &KeysSignaturesUploadResponse{}
+	err := c.RequestContext(ctx,
+		"POST", c.Endpoints.KeysSignaturesUpload(), resp,
+		httputil.WithToken(), httputil.WithJSONBody(signed),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error uploading signatures: %w", err)
+	}
+	return resp, nil
+}
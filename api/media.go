@@ -1,9 +1,12 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"mime"
+	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
@@ -14,10 +17,16 @@ import (
 
 // MediaUpload uploads the provided file to the Matrix homeserver.
 func (c *Client) MediaUpload(contentType string, filename string, body io.ReadCloser) (matrix.URL, error) {
+	return c.MediaUploadContext(c.Context(), contentType, filename, body)
+}
+
+// MediaUploadContext is the same as MediaUpload but takes a context.
+func (c *Client) MediaUploadContext(ctx context.Context, contentType string, filename string,
+	body io.ReadCloser) (matrix.URL, error) {
 	var resp struct {
 		ContentURI matrix.URL `json:"content_uri"`
 	}
-	err := c.Request(
+	err := c.RequestContext(ctx,
 		"POST", c.Endpoints.MediaUpload(), &resp,
 		httputil.WithToken(),
 		httputil.WithHeader(map[string][]string{
@@ -38,6 +47,9 @@ func (c *Client) MediaUpload(contentType string, filename string, body io.ReadCl
 
 // MediaDownloadURL returns the HTTP URL for the provided matrix URL.
 // If allowRemote is false, the server will not attempt to fetch the media if it is deemed remote.
+//
+// If Endpoints.AuthenticatedMedia is set, the URL requires the access token to be sent in the Authorization
+// header. Use MediaDownload to download the media with it attached.
 func (c *Client) MediaDownloadURL(matrixURL matrix.URL, allowRemote bool, filename string) (string, error) {
 	parsed, err := url.Parse(string(matrixURL))
 	if err != nil {
@@ -67,6 +79,9 @@ const (
 // MediaThumbnailURL returns the HTTP URL for the provided matrix URL.
 // If allowRemote is false, the server will not attempt to fetch the media if it is deemed remote.
 // The provided width and height are treated as a guideline and the actual thumbnail may be a different size.
+//
+// If Endpoints.AuthenticatedMedia is set, the URL requires the access token to be sent in the Authorization
+// header. Use MediaThumbnail to download the thumbnail with it attached.
 func (c *Client) MediaThumbnailURL(matrixURL matrix.URL, allowRemote bool,
 	width int, height int, method MediaThumbnailMethod) (string, error) {
 	parsed, err := url.Parse(string(matrixURL))
@@ -90,6 +105,159 @@ func (c *Client) MediaThumbnailURL(matrixURL matrix.URL, allowRemote bool,
 	return c.FullRoute(c.Endpoints.MediaThumbnail(parsed.Host, parsed.Path)) + "?" + query.Encode(), nil
 }
 
+// MediaCreateResponse is the response of MediaCreate.
+type MediaCreateResponse struct {
+	ContentURI matrix.URL `json:"content_uri"`
+	// UnusedExpiresAt is the time the URI expires if the content has not been uploaded by then.
+	UnusedExpiresAt matrix.Timestamp `json:"unused_expires_at,omitempty"`
+}
+
+// MediaCreate creates a MXC URI without uploading any content so that it can be used before the content is
+// uploaded with MediaUploadTo.
+func (c *Client) MediaCreate() (MediaCreateResponse, error) {
+	return c.MediaCreateContext(c.Context())
+}
+
+// MediaCreateContext is the same as MediaCreate but takes a context.
+func (c *Client) MediaCreateContext(ctx context.Context) (MediaCreateResponse, error) {
+	var resp MediaCreateResponse
+	err := c.RequestContext(ctx,
+		"POST", c.Endpoints.MediaCreate(), &resp,
+		httputil.WithToken(),
+	)
+	if err != nil {
+		return MediaCreateResponse{}, fmt.Errorf("error creating media: %w", err)
+	}
+	return resp, nil
+}
+
+// MediaUploadTo uploads the content of a MXC URI previously created with MediaCreate.
+//
+// An error with matrix.CodeCannotOverwriteMedia is returned if the content has already been uploaded.
+func (c *Client) MediaUploadTo(matrixURL matrix.URL, contentType string, filename string, body io.ReadCloser) error {
+	return c.MediaUploadToContext(c.Context(), matrixURL, contentType, filename, body)
+}
+
+// MediaUploadToContext is the same as MediaUploadTo but takes a context.
+func (c *Client) MediaUploadToContext(ctx context.Context, matrixURL matrix.URL, contentType string,
+	filename string, body io.ReadCloser) error {
+	serverName, mediaID, err := matrixURL.Parse()
+	if err != nil {
+		return fmt.Errorf("error uploading media: %w", err)
+	}
+
+	err = c.RequestContext(ctx,
+		"PUT", c.Endpoints.MediaUploadTo(serverName, mediaID), nil,
+		httputil.WithToken(),
+		httputil.WithHeader(map[string][]string{
+			"Content-Type": {
+				contentType,
+			},
+		}),
+		httputil.WithQuery(map[string]string{
+			"filename": filename,
+		}),
+		httputil.WithBody(body),
+	)
+	if err != nil {
+		return fmt.Errorf("error uploading media: %w", err)
+	}
+	return nil
+}
+
+// MediaInfo is the information about a piece of media returned by the homeserver when it is downloaded.
+type MediaInfo struct {
+	ContentType string
+	// FileName is the name of the file as given by the homeserver. It is empty if the homeserver did not
+	// provide one.
+	FileName string
+	// Length is the number of bytes written.
+	Length int64
+}
+
+// MediaDownload downloads the media at the provided MXC URI and writes it into w.
+//
+// matrix.ErrInvalidMXC is returned if the URL is not a valid MXC URI.
+func (c *Client) MediaDownload(matrixURL matrix.URL, w io.Writer) (MediaInfo, error) {
+	return c.MediaDownloadContext(c.Context(), matrixURL, w)
+}
+
+// MediaDownloadContext is the same as MediaDownload but takes a context.
+func (c *Client) MediaDownloadContext(ctx context.Context, matrixURL matrix.URL, w io.Writer) (MediaInfo, error) {
+	serverName, mediaID, err := matrixURL.Parse()
+	if err != nil {
+		return MediaInfo{}, fmt.Errorf("error downloading media: %w", err)
+	}
+
+	resp, err := c.RequestStream(ctx,
+		"GET", c.Endpoints.MediaDownload(serverName, mediaID, ""),
+		httputil.WithToken(),
+	)
+	if err != nil {
+		return MediaInfo{}, fmt.Errorf("error downloading media: %w", err)
+	}
+
+	info, err := copyMedia(resp, w)
+	if err != nil {
+		return info, fmt.Errorf("error downloading media: %w", err)
+	}
+	return info, nil
+}
+
+// MediaThumbnail downloads a thumbnail of the media at the provided MXC URI and writes it into w.
+// The provided width and height are treated as a guideline and the actual thumbnail may be a different size.
+//
+// matrix.ErrInvalidMXC is returned if the URL is not a valid MXC URI.
+func (c *Client) MediaThumbnail(matrixURL matrix.URL, w io.Writer,
+	width int, height int, method MediaThumbnailMethod) (MediaInfo, error) {
+	return c.MediaThumbnailContext(c.Context(), matrixURL, w, width, height, method)
+}
+
+// MediaThumbnailContext is the same as MediaThumbnail but takes a context.
+func (c *Client) MediaThumbnailContext(ctx context.Context, matrixURL matrix.URL, w io.Writer,
+	width int, height int, method MediaThumbnailMethod) (MediaInfo, error) {
+	serverName, mediaID, err := matrixURL.Parse()
+	if err != nil {
+		return MediaInfo{}, fmt.Errorf("error downloading thumbnail: %w", err)
+	}
+
+	resp, err := c.RequestStream(ctx,
+		"GET", c.Endpoints.MediaThumbnail(serverName, mediaID),
+		httputil.WithToken(), httputil.WithQuery(map[string]string{
+			"width":  strconv.Itoa(width),
+			"height": strconv.Itoa(height),
+			"method": string(method),
+		}),
+	)
+	if err != nil {
+		return MediaInfo{}, fmt.Errorf("error downloading thumbnail: %w", err)
+	}
+
+	info, err := copyMedia(resp, w)
+	if err != nil {
+		return info, fmt.Errorf("error downloading thumbnail: %w", err)
+	}
+	return info, nil
+}
+
+// copyMedia copies the body of the response into w and closes it.
+func copyMedia(resp *http.Response, w io.Writer) (MediaInfo, error) {
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	info := MediaInfo{
+		ContentType: resp.Header.Get("Content-Type"),
+	}
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		info.FileName = params["filename"]
+	}
+
+	var err error
+	info.Length, err = io.Copy(w, resp.Body)
+	return info, err
+}
+
 // URLMetadata contains the basic OpenGraph metadata that the Matrix backend
 // gives us using PreviewURL, as well as the raw JSON for the user to parse it
 // further.
@@ -135,6 +303,11 @@ func (u *URLMetadata) MarshalJSON() ([]byte, error) {
 // The returned structure is a URLMetadata containing basic OpenGraph info, as well as the bundled
 // JSON for further parsing.
 func (c *Client) PreviewURL(url string, ts matrix.Timestamp) (*URLMetadata, error) {
+	return c.PreviewURLContext(c.Context(), url, ts)
+}
+
+// PreviewURLContext is the same as PreviewURL but takes a context.
+func (c *Client) PreviewURLContext(ctx context.Context, url string, ts matrix.Timestamp) (*URLMetadata, error) {
 	query := map[string]string{
 		"url": url,
 	}
@@ -143,7 +316,7 @@ func (c *Client) PreviewURL(url string, ts matrix.Timestamp) (*URLMetadata, erro
 	}
 
 	var resp *URLMetadata
-	err := c.Request(
+	err := c.RequestContext(ctx,
 		"GET", c.Endpoints.MediaPreviewURL(), &resp,
 		httputil.WithToken(), httputil.WithQuery(query),
 	)
@@ -162,8 +335,13 @@ type MediaConfig struct {
 // MediaConfig requests the media configuration of the server.
 // Clients should follow the guide when using content repository endpoints.
 func (c *Client) MediaConfig() (MediaConfig, error) {
+	return c.MediaConfigContext(c.Context())
+}
+
+// MediaConfigContext is the same as MediaConfig but takes a context.
+func (c *Client) MediaConfigContext(ctx context.Context) (MediaConfig, error) {
 	var resp MediaConfig
-	err := c.Request(
+	err := c.RequestContext(ctx,
 		"GET", c.Endpoints.MediaConfig(), &resp,
 		httputil.WithToken(),
 	)
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/chanbakjsd/gotrix/api/httputil"
@@ -17,8 +18,13 @@ type Presence struct {
 
 // Presence fetches the presence of the requested user.
 func (c *Client) Presence(userID matrix.UserID) (Presence, error) {
+	return c.PresenceContext(c.Context(), userID)
+}
+
+// PresenceContext is the same as Presence but takes a context.
+func (c *Client) PresenceContext(ctx context.Context, userID matrix.UserID) (Presence, error) {
 	var resp Presence
-	err := c.Request(
+	err := c.RequestContext(ctx,
 		"GET", c.Endpoints.PresenceStatus(userID), &resp,
 		httputil.WithToken(),
 	)
@@ -30,6 +36,11 @@ func (c *Client) Presence(userID matrix.UserID) (Presence, error) {
 
 // PresenceSet sets the presence of the current user to the provided presence and status message.
 func (c *Client) PresenceSet(presence matrix.Presence, statusMsg string) error {
+	return c.PresenceSetContext(c.Context(), presence, statusMsg)
+}
+
+// PresenceSetContext is the same as PresenceSet but takes a context.
+func (c *Client) PresenceSetContext(ctx context.Context, presence matrix.Presence, statusMsg string) error {
 	req := struct {
 		Presence  matrix.Presence `json:"presence"`
 		StatusMsg string          `json:"status_msg,omitempty"`
@@ -38,7 +49,7 @@ func (c *Client) PresenceSet(presence matrix.Presence, statusMsg string) error {
 		StatusMsg: statusMsg,
 	}
 
-	err := c.Request(
+	err := c.RequestContext(ctx,
 		"PUT", c.Endpoints.PresenceStatus(c.UserID), nil,
 		httputil.WithToken(), httputil.WithJSONBody(req),
 	)
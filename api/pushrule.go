@@ -0,0 +1,218 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/chanbakjsd/gotrix/api/httputil"
+	"github.com/chanbakjsd/gotrix/matrix"
+)
+
+// ErrPushRuleNotFound is returned when the push rule (or the rule it is positioned relative to) does not
+// exist.
+var ErrPushRuleNotFound = errors.New("push rule not found")
+
+// pushRuleErrors maps the errors returned by the push rule endpoints.
+var pushRuleErrors = matrix.ErrorMap{
+	matrix.CodeNotFound: ErrPushRuleNotFound,
+}
+
+// PushRules retrieves the global push ruleset of the user.
+func (c *Client) PushRules() (*matrix.PushRuleset, error) {
+	return c.PushRulesContext(c.Context())
+}
+
+// PushRulesContext is the same as PushRules but takes a context.
+func (c *Client) PushRulesContext(ctx context.Context) (*matrix.PushRuleset, error) {
+	var resp struct {
+		Global matrix.PushRuleset `json:"global"`
+	}
+	err := c.RequestContext(ctx,
+		"GET", c.Endpoints.PushRules(), &resp,
+		httputil.WithToken(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching push rules: %w", err)
+	}
+	return &resp.Global, nil
+}
+
+// PushRule retrieves a single push rule.
+//
+// ErrPushRuleNotFound is returned if the rule does not exist.
+func (c *Client) PushRule(kind matrix.PushRuleKind, ruleID matrix.PushRuleID) (*matrix.PushRule, error) {
+	return c.PushRuleContext(c.Context(), kind, ruleID)
+}
+
+// PushRuleContext is the same as PushRule but takes a context.
+func (c *Client) PushRuleContext(ctx context.Context, kind matrix.PushRuleKind,
+	ruleID matrix.PushRuleID) (*matrix.PushRule, error) {
+	var resp matrix.PushRule
+	err := c.RequestContext(ctx,
+		"GET", c.Endpoints.PushRule(kind, ruleID), &resp,
+		httputil.WithToken(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching push rule: %w", matrix.MapAPIError(err, pushRuleErrors))
+	}
+	return &resp, nil
+}
+
+// PushRuleSetArg represents all possible arguments to PushRuleSet.
+type PushRuleSetArg struct {
+	// Before and After position the rule relative to another user-defined rule of the same kind. At most
+	// one of them can be set. New rules are added with the highest priority among the user-defined rules
+	// of their kind if neither is set.
+	Before matrix.PushRuleID `json:"-"`
+	After  matrix.PushRuleID `json:"-"`
+
+	Actions matrix.PushActions `json:"actions"`
+	// Conditions only apply to Override and Underride rules.
+	Conditions []matrix.PushCondition `json:"conditions,omitempty"`
+	// Pattern only applies to Content rules.
+	Pattern matrix.PushPattern `json:"pattern,omitempty"`
+}
+
+// PushRuleSet adds a user-defined push rule, or updates it if it already exists. The rule ID of Room and
+// Sender rules must be the ID of the room or user they apply to.
+//
+// ErrPushRuleNotFound is returned if the rule in Before or After does not exist.
+func (c *Client) PushRuleSet(kind matrix.PushRuleKind, ruleID matrix.PushRuleID, arg PushRuleSetArg) error {
+	return c.PushRuleSetContext(c.Context(), kind, ruleID, arg)
+}
+
+// PushRuleSetContext is the same as PushRuleSet but takes a context.
+func (c *Client) PushRuleSetContext(ctx context.Context, kind matrix.PushRuleKind, ruleID matrix.PushRuleID,
+	arg PushRuleSetArg) error {
+	query := make(map[string]string)
+	if arg.Before != "" {
+		query["before"] = string(arg.Before)
+	}
+	if arg.After != "" {
+		query["after"] = string(arg.After)
+	}
+
+	err := c.RequestContext(ctx,
+		"PUT", c.Endpoints.PushRule(kind, ruleID), nil,
+		httputil.WithToken(), httputil.WithJSONBody(arg), httputil.WithQuery(query),
+	)
+	if err != nil {
+		return fmt.Errorf("error setting push rule: %w", matrix.MapAPIError(err, pushRuleErrors))
+	}
+	return nil
+}
+
+// PushRuleDelete deletes a user-defined push rule.
+//
+// ErrPushRuleNotFound is returned if the rule does not exist.
+func (c *Client) PushRuleDelete(kind matrix.PushRuleKind, ruleID matrix.PushRuleID) error {
+	return c.PushRuleDeleteContext(c.Context(), kind, ruleID)
+}
+
+// PushRuleDeleteContext is the same as PushRuleDelete but takes a context.
+func (c *Client) PushRuleDeleteContext(ctx context.Context, kind matrix.PushRuleKind,
+	ruleID matrix.PushRuleID) error {
+	err := c.RequestContext(ctx,
+		"DELETE", c.Endpoints.PushRule(kind, ruleID), nil,
+		httputil.WithToken(),
+	)
+	if err != nil {
+		return fmt.Errorf("error deleting push rule: %w", matrix.MapAPIError(err, pushRuleErrors))
+	}
+	return nil
+}
+
+// PushRuleEnabled returns whether the push rule is enabled.
+//
+// ErrPushRuleNotFound is returned if the rule does not exist.
+func (c *Client) PushRuleEnabled(kind matrix.PushRuleKind, ruleID matrix.PushRuleID) (bool, error) {
+	return c.PushRuleEnabledContext(c.Context(), kind, ruleID)
+}
+
+// PushRuleEnabledContext is the same as PushRuleEnabled but takes a context.
+func (c *Client) PushRuleEnabledContext(ctx context.Context, kind matrix.PushRuleKind,
+	ruleID matrix.PushRuleID) (bool, error) {
+	var resp struct {
+		Enabled bool `json:"enabled"`
+	}
+	err := c.RequestContext(ctx,
+		"GET", c.Endpoints.PushRuleEnabled(kind, ruleID), &resp,
+		httputil.WithToken(),
+	)
+	if err != nil {
+		return false, fmt.Errorf("error fetching push rule state: %w", matrix.MapAPIError(err, pushRuleErrors))
+	}
+	return resp.Enabled, nil
+}
+
+// PushRuleSetEnabled enables or disables the push rule.
+//
+// ErrPushRuleNotFound is returned if the rule does not exist.
+func (c *Client) PushRuleSetEnabled(kind matrix.PushRuleKind, ruleID matrix.PushRuleID, enabled bool) error {
+	return c.PushRuleSetEnabledContext(c.Context(), kind, ruleID, enabled)
+}
+
+// PushRuleSetEnabledContext is the same as PushRuleSetEnabled but takes a context.
+func (c *Client) PushRuleSetEnabledContext(ctx context.Context, kind matrix.PushRuleKind,
+	ruleID matrix.PushRuleID, enabled bool) error {
+	req := map[string]bool{
+		"enabled": enabled,
+	}
+	err := c.RequestContext(ctx,
+		"PUT", c.Endpoints.PushRuleEnabled(kind, ruleID), nil,
+		httputil.WithToken(), httputil.WithJSONBody(req),
+	)
+	if err != nil {
+		return fmt.Errorf("error setting push rule state: %w", matrix.MapAPIError(err, pushRuleErrors))
+	}
+	return nil
+}
+
+// PushRuleActions returns the actions of the push rule.
+//
+// ErrPushRuleNotFound is returned if the rule does not exist.
+func (c *Client) PushRuleActions(kind matrix.PushRuleKind, ruleID matrix.PushRuleID) (matrix.PushActions, error) {
+	return c.PushRuleActionsContext(c.Context(), kind, ruleID)
+}
+
+// PushRuleActionsContext is the same as PushRuleActions but takes a context.
+func (c *Client) PushRuleActionsContext(ctx context.Context, kind matrix.PushRuleKind,
+	ruleID matrix.PushRuleID) (matrix.PushActions, error) {
+	var resp struct {
+		Actions matrix.PushActions `json:"actions"`
+	}
+	err := c.RequestContext(ctx,
+		"GET", c.Endpoints.PushRuleActions(kind, ruleID), &resp,
+		httputil.WithToken(),
+	)
+	if err != nil {
+		return matrix.PushActions{}, fmt.Errorf("error fetching push rule actions: %w",
+			matrix.MapAPIError(err, pushRuleErrors))
+	}
+	return resp.Actions, nil
+}
+
+// PushRuleSetActions sets the actions of the push rule. Actions of server-default rules can be changed too.
+//
+// ErrPushRuleNotFound is returned if the rule does not exist.
+func (c *Client) PushRuleSetActions(kind matrix.PushRuleKind, ruleID matrix.PushRuleID,
+	actions matrix.PushActions) error {
+	return c.PushRuleSetActionsContext(c.Context(), kind, ruleID, actions)
+}
+
+// PushRuleSetActionsContext is the same as PushRuleSetActions but takes a context.
+func (c *Client) PushRuleSetActionsContext(ctx context.Context, kind matrix.PushRuleKind,
+	ruleID matrix.PushRuleID, actions matrix.PushActions) error {
+	req := map[string]matrix.PushActions{
+		"actions": actions,
+	}
+	err := c.RequestContext(ctx,
+		"PUT", c.Endpoints.PushRuleActions(kind, ruleID), nil,
+		httputil.WithToken(), httputil.WithJSONBody(req),
+	)
+	if err != nil {
+		return fmt.Errorf("error setting push rule actions: %w", matrix.MapAPIError(err, pushRuleErrors))
+	}
+	return nil
+}
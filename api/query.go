@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"strconv"
@@ -12,8 +13,14 @@ import (
 
 // RoomEvent fetches an event from the server with the provided room ID or event ID.
 func (c *Client) RoomEvent(roomID matrix.RoomID, eventID matrix.EventID) (event.RawEvent, error) {
+	return c.RoomEventContext(c.Context(), roomID, eventID)
+}
+
+// RoomEventContext is the same as RoomEvent but takes a context.
+func (c *Client) RoomEventContext(ctx context.Context, roomID matrix.RoomID,
+	eventID matrix.EventID) (event.RawEvent, error) {
 	var resp event.RawEvent
-	err := c.Request(
+	err := c.RequestContext(ctx,
 		"GET", c.Endpoints.RoomEvent(roomID, eventID), &resp,
 		httputil.WithToken(),
 	)
@@ -25,8 +32,14 @@ func (c *Client) RoomEvent(roomID matrix.RoomID, eventID matrix.EventID) (event.
 
 // RoomState fetches the latest state event for the provided state in the provided room.
 func (c *Client) RoomState(roomID matrix.RoomID, eventType event.Type, key string) (event.RawEvent, error) {
+	return c.RoomStateContext(c.Context(), roomID, eventType, key)
+}
+
+// RoomStateContext is the same as RoomState but takes a context.
+func (c *Client) RoomStateContext(ctx context.Context, roomID matrix.RoomID, eventType event.Type,
+	key string) (event.RawEvent, error) {
 	var content json.RawMessage
-	err := c.Request(
+	err := c.RequestContext(ctx,
 		"GET", c.Endpoints.RoomStateExact(roomID, eventType, key), &content,
 		httputil.WithToken(),
 	)
@@ -54,8 +67,13 @@ func (c *Client) RoomState(roomID matrix.RoomID, eventType event.Type, key strin
 // RoomStates fetches all the current state events of the provided room.
 // If the user has left the room, it returns the state before the user leaves.
 func (c *Client) RoomStates(roomID matrix.RoomID) ([]event.RawEvent, error) {
+	return c.RoomStatesContext(c.Context(), roomID)
+}
+
+// RoomStatesContext is the same as RoomStates but takes a context.
+func (c *Client) RoomStatesContext(ctx context.Context, roomID matrix.RoomID) ([]event.RawEvent, error) {
 	resp := []event.RawEvent{}
-	err := c.Request(
+	err := c.RequestContext(ctx,
 		"GET", c.Endpoints.RoomState(roomID), &resp,
 		httputil.WithToken(),
 	)
@@ -76,6 +94,12 @@ type RoomMemberFilter struct {
 // RoomMembers fetches the member list for a room from the homeserver.
 // The returned member list is in the form of an array of RoomMember events.
 func (c *Client) RoomMembers(roomID matrix.RoomID, filter RoomMemberFilter) ([]event.RawEvent, error) {
+	return c.RoomMembersContext(c.Context(), roomID, filter)
+}
+
+// RoomMembersContext is the same as RoomMembers but takes a context.
+func (c *Client) RoomMembersContext(ctx context.Context, roomID matrix.RoomID,
+	filter RoomMemberFilter) ([]event.RawEvent, error) {
 	var resp struct {
 		Chunk []event.RawEvent `json:"chunk,omitempty"`
 	}
@@ -91,7 +115,7 @@ func (c *Client) RoomMembers(roomID matrix.RoomID, filter RoomMemberFilter) ([]e
 		arg["not_membership"] = string(filter.NotMembership)
 	}
 
-	err := c.Request(
+	err := c.RequestContext(ctx,
 		"GET", c.Endpoints.RoomMembers(roomID), &resp,
 		httputil.WithToken(), httputil.WithQuery(arg),
 	)
@@ -109,8 +133,14 @@ type RoomMember struct {
 
 // RoomJoinedMembers fetches all the joined members and return them as a map of user ID to room member.
 func (c *Client) RoomJoinedMembers(roomID matrix.RoomID) (map[matrix.UserID]RoomMember, error) {
+	return c.RoomJoinedMembersContext(c.Context(), roomID)
+}
+
+// RoomJoinedMembersContext is the same as RoomJoinedMembers but takes a context.
+func (c *Client) RoomJoinedMembersContext(ctx context.Context,
+	roomID matrix.RoomID) (map[matrix.UserID]RoomMember, error) {
 	var resp map[matrix.UserID]RoomMember
-	err := c.Request(
+	err := c.RequestContext(ctx,
 		"GET", c.Endpoints.RoomJoinedMembers(roomID), &resp,
 		httputil.WithToken(),
 	)
@@ -149,6 +179,12 @@ type RoomMessagesResponse struct {
 
 // RoomMessages fetches the messages specified in the query range and return them.
 func (c *Client) RoomMessages(roomID matrix.RoomID, query RoomMessagesQuery) (RoomMessagesResponse, error) {
+	return c.RoomMessagesContext(c.Context(), roomID, query)
+}
+
+// RoomMessagesContext is the same as RoomMessages but takes a context.
+func (c *Client) RoomMessagesContext(ctx context.Context, roomID matrix.RoomID,
+	query RoomMessagesQuery) (RoomMessagesResponse, error) {
 	arg := map[string]string{
 		"from": query.From,
 		"dir":  string(query.Direction),
@@ -168,7 +204,7 @@ func (c *Client) RoomMessages(roomID matrix.RoomID, query RoomMessagesQuery) (Ro
 	}
 
 	var resp RoomMessagesResponse
-	err := c.Request(
+	err := c.RequestContext(ctx,
 		"GET", c.Endpoints.RoomMessages(roomID), &resp,
 		httputil.WithToken(), httputil.WithQuery(arg),
 	)
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/chanbakjsd/gotrix/matrix"
@@ -14,7 +15,13 @@ const ReceiptRead ReceiptType = "m.read"
 
 // ReceiptMarkerUpdate updates the location of receipt marker to the event ID specified.
 func (c *Client) ReceiptMarkerUpdate(roomID matrix.RoomID, receiptType ReceiptType, eventID matrix.EventID) error {
-	err := c.Request(
+	return c.ReceiptMarkerUpdateContext(c.Context(), roomID, receiptType, eventID)
+}
+
+// ReceiptMarkerUpdateContext is the same as ReceiptMarkerUpdate but takes a context.
+func (c *Client) ReceiptMarkerUpdateContext(ctx context.Context, roomID matrix.RoomID, receiptType ReceiptType,
+	eventID matrix.EventID) error {
+	err := c.RequestContext(ctx,
 		"POST", c.Endpoints.RoomReceipt(roomID, receiptType, eventID), nil,
 	)
 	if err != nil {
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 
@@ -32,27 +33,27 @@ type RegisterResponse struct {
 // It returns an InteractiveRegister object which should be used to interactively
 // fulfill authentication requirements of the server.
 func (c *Client) Register(kind string, req RegisterArg) (InteractiveRegister, error) {
-	ir := InteractiveRegister{
-		UserInteractiveAuthAPI: &UserInteractiveAuthAPI{},
-	}
+	return c.RegisterContext(c.Context(), kind, req)
+}
 
-	ir.Request = func(auth, to interface{}) error {
+// RegisterContext is the same as Register but takes a context.
+func (c *Client) RegisterContext(ctx context.Context, kind string, req RegisterArg) (InteractiveRegister, error) {
+	var ir InteractiveRegister
+	ir.UserInteractiveAuthAPI = c.newUserInteractiveAuthAPI(func(ctx context.Context, auth, to interface{}) error {
 		req.Auth = auth
-		err := c.Request(
+		err := c.RequestContext(ctx,
 			"POST", c.Endpoints.Register(), to,
 			httputil.WithJSONBody(req), httputil.WithQuery(map[string]string{
 				"kind": kind,
 			}),
 		)
 		return fmt.Errorf("error while registering: %w", err)
-	}
-
-	ir.RequestThreePID = func(authType string, auth, to interface{}) error {
-		return c.Request(
+	}, func(ctx context.Context, authType string, auth, to interface{}) error {
+		return c.RequestContext(ctx,
 			"POST", c.Endpoints.RegisterRequestToken(authType), to,
 			httputil.WithJSONBody(auth),
 		)
-	}
+	})
 
 	ir.SuccessCallback = func(json.RawMessage) error {
 		resp, err := ir.RegisterResponse()
@@ -69,7 +70,7 @@ func (c *Client) Register(kind string, req RegisterArg) (InteractiveRegister, er
 		return nil
 	}
 
-	err := ir.Auth(nil)
+	err := ir.AuthContext(ctx, nil)
 
 	return ir, err
 }
@@ -98,7 +99,12 @@ func (i InteractiveRegister) RegisterResponse() (*RegisterResponse, error) {
 // Clients should be aware that this might be racey as registration can take place
 // between UsernameAvailable() and actual registration.
 func (c *Client) UsernameAvailable(username string) (bool, error) {
-	err := c.Request(
+	return c.UsernameAvailableContext(c.Context(), username)
+}
+
+// UsernameAvailableContext is the same as UsernameAvailable but takes a context.
+func (c *Client) UsernameAvailableContext(ctx context.Context, username string) (bool, error) {
+	err := c.RequestContext(ctx,
 		"GET", c.Endpoints.RegisterAvailable(), nil,
 		httputil.WithQuery(map[string]string{
 			"username": username,
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/chanbakjsd/gotrix/api/httputil"
@@ -61,10 +62,15 @@ type RoomCreateInvite struct {
 // can view all messages as long as they were a member at some point. This might be undesirable and the client
 // should prompt the user to decide, sending RoomHistoryVisibilityEvent as necessary.
 func (c *Client) RoomCreate(arg RoomCreateArg) (matrix.RoomID, error) {
+	return c.RoomCreateContext(c.Context(), arg)
+}
+
+// RoomCreateContext is the same as RoomCreate but takes a context.
+func (c *Client) RoomCreateContext(ctx context.Context, arg RoomCreateArg) (matrix.RoomID, error) {
 	resp := &struct {
 		RoomID matrix.RoomID `json:"room_id"`
 	}{}
-	err := c.Request(
+	err := c.RequestContext(ctx,
 		"POST", c.Endpoints.RoomCreate(), resp,
 		httputil.WithToken(), httputil.WithJSONBody(arg),
 	)
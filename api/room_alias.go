@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/chanbakjsd/gotrix/api/httputil"
@@ -15,8 +16,13 @@ type RoomAliasResponse struct {
 
 // RoomAlias fetches information about a room alias.
 func (c *Client) RoomAlias(alias string) (RoomAliasResponse, error) {
+	return c.RoomAliasContext(c.Context(), alias)
+}
+
+// RoomAliasContext is the same as RoomAlias but takes a context.
+func (c *Client) RoomAliasContext(ctx context.Context, alias string) (RoomAliasResponse, error) {
 	var resp RoomAliasResponse
-	err := c.Request(
+	err := c.RequestContext(ctx,
 		"GET", c.Endpoints.DirectoryRoomAlias(alias), &resp,
 		httputil.WithToken(),
 	)
@@ -28,10 +34,15 @@ func (c *Client) RoomAlias(alias string) (RoomAliasResponse, error) {
 
 // RoomAliases fetches all alias of a given room.
 func (c *Client) RoomAliases(roomID matrix.RoomID) ([]string, error) {
+	return c.RoomAliasesContext(c.Context(), roomID)
+}
+
+// RoomAliasesContext is the same as RoomAliases but takes a context.
+func (c *Client) RoomAliasesContext(ctx context.Context, roomID matrix.RoomID) ([]string, error) {
 	var resp struct {
 		Aliases []string `json:"aliases"`
 	}
-	err := c.Request(
+	err := c.RequestContext(ctx,
 		"GET", c.Endpoints.RoomAliases(roomID), &resp,
 		httputil.WithToken(),
 	)
@@ -43,12 +54,17 @@ func (c *Client) RoomAliases(roomID matrix.RoomID) ([]string, error) {
 
 // RoomAliasCreate creates a room alias.
 func (c *Client) RoomAliasCreate(alias string, roomID matrix.RoomID) error {
+	return c.RoomAliasCreateContext(c.Context(), alias, roomID)
+}
+
+// RoomAliasCreateContext is the same as RoomAliasCreate but takes a context.
+func (c *Client) RoomAliasCreateContext(ctx context.Context, alias string, roomID matrix.RoomID) error {
 	req := struct {
 		RoomID matrix.RoomID `json:"room_id"`
 	}{
 		RoomID: roomID,
 	}
-	err := c.Request(
+	err := c.RequestContext(ctx,
 		"PUT", c.Endpoints.DirectoryRoomAlias(alias), nil,
 		httputil.WithToken(), httputil.WithJSONBody(req),
 	)
@@ -60,7 +76,12 @@ func (c *Client) RoomAliasCreate(alias string, roomID matrix.RoomID) error {
 
 // RoomAliasDelete deletes a room alias.
 func (c *Client) RoomAliasDelete(alias string) error {
-	err := c.Request(
+	return c.RoomAliasDeleteContext(c.Context(), alias)
+}
+
+// RoomAliasDeleteContext is the same as RoomAliasDelete but takes a context.
+func (c *Client) RoomAliasDeleteContext(ctx context.Context, alias string) error {
+	err := c.RequestContext(ctx,
 		"DELETE", c.Endpoints.DirectoryRoomAlias(alias), nil,
 		httputil.WithToken(),
 	)
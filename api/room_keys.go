@@ -0,0 +1,245 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/chanbakjsd/gotrix/api/httputil"
+	"github.com/chanbakjsd/gotrix/matrix"
+)
+
+// KeyBackupVersion is a version of the server-side backup of room keys.
+type KeyBackupVersion struct {
+	Version   string `json:"version"`
+	Algorithm string `json:"algorithm"`
+	// AuthData is the algorithm-dependent data used to verify and encrypt to the backup.
+	AuthData json.RawMessage `json:"auth_data"`
+	// Count is the number of keys stored in the backup.
+	Count int `json:"count"`
+	// ETag changes whenever the keys stored in the backup change.
+	ETag string `json:"etag"`
+}
+
+// KeyBackupData is a Megolm session stored in the key backup.
+type KeyBackupData struct {
+	FirstMessageIndex uint32 `json:"first_message_index"`
+	// ForwardedCount is the number of times the session has been forwarded to us.
+	ForwardedCount int  `json:"forwarded_count"`
+	IsVerified     bool `json:"is_verified"`
+	// SessionData is the algorithm-dependent encrypted session.
+	SessionData json.RawMessage `json:"session_data"`
+}
+
+// RoomKeyBackup contains the sessions of a room stored in the key backup, indexed by their session ID.
+type RoomKeyBackup struct {
+	Sessions map[string]KeyBackupData `json:"sessions"`
+}
+
+// KeyBackup contains the sessions stored in the key backup, indexed by their room ID.
+type KeyBackup struct {
+	Rooms map[matrix.RoomID]RoomKeyBackup `json:"rooms"`
+}
+
+// RoomKeysUpdateResponse is the state of the key backup after keys have been uploaded or deleted.
+type RoomKeysUpdateResponse struct {
+	Count int    `json:"count"`
+	ETag  string `json:"etag"`
+}
+
+// RoomKeysVersionCreate creates a new key backup version and returns its version.
+func (c *Client) RoomKeysVersionCreate(algorithm string, authData interface{}) (string, error) {
+	return c.RoomKeysVersionCreateContext(c.Context(), algorithm, authData)
+}
+
+// RoomKeysVersionCreateContext is the same as RoomKeysVersionCreate but takes a context.
+func (c *Client) RoomKeysVersionCreateContext(ctx context.Context, algorithm string,
+	authData interface{}) (string, error) {
+	req := map[string]interface{}{
+		"algorithm": algorithm,
+		"auth_data": authData,
+	}
+	var resp struct {
+		Version string `json:"version"`
+	}
+	err := c.RequestContext(ctx,
+		"POST", c.Endpoints.RoomKeysVersion(), &resp,
+		httputil.WithToken(), httputil.WithJSONBody(req),
+	)
+	if err != nil {
+		return "", fmt.Errorf("error creating key backup version: %w", err)
+	}
+	return resp.Version, nil
+}
+
+// RoomKeysVersion returns the information of the key backup version. The latest version is returned if
+// version is empty.
+//
+// An error wrapping matrix.CodeNotFound is returned if there is no such version.
+func (c *Client) RoomKeysVersion(version string) (*KeyBackupVersion, error) {
+	return c.RoomKeysVersionContext(c.Context(), version)
+}
+
+// RoomKeysVersionContext is the same as RoomKeysVersion but takes a context.
+func (c *Client) RoomKeysVersionContext(ctx context.Context, version string) (*KeyBackupVersion, error) {
+	route := c.Endpoints.RoomKeysVersion()
+	if version != "" {
+		route = c.Endpoints.RoomKeysVersionID(version)
+	}
+	resp := &KeyBackupVersion{}
+	err := c.RequestContext(ctx,
+		"GET", route, resp,
+		httputil.WithToken(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching key backup version: %w", err)
+	}
+	return resp, nil
+}
+
+// RoomKeysVersionUpdate updates the auth data of the key backup version. The algorithm must match the one
+// of the version.
+func (c *Client) RoomKeysVersionUpdate(version, algorithm string, authData interface{}) error {
+	return c.RoomKeysVersionUpdateContext(c.Context(), version, algorithm, authData)
+}
+
+// RoomKeysVersionUpdateContext is the same as RoomKeysVersionUpdate but takes a context.
+func (c *Client) RoomKeysVersionUpdateContext(ctx context.Context, version, algorithm string,
+	authData interface{}) error {
+	req := map[string]interface{}{
+		"algorithm": algorithm,
+		"auth_data": authData,
+		"version":   version,
+	}
+	err := c.RequestContext(ctx,
+		"PUT", c.Endpoints.RoomKeysVersionID(version), nil,
+		httputil.WithToken(), httputil.WithJSONBody(req),
+	)
+	if err != nil {
+		return fmt.Errorf("error updating key backup version: %w", err)
+	}
+	return nil
+}
+
+// RoomKeysVersionDelete deletes the key backup version along with the keys stored in it.
+func (c *Client) RoomKeysVersionDelete(version string) error {
+	return c.RoomKeysVersionDeleteContext(c.Context(), version)
+}
+
+// RoomKeysVersionDeleteContext is the same as RoomKeysVersionDelete but takes a context.
+func (c *Client) RoomKeysVersionDeleteContext(ctx context.Context, version string) error {
+	err := c.RequestContext(ctx,
+		"DELETE", c.Endpoints.RoomKeysVersionID(version), nil,
+		httputil.WithToken(),
+	)
+	if err != nil {
+		return fmt.Errorf("error deleting key backup version: %w", err)
+	}
+	return nil
+}
+
+// RoomKeysUpload stores the sessions into the key backup version. Sessions that are already stored are
+// only replaced if the new session is better.
+func (c *Client) RoomKeysUpload(version string, backup *KeyBackup) (*RoomKeysUpdateResponse, error) {
+	return c.RoomKeysUploadContext(c.Context(), version, backup)
+}
+
+// RoomKeysUploadContext is the same as RoomKeysUpload but takes a context.
+func (c *Client) RoomKeysUploadContext(ctx context.Context, version string,
+	backup *KeyBackup) (*RoomKeysUpdateResponse, error) {
+	resp := &RoomKeysUpdateResponse{}
+	err := c.RequestContext(ctx,
+		"PUT", c.Endpoints.RoomKeys(), resp,
+		httputil.WithToken(), httputil.WithJSONBody(backup), httputil.WithQuery(map[string]string{
+			"version": version,
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error uploading room keys: %w", err)
+	}
+	return resp, nil
+}
+
+// RoomKeys returns all sessions stored in the key backup version.
+func (c *Client) RoomKeys(version string) (*KeyBackup, error) {
+	return c.RoomKeysContext(c.Context(), version)
+}
+
+// RoomKeysContext is the same as RoomKeys but takes a context.
+func (c *Client) RoomKeysContext(ctx context.Context, version string) (*KeyBackup, error) {
+	resp := &KeyBackup{}
+	err := c.RequestContext(ctx,
+		"GET", c.Endpoints.RoomKeys(), resp,
+		httputil.WithToken(), httputil.WithQuery(map[string]string{
+			"version": version,
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching room keys: %w", err)
+	}
+	return resp, nil
+}
+
+// RoomKeysRoom returns the sessions of the room stored in the key backup version.
+func (c *Client) RoomKeysRoom(version string, roomID matrix.RoomID) (*RoomKeyBackup, error) {
+	return c.RoomKeysRoomContext(c.Context(), version, roomID)
+}
+
+// RoomKeysRoomContext is the same as RoomKeysRoom but takes a context.
+func (c *Client) RoomKeysRoomContext(ctx context.Context, version string,
+	roomID matrix.RoomID) (*RoomKeyBackup, error) {
+	resp := &RoomKeyBackup{}
+	err := c.RequestContext(ctx,
+		"GET", c.Endpoints.RoomKeysRoom(roomID), resp,
+		httputil.WithToken(), httputil.WithQuery(map[string]string{
+			"version": version,
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching room keys of room: %w", err)
+	}
+	return resp, nil
+}
+
+// RoomKeysSession returns a single session stored in the key backup version.
+//
+// An error wrapping matrix.CodeNotFound is returned if the session is not in the backup.
+func (c *Client) RoomKeysSession(version string, roomID matrix.RoomID, sessionID string) (*KeyBackupData, error) {
+	return c.RoomKeysSessionContext(c.Context(), version, roomID, sessionID)
+}
+
+// RoomKeysSessionContext is the same as RoomKeysSession but takes a context.
+func (c *Client) RoomKeysSessionContext(ctx context.Context, version string, roomID matrix.RoomID,
+	sessionID string) (*KeyBackupData, error) {
+	resp := &KeyBackupData{}
+	err := c.RequestContext(ctx,
+		"GET", c.Endpoints.RoomKeysSession(roomID, sessionID), resp,
+		httputil.WithToken(), httputil.WithQuery(map[string]string{
+			"version": version,
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching room key: %w", err)
+	}
+	return resp, nil
+}
+
+// RoomKeysDelete deletes all sessions stored in the key backup version.
+func (c *Client) RoomKeysDelete(version string) (*RoomKeysUpdateResponse, error) {
+	return c.RoomKeysDeleteContext(c.Context(), version)
+}
+
+// RoomKeysDeleteContext is the same as RoomKeysDelete but takes a context.
+func (c *Client) RoomKeysDeleteContext(ctx context.Context, version string) (*RoomKeysUpdateResponse, error) {
+	resp := &RoomKeysUpdateResponse{}
+	err := c.RequestContext(ctx,
+		"DELETE", c.Endpoints.RoomKeys(), resp,
+		httputil.WithToken(), httputil.WithQuery(map[string]string{
+			"version": version,
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error deleting room keys: %w", err)
+	}
+	return resp, nil
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"fmt"
 	"strconv"
 
@@ -10,11 +11,16 @@ import (
 
 // RoomVisibility returns the visibility of a room on the server's public room directory.
 func (c *Client) RoomVisibility(roomID matrix.RoomID) (RoomVisibility, error) {
+	return c.RoomVisibilityContext(c.Context(), roomID)
+}
+
+// RoomVisibilityContext is the same as RoomVisibility but takes a context.
+func (c *Client) RoomVisibilityContext(ctx context.Context, roomID matrix.RoomID) (RoomVisibility, error) {
 	var resp struct {
 		Visibility RoomVisibility `json:"visibility"`
 	}
 
-	err := c.Request("GET", c.Endpoints.DirectoryListRoom(roomID), &resp)
+	err := c.RequestContext(ctx, "GET", c.Endpoints.DirectoryListRoom(roomID), &resp)
 	if err != nil {
 		return "", fmt.Errorf("error fetching room visibility: %w", err)
 	}
@@ -23,11 +29,17 @@ func (c *Client) RoomVisibility(roomID matrix.RoomID) (RoomVisibility, error) {
 
 // RoomVisibilitySet sets the visibility of a room on the server's public room directory.
 func (c *Client) RoomVisibilitySet(roomID matrix.RoomID, newVisibility RoomVisibility) error {
+	return c.RoomVisibilitySetContext(c.Context(), roomID, newVisibility)
+}
+
+// RoomVisibilitySetContext is the same as RoomVisibilitySet but takes a context.
+func (c *Client) RoomVisibilitySetContext(ctx context.Context, roomID matrix.RoomID,
+	newVisibility RoomVisibility) error {
 	req := struct {
 		Visibility RoomVisibility `json:"visibility"`
 	}{newVisibility}
 
-	err := c.Request(
+	err := c.RequestContext(ctx,
 		"GET", c.Endpoints.DirectoryListRoom(roomID), nil,
 		httputil.WithToken(), httputil.WithJSONBody(req),
 	)
@@ -68,6 +80,12 @@ type PublicRoomsResponse struct {
 // 'since' should be a token returned in PublicRoomsResponse.
 // 'server' defaults to the homeserver if it is an empty string.
 func (c *Client) PublicRooms(limit int, since string, server string) (PublicRoomsResponse, error) {
+	return c.PublicRoomsContext(c.Context(), limit, since, server)
+}
+
+// PublicRoomsContext is the same as PublicRooms but takes a context.
+func (c *Client) PublicRoomsContext(ctx context.Context, limit int, since string,
+	server string) (PublicRoomsResponse, error) {
 	req := map[string]string{}
 	if limit != 0 {
 		req["limit"] = strconv.Itoa(limit)
@@ -80,7 +98,7 @@ func (c *Client) PublicRooms(limit int, since string, server string) (PublicRoom
 	}
 
 	var resp PublicRoomsResponse
-	err := c.Request(
+	err := c.RequestContext(ctx,
 		"GET", c.Endpoints.PublicRooms(), &resp,
 		httputil.WithQuery(req),
 	)
@@ -107,6 +125,11 @@ type PublicRoomsSearchArg struct {
 
 // PublicRoomsSearch is equivalent to PublicRooms except it allows the user to provide filters to narrow search result.
 func (c *Client) PublicRoomsSearch(arg PublicRoomsSearchArg) (PublicRoomsResponse, error) {
+	return c.PublicRoomsSearchContext(c.Context(), arg)
+}
+
+// PublicRoomsSearchContext is the same as PublicRoomsSearch but takes a context.
+func (c *Client) PublicRoomsSearchContext(ctx context.Context, arg PublicRoomsSearchArg) (PublicRoomsResponse, error) {
 	req := map[string]string{}
 	if arg.Limit != 0 {
 		req["limit"] = strconv.Itoa(arg.Limit)
@@ -119,7 +142,7 @@ func (c *Client) PublicRoomsSearch(arg PublicRoomsSearchArg) (PublicRoomsRespons
 	}
 
 	var resp PublicRoomsResponse
-	err := c.Request(
+	err := c.RequestContext(ctx,
 		"POST", c.Endpoints.PublicRooms(), &resp,
 		httputil.WithQuery(req), httputil.WithJSONBody(arg),
 	)
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/chanbakjsd/gotrix/api/httputil"
@@ -9,10 +10,15 @@ import (
 
 // Rooms returns a list of the user's current rooms.
 func (c *Client) Rooms() ([]matrix.RoomID, error) {
+	return c.RoomsContext(c.Context())
+}
+
+// RoomsContext is the same as Rooms but takes a context.
+func (c *Client) RoomsContext(ctx context.Context) ([]matrix.RoomID, error) {
 	var resp struct {
 		JoinedRooms []matrix.RoomID `json:"joined_rooms"`
 	}
-	err := c.Request(
+	err := c.RequestContext(ctx,
 		"GET", c.Endpoints.JoinedRooms(), &resp,
 		httputil.WithToken(),
 	)
@@ -25,11 +31,16 @@ func (c *Client) Rooms() ([]matrix.RoomID, error) {
 // Invite invites the requested user to the specified room ID.
 // To not specify a reason, pass an empty string to the reason parameter.
 func (c *Client) Invite(roomID matrix.RoomID, userID matrix.UserID, reason string) error {
+	return c.InviteContext(c.Context(), roomID, userID, reason)
+}
+
+// InviteContext is the same as Invite but takes a context.
+func (c *Client) InviteContext(ctx context.Context, roomID matrix.RoomID, userID matrix.UserID, reason string) error {
 	body := struct {
 		UserID matrix.UserID `json:"user_id"`
 		Reason string        `json:"reason,omitempty"`
 	}{userID, reason}
-	err := c.Request(
+	err := c.RequestContext(ctx,
 		"POST", c.Endpoints.RoomInvite(roomID), nil,
 		httputil.WithToken(), httputil.WithJSONBody(body),
 	)
@@ -42,10 +53,15 @@ func (c *Client) Invite(roomID matrix.RoomID, userID matrix.UserID, reason strin
 // RoomJoin joins the specified room ID.
 // To not specify a reason, pass an empty string to the reason parameter.
 func (c *Client) RoomJoin(roomID matrix.RoomID, reason string) error {
+	return c.RoomJoinContext(c.Context(), roomID, reason)
+}
+
+// RoomJoinContext is the same as RoomJoin but takes a context.
+func (c *Client) RoomJoinContext(ctx context.Context, roomID matrix.RoomID, reason string) error {
 	body := struct {
 		Reason string `json:"reason,omitempty"`
 	}{reason}
-	err := c.Request(
+	err := c.RequestContext(ctx,
 		"POST", c.Endpoints.RoomJoin(roomID), nil,
 		httputil.WithToken(), httputil.WithJSONBody(body),
 	)
@@ -60,10 +76,15 @@ func (c *Client) RoomJoin(roomID matrix.RoomID, reason string) error {
 // RoomLeave leaves the specified room ID.
 // To not specify a reason, pass an empty string to the reason parameter.
 func (c *Client) RoomLeave(roomID matrix.RoomID, reason string) error {
+	return c.RoomLeaveContext(c.Context(), roomID, reason)
+}
+
+// RoomLeaveContext is the same as RoomLeave but takes a context.
+func (c *Client) RoomLeaveContext(ctx context.Context, roomID matrix.RoomID, reason string) error {
 	body := struct {
 		Reason string `json:"reason,omitempty"`
 	}{reason}
-	err := c.Request(
+	err := c.RequestContext(ctx,
 		"POST", c.Endpoints.RoomLeave(roomID), nil,
 		httputil.WithToken(), httputil.WithJSONBody(body),
 	)
@@ -74,7 +95,12 @@ func (c *Client) RoomLeave(roomID matrix.RoomID, reason string) error {
 // room. This allows the homeserver to delete the room if every previous member forgets it.
 // The client must not be in the room when RoomForget is called.
 func (c *Client) RoomForget(roomID matrix.RoomID) error {
-	err := c.Request(
+	return c.RoomForgetContext(c.Context(), roomID)
+}
+
+// RoomForgetContext is the same as RoomForget but takes a context.
+func (c *Client) RoomForgetContext(ctx context.Context, roomID matrix.RoomID) error {
+	err := c.RequestContext(ctx,
 		"POST", c.Endpoints.RoomForget(roomID), nil,
 		httputil.WithToken(),
 	)
@@ -86,12 +112,17 @@ func (c *Client) RoomForget(roomID matrix.RoomID) error {
 
 // Kick kicks the user from the provided room.
 func (c *Client) Kick(roomID matrix.RoomID, userID matrix.UserID, reason string) error {
+	return c.KickContext(c.Context(), roomID, userID, reason)
+}
+
+// KickContext is the same as Kick but takes a context.
+func (c *Client) KickContext(ctx context.Context, roomID matrix.RoomID, userID matrix.UserID, reason string) error {
 	param := struct {
 		UserID matrix.UserID `json:"user_id"`
 		Reason string        `json:"reason,omitempty"`
 	}{userID, reason}
 
-	err := c.Request(
+	err := c.RequestContext(ctx,
 		"POST", c.Endpoints.RoomKick(roomID), nil,
 		httputil.WithToken(), httputil.WithJSONBody(param),
 	)
@@ -103,12 +134,17 @@ func (c *Client) Kick(roomID matrix.RoomID, userID matrix.UserID, reason string)
 
 // Ban bans the user from the provided room.
 func (c *Client) Ban(roomID matrix.RoomID, userID matrix.UserID, reason string) error {
+	return c.BanContext(c.Context(), roomID, userID, reason)
+}
+
+// BanContext is the same as Ban but takes a context.
+func (c *Client) BanContext(ctx context.Context, roomID matrix.RoomID, userID matrix.UserID, reason string) error {
 	param := struct {
 		UserID matrix.UserID `json:"user_id"`
 		Reason string        `json:"reason,omitempty"`
 	}{userID, reason}
 
-	err := c.Request(
+	err := c.RequestContext(ctx,
 		"POST", c.Endpoints.RoomBan(roomID), nil,
 		httputil.WithToken(), httputil.WithJSONBody(param),
 	)
@@ -121,12 +157,17 @@ func (c *Client) Ban(roomID matrix.RoomID, userID matrix.UserID, reason string)
 // Unban unbans the user from the provided room.
 // To not specify a reason, pass an empty string to the reason parameter.
 func (c *Client) Unban(roomID matrix.RoomID, userID matrix.UserID, reason string) error {
+	return c.UnbanContext(c.Context(), roomID, userID, reason)
+}
+
+// UnbanContext is the same as Unban but takes a context.
+func (c *Client) UnbanContext(ctx context.Context, roomID matrix.RoomID, userID matrix.UserID, reason string) error {
 	param := struct {
 		UserID matrix.UserID `json:"user_id"`
 		Reason string        `json:"reason,omitempty"`
 	}{userID, reason}
 
-	err := c.Request(
+	err := c.RequestContext(ctx,
 		"POST", c.Endpoints.RoomUnban(roomID), nil,
 		httputil.WithToken(), httputil.WithJSONBody(param),
 	)
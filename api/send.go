@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/chanbakjsd/gotrix/api/httputil"
@@ -17,10 +18,16 @@ type RoomStateSendArg struct {
 
 // RoomStateSend sends the provided state event to the provided room ID.
 func (c *Client) RoomStateSend(roomID matrix.RoomID, event RoomStateSendArg) (matrix.EventID, error) {
+	return c.RoomStateSendContext(c.Context(), roomID, event)
+}
+
+// RoomStateSendContext is the same as RoomStateSend but takes a context.
+func (c *Client) RoomStateSendContext(ctx context.Context, roomID matrix.RoomID,
+	event RoomStateSendArg) (matrix.EventID, error) {
 	var resp struct {
 		EventID matrix.EventID `json:"event_id"`
 	}
-	err := c.Request(
+	err := c.RequestContext(ctx,
 		"PUT", c.Endpoints.RoomStateExact(roomID, event.Type, event.StateKey), &resp,
 		httputil.WithToken(), httputil.WithJSONBody(event.Content),
 	)
@@ -32,11 +39,17 @@ func (c *Client) RoomStateSend(roomID matrix.RoomID, event RoomStateSendArg) (ma
 
 // RoomEventSend sends the provided one-off event to the provided room ID.
 func (c *Client) RoomEventSend(roomID matrix.RoomID, eventType event.Type, body interface{}) (matrix.EventID, error) {
+	return c.RoomEventSendContext(c.Context(), roomID, eventType, body)
+}
+
+// RoomEventSendContext is the same as RoomEventSend but takes a context.
+func (c *Client) RoomEventSendContext(ctx context.Context, roomID matrix.RoomID, eventType event.Type,
+	body interface{}) (matrix.EventID, error) {
 	var resp struct {
 		EventID matrix.EventID `json:"event_id"`
 	}
 
-	err := c.Request(
+	err := c.RequestContext(ctx,
 		"PUT", c.Endpoints.RoomSend(roomID, eventType, NextTransactionID()), &resp,
 		httputil.WithToken(), httputil.WithJSONBody(body),
 	)
@@ -49,6 +62,12 @@ func (c *Client) RoomEventSend(roomID matrix.RoomID, eventType event.Type, body
 // RoomEventRedact redacts a room event as specified by the room ID and event ID.
 // A user can redact events they sent out or other people's event provided they have the power level to.
 func (c *Client) RoomEventRedact(roomID matrix.RoomID, eventID matrix.EventID, reason string) (matrix.EventID, error) {
+	return c.RoomEventRedactContext(c.Context(), roomID, eventID, reason)
+}
+
+// RoomEventRedactContext is the same as RoomEventRedact but takes a context.
+func (c *Client) RoomEventRedactContext(ctx context.Context, roomID matrix.RoomID, eventID matrix.EventID,
+	reason string) (matrix.EventID, error) {
 	req := struct {
 		Reason string `json:"reason,omitempty"`
 	}{reason}
@@ -56,7 +75,7 @@ func (c *Client) RoomEventRedact(roomID matrix.RoomID, eventID matrix.EventID, r
 		EventID matrix.EventID `json:"event_id"`
 	}
 
-	err := c.Request(
+	err := c.RequestContext(ctx,
 		"PUT", c.Endpoints.RoomRedact(roomID, eventID, NextTransactionID()), &resp,
 		httputil.WithToken(), httputil.WithJSONBody(req),
 	)
@@ -71,11 +90,16 @@ type DeviceMessages map[matrix.UserID]map[matrix.DeviceID]interface{}
 
 // SendToDevice sends the provided event to the specified devices.
 func (c *Client) SendToDevice(eventType event.Type, messages DeviceMessages) error {
+	return c.SendToDeviceContext(c.Context(), eventType, messages)
+}
+
+// SendToDeviceContext is the same as SendToDevice but takes a context.
+func (c *Client) SendToDeviceContext(ctx context.Context, eventType event.Type, messages DeviceMessages) error {
 	body := map[string]interface{}{
 		"messages": messages,
 	}
 
-	err := c.Request(
+	err := c.RequestContext(ctx,
 		"PUT", c.Endpoints.SendToDevice(eventType, NextTransactionID()), nil,
 		httputil.WithToken(), httputil.WithJSONBody(body),
 	)
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"fmt"
 	"strconv"
 
@@ -28,6 +29,8 @@ type SyncResponse struct {
 	ToDevice               SyncEvents      `json:"to_device,omitempty"`
 	DeviceLists            SyncDeviceLists `json:"device_lists,omitempty"`
 	DeviceOneTimeKeysCount map[string]int  `json:"device_one_time_keys_count,omitempty"`
+	// DeviceUnusedFallbackKeyTypes is nil if the homeserver does not support fallback keys.
+	DeviceUnusedFallbackKeyTypes []string `json:"device_unused_fallback_key_types,omitempty"`
 }
 
 // SyncRoomEvents consists of events that are tied to specific rooms (like messages and typing
@@ -94,12 +97,17 @@ type SyncEvents struct {
 // SyncDeviceLists is a list of users who has their encryption keys changed (added or modified)
 // or deleted (Left).
 type SyncDeviceLists struct {
-	Changed []matrix.UserID
-	Left    []matrix.UserID
+	Changed []matrix.UserID `json:"changed,omitempty"`
+	Left    []matrix.UserID `json:"left,omitempty"`
 }
 
 // Sync requests the latest state changes from the server.
 func (c *Client) Sync(req SyncArg) (*SyncResponse, error) {
+	return c.SyncContext(c.Context(), req)
+}
+
+// SyncContext is the same as Sync but takes a context.
+func (c *Client) SyncContext(ctx context.Context, req SyncArg) (*SyncResponse, error) {
 	resp := &SyncResponse{}
 	args := make(map[string]string)
 	if req.Filter != "" {
@@ -117,7 +125,7 @@ func (c *Client) Sync(req SyncArg) (*SyncResponse, error) {
 	if req.Timeout != 0 {
 		args["timeout"] = strconv.Itoa(req.Timeout)
 	}
-	err := c.Request(
+	err := c.RequestContext(ctx,
 		"GET", c.Endpoints.Sync(), resp,
 		httputil.WithToken(), httputil.WithQuery(args),
 	)
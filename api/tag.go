@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"errors"
 	"fmt"
 
@@ -12,10 +13,15 @@ var ErrInvalidTagName = errors.New("tag name must not exceed 255 bytes")
 
 // Tags retrives the tags for a room.
 func (c *Client) Tags(roomID matrix.RoomID) (map[matrix.TagName]matrix.Tag, error) {
+	return c.TagsContext(c.Context(), roomID)
+}
+
+// TagsContext is the same as Tags but takes a context.
+func (c *Client) TagsContext(ctx context.Context, roomID matrix.RoomID) (map[matrix.TagName]matrix.Tag, error) {
 	var resp struct {
 		Tags map[matrix.TagName]matrix.Tag `json:"tags"`
 	}
-	err := c.Request(
+	err := c.RequestContext(ctx,
 		"GET", c.Endpoints.Tags(c.UserID, roomID), &resp,
 		httputil.WithToken(),
 	)
@@ -27,10 +33,16 @@ func (c *Client) Tags(roomID matrix.RoomID) (map[matrix.TagName]matrix.Tag, erro
 
 // TagAdd adds a room tag.
 func (c *Client) TagAdd(roomID matrix.RoomID, name matrix.TagName, tagData matrix.Tag) error {
+	return c.TagAddContext(c.Context(), roomID, name, tagData)
+}
+
+// TagAddContext is the same as TagAdd but takes a context.
+func (c *Client) TagAddContext(ctx context.Context, roomID matrix.RoomID, name matrix.TagName,
+	tagData matrix.Tag) error {
 	if len([]byte(name)) > 255 {
 		return ErrInvalidTagName
 	}
-	err := c.Request(
+	err := c.RequestContext(ctx,
 		"PUT", c.Endpoints.Tag(c.UserID, roomID, name), nil,
 		httputil.WithToken(), httputil.WithJSONBody(tagData),
 	)
@@ -42,10 +54,15 @@ func (c *Client) TagAdd(roomID matrix.RoomID, name matrix.TagName, tagData matri
 
 // TagDelete removes a room tag.
 func (c *Client) TagDelete(roomID matrix.RoomID, name matrix.TagName) error {
+	return c.TagDeleteContext(c.Context(), roomID, name)
+}
+
+// TagDeleteContext is the same as TagDelete but takes a context.
+func (c *Client) TagDeleteContext(ctx context.Context, roomID matrix.RoomID, name matrix.TagName) error {
 	if len([]byte(name)) > 255 {
 		return ErrInvalidTagName
 	}
-	err := c.Request(
+	err := c.RequestContext(ctx,
 		"DELETE", c.Endpoints.Tag(c.UserID, roomID, name), nil,
 		httputil.WithToken(),
 	)
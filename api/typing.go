@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -11,12 +12,17 @@ import (
 // TypingStart notifies the server that the user is typing in a specific room.
 // It should be repeated while the user is typing with preferably a few seconds of safety margin from timeout.
 func (c *Client) TypingStart(roomID matrix.RoomID, timeout time.Duration) error {
+	return c.TypingStartContext(c.Context(), roomID, timeout)
+}
+
+// TypingStartContext is the same as TypingStart but takes a context.
+func (c *Client) TypingStartContext(ctx context.Context, roomID matrix.RoomID, timeout time.Duration) error {
 	req := map[string]interface{}{
 		"typing":  true,
 		"timeout": timeout / time.Millisecond,
 	}
 
-	err := c.Request(
+	err := c.RequestContext(ctx,
 		"PUT", c.Endpoints.RoomTyping(roomID, c.UserID), nil,
 		httputil.WithToken(), httputil.WithJSONBody(req),
 	)
@@ -28,11 +34,16 @@ func (c *Client) TypingStart(roomID matrix.RoomID, timeout time.Duration) error
 
 // TypingStop notifies the server that the user has stopped typing in a specific room.
 func (c *Client) TypingStop(roomID matrix.RoomID) error {
+	return c.TypingStopContext(c.Context(), roomID)
+}
+
+// TypingStopContext is the same as TypingStop but takes a context.
+func (c *Client) TypingStopContext(ctx context.Context, roomID matrix.RoomID) error {
 	req := map[string]interface{}{
 		"typing": false,
 	}
 
-	err := c.Request(
+	err := c.RequestContext(ctx,
 		"PUT", c.Endpoints.RoomTyping(roomID, c.UserID), nil,
 		httputil.WithToken(), httputil.WithJSONBody(req),
 	)
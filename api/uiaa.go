@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -42,18 +43,84 @@ type UserInteractiveAuthAPI struct {
 
 	// Request is the function to call to make a request.
 	// RequestThreePID is the function called to request a token. It passes the type of 3PID to the function.
+	//
+	// They are only called if RequestContext and RequestThreePIDContext respectively are nil.
 	Request         func(req, to interface{}) error                  `json:"-"`
 	RequestThreePID func(authType string, req, to interface{}) error `json:"-"`
 	SuccessCallback func(json.RawMessage) error                      `json:"-"`
 
+	// RequestContext and RequestThreePIDContext are the same as Request and RequestThreePID but take
+	// the context of the request.
+	RequestContext         func(ctx context.Context, req, to interface{}) error                  `json:"-"`
+	RequestThreePIDContext func(ctx context.Context, authType string, req, to interface{}) error `json:"-"`
+
+	// defaultContext returns the context used by methods that do not take one. It is the Context
+	// method of the client that started the interactive auth.
+	defaultContext func() context.Context
+
 	// Result is the result after everything succeeds.
 	result *json.RawMessage
 }
 
+// newUserInteractiveAuthAPI creates a UserInteractiveAuthAPI that uses the provided functions to make requests.
+// requestThreePID may be nil if the API call does not support 3PID auth.
+func (c *Client) newUserInteractiveAuthAPI(request func(ctx context.Context, req, to interface{}) error,
+	requestThreePID func(ctx context.Context, authType string, req, to interface{}) error) *UserInteractiveAuthAPI {
+	u := &UserInteractiveAuthAPI{
+		RequestContext: request,
+		Request: func(req, to interface{}) error {
+			return request(c.Context(), req, to)
+		},
+		defaultContext: c.Context,
+	}
+	if requestThreePID != nil {
+		u.RequestThreePIDContext = requestThreePID
+		u.RequestThreePID = func(authType string, req, to interface{}) error {
+			return requestThreePID(c.Context(), authType, req, to)
+		}
+	}
+	return u
+}
+
+// context returns the context follow-up requests should use by default.
+func (u *UserInteractiveAuthAPI) context() context.Context {
+	if u.defaultContext == nil {
+		return context.Background()
+	}
+	return u.defaultContext()
+}
+
+// request makes a request with RequestContext, or Request if it is nil.
+func (u *UserInteractiveAuthAPI) request(ctx context.Context, req, to interface{}) error {
+	if u.RequestContext != nil {
+		return u.RequestContext(ctx, req, to)
+	}
+	return u.Request(req, to)
+}
+
+// canRequestThreePID returns true if a 3PID token can be requested.
+func (u *UserInteractiveAuthAPI) canRequestThreePID() bool {
+	return u.RequestThreePIDContext != nil || u.RequestThreePID != nil
+}
+
+// requestThreePID requests a 3PID token with RequestThreePIDContext, or RequestThreePID if it is nil.
+func (u *UserInteractiveAuthAPI) requestThreePID(ctx context.Context, authType string, req, to interface{}) error {
+	if u.RequestThreePIDContext != nil {
+		return u.RequestThreePIDContext(ctx, authType, req, to)
+	}
+	return u.RequestThreePID(authType, req, to)
+}
+
 // Auth attempts to authenticate using the provided information in an attempt to progress in the authentication.
+// It uses the context of the client that started the interactive auth.
 func (u *UserInteractiveAuthAPI) Auth(req interface{}) error {
+	return u.AuthContext(u.context(), req)
+}
+
+// AuthContext is the same as Auth but takes a context.
+func (u *UserInteractiveAuthAPI) AuthContext(ctx context.Context, req interface{}) error {
 	var rawMsg json.RawMessage
-	err := u.Request(req, &rawMsg)
+	err := u.request(ctx, req, &rawMsg)
 	return u.processResponse(rawMsg, err)
 }
 
@@ -80,6 +147,14 @@ func (u *UserInteractiveAuthAPI) processResponse(rawMsg json.RawMessage, reqErro
 		return fmt.Errorf("uiaa: Failed to unmarshal: %w", err)
 	}
 
+	// Keep the functions needed to continue the auth.
+	resp.Request = u.Request
+	resp.RequestThreePID = u.RequestThreePID
+	resp.SuccessCallback = u.SuccessCallback
+	resp.RequestContext = u.RequestContext
+	resp.RequestThreePIDContext = u.RequestThreePIDContext
+	resp.defaultContext = u.defaultContext
+
 	*u = *resp
 	return nil
 }
@@ -28,11 +28,11 @@ type RequestEmailTokenResponse struct {
 // for registration purposes.
 // It returns the session ID needed in 3PID auth and the submit URL (if applicable).
 func (u *UserInteractiveAuthAPI) RequestEmailToken(req RequestEmailTokenArg) (*RequestEmailTokenResponse, error) {
-	if u.RequestThreePID == nil {
+	if !u.canRequestThreePID() {
 		return nil, ErrUnsupportedAuthType
 	}
 	response := &RequestEmailTokenResponse{}
-	err := u.RequestThreePID("email", req, response)
+	err := u.requestThreePID(u.context(), "email", req, response)
 	if err != nil {
 		return nil, fmt.Errorf("uiaa: error requesting email token: %w", err)
 	}
@@ -59,11 +59,11 @@ type RequestPhoneTokenResponse struct {
 // for registration purposes.
 // It returns the session ID needed in 3PID auth and the submit URL (if applicable).
 func (u *UserInteractiveAuthAPI) RequestPhoneToken(req RequestPhoneTokenArg) (*RequestPhoneTokenResponse, error) {
-	if u.RequestThreePID == nil {
+	if !u.canRequestThreePID() {
 		return nil, ErrUnsupportedAuthType
 	}
 	response := &RequestPhoneTokenResponse{}
-	err := u.RequestThreePID("phone", req, response)
+	err := u.requestThreePID(u.context(), "phone", req, response)
 	if err != nil {
 		return nil, fmt.Errorf("uiaa: error requesting phone token: %w", err)
 	}
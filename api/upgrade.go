@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/chanbakjsd/gotrix/api/httputil"
@@ -9,13 +10,19 @@ import (
 
 // UpgradeRoom creates a new room linked to the specified room with the specified new version.
 func (c *Client) UpgradeRoom(roomID matrix.RoomID, newVersion string) (matrix.RoomID, error) {
+	return c.UpgradeRoomContext(c.Context(), roomID, newVersion)
+}
+
+// UpgradeRoomContext is the same as UpgradeRoom but takes a context.
+func (c *Client) UpgradeRoomContext(ctx context.Context, roomID matrix.RoomID,
+	newVersion string) (matrix.RoomID, error) {
 	req := map[string]string{
 		"new_version": newVersion,
 	}
 	var resp struct {
 		ReplacementRoom matrix.RoomID `json:"replacement_room"`
 	}
-	err := c.Request(
+	err := c.RequestContext(ctx,
 		"POST", c.Endpoints.RoomUpgrade(roomID), &resp,
 		httputil.WithToken(), httputil.WithJSONBody(req),
 	)
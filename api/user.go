@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/chanbakjsd/gotrix/api/httputil"
@@ -17,6 +18,12 @@ type User struct {
 // UserSearch searches for users that match the keyword. The default limit is 10 if the zero value is provided.
 // 'limited' is true when the result is being limited by the provided limit.
 func (c *Client) UserSearch(keyword string, limit int) (result []User, limited bool, err error) {
+	return c.UserSearchContext(c.Context(), keyword, limit)
+}
+
+// UserSearchContext is the same as UserSearch but takes a context.
+func (c *Client) UserSearchContext(ctx context.Context, keyword string,
+	limit int) (result []User, limited bool, err error) {
 	req := struct {
 		SearchTerm string `json:"search_term"`
 		Limit      int    `json:"limit"`
@@ -29,7 +36,7 @@ func (c *Client) UserSearch(keyword string, limit int) (result []User, limited b
 		Limited bool   `json:"limited"`
 	}
 
-	err = c.Request(
+	err = c.RequestContext(ctx,
 		"POST", c.Endpoints.UserDirectorySearch(), &resp,
 		httputil.WithToken(), httputil.WithJSONBody(req),
 	)
@@ -42,10 +49,15 @@ func (c *Client) UserSearch(keyword string, limit int) (result []User, limited b
 
 // User returns the combined info of the provided user.
 func (c *Client) User(userID matrix.UserID) (User, error) {
+	return c.UserContext(c.Context(), userID)
+}
+
+// UserContext is the same as User but takes a context.
+func (c *Client) UserContext(ctx context.Context, userID matrix.UserID) (User, error) {
 	resp := User{
 		ID: userID,
 	}
-	err := c.Request(
+	err := c.RequestContext(ctx,
 		"GET", c.Endpoints.Profile(userID), &resp,
 	)
 	if err != nil {
@@ -56,10 +68,15 @@ func (c *Client) User(userID matrix.UserID) (User, error) {
 
 // DisplayName returns the display name of the provided user.
 func (c *Client) DisplayName(userID matrix.UserID) (*string, error) {
+	return c.DisplayNameContext(c.Context(), userID)
+}
+
+// DisplayNameContext is the same as DisplayName but takes a context.
+func (c *Client) DisplayNameContext(ctx context.Context, userID matrix.UserID) (*string, error) {
 	var resp struct {
 		DisplayName *string `json:"displayname,omitempty"`
 	}
-	err := c.Request(
+	err := c.RequestContext(ctx,
 		"GET", c.Endpoints.ProfileDisplayName(userID), &resp,
 	)
 	if err != nil {
@@ -70,11 +87,16 @@ func (c *Client) DisplayName(userID matrix.UserID) (*string, error) {
 
 // DisplayNameSet sets the display name of the provided user.
 func (c *Client) DisplayNameSet(displayName string) error {
+	return c.DisplayNameSetContext(c.Context(), displayName)
+}
+
+// DisplayNameSetContext is the same as DisplayNameSet but takes a context.
+func (c *Client) DisplayNameSetContext(ctx context.Context, displayName string) error {
 	req := map[string]string{
 		"displayname": displayName,
 	}
 
-	err := c.Request(
+	err := c.RequestContext(ctx,
 		"PUT", c.Endpoints.ProfileDisplayName(c.UserID), nil,
 		httputil.WithToken(), httputil.WithJSONBody(req),
 	)
@@ -86,10 +108,15 @@ func (c *Client) DisplayNameSet(displayName string) error {
 
 // AvatarURL returns the avatar URL of the provided user.
 func (c *Client) AvatarURL(userID matrix.UserID) (*matrix.URL, error) {
+	return c.AvatarURLContext(c.Context(), userID)
+}
+
+// AvatarURLContext is the same as AvatarURL but takes a context.
+func (c *Client) AvatarURLContext(ctx context.Context, userID matrix.UserID) (*matrix.URL, error) {
 	var resp struct {
 		AvatarURL *matrix.URL `json:"avatar_url,omitempty"`
 	}
-	err := c.Request(
+	err := c.RequestContext(ctx,
 		"GET", c.Endpoints.ProfileAvatarURL(userID), &resp,
 	)
 	if err != nil {
@@ -100,11 +127,16 @@ func (c *Client) AvatarURL(userID matrix.UserID) (*matrix.URL, error) {
 
 // AvatarURLSet sets the avatar URL of the provided user.
 func (c *Client) AvatarURLSet(avatarURL matrix.URL) error {
+	return c.AvatarURLSetContext(c.Context(), avatarURL)
+}
+
+// AvatarURLSetContext is the same as AvatarURLSet but takes a context.
+func (c *Client) AvatarURLSetContext(ctx context.Context, avatarURL matrix.URL) error {
 	req := map[string]interface{}{
 		"avatar_url": avatarURL,
 	}
 
-	err := c.Request(
+	err := c.RequestContext(ctx,
 		"PUT", c.Endpoints.ProfileAvatarURL(c.UserID), nil,
 		httputil.WithToken(), httputil.WithJSONBody(req),
 	)
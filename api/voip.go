@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/chanbakjsd/gotrix/api/httputil"
@@ -17,9 +18,14 @@ type TURNServersResponse struct {
 // TURNServers returns the list of TURN servers which clients can use to contact the remote party.
 // It may error if the homeserver doesn't support the VoIP module or if the request failed.
 func (c *Client) TURNServers() (TURNServersResponse, error) {
+	return c.TURNServersContext(c.Context())
+}
+
+// TURNServersContext is the same as TURNServers but takes a context.
+func (c *Client) TURNServersContext(ctx context.Context) (TURNServersResponse, error) {
 	var resp TURNServersResponse
-	err := c.Request(
-		"GET", c.Endpoints.VOIPTURNServers(), resp,
+	err := c.RequestContext(ctx,
+		"GET", c.Endpoints.VOIPTURNServers(), &resp,
 		httputil.WithToken(),
 	)
 	if err != nil {
@@ -1,13 +1,20 @@
 package gotrix
 
 import (
+	"context"
+
 	"github.com/chanbakjsd/gotrix/api"
 	"github.com/chanbakjsd/gotrix/matrix"
 )
 
 // LoginPassword authenticates the client using the provided username and password.
 func (c *Client) LoginPassword(username, password string) error {
-	return c.Login(api.LoginArg{
+	return c.LoginPasswordContext(c.Context(), username, password)
+}
+
+// LoginPasswordContext is the same as LoginPassword but takes a context.
+func (c *Client) LoginPasswordContext(ctx context.Context, username, password string) error {
+	return c.LoginContext(ctx, api.LoginArg{
 		Type: matrix.LoginPassword,
 		Identifier: matrix.Identifier{
 			Type: matrix.IdentifierUser,
@@ -19,7 +26,12 @@ func (c *Client) LoginPassword(username, password string) error {
 
 // LoginToken authenticates the client using the provided token.
 func (c *Client) LoginToken(token string) error {
-	return c.Login(api.LoginArg{
+	return c.LoginTokenContext(c.Context(), token)
+}
+
+// LoginTokenContext is the same as LoginToken but takes a context.
+func (c *Client) LoginTokenContext(ctx context.Context, token string) error {
+	return c.LoginContext(ctx, api.LoginArg{
 		Type:  matrix.LoginToken,
 		Token: token,
 	})
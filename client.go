@@ -3,13 +3,13 @@ package gotrix
 import (
 	"context"
 	"fmt"
-	"net/url"
 	"reflect"
 	"strings"
 
 	"github.com/chanbakjsd/gotrix/api"
 	"github.com/chanbakjsd/gotrix/api/httputil"
-	"github.com/chanbakjsd/gotrix/event"
+	"github.com/chanbakjsd/gotrix/encrypt/e2ee"
+	"github.com/chanbakjsd/gotrix/media"
 	"github.com/chanbakjsd/gotrix/state"
 )
 
@@ -20,8 +20,11 @@ type Client struct {
 	SyncOpts SyncOptions
 	Handler  Handler
 	State    State
+	// MediaCache caches the media downloaded by MediaDownload and MediaThumbnail if it is not nil.
+	MediaCache *media.Cache
+	// Crypto manages the end-to-end encryption keys of the device if encryption has been enabled.
+	Crypto *e2ee.Machine
 
-	ctx        context.Context
 	next       string
 	cancelFunc func()
 	closeDone  chan struct{}
@@ -29,28 +32,29 @@ type Client struct {
 
 // New creates a client with the provided host URL and the default HTTP client.
 // It assumes https if the scheme is not provided.
+//
+// The URL may include a path prefix (https://example.com/matrix) or point to a unix socket
+// (unix:///run/matrix.sock).
 func New(homeServerHost string) (*Client, error) {
 	return NewWithClient(httputil.NewClient(), homeServerHost)
 }
 
 // NewWithClient creates a client with the provided host URL and the provided client.
-// It assumes https if the scheme is not provided.
+// It assumes https if the scheme is not provided. A unix socket URL replaces the driver of the
+// provided client with httputil.UnixDriver.
 func NewWithClient(httpClient httputil.Client, serverName string) (*Client, error) {
 	if !strings.Contains(serverName, "://") {
 		// First is protocol while second is port.
 		serverName = "https://" + serverName
 	}
-	parsed, err := url.Parse(serverName)
-	if err != nil {
-		return nil, fmt.Errorf("cannot parse %s: %w", serverName, err)
-	}
 
 	apiClient := &api.Client{
 		Client:    httpClient,
 		Endpoints: api.Endpoints{Version: "r0"},
 	}
-	apiClient.HomeServer = parsed.Host
-	apiClient.HomeServerScheme = parsed.Scheme
+	if err := apiClient.SetBaseURL(serverName); err != nil {
+		return nil, fmt.Errorf("cannot parse %s: %w", serverName, err)
+	}
 
 	if vClient, err := apiClient.WithLatestVersion(); err == nil {
 		apiClient = vClient
@@ -60,15 +64,16 @@ func NewWithClient(httpClient httputil.Client, serverName string) (*Client, erro
 		Client:   apiClient,
 		SyncOpts: DefaultSyncOptions,
 		Handler: &defaultHandler{
-			handlers: make(map[event.Type][]reflect.Value),
+			handlers: make(map[reflect.Type][]handlerFunc),
 		},
 		State: state.NewDefault(),
 	}, nil
 }
 
 // WithContext creates a copy of the client that uses the provided context.
+//
+// Prefer the Context variant of the method instead as it does not copy the client.
 func (c Client) WithContext(ctx context.Context) *Client {
 	c.Client = c.Client.WithContext(ctx)
-	c.ctx = ctx
 	return &c
 }
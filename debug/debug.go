@@ -22,6 +22,9 @@ var (
 
 // Logger is the default logger called.
 // You can set it to redirect logs to other places.
+//
+// It is global to the process. To tell the logs of multiple clients apart, set the Logger field of
+// each client to a StructuredLogger instead.
 var Logger LoggerType = defaultLogger{}
 
 func init() {
@@ -29,6 +32,15 @@ func init() {
 	_, TraceEnabled = os.LookupEnv("GOMATRIX_TRACE")
 
 	DebugEnabled = DebugEnabled || TraceEnabled
+
+	switch {
+	case TraceEnabled:
+		DefaultLevel.Set(LevelTrace)
+	case DebugEnabled:
+		DefaultLevel.Set(LevelDebug)
+	default:
+		DefaultLevel.Set(LevelInfo)
+	}
 }
 
 // Trace calls Trace on the default Logger.
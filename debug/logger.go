@@ -20,6 +20,25 @@ var (
 
 type defaultLogger struct{}
 
+// writeColored writes the line in the color of the level without checking if the level is enabled.
+func writeColored(level Level, a interface{}) {
+	c := errorColor
+	switch level {
+	case LevelTrace:
+		c = traceColor
+	case LevelDebug:
+		c = debugColor
+	case LevelInfo:
+		c = infoColor
+	case LevelWarn:
+		c = warningColor
+	}
+
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	_, _ = c.Println(a)
+}
+
 func (defaultLogger) Trace(a interface{}) {
 	if TraceEnabled {
 		logMutex.Lock()
@@ -0,0 +1,69 @@
+package debug
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// FormatLine formats a line as the message followed by space-separated key=value pairs.
+// Values containing spaces or quotes are quoted.
+func FormatLine(msg string, fields []Field) string {
+	var sb strings.Builder
+	sb.WriteString(msg)
+	for _, f := range fields {
+		sb.WriteByte(' ')
+		sb.WriteString(f.Key)
+		sb.WriteByte('=')
+
+		value := fmt.Sprint(f.Value)
+		if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+			value = strconv.Quote(value)
+		}
+		sb.WriteString(value)
+	}
+	return sb.String()
+}
+
+// StdSink returns a Sink that writes to the provided standard library logger.
+// Each line is prefixed with its level.
+func StdSink(logger *log.Logger) Sink {
+	return stdSink{logger}
+}
+
+type stdSink struct {
+	logger *log.Logger
+}
+
+func (s stdSink) Write(level Level, msg string, fields []Field) {
+	s.logger.Print(level.String() + " " + FormatLine(msg, fields))
+}
+
+// defaultSink forwards structured lines to the global Logger.
+//
+// Level filtering has already been done by the StructuredLogger so lines are written by the
+// default colored logger even if the environment variables were not set.
+type defaultSink struct{}
+
+func (defaultSink) Write(level Level, msg string, fields []Field) {
+	line := FormatLine(msg, fields)
+
+	if _, ok := Logger.(defaultLogger); ok {
+		writeColored(level, line)
+		return
+	}
+
+	switch level {
+	case LevelTrace:
+		Logger.Trace(line)
+	case LevelDebug:
+		Logger.Debug(line)
+	case LevelInfo:
+		Logger.Info(line)
+	case LevelWarn:
+		Logger.Warn(line)
+	default:
+		Logger.Error(line)
+	}
+}
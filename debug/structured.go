@@ -0,0 +1,208 @@
+package debug
+
+import (
+	"sync/atomic"
+
+	"github.com/chanbakjsd/gotrix/matrix"
+)
+
+// Level is the severity of a log line.
+type Level int32
+
+// Levels from the most verbose to the least verbose.
+const (
+	LevelTrace Level = iota
+	LevelDebug
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// String returns the upper-case name of the level.
+func (l Level) String() string {
+	switch l {
+	case LevelTrace:
+		return "TRACE"
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// LevelVar is a Level that can be changed at runtime.
+// It is safe for concurrent use and its zero value is LevelTrace.
+type LevelVar struct {
+	level int32
+}
+
+// Level returns the current level.
+func (v *LevelVar) Level() Level {
+	return Level(atomic.LoadInt32(&v.level))
+}
+
+// Set changes the level. It takes effect on every StructuredLogger sharing the LevelVar.
+func (v *LevelVar) Set(level Level) {
+	atomic.StoreInt32(&v.level, int32(level))
+}
+
+// Field is a key-value pair attached to a log line.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Any creates a field with the provided key and value.
+func Any(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Err creates a field holding the provided error.
+func Err(err error) Field {
+	return Field{Key: "error", Value: err}
+}
+
+// UserID creates a field holding the provided user ID.
+func UserID(id matrix.UserID) Field {
+	return Field{Key: "user_id", Value: id}
+}
+
+// RoomID creates a field holding the provided room ID.
+func RoomID(id matrix.RoomID) Field {
+	return Field{Key: "room_id", Value: id}
+}
+
+// EventID creates a field holding the provided event ID.
+func EventID(id matrix.EventID) Field {
+	return Field{Key: "event_id", Value: id}
+}
+
+// RequestID creates a field holding the ID the HTTP client assigned to a request.
+func RequestID(id string) Field {
+	return Field{Key: "request_id", Value: id}
+}
+
+// Sink is the destination of structured log lines. It must be safe for concurrent use.
+type Sink interface {
+	Write(level Level, msg string, fields []Field)
+}
+
+// StructuredLogger is a leveled logger that attaches key-value fields to every line.
+//
+// A nil *StructuredLogger is valid and logs through Default.
+type StructuredLogger struct {
+	sink   Sink
+	level  *LevelVar
+	fields []Field
+}
+
+// DefaultLevel is the level of the Default logger. It is initialized from the GOMATRIX_DEBUG and
+// GOMATRIX_TRACE environment variables and can be changed at runtime.
+var DefaultLevel LevelVar
+
+var defaultStructured = &StructuredLogger{
+	sink:  defaultSink{},
+	level: &DefaultLevel,
+}
+
+// Default returns the logger used when no logger has been provided. It writes to the global Logger
+// and is filtered by DefaultLevel.
+func Default() *StructuredLogger {
+	return defaultStructured
+}
+
+// NewStructuredLogger creates a logger that writes lines at or above the level of levelVar to the
+// provided sink. The level can be changed at runtime through levelVar.
+func NewStructuredLogger(sink Sink, levelVar *LevelVar) *StructuredLogger {
+	if levelVar == nil {
+		levelVar = &LevelVar{}
+		levelVar.Set(LevelInfo)
+	}
+	return &StructuredLogger{
+		sink:  sink,
+		level: levelVar,
+	}
+}
+
+// With creates a child logger that attaches the provided fields to every line in addition to the
+// fields of l. The child shares the sink and level of l.
+func (l *StructuredLogger) With(fields ...Field) *StructuredLogger {
+	if l == nil {
+		l = Default()
+	}
+	if len(fields) == 0 {
+		return l
+	}
+
+	merged := make([]Field, 0, len(l.fields)+len(fields))
+	merged = append(merged, l.fields...)
+	merged = append(merged, fields...)
+	return &StructuredLogger{
+		sink:   l.sink,
+		level:  l.level,
+		fields: merged,
+	}
+}
+
+// LevelVar returns the LevelVar controlling l.
+func (l *StructuredLogger) LevelVar() *LevelVar {
+	if l == nil {
+		return Default().level
+	}
+	return l.level
+}
+
+// Enabled returns true if lines of the provided level are written.
+// It can be used to skip expensive work before logging.
+func (l *StructuredLogger) Enabled(level Level) bool {
+	return level >= l.LevelVar().Level()
+}
+
+// Log writes a line with the provided level, message and fields.
+func (l *StructuredLogger) Log(level Level, msg string, fields ...Field) {
+	if l == nil {
+		l = Default()
+	}
+	if !l.Enabled(level) {
+		return
+	}
+
+	all := fields
+	if len(l.fields) > 0 {
+		all = make([]Field, 0, len(l.fields)+len(fields))
+		all = append(all, l.fields...)
+		all = append(all, fields...)
+	}
+	l.sink.Write(level, msg, all)
+}
+
+// Trace logs msg with LevelTrace.
+func (l *StructuredLogger) Trace(msg string, fields ...Field) {
+	l.Log(LevelTrace, msg, fields...)
+}
+
+// Debug logs msg with LevelDebug.
+func (l *StructuredLogger) Debug(msg string, fields ...Field) {
+	l.Log(LevelDebug, msg, fields...)
+}
+
+// Info logs msg with LevelInfo.
+func (l *StructuredLogger) Info(msg string, fields ...Field) {
+	l.Log(LevelInfo, msg, fields...)
+}
+
+// Warn logs msg with LevelWarn.
+func (l *StructuredLogger) Warn(msg string, fields ...Field) {
+	l.Log(LevelWarn, msg, fields...)
+}
+
+// Error logs msg with LevelError.
+func (l *StructuredLogger) Error(msg string, fields ...Field) {
+	l.Log(LevelError, msg, fields...)
+}
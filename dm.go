@@ -1,6 +1,7 @@
 package gotrix
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/chanbakjsd/gotrix/matrix"
@@ -9,13 +10,18 @@ import (
 // MarkRoomAsDM fetches the DM room list, appends the provided room and reuploads the list.
 // It is the caller's duty to make sure only one instance is called at once.
 func (c *Client) MarkRoomAsDM(remoteID matrix.UserID, roomID matrix.RoomID) error {
-	directEvent, err := c.DMRooms()
+	return c.MarkRoomAsDMContext(c.Context(), remoteID, roomID)
+}
+
+// MarkRoomAsDMContext is the same as MarkRoomAsDM but takes a context.
+func (c *Client) MarkRoomAsDMContext(ctx context.Context, remoteID matrix.UserID, roomID matrix.RoomID) error {
+	directEvent, err := c.DMRoomsContext(ctx)
 	if err != nil {
 		return fmt.Errorf("error while marking room as DM: %w", err)
 	}
 
 	directEvent.Rooms[remoteID] = append(directEvent.Rooms[remoteID], roomID)
-	err = c.DMRoomsSet(directEvent)
+	err = c.DMRoomsSetContext(ctx, directEvent)
 	if err != nil {
 		return fmt.Errorf("error while marking room as DM: %w", err)
 	}
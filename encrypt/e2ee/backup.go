@@ -0,0 +1,466 @@
+package e2ee
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/chanbakjsd/gotrix/api"
+	"github.com/chanbakjsd/gotrix/debug"
+	"github.com/chanbakjsd/gotrix/encrypt/olm"
+	"github.com/chanbakjsd/gotrix/matrix"
+)
+
+// AlgorithmMegolmBackup is the key backup algorithm supported by the Machine.
+const AlgorithmMegolmBackup = "m.megolm_backup.v1.curve25519-aes-sha2"
+
+// Errors returned when using the key backup.
+var (
+	// ErrNoKeyBackup is returned when there is no key backup to use.
+	ErrNoKeyBackup = errors.New("no key backup")
+	// ErrBackupKeyMismatch is returned when the recovery key is not the key of the key backup.
+	ErrBackupKeyMismatch = errors.New("recovery key does not match the key backup")
+	// ErrUntrustedKeyBackup is returned when the key backup is not signed by a trusted device or by the
+	// trusted master key of the user.
+	ErrUntrustedKeyBackup = errors.New("key backup is not signed by a trusted key")
+)
+
+// backupBatchSize is the maximum number of sessions uploaded in a single request.
+const backupBatchSize = 100
+
+// KeyBackup is the server-side key backup version that room keys are uploaded to.
+type KeyBackup struct {
+	Version string
+	// PublicKey is the Curve25519 public key the sessions are encrypted to in base64.
+	PublicKey string
+	// Key is the private key of the backup. It is nil if it is not known by this device, in which case
+	// sessions can be uploaded but not restored.
+	Key *olm.PKDecryption
+}
+
+// backupAuthData is the auth data of a m.megolm_backup.v1.curve25519-aes-sha2 key backup.
+type backupAuthData struct {
+	PublicKey  string         `json:"public_key"`
+	Signatures api.Signatures `json:"signatures,omitempty"`
+}
+
+// backupSessionData is the plaintext of an encrypted session in the key backup.
+type backupSessionData struct {
+	Algorithm         string            `json:"algorithm"`
+	ForwardingChain   []string          `json:"forwarding_curve25519_key_chain"`
+	SenderClaimedKeys map[string]string `json:"sender_claimed_keys"`
+	SenderKey         string            `json:"sender_key"`
+	SessionKey        string            `json:"session_key"`
+}
+
+// KeyBackup returns the key backup room keys are uploaded to, or nil if key backup is not enabled.
+func (m *Machine) KeyBackup() *KeyBackup {
+	m.backupMu.Lock()
+	defer m.backupMu.Unlock()
+	return m.backup
+}
+
+// CreateKeyBackup creates a new key backup version and starts uploading room keys to it. The returned
+// recovery key is needed to restore the keys and should be shown to the user.
+func (m *Machine) CreateKeyBackup(ctx context.Context) (string, error) {
+	key, err := olm.NewPKDecryption()
+	if err != nil {
+		return "", fmt.Errorf("error generating backup key: %w", err)
+	}
+	authData := backupAuthData{PublicKey: key.PublicKey()}
+
+	m.mu.Lock()
+	authData.Signatures, err = signJSON(authData, m.account.Account, m.Client.UserID,
+		"ed25519:"+string(m.Client.DeviceID))
+	m.mu.Unlock()
+	if err != nil {
+		return "", err
+	}
+	// Signing with the master key allows other devices to trust the backup without verifying this device.
+	keys, err := m.Store.LoadCrossSigningKeys()
+	if err != nil {
+		return "", fmt.Errorf("error loading cross-signing keys: %w", err)
+	}
+	if keys != nil && keys.Master != nil {
+		signatures, err := signJSON(authData, keys.Master, m.Client.UserID, "ed25519:"+keys.Master.PublicKey())
+		if err != nil {
+			return "", err
+		}
+		authData.Signatures = mergeSignatures(authData.Signatures, signatures)
+	}
+
+	version, err := m.Client.RoomKeysVersionCreateContext(ctx, AlgorithmMegolmBackup, authData)
+	if err != nil {
+		return "", err
+	}
+	err = m.setKeyBackup(&KeyBackup{
+		Version:   version,
+		PublicKey: key.PublicKey(),
+		Key:       key,
+	})
+	if err != nil {
+		return "", err
+	}
+	return EncodeRecoveryKey(key.PrivateKey()), m.BackupRoomKeys(ctx)
+}
+
+// EnableKeyBackup starts uploading room keys to the latest key backup version. The backup must be signed by
+// this device, by another trusted device or by the trusted master key of the user.
+func (m *Machine) EnableKeyBackup(ctx context.Context) error {
+	info, authData, err := m.latestKeyBackup(ctx)
+	if err != nil {
+		return err
+	}
+	trusted, err := m.backupTrusted(authData)
+	if err != nil {
+		return err
+	}
+	if !trusted {
+		return ErrUntrustedKeyBackup
+	}
+
+	backup := &KeyBackup{
+		Version:   info.Version,
+		PublicKey: authData.PublicKey,
+	}
+	// Keep the private key if it is known.
+	if old := m.KeyBackup(); old != nil && old.Key != nil && old.PublicKey == authData.PublicKey {
+		backup.Key = old.Key
+	}
+	if err := m.setKeyBackup(backup); err != nil {
+		return err
+	}
+	return m.BackupRoomKeys(ctx)
+}
+
+// DisableKeyBackup stops uploading room keys to the key backup. The backup itself is kept on the homeserver.
+func (m *Machine) DisableKeyBackup() error {
+	return m.setKeyBackup(nil)
+}
+
+// RestoreKeyBackup imports the sessions stored in the latest key backup version using the recovery key and
+// returns the number of imported sessions. The key backup is then enabled as the recovery key proves that
+// it belongs to the user.
+func (m *Machine) RestoreKeyBackup(ctx context.Context, recoveryKey string) (int, error) {
+	private, err := DecodeRecoveryKey(recoveryKey)
+	if err != nil {
+		return 0, err
+	}
+	key, err := olm.NewPKDecryptionFromPrivateKey(private)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrBadRecoveryKey, err)
+	}
+	info, authData, err := m.latestKeyBackup(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if authData.PublicKey != key.PublicKey() {
+		return 0, ErrBackupKeyMismatch
+	}
+
+	backup := &KeyBackup{
+		Version:   info.Version,
+		PublicKey: authData.PublicKey,
+		Key:       key,
+	}
+	keys, err := m.Client.RoomKeysContext(ctx, info.Version)
+	if err != nil {
+		return 0, err
+	}
+	imported := 0
+	for roomID, room := range keys.Rooms {
+		for sessionID, data := range room.Sessions {
+			ok, err := m.restoreSession(backup, roomID, sessionID, data)
+			if err != nil {
+				m.Client.Logger.Warn("error restoring session from key backup", debug.Err(err),
+					debug.RoomID(roomID), debug.Any("session_id", sessionID))
+				continue
+			}
+			if ok {
+				imported++
+			}
+		}
+	}
+	m.Client.Logger.Debug("restored key backup", debug.Any("version", info.Version),
+		debug.Any("imported", imported))
+
+	if err := m.setKeyBackup(backup); err != nil {
+		return imported, err
+	}
+	return imported, m.BackupRoomKeys(ctx)
+}
+
+// restoreSession decrypts the session from the key backup and saves it unless a better session is known.
+func (m *Machine) restoreSession(backup *KeyBackup, roomID matrix.RoomID, sessionID string,
+	data api.KeyBackupData) (bool, error) {
+	var msg olm.PKMessage
+	if err := json.Unmarshal(data.SessionData, &msg); err != nil {
+		return false, fmt.Errorf("error decoding session data: %w", err)
+	}
+	plaintext, err := backup.Key.Decrypt(&msg)
+	if err != nil {
+		return false, fmt.Errorf("error decrypting session data: %w", err)
+	}
+	var payload backupSessionData
+	if err := json.Unmarshal(plaintext, &payload); err != nil {
+		return false, fmt.Errorf("error decoding session data: %w", err)
+	}
+	if payload.Algorithm != AlgorithmMegolm {
+		return false, ErrUnsupportedAlgorithm
+	}
+
+	olmSession, err := olm.ImportInboundGroupSession(payload.SessionKey)
+	if err != nil {
+		return false, fmt.Errorf("error importing inbound group session: %w", err)
+	}
+	session := &InboundGroupSession{
+		InboundGroupSession: olmSession,
+		RoomID:              roomID,
+		SenderKey:           payload.SenderKey,
+		SigningKey:          payload.SenderClaimedKeys["ed25519"],
+		ForwardingChain:     payload.ForwardingChain,
+		BackupVersion:       backup.Version,
+		Imported:            true,
+	}
+	if session.ID() != sessionID {
+		return false, fmt.Errorf("%w: session ID does not match the session key", ErrPayloadMismatch)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.saveBetterSession(session)
+}
+
+// latestKeyBackup returns the latest key backup version and its auth data.
+func (m *Machine) latestKeyBackup(ctx context.Context) (*api.KeyBackupVersion, *backupAuthData, error) {
+	info, err := m.Client.RoomKeysVersionContext(ctx, "")
+	if err != nil {
+		if errors.Is(err, matrix.CodeNotFound) {
+			return nil, nil, ErrNoKeyBackup
+		}
+		return nil, nil, err
+	}
+	if info.Algorithm != AlgorithmMegolmBackup {
+		return nil, nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, info.Algorithm)
+	}
+	var authData backupAuthData
+	if err := json.Unmarshal(info.AuthData, &authData); err != nil {
+		return nil, nil, fmt.Errorf("error decoding key backup auth data: %w", err)
+	}
+	return info, &authData, nil
+}
+
+// backupTrusted returns true if the auth data is signed by a trusted device or master key of the user.
+func (m *Machine) backupTrusted(authData *backupAuthData) (bool, error) {
+	master, err := m.trustedMasterKey(m.Client.UserID)
+	if err != nil {
+		return false, err
+	}
+	if master != "" && VerifySignature(authData, authData.Signatures, m.Client.UserID, "ed25519:"+master,
+		master) == nil {
+		return true, nil
+	}
+
+	_, ed := m.IdentityKeys()
+	if VerifySignature(authData, authData.Signatures, m.Client.UserID, "ed25519:"+string(m.Client.DeviceID),
+		ed) == nil {
+		return true, nil
+	}
+	devices, err := m.Store.LoadDevices(m.Client.UserID)
+	if err != nil {
+		return false, fmt.Errorf("error loading devices: %w", err)
+	}
+	for _, device := range devices {
+		err := VerifySignature(authData, authData.Signatures, m.Client.UserID,
+			"ed25519:"+string(device.DeviceID), device.SigningKey)
+		if err != nil {
+			continue
+		}
+		trusted, err := m.DeviceTrusted(device)
+		if err != nil {
+			return false, err
+		}
+		if trusted {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// setKeyBackup saves the key backup and uploads sessions to it from now on. Sessions that have not been
+// uploaded to it are looked up the next time BackupRoomKeys is called.
+func (m *Machine) setKeyBackup(backup *KeyBackup) error {
+	m.backupMu.Lock()
+	defer m.backupMu.Unlock()
+	if err := m.Store.SaveKeyBackup(backup); err != nil {
+		return fmt.Errorf("error saving key backup: %w", err)
+	}
+	m.backup = backup
+	m.backupQueue = nil
+	m.backupScanned = false
+	return nil
+}
+
+// queueBackup queues the session to be uploaded to the key backup by the next BackupRoomKeys call.
+func (m *Machine) queueBackup(session *InboundGroupSession) {
+	m.backupMu.Lock()
+	defer m.backupMu.Unlock()
+	if m.backup != nil && m.backupScanned {
+		m.backupQueue = append(m.backupQueue, session)
+	}
+}
+
+// BackupRoomKeys uploads the sessions that have not been uploaded yet to the key backup. It does nothing if
+// key backup is not enabled. The client calls it after every sync.
+//
+// The key backup is disabled if it has been replaced by a new version.
+func (m *Machine) BackupRoomKeys(ctx context.Context) error {
+	m.backupMu.Lock()
+	backup := m.backup
+	queue := m.backupQueue
+	scan := !m.backupScanned
+	m.backupQueue = nil
+	m.backupScanned = true
+	m.backupMu.Unlock()
+	if backup == nil {
+		return nil
+	}
+
+	// Every session is checked the first time so that sessions received before the backup was enabled or
+	// before a restart are uploaded too.
+	if scan {
+		sessions, err := m.Store.LoadInboundGroupSessions()
+		if err != nil {
+			m.backupMu.Lock()
+			m.backupScanned = false
+			m.backupMu.Unlock()
+			return fmt.Errorf("error loading inbound group sessions: %w", err)
+		}
+		queue = append(queue, sessions...)
+	}
+
+	m.mu.Lock()
+	pending := make([]*InboundGroupSession, 0, len(queue))
+	for _, v := range queue {
+		if v.BackupVersion != backup.Version {
+			pending = append(pending, v)
+		}
+	}
+	m.mu.Unlock()
+	for len(pending) > 0 {
+		n := backupBatchSize
+		if n > len(pending) {
+			n = len(pending)
+		}
+		if err := m.uploadBackup(ctx, backup, pending[:n]); err != nil {
+			m.requeueBackup(backup, pending)
+			return err
+		}
+		pending = pending[n:]
+	}
+	return nil
+}
+
+// requeueBackup puts the sessions back into the queue after they failed to upload.
+func (m *Machine) requeueBackup(backup *KeyBackup, sessions []*InboundGroupSession) {
+	m.backupMu.Lock()
+	defer m.backupMu.Unlock()
+	if m.backup == backup {
+		m.backupQueue = append(sessions, m.backupQueue...)
+	}
+}
+
+// uploadBackup encrypts the sessions and uploads them to the key backup.
+func (m *Machine) uploadBackup(ctx context.Context, backup *KeyBackup, sessions []*InboundGroupSession) error {
+	encryption, err := olm.NewPKEncryption(backup.PublicKey)
+	if err != nil {
+		return fmt.Errorf("error creating backup encryption: %w", err)
+	}
+	ownKey, _ := m.IdentityKeys()
+
+	m.mu.Lock()
+	req := &api.KeyBackup{Rooms: make(map[matrix.RoomID]api.RoomKeyBackup)}
+	for _, session := range sessions {
+		data, err := backupData(encryption, session, ownKey)
+		if err != nil {
+			m.mu.Unlock()
+			return err
+		}
+		room, ok := req.Rooms[session.RoomID]
+		if !ok {
+			room = api.RoomKeyBackup{Sessions: make(map[string]api.KeyBackupData)}
+			req.Rooms[session.RoomID] = room
+		}
+		room.Sessions[session.ID()] = *data
+	}
+	m.mu.Unlock()
+
+	_, err = m.Client.RoomKeysUploadContext(ctx, backup.Version, req)
+	if err != nil {
+		if errors.Is(err, matrix.CodeWrongRoomKeysVersion) || errors.Is(err, matrix.CodeNotFound) {
+			m.Client.Logger.Warn("key backup has been replaced, disabling it", debug.Any("version", backup.Version))
+			if err := m.setKeyBackup(nil); err != nil {
+				m.Client.Logger.Warn("error disabling key backup", debug.Err(err))
+			}
+		}
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, v := range sessions {
+		// Reload the session as it may have been replaced since it was queued.
+		session, err := m.Store.LoadInboundGroupSession(v.RoomID, v.ID())
+		if err != nil {
+			return fmt.Errorf("error loading inbound group session: %w", err)
+		}
+		if session == nil || session.FirstKnownIndex() != v.FirstKnownIndex() {
+			continue
+		}
+		session.BackupVersion = backup.Version
+		if err := m.Store.SaveInboundGroupSession(session); err != nil {
+			return fmt.Errorf("error saving inbound group session: %w", err)
+		}
+	}
+	return nil
+}
+
+// backupData encrypts the session for the key backup.
+func backupData(encryption *olm.PKEncryption, session *InboundGroupSession,
+	ownKey string) (*api.KeyBackupData, error) {
+	index := session.FirstKnownIndex()
+	exported, err := session.Export(index)
+	if err != nil {
+		return nil, fmt.Errorf("error exporting inbound group session: %w", err)
+	}
+	chain := session.ForwardingChain
+	if chain == nil {
+		chain = []string{}
+	}
+	plaintext, err := json.Marshal(backupSessionData{
+		Algorithm:         AlgorithmMegolm,
+		ForwardingChain:   chain,
+		SenderClaimedKeys: map[string]string{"ed25519": session.SigningKey},
+		SenderKey:         session.SenderKey,
+		SessionKey:        exported,
+	})
+	if err != nil {
+		return nil, err
+	}
+	msg, err := encryption.Encrypt(plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("error encrypting session: %w", err)
+	}
+	sessionData, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	return &api.KeyBackupData{
+		FirstMessageIndex: index,
+		ForwardedCount:    len(session.ForwardingChain),
+		// Only sessions created by this device are known to come from a verified device.
+		IsVerified:  session.SenderKey == ownKey,
+		SessionData: sessionData,
+	}, nil
+}
@@ -0,0 +1,86 @@
+package e2ee
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/chanbakjsd/gotrix/api"
+	"github.com/chanbakjsd/gotrix/encrypt/olm"
+	"github.com/chanbakjsd/gotrix/matrix"
+)
+
+// CanonicalJSON encodes v into the canonical JSON used by Matrix to sign objects: object keys are sorted,
+// there is no insignificant whitespace and strings are not escaped more than necessary.
+func CanonicalJSON(v interface{}) ([]byte, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	// Decoding into interface{} turns objects into maps, which encoding/json encodes with sorted keys.
+	var decoded interface{}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&decoded); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(decoded); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// signedJSON returns the canonical JSON of v without its "signatures" and "unsigned" fields, which is the
+// message that is signed.
+func signedJSON(v interface{}) ([]byte, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return nil, fmt.Errorf("error signing JSON: %w", err)
+	}
+	delete(obj, "signatures")
+	delete(obj, "unsigned")
+	return CanonicalJSON(obj)
+}
+
+// signer is an Ed25519 key that can sign messages, such as *olm.Account and *olm.PKSigning.
+type signer interface {
+	Sign(message []byte) string
+}
+
+// signJSON signs v with the key and returns the signatures to attach to it. keyID is the ID of the key
+// ("ed25519:DEVICEID" for device keys and "ed25519:PUBLICKEY" for cross-signing keys).
+func signJSON(v interface{}, key signer, userID matrix.UserID, keyID string) (api.Signatures, error) {
+	message, err := signedJSON(v)
+	if err != nil {
+		return nil, err
+	}
+	return api.Signatures{
+		userID: {
+			keyID: key.Sign(message),
+		},
+	}, nil
+}
+
+// VerifySignature checks if v has been signed by the Ed25519 key (in base64) with the provided user ID and
+// key ID.
+func VerifySignature(v interface{}, signatures api.Signatures, userID matrix.UserID, keyID string,
+	key string) error {
+	signature, ok := signatures[userID][keyID]
+	if !ok {
+		return olm.ErrBadSignature
+	}
+	message, err := signedJSON(v)
+	if err != nil {
+		return err
+	}
+	return olm.VerifySignature(key, message, signature)
+}
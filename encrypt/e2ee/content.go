@@ -0,0 +1,43 @@
+package e2ee
+
+import (
+	"encoding/json"
+
+	"github.com/chanbakjsd/gotrix/event"
+	"github.com/chanbakjsd/gotrix/matrix"
+)
+
+// OlmEncryptedContent is the content of a m.room.encrypted to-device event encrypted with Olm.
+type OlmEncryptedContent struct {
+	Algorithm string `json:"algorithm"`
+	SenderKey string `json:"sender_key"`
+	// Ciphertext contains a message for each recipient device, indexed by their Curve25519 identity key.
+	Ciphertext map[string]event.OlmCiphertext `json:"ciphertext"`
+}
+
+// MegolmEncryptedContent is the content of a m.room.encrypted room event encrypted with Megolm.
+type MegolmEncryptedContent struct {
+	Algorithm  string          `json:"algorithm"`
+	SenderKey  string          `json:"sender_key"`
+	Ciphertext string          `json:"ciphertext"`
+	SessionID  string          `json:"session_id"`
+	DeviceID   matrix.DeviceID `json:"device_id"`
+}
+
+// olmPayload is the plaintext of an Olm message.
+type olmPayload struct {
+	Type          event.Type        `json:"type"`
+	Content       json.RawMessage   `json:"content"`
+	Sender        matrix.UserID     `json:"sender"`
+	SenderDevice  matrix.DeviceID   `json:"sender_device,omitempty"`
+	Recipient     matrix.UserID     `json:"recipient"`
+	RecipientKeys map[string]string `json:"recipient_keys"`
+	Keys          map[string]string `json:"keys"`
+}
+
+// megolmPayload is the plaintext of a Megolm message.
+type megolmPayload struct {
+	Type    event.Type      `json:"type"`
+	Content json.RawMessage `json:"content"`
+	RoomID  matrix.RoomID   `json:"room_id"`
+}
@@ -0,0 +1,412 @@
+package e2ee
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/chanbakjsd/gotrix/api"
+	"github.com/chanbakjsd/gotrix/debug"
+	"github.com/chanbakjsd/gotrix/encrypt/olm"
+	"github.com/chanbakjsd/gotrix/matrix"
+)
+
+// Errors returned when cross-signing.
+var (
+	// ErrNoCrossSigningKeys is returned when signing without the required private cross-signing key.
+	ErrNoCrossSigningKeys = errors.New("private cross-signing key is not available")
+	// ErrNoUserIdentity is returned when signing a user that has no cross-signing keys.
+	ErrNoUserIdentity = errors.New("user has no cross-signing keys")
+	// ErrSignatureRejected is returned when the homeserver rejects an uploaded signature.
+	ErrSignatureRejected = errors.New("signature has been rejected by the homeserver")
+)
+
+// CrossSigningKeys are the private cross-signing keys of the user. Keys that are not available on this device
+// are nil.
+type CrossSigningKeys struct {
+	// Master signs the other cross-signing keys.
+	Master *olm.PKSigning
+	// SelfSigning signs the devices of the user.
+	SelfSigning *olm.PKSigning
+	// UserSigning signs the master keys of other users.
+	UserSigning *olm.PKSigning
+}
+
+// UserIdentity contains the public cross-signing keys of a user. The self-signing and user-signing keys are
+// only kept if they are signed by the master key.
+type UserIdentity struct {
+	UserID      matrix.UserID        `json:"user_id"`
+	Master      api.CrossSigningKey  `json:"master"`
+	SelfSigning *api.CrossSigningKey `json:"self_signing,omitempty"`
+	// UserSigning is only known for the current user.
+	UserSigning *api.CrossSigningKey `json:"user_signing,omitempty"`
+	// Verified is true if the master key has been verified by the user through key verification.
+	Verified bool `json:"verified,omitempty"`
+}
+
+// MasterKey returns the public master key of the user in base64.
+func (u *UserIdentity) MasterKey() string {
+	return u.Master.PublicKey()
+}
+
+// identityFromKeys checks the cross-signing keys returned by the homeserver and converts them into a
+// UserIdentity. nil is returned if the user has no valid master key.
+func identityFromKeys(userID matrix.UserID, resp *api.KeysQueryResponse) *UserIdentity {
+	master, ok := resp.MasterKeys[userID]
+	if !ok || !validCrossSigningKey(userID, master, api.CrossSigningUsageMaster) {
+		return nil
+	}
+	identity := &UserIdentity{
+		UserID: userID,
+		Master: master,
+	}
+
+	masterKey := master.PublicKey()
+	signedByMaster := func(usage string, key api.CrossSigningKey) bool {
+		if !validCrossSigningKey(userID, key, usage) {
+			return false
+		}
+		return VerifySignature(key, key.Signatures, userID, "ed25519:"+masterKey, masterKey) == nil
+	}
+	if key, ok := resp.SelfSigningKeys[userID]; ok && signedByMaster(api.CrossSigningUsageSelfSigning, key) {
+		identity.SelfSigning = &key
+	}
+	if key, ok := resp.UserSigningKeys[userID]; ok && signedByMaster(api.CrossSigningUsageUserSigning, key) {
+		identity.UserSigning = &key
+	}
+	return identity
+}
+
+// validCrossSigningKey returns true if the key belongs to the user, has the provided usage and contains a
+// single Ed25519 key whose key ID matches.
+func validCrossSigningKey(userID matrix.UserID, key api.CrossSigningKey, usage string) bool {
+	if key.UserID != userID || !containsString(key.Usage, usage) || len(key.Keys) != 1 {
+		return false
+	}
+	public := key.PublicKey()
+	return public != "" && key.Keys["ed25519:"+public] == public
+}
+
+// newCrossSigningKey returns the public cross-signing key of the private key.
+func newCrossSigningKey(userID matrix.UserID, usage string, key *olm.PKSigning) api.CrossSigningKey {
+	public := key.PublicKey()
+	return api.CrossSigningKey{
+		UserID: userID,
+		Usage:  []string{usage},
+		Keys:   map[string]string{"ed25519:" + public: public},
+	}
+}
+
+// GenerateCrossSigningKeys generates new cross-signing keys and uploads them, replacing the existing ones.
+// Once they are uploaded, the private keys are saved into the store and this device is signed with the new
+// self-signing key.
+//
+// The homeserver may require interactive auth before replacing the keys. The returned
+// UserInteractiveAuthAPI should be used to fulfill the requirements. The context is also used to sign this
+// device once the interactive auth is complete.
+func (m *Machine) GenerateCrossSigningKeys(ctx context.Context) (*api.UserInteractiveAuthAPI, error) {
+	keys := &CrossSigningKeys{}
+	var err error
+	for _, v := range []**olm.PKSigning{&keys.Master, &keys.SelfSigning, &keys.UserSigning} {
+		*v, err = olm.NewPKSigning()
+		if err != nil {
+			return nil, fmt.Errorf("error generating cross-signing key: %w", err)
+		}
+	}
+
+	userID := m.Client.UserID
+	masterKeyID := "ed25519:" + keys.Master.PublicKey()
+	master := newCrossSigningKey(userID, api.CrossSigningUsageMaster, keys.Master)
+	selfSigning := newCrossSigningKey(userID, api.CrossSigningUsageSelfSigning, keys.SelfSigning)
+	userSigning := newCrossSigningKey(userID, api.CrossSigningUsageUserSigning, keys.UserSigning)
+
+	// The master key is signed by this device so that other devices can trust it after verifying us.
+	m.mu.Lock()
+	master.Signatures, err = signJSON(master, m.account.Account, userID, "ed25519:"+string(m.Client.DeviceID))
+	m.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if selfSigning.Signatures, err = signJSON(selfSigning, keys.Master, userID, masterKeyID); err != nil {
+		return nil, err
+	}
+	if userSigning.Signatures, err = signJSON(userSigning, keys.Master, userID, masterKeyID); err != nil {
+		return nil, err
+	}
+
+	uiaa, err := m.Client.KeysDeviceSigningUploadContext(ctx, api.KeysDeviceSigningUploadArg{
+		MasterKey:      &master,
+		SelfSigningKey: &selfSigning,
+		UserSigningKey: &userSigning,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	uploaded := func(json.RawMessage) error {
+		if err := m.Store.SaveCrossSigningKeys(keys); err != nil {
+			return fmt.Errorf("error saving cross-signing keys: %w", err)
+		}
+		m.deviceMu.Lock()
+		err := m.Store.SaveUserIdentity(&UserIdentity{
+			UserID:      userID,
+			Master:      master,
+			SelfSigning: &selfSigning,
+			UserSigning: &userSigning,
+		})
+		m.deviceMu.Unlock()
+		if err != nil {
+			return fmt.Errorf("error saving user identity: %w", err)
+		}
+		m.Client.Logger.Debug("uploaded cross-signing keys", debug.Any("master_key", keys.Master.PublicKey()))
+		return m.SignOwnDevice(ctx, m.Client.DeviceID)
+	}
+	if uiaa.IsComplete() {
+		return uiaa, uploaded(nil)
+	}
+	uiaa.SuccessCallback = uploaded
+	return uiaa, nil
+}
+
+// SetCrossSigningKeys saves the provided private cross-signing keys, such as keys fetched from secret
+// storage, into the store. Keys that are nil are kept unchanged.
+func (m *Machine) SetCrossSigningKeys(keys *CrossSigningKeys) error {
+	existing, err := m.Store.LoadCrossSigningKeys()
+	if err != nil {
+		return fmt.Errorf("error loading cross-signing keys: %w", err)
+	}
+	if existing == nil {
+		existing = &CrossSigningKeys{}
+	}
+	if keys.Master != nil {
+		existing.Master = keys.Master
+	}
+	if keys.SelfSigning != nil {
+		existing.SelfSigning = keys.SelfSigning
+	}
+	if keys.UserSigning != nil {
+		existing.UserSigning = keys.UserSigning
+	}
+	if err := m.Store.SaveCrossSigningKeys(existing); err != nil {
+		return fmt.Errorf("error saving cross-signing keys: %w", err)
+	}
+	return nil
+}
+
+// SignOwnDevice signs the device of the current user with the self-signing key and uploads the signature.
+func (m *Machine) SignOwnDevice(ctx context.Context, deviceID matrix.DeviceID) error {
+	keys, err := m.Store.LoadCrossSigningKeys()
+	if err != nil {
+		return fmt.Errorf("error loading cross-signing keys: %w", err)
+	}
+	if keys == nil || keys.SelfSigning == nil {
+		return ErrNoCrossSigningKeys
+	}
+
+	var deviceKeys api.DeviceKeys
+	if deviceID == m.Client.DeviceID {
+		ours, err := m.DeviceKeys()
+		if err != nil {
+			return err
+		}
+		deviceKeys = *ours
+	} else {
+		resp, err := m.Client.KeysQueryContext(ctx, map[matrix.UserID][]matrix.DeviceID{
+			m.Client.UserID: {deviceID},
+		}, 0)
+		if err != nil {
+			return err
+		}
+		var ok bool
+		deviceKeys, ok = resp.DeviceKeys[m.Client.UserID][deviceID]
+		if !ok {
+			return ErrUnknownDevice
+		}
+		if _, err := deviceFromKeys(m.Client.UserID, deviceID, deviceKeys); err != nil {
+			return err
+		}
+	}
+
+	deviceKeys.Signatures, err = signJSON(deviceKeys, keys.SelfSigning, m.Client.UserID,
+		"ed25519:"+keys.SelfSigning.PublicKey())
+	if err != nil {
+		return err
+	}
+	deviceKeys.Unsigned = nil
+	return m.uploadSignature(ctx, m.Client.UserID, string(deviceID), deviceKeys)
+}
+
+// SignUser signs the master key of another user with the user-signing key and uploads the signature.
+// The master key should have been verified beforehand.
+func (m *Machine) SignUser(ctx context.Context, userID matrix.UserID) error {
+	keys, err := m.Store.LoadCrossSigningKeys()
+	if err != nil {
+		return fmt.Errorf("error loading cross-signing keys: %w", err)
+	}
+	if keys == nil || keys.UserSigning == nil {
+		return ErrNoCrossSigningKeys
+	}
+
+	// Make sure that the identity is up to date.
+	if _, err := m.Devices(ctx, userID); err != nil {
+		return fmt.Errorf("error querying devices: %w", err)
+	}
+	identity, err := m.Store.LoadUserIdentity(userID)
+	if err != nil {
+		return fmt.Errorf("error loading user identity: %w", err)
+	}
+	if identity == nil {
+		return ErrNoUserIdentity
+	}
+
+	master := identity.Master
+	master.Signatures, err = signJSON(master, keys.UserSigning, m.Client.UserID,
+		"ed25519:"+keys.UserSigning.PublicKey())
+	if err != nil {
+		return err
+	}
+	if err := m.uploadSignature(ctx, userID, master.PublicKey(), master); err != nil {
+		return err
+	}
+
+	// Keep the signature so that the user is trusted without querying them again.
+	identity.Master.Signatures = mergeSignatures(identity.Master.Signatures, master.Signatures)
+	m.deviceMu.Lock()
+	defer m.deviceMu.Unlock()
+	if err := m.Store.SaveUserIdentity(identity); err != nil {
+		return fmt.Errorf("error saving user identity: %w", err)
+	}
+	return nil
+}
+
+// uploadSignature uploads the signed object of the user, identified by a device ID or a public key.
+func (m *Machine) uploadSignature(ctx context.Context, userID matrix.UserID, id string,
+	signed interface{}) error {
+	resp, err := m.Client.KeysSignaturesUploadContext(ctx, map[matrix.UserID]map[string]interface{}{
+		userID: {id: signed},
+	})
+	if err != nil {
+		return err
+	}
+	if failure, ok := resp.Failures[userID][id]; ok {
+		return fmt.Errorf("%w: %s", ErrSignatureRejected, failure)
+	}
+	return nil
+}
+
+// mergeSignatures returns the signatures of a and b combined.
+func mergeSignatures(a, b api.Signatures) api.Signatures {
+	merged := make(api.Signatures, len(a)+len(b))
+	for _, v := range []api.Signatures{a, b} {
+		for userID, signatures := range v {
+			if merged[userID] == nil {
+				merged[userID] = make(map[string]string)
+			}
+			for keyID, signature := range signatures {
+				merged[userID][keyID] = signature
+			}
+		}
+	}
+	return merged
+}
+
+// UserTrusted returns true if the master key of the user is trusted. The master key of the current user is
+// trusted if its private key is available or if it has been verified. The master key of other users is
+// trusted if it has been verified or signed by our trusted user-signing key.
+func (m *Machine) UserTrusted(userID matrix.UserID) (bool, error) {
+	identity, err := m.Store.LoadUserIdentity(userID)
+	if err != nil {
+		return false, fmt.Errorf("error loading user identity: %w", err)
+	}
+	if identity == nil {
+		return false, nil
+	}
+	return m.identityTrusted(identity)
+}
+
+// trustedMasterKey returns the master key of the user if it is trusted or an empty string otherwise.
+func (m *Machine) trustedMasterKey(userID matrix.UserID) (string, error) {
+	identity, err := m.Store.LoadUserIdentity(userID)
+	if err != nil {
+		return "", fmt.Errorf("error loading user identity: %w", err)
+	}
+	if identity == nil {
+		return "", nil
+	}
+	trusted, err := m.identityTrusted(identity)
+	if err != nil || !trusted {
+		return "", err
+	}
+	return identity.MasterKey(), nil
+}
+
+func (m *Machine) identityTrusted(identity *UserIdentity) (bool, error) {
+	if identity.Verified {
+		return true, nil
+	}
+
+	own, err := m.Store.LoadUserIdentity(m.Client.UserID)
+	if err != nil {
+		return false, fmt.Errorf("error loading user identity: %w", err)
+	}
+	if own == nil {
+		return false, nil
+	}
+	ownTrusted := own.Verified
+	if !ownTrusted {
+		keys, err := m.Store.LoadCrossSigningKeys()
+		if err != nil {
+			return false, fmt.Errorf("error loading cross-signing keys: %w", err)
+		}
+		ownTrusted = keys != nil && keys.Master != nil && keys.Master.PublicKey() == own.MasterKey()
+	}
+	if identity.UserID == m.Client.UserID {
+		return ownTrusted, nil
+	}
+	if !ownTrusted || own.UserSigning == nil {
+		return false, nil
+	}
+	userSigningKey := own.UserSigning.PublicKey()
+	err = VerifySignature(identity.Master, identity.Master.Signatures, m.Client.UserID,
+		"ed25519:"+userSigningKey, userSigningKey)
+	return err == nil, nil
+}
+
+// DeviceTrusted returns true if the device has been verified, or if it is signed by the self-signing key of
+// its user and the user is trusted.
+func (m *Machine) DeviceTrusted(device *Device) (bool, error) {
+	if device.Verified {
+		return true, nil
+	}
+	if device.CrossSignedBy == "" {
+		return false, nil
+	}
+	identity, err := m.Store.LoadUserIdentity(device.UserID)
+	if err != nil {
+		return false, fmt.Errorf("error loading user identity: %w", err)
+	}
+	if identity == nil || identity.SelfSigning == nil || identity.SelfSigning.PublicKey() != device.CrossSignedBy {
+		return false, nil
+	}
+	return m.identityTrusted(identity)
+}
+
+// setIdentityVerified marks the master key of the user as verified if it is still the provided key.
+func (m *Machine) setIdentityVerified(userID matrix.UserID, masterKey string) error {
+	m.deviceMu.Lock()
+	defer m.deviceMu.Unlock()
+
+	identity, err := m.Store.LoadUserIdentity(userID)
+	if err != nil {
+		return fmt.Errorf("error loading user identity: %w", err)
+	}
+	if identity == nil || identity.MasterKey() != masterKey {
+		return ErrNoUserIdentity
+	}
+	identity.Verified = true
+	if err := m.Store.SaveUserIdentity(identity); err != nil {
+		return fmt.Errorf("error saving user identity: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package e2ee
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/chanbakjsd/gotrix/api"
+	"github.com/chanbakjsd/gotrix/encrypt/olm"
+)
+
+func TestIdentityFromKeysUnknownFields(t *testing.T) {
+	master, err := olm.NewPKSigning()
+	if err != nil {
+		t.Fatalf("error generating master key: %v", err)
+	}
+	selfSigning, err := olm.NewPKSigning()
+	if err != nil {
+		t.Fatalf("error generating self-signing key: %v", err)
+	}
+
+	masterKey := newCrossSigningKey("@alice:example.org", api.CrossSigningUsageMaster, master)
+	selfSigningKey := newCrossSigningKey("@alice:example.org", api.CrossSigningUsageSelfSigning, selfSigning)
+	// Add a field unknown to api.CrossSigningKey before signing.
+	raw, err := json.Marshal(selfSigningKey)
+	if err != nil {
+		t.Fatalf("error encoding self-signing key: %v", err)
+	}
+	raw = append(raw[:len(raw)-1], `,"org.example.field":"value"}`...)
+	signatures, err := signJSON(json.RawMessage(raw), master, "@alice:example.org", "ed25519:"+master.PublicKey())
+	if err != nil {
+		t.Fatalf("error signing self-signing key: %v", err)
+	}
+
+	var resp api.KeysQueryResponse
+	respJSON, err := json.Marshal(map[string]interface{}{
+		"master_keys": map[string]interface{}{"@alice:example.org": masterKey},
+		"self_signing_keys": map[string]json.RawMessage{
+			"@alice:example.org": json.RawMessage(string(raw[:len(raw)-1]) + `,"signatures":` +
+				mustMarshal(t, signatures) + `}`),
+		},
+	})
+	if err != nil {
+		t.Fatalf("error encoding query response: %v", err)
+	}
+	if err := json.Unmarshal(respJSON, &resp); err != nil {
+		t.Fatalf("error decoding query response: %v", err)
+	}
+
+	identity := identityFromKeys("@alice:example.org", &resp)
+	if identity == nil || identity.SelfSigning == nil {
+		t.Fatalf("expected self-signing key to be accepted, got %+v", identity)
+	}
+
+	// The unknown field must survive saving and loading the identity.
+	saved, err := json.Marshal(identity)
+	if err != nil {
+		t.Fatalf("error encoding identity: %v", err)
+	}
+	var loaded UserIdentity
+	if err := json.Unmarshal(saved, &loaded); err != nil {
+		t.Fatalf("error decoding identity: %v", err)
+	}
+	err = VerifySignature(loaded.SelfSigning, loaded.SelfSigning.Signatures, "@alice:example.org",
+		"ed25519:"+master.PublicKey(), master.PublicKey())
+	if err != nil {
+		t.Errorf("unexpected error verifying loaded self-signing key: %v", err)
+	}
+}
+
+func mustMarshal(t *testing.T, v interface{}) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("error encoding JSON: %v", err)
+	}
+	return string(b)
+}
@@ -0,0 +1,398 @@
+package e2ee
+
+import (
+	"container/list"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/chanbakjsd/gotrix/debug"
+	"github.com/chanbakjsd/gotrix/encrypt/olm"
+	"github.com/chanbakjsd/gotrix/event"
+	"github.com/chanbakjsd/gotrix/matrix"
+)
+
+// Errors returned when decrypting events.
+var (
+	// ErrUnsupportedAlgorithm is returned when the event is encrypted with an unknown algorithm.
+	ErrUnsupportedAlgorithm = errors.New("unsupported encryption algorithm")
+	// ErrNotForThisDevice is returned when an Olm encrypted event does not contain a message for this device.
+	ErrNotForThisDevice = errors.New("event is not encrypted for this device")
+	// ErrNoSession is returned when the session used to encrypt the event is unknown. For Megolm, it usually
+	// means that the room key has not been received yet.
+	ErrNoSession = errors.New("session used to encrypt the event is unknown")
+	// ErrPayloadMismatch is returned when the decrypted payload does not match the encrypted event, which
+	// means that it has been tampered with.
+	ErrPayloadMismatch = errors.New("decrypted payload does not match the event")
+	// ErrReplayedMessage is returned when a Megolm message index has already been used by another event.
+	ErrReplayedMessage = errors.New("message index has been used by another event")
+)
+
+// DecryptRoomEvent decrypts a room event encrypted with Megolm. The RoomID of the event must be set.
+// The returned event has its EncryptionInfo set.
+func (m *Machine) DecryptRoomEvent(e *event.RoomEncryptedEvent) (event.Event, error) {
+	if e.Algorithm != AlgorithmMegolm {
+		return nil, ErrUnsupportedAlgorithm
+	}
+	var ciphertext string
+	if err := json.Unmarshal(e.Ciphertext, &ciphertext); err != nil {
+		return nil, fmt.Errorf("error decoding ciphertext: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// The deprecated sender_key is not sent by newer clients so sessions are only identified by their ID.
+	session, err := m.Store.LoadInboundGroupSession(e.RoomID, e.SessionID)
+	if err != nil {
+		return nil, fmt.Errorf("error loading inbound group session: %w", err)
+	}
+	if session == nil {
+		return nil, ErrNoSession
+	}
+	if e.SenderKey != "" && e.SenderKey != session.SenderKey {
+		return nil, fmt.Errorf("%w: sender key does not match the session", ErrPayloadMismatch)
+	}
+
+	plaintext, index, err := session.Decrypt(ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting event: %w", err)
+	}
+	if err := m.checkReplay(e, index); err != nil {
+		return nil, err
+	}
+	if err := m.Store.SaveInboundGroupSession(session); err != nil {
+		return nil, fmt.Errorf("error saving inbound group session: %w", err)
+	}
+
+	var payload megolmPayload
+	if err := json.Unmarshal(plaintext, &payload); err != nil {
+		return nil, fmt.Errorf("error decoding payload: %w", err)
+	}
+	if payload.RoomID != e.RoomID {
+		return nil, ErrPayloadMismatch
+	}
+
+	return decryptedEvent(e, payload.Type, payload.Content, &event.EncryptionInfo{
+		Algorithm:        AlgorithmMegolm,
+		SenderKey:        session.SenderKey,
+		SenderSigningKey: session.SigningKey,
+		SessionID:        e.SessionID,
+		Trust:            m.sessionTrust(e.Sender, session),
+		Encrypted:        e,
+	})
+}
+
+// maxMessageIndexes is the maximum number of decrypted message indexes remembered to detect replay attacks.
+// A replayed message is not detected once the index of the original message has been forgotten.
+const maxMessageIndexes = 10000
+
+// messageIndex is a Megolm message index that has been decrypted.
+type messageIndex struct {
+	key     string
+	eventID matrix.EventID
+}
+
+// checkReplay returns ErrReplayedMessage if the message index of the session has been used by another event.
+// m.mu must be held.
+func (m *Machine) checkReplay(e *event.RoomEncryptedEvent, index uint32) error {
+	if m.messageIndexes == nil {
+		m.messageIndexes = make(map[string]*list.Element)
+		m.messageIndexLRU = list.New()
+	}
+
+	key := string(e.RoomID) + "|" + e.SessionID + "|" + strconv.FormatUint(uint64(index), 10)
+	if elem, ok := m.messageIndexes[key]; ok {
+		if elem.Value.(*messageIndex).eventID != e.ID {
+			return ErrReplayedMessage
+		}
+		m.messageIndexLRU.MoveToFront(elem)
+		return nil
+	}
+
+	m.messageIndexes[key] = m.messageIndexLRU.PushFront(&messageIndex{key: key, eventID: e.ID})
+	if m.messageIndexLRU.Len() > maxMessageIndexes {
+		oldest := m.messageIndexLRU.Back()
+		m.messageIndexLRU.Remove(oldest)
+		delete(m.messageIndexes, oldest.Value.(*messageIndex).key)
+	}
+	return nil
+}
+
+// sessionTrust returns the trust of events sent by the user and decrypted with the session.
+func (m *Machine) sessionTrust(sender matrix.UserID, session *InboundGroupSession) event.TrustState {
+	ourCurve, _ := m.account.IdentityKeys()
+	switch {
+	case len(session.ForwardingChain) > 0 || session.Imported:
+		return event.TrustUnknown
+	case session.SenderKey == ourCurve:
+		return event.TrustVerified
+	}
+
+	device, err := m.deviceByKey(sender, session.SenderKey)
+	if err != nil {
+		m.Client.Logger.Warn("error looking up sender device", debug.Err(err), debug.UserID(sender))
+	}
+	if device == nil || device.SigningKey != session.SigningKey {
+		return event.TrustUnverified
+	}
+	trusted, err := m.DeviceTrusted(device)
+	if err != nil {
+		m.Client.Logger.Warn("error checking device trust", debug.Err(err), debug.UserID(sender))
+	}
+	if trusted {
+		return event.TrustVerified
+	}
+	return event.TrustUnverified
+}
+
+// DecryptToDeviceEvent decrypts a to-device event encrypted with Olm. Room keys contained in the event are
+// saved into the store. The returned event has its EncryptionInfo set.
+func (m *Machine) DecryptToDeviceEvent(e *event.RoomEncryptedEvent) (event.Event, error) {
+	if e.Algorithm != AlgorithmOlm {
+		return nil, ErrUnsupportedAlgorithm
+	}
+	var ciphertexts map[string]event.OlmCiphertext
+	if err := json.Unmarshal(e.Ciphertext, &ciphertexts); err != nil {
+		return nil, fmt.Errorf("error decoding ciphertext: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ourCurve, ourEd := m.account.IdentityKeys()
+	ciphertext, ok := ciphertexts[ourCurve]
+	if !ok {
+		return nil, ErrNotForThisDevice
+	}
+	plaintext, err := m.decryptOlm(e.SenderKey, ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	var payload olmPayload
+	if err := json.Unmarshal(plaintext, &payload); err != nil {
+		return nil, fmt.Errorf("error decoding payload: %w", err)
+	}
+	if payload.Sender != e.Sender || payload.Recipient != m.Client.UserID ||
+		payload.RecipientKeys["ed25519"] != ourEd {
+		return nil, ErrPayloadMismatch
+	}
+
+	decrypted, err := decryptedEvent(e, payload.Type, payload.Content, &event.EncryptionInfo{
+		Algorithm:        AlgorithmOlm,
+		SenderKey:        e.SenderKey,
+		SenderSigningKey: payload.Keys["ed25519"],
+		Trust:            event.TrustUnverified,
+		Encrypted:        e,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	switch v := decrypted.(type) {
+	case *event.RoomKeyEvent:
+		err = m.receiveRoomKey(v)
+	case *event.ForwardedRoomKeyEvent:
+		err = m.receiveForwardedRoomKey(v)
+	}
+	return decrypted, err
+}
+
+// decryptOlm decrypts the Olm message using the sessions with the sender, creating a new session if it is a
+// pre-key message for a new session.
+func (m *Machine) decryptOlm(senderKey string, ciphertext event.OlmCiphertext) ([]byte, error) {
+	sessions, err := m.Store.LoadOlmSessions(senderKey)
+	if err != nil {
+		return nil, fmt.Errorf("error loading Olm sessions: %w", err)
+	}
+
+	for _, session := range sessions {
+		preKey := ciphertext.Type == olm.MessageTypePreKey
+		if preKey && !session.MatchesInboundSession(senderKey, ciphertext.Body) {
+			continue
+		}
+		plaintext, err := session.Decrypt(ciphertext.Type, ciphertext.Body)
+		if err != nil {
+			if preKey {
+				// The message is meant for this session so no other session can decrypt it.
+				return nil, fmt.Errorf("error decrypting Olm message: %w", err)
+			}
+			continue
+		}
+		session.LastUsed = time.Now()
+		if err := m.Store.SaveOlmSession(senderKey, session); err != nil {
+			return nil, fmt.Errorf("error saving Olm session: %w", err)
+		}
+		return plaintext, nil
+	}
+
+	if ciphertext.Type != olm.MessageTypePreKey {
+		return nil, ErrNoSession
+	}
+
+	session, err := m.account.NewInboundSession(senderKey, ciphertext.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error creating inbound Olm session: %w", err)
+	}
+	plaintext, err := session.Decrypt(ciphertext.Type, ciphertext.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting Olm message: %w", err)
+	}
+
+	m.account.RemoveOneTimeKeys(session)
+	if err := m.Store.SaveAccount(m.account); err != nil {
+		return nil, fmt.Errorf("error saving account: %w", err)
+	}
+	err = m.Store.SaveOlmSession(senderKey, &OlmSession{
+		Session:  session,
+		LastUsed: time.Now(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error saving Olm session: %w", err)
+	}
+	return plaintext, nil
+}
+
+// receiveRoomKey saves the session in a m.room_key event.
+func (m *Machine) receiveRoomKey(e *event.RoomKeyEvent) error {
+	if e.Algorithm != AlgorithmMegolm {
+		return nil
+	}
+	session, err := olm.NewInboundGroupSession(e.SessionKey)
+	if err != nil {
+		return fmt.Errorf("error creating inbound group session: %w", err)
+	}
+	return m.saveReceivedSession(&InboundGroupSession{
+		InboundGroupSession: session,
+		RoomID:              e.RoomID,
+		SenderKey:           e.Encryption.SenderKey,
+		SigningKey:          e.Encryption.SenderSigningKey,
+	}, e.SessionID)
+}
+
+// receiveForwardedRoomKey saves the session in a m.forwarded_room_key event. Only keys that have been requested
+// and are forwarded by our own trusted devices are accepted.
+func (m *Machine) receiveForwardedRoomKey(e *event.ForwardedRoomKeyEvent) error {
+	if e.Algorithm != AlgorithmMegolm {
+		return nil
+	}
+	if e.Sender != m.Client.UserID {
+		m.Client.Logger.Warn("ignoring room key forwarded by another user", debug.UserID(e.Sender))
+		return nil
+	}
+	log := m.Client.Logger.With(debug.RoomID(e.RoomID), debug.Any("session_id", e.SessionID))
+
+	m.keyRequestMu.Lock()
+	_, requested := m.keyRequests[roomKeyRequestKey{e.RoomID, e.SessionID}]
+	m.keyRequestMu.Unlock()
+	if !requested {
+		log.Warn("ignoring room key that has not been requested")
+		return nil
+	}
+
+	device, err := m.deviceByKey(m.Client.UserID, e.Encryption.SenderKey)
+	if err != nil {
+		return err
+	}
+	trusted := false
+	if device != nil {
+		trusted, err = m.DeviceTrusted(device)
+		if err != nil {
+			return err
+		}
+	}
+	if !trusted {
+		log.Warn("ignoring room key forwarded by untrusted device")
+		return nil
+	}
+
+	session, err := olm.ImportInboundGroupSession(e.SessionKey)
+	if err != nil {
+		return fmt.Errorf("error importing inbound group session: %w", err)
+	}
+	chain := append(append([]string(nil), e.ForwardingChain...), e.Encryption.SenderKey)
+	return m.saveReceivedSession(&InboundGroupSession{
+		InboundGroupSession: session,
+		RoomID:              e.RoomID,
+		SenderKey:           e.SenderKey,
+		SigningKey:          e.SenderClaimedSigningKey,
+		ForwardingChain:     chain,
+	}, e.SessionID)
+}
+
+// saveReceivedSession saves the received session unless a better one with the same ID is already known.
+func (m *Machine) saveReceivedSession(session *InboundGroupSession, sessionID string) error {
+	if session.ID() != sessionID {
+		return fmt.Errorf("%w: session ID does not match the session key", ErrPayloadMismatch)
+	}
+
+	saved, err := m.saveBetterSession(session)
+	if err != nil || !saved {
+		return err
+	}
+	m.Client.Logger.Debug("received room key", debug.RoomID(session.RoomID),
+		debug.Any("session_id", session.ID()), debug.Any("first_index", session.FirstKnownIndex()))
+	m.queueBackup(session)
+	return nil
+}
+
+// saveBetterSession saves the session unless a session with the same ID that is known from an earlier
+// message index or through fewer devices is already saved. It returns true if the session is saved.
+// Sessions claiming to be from another device than the saved one are rejected.
+func (m *Machine) saveBetterSession(session *InboundGroupSession) (bool, error) {
+	existing, err := m.Store.LoadInboundGroupSession(session.RoomID, session.ID())
+	if err != nil {
+		return false, fmt.Errorf("error loading inbound group session: %w", err)
+	}
+	if existing != nil && existing.SenderKey != session.SenderKey {
+		m.Client.Logger.Warn("ignoring session with a different sender key", debug.RoomID(session.RoomID),
+			debug.Any("session_id", session.ID()))
+		return false, nil
+	}
+	// The session ID is the public key signing the messages so the sending device of the saved session still
+	// applies to an imported one.
+	if existing != nil && !existing.Imported {
+		session.Imported = false
+	}
+	if existing != nil && existing.FirstKnownIndex() <= session.FirstKnownIndex() &&
+		len(existing.ForwardingChain) <= len(session.ForwardingChain) {
+		return false, nil
+	}
+	if err := m.Store.SaveInboundGroupSession(session); err != nil {
+		return false, fmt.Errorf("error saving inbound group session: %w", err)
+	}
+	return true, nil
+}
+
+// decryptedEvent replaces the type and content of the encrypted event with the decrypted ones and parses it.
+func decryptedEvent(e *event.RoomEncryptedEvent, eventType event.Type, content json.RawMessage,
+	info *event.EncryptionInfo) (event.Event, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(e.Raw, &fields); err != nil {
+		return nil, err
+	}
+	rawType, err := json.Marshal(eventType)
+	if err != nil {
+		return nil, err
+	}
+	fields["type"] = rawType
+	fields["content"] = content
+
+	raw, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	decrypted, err := event.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	decrypted.Info().Encryption = info
+	if v, ok := decrypted.(event.RoomEvent); ok {
+		v.RoomInfo().RoomID = e.RoomID
+	}
+	return decrypted, nil
+}
@@ -0,0 +1,61 @@
+package e2ee
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/chanbakjsd/gotrix/event"
+	"github.com/chanbakjsd/gotrix/matrix"
+)
+
+func TestCheckReplay(t *testing.T) {
+	m := &Machine{}
+	newEvent := func(id matrix.EventID, sessionID string) *event.RoomEncryptedEvent {
+		e := &event.RoomEncryptedEvent{SessionID: sessionID}
+		e.ID = id
+		e.RoomID = "!room:example.org"
+		return e
+	}
+
+	if err := m.checkReplay(newEvent("$first", "session"), 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Decrypting the same event again is fine.
+	if err := m.checkReplay(newEvent("$first", "session"), 0); err != nil {
+		t.Errorf("unexpected error decrypting event again: %v", err)
+	}
+	if err := m.checkReplay(newEvent("$replayed", "session"), 0); !errors.Is(err, ErrReplayedMessage) {
+		t.Errorf("expected %v, got %v", ErrReplayedMessage, err)
+	}
+	// The index is only reused if it is in the same session.
+	if err := m.checkReplay(newEvent("$other", "other session"), 0); err != nil {
+		t.Errorf("unexpected error for another session: %v", err)
+	}
+
+	// Indexes are forgotten once too many other indexes have been decrypted, starting with the least
+	// recently used.
+	for i := 1; i < maxMessageIndexes; i++ {
+		id := matrix.EventID("$" + strconv.Itoa(i))
+		if err := m.checkReplay(newEvent(id, "session"), uint32(i)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := m.checkReplay(newEvent("$first", "session"), 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := m.checkReplay(newEvent("$new", "session"), uint32(maxMessageIndexes)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(m.messageIndexes) != maxMessageIndexes || m.messageIndexLRU.Len() != maxMessageIndexes {
+		t.Fatalf("expected %d remembered indexes, got %d and %d", maxMessageIndexes, len(m.messageIndexes),
+			m.messageIndexLRU.Len())
+	}
+	if err := m.checkReplay(newEvent("$replayed", "session"), 0); !errors.Is(err, ErrReplayedMessage) {
+		t.Errorf("expected recently used index to be remembered, got %v", err)
+	}
+	// The index of the other session is the least recently used so it has been forgotten.
+	if err := m.checkReplay(newEvent("$replayed", "other session"), 0); err != nil {
+		t.Errorf("expected least recently used index to be forgotten, got %v", err)
+	}
+}
@@ -0,0 +1,304 @@
+package e2ee
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/chanbakjsd/gotrix/api"
+	"github.com/chanbakjsd/gotrix/debug"
+	"github.com/chanbakjsd/gotrix/matrix"
+)
+
+// ErrUnknownDevice is returned when the device has not been queried from the homeserver.
+var ErrUnknownDevice = errors.New("unknown device")
+
+// Device is a device with end-to-end encryption enabled.
+type Device struct {
+	UserID   matrix.UserID   `json:"user_id"`
+	DeviceID matrix.DeviceID `json:"device_id"`
+	// IdentityKey is the Curve25519 identity key of the device in base64.
+	IdentityKey string `json:"identity_key"`
+	// SigningKey is the Ed25519 identity key of the device in base64.
+	SigningKey  string `json:"signing_key"`
+	DisplayName string `json:"display_name,omitempty"`
+	// Verified is true if the device has been verified by the user.
+	Verified bool `json:"verified,omitempty"`
+	// CrossSignedBy is the self-signing key (in base64) of the user that signed the device keys. It is empty
+	// if the device is not cross-signed.
+	CrossSignedBy string `json:"cross_signed_by,omitempty"`
+}
+
+// DeviceLists is the state of the device tracker.
+type DeviceLists struct {
+	// Tracked contains the users whose devices are tracked. The value is true if the devices of the user
+	// have changed since they were last queried.
+	Tracked map[matrix.UserID]bool `json:"tracked"`
+	// SyncToken is the token of the last sync whose device list changes have been applied.
+	SyncToken string `json:"sync_token"`
+}
+
+// Devices returns the devices of the user. The devices are queried from the homeserver if the user is not
+// tracked yet or if they have changed since they were last queried.
+func (m *Machine) Devices(ctx context.Context, userID matrix.UserID) (map[matrix.DeviceID]*Device, error) {
+	devices, err := m.UsersDevices(ctx, []matrix.UserID{userID})
+	if err != nil {
+		return nil, err
+	}
+	return devices[userID], nil
+}
+
+// UsersDevices is the same as Devices but returns the devices of multiple users at once.
+func (m *Machine) UsersDevices(ctx context.Context,
+	users []matrix.UserID) (map[matrix.UserID]map[matrix.DeviceID]*Device, error) {
+	m.deviceMu.Lock()
+	defer m.deviceMu.Unlock()
+
+	if err := m.loadDeviceLists(); err != nil {
+		return nil, err
+	}
+
+	var outdated []matrix.UserID
+	for _, v := range users {
+		if changed, ok := m.lists.Tracked[v]; !ok || changed {
+			outdated = append(outdated, v)
+		}
+	}
+	if len(outdated) > 0 {
+		if err := m.queryDevices(ctx, outdated); err != nil {
+			return nil, err
+		}
+	}
+
+	devices := make(map[matrix.UserID]map[matrix.DeviceID]*Device, len(users))
+	for _, v := range users {
+		userDevices, err := m.Store.LoadDevices(v)
+		if err != nil {
+			return nil, fmt.Errorf("error loading devices: %w", err)
+		}
+		devices[v] = userDevices
+	}
+	return devices, nil
+}
+
+// queryDevices fetches the devices of the provided users from the homeserver, saves them and marks the users
+// as up to date. Devices whose keys are not correctly signed or whose Ed25519 key has changed are left out.
+func (m *Machine) queryDevices(ctx context.Context, users []matrix.UserID) error {
+	query := make(map[matrix.UserID][]matrix.DeviceID, len(users))
+	for _, v := range users {
+		query[v] = nil
+	}
+	resp, err := m.Client.KeysQueryContext(ctx, query, 0)
+	if err != nil {
+		return err
+	}
+
+	// Users on homeservers that could not be reached are left out of the response and stay outdated.
+	for userID, userDevices := range resp.DeviceKeys {
+		known, err := m.Store.LoadDevices(userID)
+		if err != nil {
+			return fmt.Errorf("error loading devices: %w", err)
+		}
+
+		identity, err := m.updateIdentity(userID, resp)
+		if err != nil {
+			return err
+		}
+
+		devices := make(map[matrix.DeviceID]*Device, len(userDevices))
+		for deviceID, keys := range userDevices {
+			device, err := deviceFromKeys(userID, deviceID, keys)
+			if err != nil {
+				m.Client.Logger.Warn("ignoring device with invalid keys", debug.Err(err),
+					debug.UserID(userID), debug.Any("device_id", deviceID))
+				continue
+			}
+			if identity != nil && identity.SelfSigning != nil {
+				// Fields unknown to api.DeviceKeys are kept when it is marshalled so they are also checked.
+				key := identity.SelfSigning.PublicKey()
+				if VerifySignature(keys, keys.Signatures, userID, "ed25519:"+key, key) == nil {
+					device.CrossSignedBy = key
+				}
+			}
+			// The signing key of a device must never change, so the old device is kept if it does.
+			if old, ok := known[deviceID]; ok && old.SigningKey != device.SigningKey {
+				m.Client.Logger.Warn("ignoring device with changed signing key",
+					debug.UserID(userID), debug.Any("device_id", deviceID))
+				devices[deviceID] = old
+				continue
+			}
+			if old, ok := known[deviceID]; ok {
+				device.Verified = old.Verified
+			}
+			devices[deviceID] = device
+		}
+
+		if err := m.Store.SaveDevices(userID, devices); err != nil {
+			return fmt.Errorf("error saving devices: %w", err)
+		}
+		m.lists.Tracked[userID] = false
+	}
+
+	if err := m.Store.SaveDeviceLists(m.lists); err != nil {
+		return fmt.Errorf("error saving device lists: %w", err)
+	}
+	return nil
+}
+
+// updateIdentity saves the cross-signing keys of the user in the query response. The identity stays verified
+// if the master key has not changed. The saved identity is removed if the user no longer has valid
+// cross-signing keys.
+func (m *Machine) updateIdentity(userID matrix.UserID, resp *api.KeysQueryResponse) (*UserIdentity, error) {
+	identity := identityFromKeys(userID, resp)
+	old, err := m.Store.LoadUserIdentity(userID)
+	if err != nil {
+		return nil, fmt.Errorf("error loading user identity: %w", err)
+	}
+	if identity == nil {
+		if old == nil {
+			return nil, nil
+		}
+		m.Client.Logger.Warn("cross-signing keys of user have been removed", debug.UserID(userID))
+		if err := m.Store.RemoveUserIdentity(userID); err != nil {
+			return nil, fmt.Errorf("error removing user identity: %w", err)
+		}
+		return nil, nil
+	}
+	if old != nil && old.MasterKey() == identity.MasterKey() {
+		identity.Verified = old.Verified
+	} else if old != nil {
+		m.Client.Logger.Warn("master key of user has changed", debug.UserID(userID))
+	}
+	if err := m.Store.SaveUserIdentity(identity); err != nil {
+		return nil, fmt.Errorf("error saving user identity: %w", err)
+	}
+	return identity, nil
+}
+
+// SetDeviceVerified marks the device as verified or unverified in the store. The device must have been
+// queried before.
+func (m *Machine) SetDeviceVerified(userID matrix.UserID, deviceID matrix.DeviceID, verified bool) error {
+	m.deviceMu.Lock()
+	defer m.deviceMu.Unlock()
+
+	devices, err := m.Store.LoadDevices(userID)
+	if err != nil {
+		return fmt.Errorf("error loading devices: %w", err)
+	}
+	device, ok := devices[deviceID]
+	if !ok {
+		return ErrUnknownDevice
+	}
+	device.Verified = verified
+	if err := m.Store.SaveDevices(userID, devices); err != nil {
+		return fmt.Errorf("error saving devices: %w", err)
+	}
+	return nil
+}
+
+// deviceByKey returns the saved device of the user with the provided Curve25519 identity key, or nil if there
+// is none.
+func (m *Machine) deviceByKey(userID matrix.UserID, identityKey string) (*Device, error) {
+	devices, err := m.Store.LoadDevices(userID)
+	if err != nil {
+		return nil, fmt.Errorf("error loading devices: %w", err)
+	}
+	for _, v := range devices {
+		if v.IdentityKey == identityKey {
+			return v, nil
+		}
+	}
+	return nil, nil
+}
+
+// updateDeviceLists marks the users whose devices have changed according to the sync response as outdated.
+// since is the sync token the response was requested with.
+func (m *Machine) updateDeviceLists(ctx context.Context, since string, resp *api.SyncResponse) error {
+	m.deviceMu.Lock()
+	defer m.deviceMu.Unlock()
+
+	if err := m.loadDeviceLists(); err != nil {
+		return err
+	}
+
+	changed := resp.DeviceLists.Changed
+	left := resp.DeviceLists.Left
+	if since != m.lists.SyncToken {
+		// Changes between the last processed sync and this one have been missed.
+		var changes *api.KeysChangesResponse
+		var err error
+		if since != "" && m.lists.SyncToken != "" {
+			changes, err = m.Client.KeysChangesContext(ctx, m.lists.SyncToken, since)
+		}
+		if changes != nil {
+			changed = append(changed, changes.Changed...)
+			left = append(left, changes.Left...)
+		} else {
+			if err != nil {
+				m.Client.Logger.Warn("error fetching key changes", debug.Err(err))
+			}
+			for k := range m.lists.Tracked {
+				m.lists.Tracked[k] = true
+			}
+		}
+	}
+
+	for _, v := range changed {
+		if _, ok := m.lists.Tracked[v]; ok {
+			m.lists.Tracked[v] = true
+		}
+	}
+	for _, v := range left {
+		delete(m.lists.Tracked, v)
+	}
+	m.lists.SyncToken = resp.NextBatch
+
+	if err := m.Store.SaveDeviceLists(m.lists); err != nil {
+		return fmt.Errorf("error saving device lists: %w", err)
+	}
+	return nil
+}
+
+// loadDeviceLists loads the state of the device tracker from the store if it has not been loaded yet.
+func (m *Machine) loadDeviceLists() error {
+	if m.lists != nil {
+		return nil
+	}
+	lists, err := m.Store.LoadDeviceLists()
+	if err != nil {
+		return fmt.Errorf("error loading device lists: %w", err)
+	}
+	if lists == nil {
+		lists = &DeviceLists{}
+	}
+	if lists.Tracked == nil {
+		lists.Tracked = make(map[matrix.UserID]bool)
+	}
+	m.lists = lists
+	return nil
+}
+
+// deviceFromKeys checks the device keys returned by the homeserver and converts them into a Device.
+func deviceFromKeys(userID matrix.UserID, deviceID matrix.DeviceID, keys api.DeviceKeys) (*Device, error) {
+	if keys.UserID != userID || keys.DeviceID != deviceID {
+		return nil, ErrDeviceMismatch
+	}
+	device := &Device{
+		UserID:      userID,
+		DeviceID:    deviceID,
+		IdentityKey: keys.Keys["curve25519:"+string(deviceID)],
+		SigningKey:  keys.Keys["ed25519:"+string(deviceID)],
+	}
+	if device.IdentityKey == "" || device.SigningKey == "" {
+		return nil, ErrDeviceMismatch
+	}
+	// Fields unknown to api.DeviceKeys are kept when it is marshalled so they are also checked.
+	err := VerifySignature(keys, keys.Signatures, userID, "ed25519:"+string(deviceID), device.SigningKey)
+	if err != nil {
+		return nil, err
+	}
+	if keys.Unsigned != nil {
+		device.DisplayName = keys.Unsigned.DeviceDisplayName
+	}
+	return device, nil
+}
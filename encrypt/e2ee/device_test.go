@@ -0,0 +1,66 @@
+package e2ee
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/chanbakjsd/gotrix/api"
+	"github.com/chanbakjsd/gotrix/encrypt/olm"
+)
+
+// signedDeviceKeys returns the device keys of a new account as returned by /keys/query, with extra as additional
+// signed fields.
+func signedDeviceKeys(t *testing.T, extra string) []byte {
+	t.Helper()
+	account, err := olm.NewAccount()
+	if err != nil {
+		t.Fatalf("error creating account: %v", err)
+	}
+	curve, ed := account.IdentityKeys()
+	unsigned := `{"algorithms":["m.megolm.v1.aes-sha2"],"device_id":"DEVICE",` + extra +
+		`"keys":{"curve25519:DEVICE":"` + curve + `","ed25519:DEVICE":"` + ed + `"},"user_id":"@alice:example.org"}`
+	canonical, err := CanonicalJSON(json.RawMessage(unsigned))
+	if err != nil {
+		t.Fatalf("error encoding canonical JSON: %v", err)
+	}
+	signature := account.Sign(canonical)
+	return []byte(unsigned[:len(unsigned)-1] + `,"signatures":{"@alice:example.org":{"ed25519:DEVICE":` +
+		strconv.Quote(signature) + `}},"unsigned":{"device_display_name":"Alice's phone"}}`)
+}
+
+func TestDeviceFromKeys(t *testing.T) {
+	tests := map[string]string{
+		"known fields":   "",
+		"unknown fields": `"org.example.field":{"b":1,"a":[true,null]},"dehydrated":true,`,
+	}
+	for name, extra := range tests {
+		var keys api.DeviceKeys
+		if err := json.Unmarshal(signedDeviceKeys(t, extra), &keys); err != nil {
+			t.Fatalf("%s: error decoding device keys: %v", name, err)
+		}
+		device, err := deviceFromKeys("@alice:example.org", "DEVICE", keys)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", name, err)
+			continue
+		}
+		if device.DisplayName != "Alice's phone" {
+			t.Errorf("%s: expected display name from unsigned data, got %q", name, device.DisplayName)
+		}
+	}
+}
+
+func TestDeviceFromKeysTampered(t *testing.T) {
+	var keys api.DeviceKeys
+	if err := json.Unmarshal(signedDeviceKeys(t, `"dehydrated":true,`), &keys); err != nil {
+		t.Fatalf("error decoding device keys: %v", err)
+	}
+	// Changing an unknown field must invalidate the signature.
+	keys.Raw = bytes.Replace(keys.Raw, []byte(`"dehydrated":true`), []byte(`"dehydrated":false`), 1)
+	_, err := deviceFromKeys("@alice:example.org", "DEVICE", keys)
+	if !errors.Is(err, olm.ErrBadSignature) {
+		t.Errorf("expected ErrBadSignature, got %v", err)
+	}
+}
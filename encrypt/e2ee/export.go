@@ -0,0 +1,242 @@
+package e2ee
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/chanbakjsd/gotrix/debug"
+	"github.com/chanbakjsd/gotrix/encrypt/olm"
+	"github.com/chanbakjsd/gotrix/matrix"
+)
+
+// Errors returned when decrypting key exports.
+var (
+	// ErrBadKeyExport is returned when the key export cannot be decoded.
+	ErrBadKeyExport = errors.New("invalid key export")
+	// ErrBadKeyExportMAC is returned when the MAC of the key export does not match, usually because the
+	// passphrase is wrong.
+	ErrBadKeyExportMAC = errors.New("MAC of the key export does not match, passphrase may be wrong")
+)
+
+// Armor of the key export file.
+const (
+	keyExportHeader = "-----BEGIN MEGOLM SESSION DATA-----"
+	keyExportFooter = "-----END MEGOLM SESSION DATA-----"
+)
+
+// DefaultKeyExportRounds is the number of PBKDF2 rounds used to derive the key export keys from the passphrase.
+const DefaultKeyExportRounds = 500000
+
+// keyExportVersion is the version of the key export format.
+const keyExportVersion = 1
+
+// keyExportLineLength is the maximum length of the base64 lines in the key export file.
+const keyExportLineLength = 96
+
+// ExportedSession is an inbound Megolm session in a key export.
+type ExportedSession struct {
+	Algorithm         string            `json:"algorithm"`
+	ForwardingChain   []string          `json:"forwarding_curve25519_key_chain"`
+	RoomID            matrix.RoomID     `json:"room_id"`
+	SenderKey         string            `json:"sender_key"`
+	SenderClaimedKeys map[string]string `json:"sender_claimed_keys"`
+	SessionID         string            `json:"session_id"`
+	// SessionKey is the session exported at its first known index.
+	SessionKey string `json:"session_key"`
+}
+
+// ExportRoomKeys exports all inbound Megolm sessions in the store into a key export file encrypted with the
+// passphrase. The file can be imported by other clients.
+func (m *Machine) ExportRoomKeys(passphrase string) ([]byte, error) {
+	m.mu.Lock()
+	sessions, err := m.Store.LoadInboundGroupSessions()
+	if err != nil {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("error loading inbound group sessions: %w", err)
+	}
+
+	exported := make([]ExportedSession, 0, len(sessions))
+	for _, session := range sessions {
+		key, err := session.Export(session.FirstKnownIndex())
+		if err != nil {
+			m.mu.Unlock()
+			return nil, fmt.Errorf("error exporting inbound group session: %w", err)
+		}
+		chain := session.ForwardingChain
+		if chain == nil {
+			chain = []string{}
+		}
+		exported = append(exported, ExportedSession{
+			Algorithm:         AlgorithmMegolm,
+			ForwardingChain:   chain,
+			RoomID:            session.RoomID,
+			SenderKey:         session.SenderKey,
+			SenderClaimedKeys: map[string]string{"ed25519": session.SigningKey},
+			SessionID:         session.ID(),
+			SessionKey:        key,
+		})
+	}
+	m.mu.Unlock()
+
+	plaintext, err := json.Marshal(exported)
+	if err != nil {
+		return nil, err
+	}
+	return EncryptKeyExport(plaintext, passphrase, DefaultKeyExportRounds)
+}
+
+// ImportRoomKeys decrypts the key export file with the passphrase and saves the sessions in it, unless a
+// better session with the same ID is already known. It returns the number of imported sessions.
+//
+// Sessions with an unknown algorithm or that cannot be imported are skipped.
+func (m *Machine) ImportRoomKeys(data []byte, passphrase string) (int, error) {
+	plaintext, err := DecryptKeyExport(data, passphrase)
+	if err != nil {
+		return 0, err
+	}
+	var exported []ExportedSession
+	if err := json.Unmarshal(plaintext, &exported); err != nil {
+		return 0, fmt.Errorf("error decoding key export: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	imported := 0
+	for _, v := range exported {
+		if v.Algorithm != AlgorithmMegolm {
+			continue
+		}
+		olmSession, err := olm.ImportInboundGroupSession(v.SessionKey)
+		if err != nil || olmSession.ID() != v.SessionID {
+			m.Client.Logger.Warn("skipping invalid session in key export", debug.Err(err),
+				debug.RoomID(v.RoomID), debug.Any("session_id", v.SessionID))
+			continue
+		}
+		session := &InboundGroupSession{
+			InboundGroupSession: olmSession,
+			RoomID:              v.RoomID,
+			SenderKey:           v.SenderKey,
+			SigningKey:          v.SenderClaimedKeys["ed25519"],
+			ForwardingChain:     v.ForwardingChain,
+			Imported:            true,
+		}
+		saved, err := m.saveBetterSession(session)
+		if err != nil {
+			return imported, err
+		}
+		if saved {
+			imported++
+			m.queueBackup(session)
+		}
+	}
+	return imported, nil
+}
+
+// EncryptKeyExport encrypts the plaintext into the key export format with keys derived from the passphrase
+// using the provided number of PBKDF2 rounds.
+func EncryptKeyExport(plaintext []byte, passphrase string, rounds int) ([]byte, error) {
+	random := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, random); err != nil {
+		return nil, err
+	}
+	salt, iv := random[:16], random[16:]
+	// Bit 63 is cleared to work around differences in AES-CTR implementations.
+	iv[8] &= 0x7f
+
+	aesKey, macKey := keyExportKeys(passphrase, salt, rounds)
+	block, err := aes.NewCipher(aesKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw bytes.Buffer
+	raw.WriteByte(keyExportVersion)
+	raw.Write(salt)
+	raw.Write(iv)
+	_ = binary.Write(&raw, binary.BigEndian, uint32(rounds))
+	ciphertext := make([]byte, len(plaintext))
+	cipher.NewCTR(block, iv).XORKeyStream(ciphertext, plaintext)
+	raw.Write(ciphertext)
+	raw.Write(hmacSHA256(macKey, raw.Bytes()))
+
+	encoded := base64.StdEncoding.EncodeToString(raw.Bytes())
+	var out bytes.Buffer
+	out.WriteString(keyExportHeader + "\n")
+	for len(encoded) > 0 {
+		n := keyExportLineLength
+		if n > len(encoded) {
+			n = len(encoded)
+		}
+		out.WriteString(encoded[:n] + "\n")
+		encoded = encoded[n:]
+	}
+	out.WriteString(keyExportFooter + "\n")
+	return out.Bytes(), nil
+}
+
+// DecryptKeyExport decrypts the key export with the passphrase and returns its plaintext.
+func DecryptKeyExport(data []byte, passphrase string) ([]byte, error) {
+	text := strings.TrimSpace(string(data))
+	if !strings.HasPrefix(text, keyExportHeader) || !strings.HasSuffix(text, keyExportFooter) {
+		return nil, fmt.Errorf("%w: missing header or footer", ErrBadKeyExport)
+	}
+	text = strings.TrimSuffix(strings.TrimPrefix(text, keyExportHeader), keyExportFooter)
+	raw, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(text), ""))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadKeyExport, err)
+	}
+
+	// Version, salt, IV, rounds and MAC.
+	const overhead = 1 + 16 + 16 + 4 + sha256.Size
+	if len(raw) < overhead {
+		return nil, fmt.Errorf("%w: too short", ErrBadKeyExport)
+	}
+	if raw[0] != keyExportVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrBadKeyExport, raw[0])
+	}
+	salt := raw[1:17]
+	iv := raw[17:33]
+	rounds := binary.BigEndian.Uint32(raw[33:37])
+	if rounds == 0 || rounds > maxPBKDF2Iterations {
+		return nil, fmt.Errorf("%w: invalid number of rounds %d", ErrBadKeyExport, rounds)
+	}
+	ciphertext := raw[37 : len(raw)-sha256.Size]
+	mac := raw[len(raw)-sha256.Size:]
+
+	aesKey, macKey := keyExportKeys(passphrase, salt, int(rounds))
+	if !hmac.Equal(hmacSHA256(macKey, raw[:len(raw)-sha256.Size]), mac) {
+		return nil, ErrBadKeyExportMAC
+	}
+	block, err := aes.NewCipher(aesKey)
+	if err != nil {
+		return nil, err
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCTR(block, iv).XORKeyStream(plaintext, ciphertext)
+	return plaintext, nil
+}
+
+// keyExportKeys derives the AES key and the HMAC key of the key export from the passphrase.
+func keyExportKeys(passphrase string, salt []byte, rounds int) (aesKey, macKey []byte) {
+	derived := pbkdf2SHA512([]byte(passphrase), salt, rounds, 64)
+	return derived[:32], derived[32:]
+}
+
+// hmacSHA256 returns HMAC-SHA-256 of the input.
+func hmacSHA256(key, input []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write(input)
+	return mac.Sum(nil)
+}
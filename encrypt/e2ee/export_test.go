@@ -0,0 +1,176 @@
+package e2ee
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/chanbakjsd/gotrix/api"
+	"github.com/chanbakjsd/gotrix/encrypt/olm"
+	"github.com/chanbakjsd/gotrix/matrix"
+)
+
+// keyExportVectors are the test vectors of the key export format published by matrix-react-sdk.
+var keyExportVectors = []struct {
+	Plaintext  string
+	Passphrase string
+	Export     string
+}{
+	{
+		Plaintext:  "plain",
+		Passphrase: "password",
+		Export: "-----BEGIN MEGOLM SESSION DATA-----\n" +
+			"AXNhbHRzYWx0c2FsdHNhbHSIiIiIiIiIiIiIiIiIiIiIAAAACmIRUW2OjZ3L2l6j9h0lHlV3M2dx\n" +
+			"cissyYBxjsfsAndErh065A8=\n" +
+			"-----END MEGOLM SESSION DATA-----",
+	},
+	{
+		Plaintext:  "Hello, World",
+		Passphrase: "betterpassword",
+		Export: "-----BEGIN MEGOLM SESSION DATA-----\n" +
+			"AW1vcmVzYWx0bW9yZXNhbHT//////////wAAAAAAAAAAAAAD6KyBpe1Niv5M5NPm4ZATsJo5nghk\n" +
+			"KYu63a0YQ5DRhUWEKk7CcMkrKnAUiZny\n" +
+			"-----END MEGOLM SESSION DATA-----",
+	},
+}
+
+func TestDecryptKeyExport(t *testing.T) {
+	for _, v := range keyExportVectors {
+		plaintext, err := DecryptKeyExport([]byte(v.Export), v.Passphrase)
+		if err != nil {
+			t.Errorf("error decrypting %q: %v", v.Plaintext, err)
+			continue
+		}
+		if string(plaintext) != v.Plaintext {
+			t.Errorf("mismatch on decrypting %q: got %q", v.Plaintext, plaintext)
+		}
+	}
+}
+
+func TestDecryptKeyExportWrongPassphrase(t *testing.T) {
+	v := keyExportVectors[0]
+	_, err := DecryptKeyExport([]byte(v.Export), "wrong"+v.Passphrase)
+	if !errors.Is(err, ErrBadKeyExportMAC) {
+		t.Errorf("expected ErrBadKeyExportMAC, got %v", err)
+	}
+}
+
+func TestEncryptKeyExport(t *testing.T) {
+	const plaintext = `[{"algorithm":"m.megolm.v1.aes-sha2"}]`
+	export, err := EncryptKeyExport([]byte(plaintext), "passphrase", 10)
+	if err != nil {
+		t.Fatalf("error encrypting: %v", err)
+	}
+	decrypted, err := DecryptKeyExport(export, "passphrase")
+	if err != nil {
+		t.Fatalf("error decrypting: %v", err)
+	}
+	if string(decrypted) != plaintext {
+		t.Errorf("mismatch after round trip: got %q", decrypted)
+	}
+}
+
+func TestDecryptKeyExportRounds(t *testing.T) {
+	lines := strings.Split(keyExportVectors[0].Export, "\n")
+	raw, err := base64.StdEncoding.DecodeString(strings.Join(lines[1:len(lines)-1], ""))
+	if err != nil {
+		t.Fatalf("error decoding export: %v", err)
+	}
+	for _, rounds := range []uint32{0, maxPBKDF2Iterations + 1, 1<<32 - 1} {
+		binary.BigEndian.PutUint32(raw[33:37], rounds)
+		export := keyExportHeader + "\n" + base64.StdEncoding.EncodeToString(raw) + "\n" + keyExportFooter
+		_, err := DecryptKeyExport([]byte(export), "password")
+		if !errors.Is(err, ErrBadKeyExport) {
+			t.Errorf("%d rounds: expected ErrBadKeyExport, got %v", rounds, err)
+		}
+	}
+}
+
+func TestExportRoomKeysRoundTrip(t *testing.T) {
+	newMachine := func() *Machine {
+		m, err := NewMachine(&api.Client{UserID: "@alice:example.org", DeviceID: "DEVICE"}, NewMemoryStore())
+		if err != nil {
+			t.Fatalf("error creating machine: %v", err)
+		}
+		return m
+	}
+	exporter := newMachine()
+
+	sessions := make(map[string]*InboundGroupSession)
+	for i, chain := range [][]string{nil, {"forwarder+curve25519+key"}} {
+		outbound, err := olm.NewOutboundGroupSession()
+		if err != nil {
+			t.Fatalf("error creating outbound group session: %v", err)
+		}
+		// Export the second session from a later index.
+		for j := 0; j < i; j++ {
+			outbound.Encrypt([]byte("message"))
+		}
+		inbound, err := olm.NewInboundGroupSession(outbound.SessionKey())
+		if err != nil {
+			t.Fatalf("error creating inbound group session: %v", err)
+		}
+		session := &InboundGroupSession{
+			InboundGroupSession: inbound,
+			RoomID:              matrix.RoomID("!room" + strconv.Itoa(i) + ":example.org"),
+			SenderKey:           "sender+curve25519+key",
+			SigningKey:          "sender+ed25519+key",
+			ForwardingChain:     chain,
+		}
+		if err := exporter.Store.SaveInboundGroupSession(session); err != nil {
+			t.Fatalf("error saving session: %v", err)
+		}
+		sessions[session.ID()] = session
+	}
+
+	export, err := exporter.ExportRoomKeys("passphrase")
+	if err != nil {
+		t.Fatalf("error exporting room keys: %v", err)
+	}
+
+	importer := newMachine()
+	n, err := importer.ImportRoomKeys(export, "passphrase")
+	if err != nil {
+		t.Fatalf("error importing room keys: %v", err)
+	}
+	if n != len(sessions) {
+		t.Errorf("expected %d sessions to be imported, got %d", len(sessions), n)
+	}
+	imported, err := importer.Store.LoadInboundGroupSessions()
+	if err != nil {
+		t.Fatalf("error loading sessions: %v", err)
+	}
+	if len(imported) != len(sessions) {
+		t.Fatalf("expected %d sessions, got %d", len(sessions), len(imported))
+	}
+	for _, got := range imported {
+		want, ok := sessions[got.ID()]
+		switch {
+		case !ok:
+			t.Errorf("unexpected session %s", got.ID())
+			continue
+		case got.RoomID != want.RoomID || got.SenderKey != want.SenderKey || got.SigningKey != want.SigningKey:
+			t.Errorf("session %s: mismatched metadata %+v", got.ID(), got)
+		case len(got.ForwardingChain) != len(want.ForwardingChain):
+			t.Errorf("session %s: expected forwarding chain %v, got %v", got.ID(), want.ForwardingChain,
+				got.ForwardingChain)
+		case got.FirstKnownIndex() != want.FirstKnownIndex():
+			t.Errorf("session %s: expected first known index %d, got %d", got.ID(), want.FirstKnownIndex(),
+				got.FirstKnownIndex())
+		case !got.Imported:
+			t.Errorf("session %s: expected session to be marked as imported", got.ID())
+		}
+	}
+
+	// The sessions are already known so importing them again should not do anything.
+	n, err = importer.ImportRoomKeys(export, "passphrase")
+	if err != nil {
+		t.Fatalf("error importing room keys again: %v", err)
+	}
+	if n != 0 {
+		t.Errorf("expected no session to be imported again, got %d", n)
+	}
+}
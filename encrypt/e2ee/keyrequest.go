@@ -0,0 +1,235 @@
+package e2ee
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/chanbakjsd/gotrix/api"
+	"github.com/chanbakjsd/gotrix/debug"
+	"github.com/chanbakjsd/gotrix/encrypt/olm"
+	"github.com/chanbakjsd/gotrix/event"
+	"github.com/chanbakjsd/gotrix/matrix"
+)
+
+// roomKeyRequestRetry is how long to wait for the key before a request for the same session is sent again.
+const roomKeyRequestRetry = 10 * time.Minute
+
+// roomKeyRequestKey identifies the session requested by a room key request.
+type roomKeyRequestKey struct {
+	RoomID    matrix.RoomID
+	SessionID string
+}
+
+// roomKeyRequest is an outstanding room key request.
+type roomKeyRequest struct {
+	id     string
+	sentAt time.Time
+}
+
+// RequestRoomKey requests the key of the Megolm session the event is encrypted with from the other devices of
+// the user. The request is cancelled once the key is received. Nothing is sent if the key has been requested
+// recently.
+//
+// It is called automatically by HandleEvent for events that cannot be decrypted because of a missing key.
+func (m *Machine) RequestRoomKey(ctx context.Context, e *event.RoomEncryptedEvent) error {
+	if e.Algorithm != AlgorithmMegolm {
+		return ErrUnsupportedAlgorithm
+	}
+	key := roomKeyRequestKey{e.RoomID, e.SessionID}
+
+	m.keyRequestMu.Lock()
+	req, ok := m.keyRequests[key]
+	if ok && time.Since(req.sentAt) < roomKeyRequestRetry {
+		m.keyRequestMu.Unlock()
+		return nil
+	}
+	if !ok {
+		// Unanswered requests are sent again with the same ID.
+		id, err := randomString()
+		if err != nil {
+			m.keyRequestMu.Unlock()
+			return err
+		}
+		req = &roomKeyRequest{id: id}
+	}
+	req.sentAt = time.Now()
+	if m.keyRequests == nil {
+		m.keyRequests = make(map[roomKeyRequestKey]*roomKeyRequest)
+	}
+	m.keyRequests[key] = req
+	m.keyRequestMu.Unlock()
+
+	m.Client.Logger.Debug("requesting room key", debug.RoomID(e.RoomID), debug.Any("session_id", e.SessionID))
+	err := m.sendRoomKeyRequest(ctx, event.RoomKeyRequestEvent{
+		Action: event.RoomKeyRequestActionRequest,
+		Body: &event.RoomKeyRequestBody{
+			Algorithm: e.Algorithm,
+			RoomID:    e.RoomID,
+			SenderKey: e.SenderKey,
+			SessionID: e.SessionID,
+		},
+		RequestingDeviceID: m.Client.DeviceID,
+		RequestID:          req.id,
+	})
+	if err != nil {
+		// Allow the key to be requested again.
+		m.keyRequestMu.Lock()
+		delete(m.keyRequests, key)
+		m.keyRequestMu.Unlock()
+		return fmt.Errorf("error requesting room key: %w", err)
+	}
+	return nil
+}
+
+// cancelRoomKeyRequest cancels the outstanding request for the session, if there is one.
+func (m *Machine) cancelRoomKeyRequest(ctx context.Context, roomID matrix.RoomID, sessionID string) error {
+	key := roomKeyRequestKey{roomID, sessionID}
+	m.keyRequestMu.Lock()
+	req, ok := m.keyRequests[key]
+	delete(m.keyRequests, key)
+	m.keyRequestMu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	err := m.sendRoomKeyRequest(ctx, event.RoomKeyRequestEvent{
+		Action:             event.RoomKeyRequestActionCancellation,
+		RequestingDeviceID: m.Client.DeviceID,
+		RequestID:          req.id,
+	})
+	if err != nil {
+		return fmt.Errorf("error cancelling room key request: %w", err)
+	}
+	return nil
+}
+
+// sendRoomKeyRequest sends the request to all other devices of the user.
+func (m *Machine) sendRoomKeyRequest(ctx context.Context, content event.RoomKeyRequestEvent) error {
+	return m.Client.SendToDeviceContext(ctx, event.TypeRoomKeyRequest, api.DeviceMessages{
+		m.Client.UserID: {"*": content},
+	})
+}
+
+// handleUndecryptable requests the key of the event if it could not be decrypted because the session is
+// missing or does not go back far enough.
+func (m *Machine) handleUndecryptable(ctx context.Context, e *event.UndecryptableEvent) error {
+	if e.Algorithm != AlgorithmMegolm {
+		return nil
+	}
+	if !errors.Is(e.Err, ErrNoSession) && !errors.Is(e.Err, olm.ErrUnknownMessageIndex) {
+		return nil
+	}
+	return m.RequestRoomKey(ctx, &e.RoomEncryptedEvent)
+}
+
+// handleRoomKeyRequest forwards the requested key to the device of the user that requested it if the device
+// is trusted. A m.room_key.withheld notice is sent instead if the key is not forwarded.
+func (m *Machine) handleRoomKeyRequest(ctx context.Context, e *event.RoomKeyRequestEvent) error {
+	if e.Action != event.RoomKeyRequestActionRequest || e.Body == nil || e.Body.Algorithm != AlgorithmMegolm {
+		return nil
+	}
+	if e.Sender == m.Client.UserID && e.RequestingDeviceID == m.Client.DeviceID {
+		return nil
+	}
+	log := m.Client.Logger.With(debug.UserID(e.Sender), debug.Any("device_id", e.RequestingDeviceID),
+		debug.RoomID(e.Body.RoomID), debug.Any("session_id", e.Body.SessionID))
+
+	devices, err := m.Devices(ctx, e.Sender)
+	if err != nil {
+		return fmt.Errorf("error querying devices: %w", err)
+	}
+	device, ok := devices[e.RequestingDeviceID]
+	if !ok {
+		log.Warn("ignoring room key request from unknown device")
+		return nil
+	}
+	if e.Sender != m.Client.UserID {
+		log.Debug("refusing room key request from another user")
+		return m.withholdRoomKey(ctx, device, e.Body, event.RoomKeyWithheldUnauthorised)
+	}
+	trusted, err := m.DeviceTrusted(device)
+	if err != nil {
+		return err
+	}
+	if !trusted {
+		log.Debug("refusing room key request from untrusted device")
+		return m.withholdRoomKey(ctx, device, e.Body, event.RoomKeyWithheldUnverified)
+	}
+
+	m.mu.Lock()
+	session, err := m.Store.LoadInboundGroupSession(e.Body.RoomID, e.Body.SessionID)
+	if err != nil || session == nil {
+		m.mu.Unlock()
+		if err != nil {
+			return fmt.Errorf("error loading inbound group session: %w", err)
+		}
+		log.Debug("requested room key is unknown")
+		return m.withholdRoomKey(ctx, device, e.Body, event.RoomKeyWithheldUnavailable)
+	}
+	sessionKey, err := session.Export(session.FirstKnownIndex())
+	m.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("error exporting inbound group session: %w", err)
+	}
+	chain := session.ForwardingChain
+	if chain == nil {
+		chain = []string{}
+	}
+	messages, err := m.encryptOlm(ctx, []*Device{device}, event.TypeForwardedRoomKey, event.ForwardedRoomKeyEvent{
+		Algorithm:               AlgorithmMegolm,
+		RoomID:                  session.RoomID,
+		SessionID:               session.ID(),
+		SessionKey:              sessionKey,
+		SenderKey:               session.SenderKey,
+		SenderClaimedSigningKey: session.SigningKey,
+		ForwardingChain:         chain,
+	})
+	if err != nil {
+		return err
+	}
+	if len(messages) == 0 {
+		return m.withholdRoomKey(ctx, device, e.Body, event.RoomKeyWithheldNoOlm)
+	}
+	log.Debug("forwarding room key")
+	if err := m.Client.SendToDeviceContext(ctx, event.TypeRoomEncrypted, messages); err != nil {
+		return fmt.Errorf("error forwarding room key: %w", err)
+	}
+	return nil
+}
+
+// withholdRoomKey lets the device know that the requested key will not be sent.
+func (m *Machine) withholdRoomKey(ctx context.Context, device *Device, body *event.RoomKeyRequestBody,
+	code event.RoomKeyWithheldCode) error {
+	content := event.RoomKeyWithheldEvent{
+		Algorithm: body.Algorithm,
+		Code:      code,
+		Reason:    roomKeyWithheldReasons[code],
+		RoomID:    body.RoomID,
+		SessionID: body.SessionID,
+		SenderKey: body.SenderKey,
+	}
+	if code == event.RoomKeyWithheldNoOlm {
+		// m.no_olm is about the Olm session between the two devices instead of the Megolm session.
+		m.mu.Lock()
+		content.SenderKey, _ = m.account.IdentityKeys()
+		m.mu.Unlock()
+		content.FromDevice = m.Client.DeviceID
+	}
+	err := m.Client.SendToDeviceContext(ctx, event.TypeRoomKeyWithheld, api.DeviceMessages{
+		device.UserID: {device.DeviceID: content},
+	})
+	if err != nil {
+		return fmt.Errorf("error sending withheld notice: %w", err)
+	}
+	return nil
+}
+
+// roomKeyWithheldReasons are the human-readable reasons sent with the withheld codes.
+var roomKeyWithheldReasons = map[event.RoomKeyWithheldCode]string{
+	event.RoomKeyWithheldUnverified:   "The sender has not verified this device.",
+	event.RoomKeyWithheldUnauthorised: "You are not authorised to read the message.",
+	event.RoomKeyWithheldUnavailable:  "The requested key was not found.",
+	event.RoomKeyWithheldNoOlm:        "Unable to establish a secure channel.",
+}
@@ -0,0 +1,163 @@
+package e2ee
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/chanbakjsd/gotrix/api"
+	"github.com/chanbakjsd/gotrix/api/httputil"
+	"github.com/chanbakjsd/gotrix/encrypt/olm"
+	"github.com/chanbakjsd/gotrix/event"
+	"github.com/chanbakjsd/gotrix/matrix"
+)
+
+// newTestMachine returns a machine of the device talking to a homeserver served by handler.
+func newTestMachine(t *testing.T, userID matrix.UserID, deviceID matrix.DeviceID, handler http.HandlerFunc) *Machine {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	client := &api.Client{
+		Client:   httputil.NewClient(),
+		UserID:   userID,
+		DeviceID: deviceID,
+	}
+	if err := client.SetBaseURL(srv.URL); err != nil {
+		t.Fatalf("error setting base URL: %v", err)
+	}
+	m, err := NewMachine(client, NewMemoryStore())
+	if err != nil {
+		t.Fatalf("error creating machine: %v", err)
+	}
+	return m
+}
+
+func TestForwardedRoomKey(t *testing.T) {
+	const (
+		roomID       matrix.RoomID = "!room:example.org"
+		forwarderKey               = "forwarder+curve25519+key"
+	)
+
+	tests := []struct {
+		name      string
+		sender    matrix.UserID
+		requested bool
+		device    *Device
+		saved     bool
+	}{
+		{
+			name:      "verified device",
+			sender:    "@alice:example.org",
+			requested: true,
+			device:    &Device{DeviceID: "PHONE", IdentityKey: forwarderKey, Verified: true},
+			saved:     true,
+		},
+		{
+			name:   "not requested",
+			sender: "@alice:example.org",
+			device: &Device{DeviceID: "PHONE", IdentityKey: forwarderKey, Verified: true},
+		},
+		{
+			name:      "unknown device",
+			sender:    "@alice:example.org",
+			requested: true,
+		},
+		{
+			name:      "unverified device",
+			sender:    "@alice:example.org",
+			requested: true,
+			device:    &Device{DeviceID: "PHONE", IdentityKey: forwarderKey},
+		},
+		{
+			name:      "another user",
+			sender:    "@mallory:example.org",
+			requested: true,
+			device:    &Device{DeviceID: "PHONE", IdentityKey: forwarderKey, Verified: true},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var cancelled bool
+			m := newTestMachine(t, "@alice:example.org", "LAPTOP", func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				if strings.Contains(r.URL.Path, "/sendToDevice/m.room_key_request/") {
+					cancelled = true
+				}
+				_, _ = w.Write([]byte("{}"))
+			})
+
+			outbound, err := olm.NewOutboundGroupSession()
+			if err != nil {
+				t.Fatalf("error creating outbound group session: %v", err)
+			}
+			if test.device != nil {
+				test.device.UserID = m.Client.UserID
+				err := m.Store.SaveDevices(m.Client.UserID, map[matrix.DeviceID]*Device{
+					test.device.DeviceID: test.device,
+				})
+				if err != nil {
+					t.Fatalf("error saving devices: %v", err)
+				}
+			}
+			if test.requested {
+				m.keyRequests = map[roomKeyRequestKey]*roomKeyRequest{
+					{roomID, outbound.ID()}: {id: "request"},
+				}
+			}
+
+			inbound, err := olm.NewInboundGroupSession(outbound.SessionKey())
+			if err != nil {
+				t.Fatalf("error creating inbound group session: %v", err)
+			}
+			exported, err := inbound.Export(0)
+			if err != nil {
+				t.Fatalf("error exporting session: %v", err)
+			}
+			e := &event.ForwardedRoomKeyEvent{
+				Algorithm:  AlgorithmMegolm,
+				RoomID:     roomID,
+				SessionID:  outbound.ID(),
+				SessionKey: exported,
+				SenderKey:  "creator+curve25519+key",
+			}
+			e.Sender = test.sender
+			e.Encryption = &event.EncryptionInfo{Algorithm: AlgorithmOlm, SenderKey: forwarderKey}
+
+			m.mu.Lock()
+			err = m.receiveForwardedRoomKey(e)
+			m.mu.Unlock()
+			if err != nil {
+				t.Fatalf("unexpected error receiving key: %v", err)
+			}
+			if err := m.HandleEvent(context.Background(), e); err != nil {
+				t.Fatalf("unexpected error handling event: %v", err)
+			}
+
+			session, err := m.Store.LoadInboundGroupSession(roomID, outbound.ID())
+			if err != nil {
+				t.Fatalf("error loading session: %v", err)
+			}
+			if (session != nil) != test.saved {
+				t.Fatalf("expected saved to be %t", test.saved)
+			}
+			if session != nil && len(session.ForwardingChain) != 1 {
+				t.Errorf("expected forwarding chain of 1 device, got %v", session.ForwardingChain)
+			}
+			_, pending := m.keyRequests[roomKeyRequestKey{roomID, outbound.ID()}]
+			if pending != (test.requested && !test.saved) {
+				t.Errorf("expected pending to be %t, got %t", test.requested && !test.saved, pending)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if cancelled != test.saved {
+				t.Errorf("expected cancellation sent to be %t, got %t", test.saved, cancelled)
+			}
+		})
+	}
+}
@@ -0,0 +1,310 @@
+// Package e2ee implements Matrix end-to-end encryption on top of package olm.
+package e2ee
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/chanbakjsd/gotrix/api"
+	"github.com/chanbakjsd/gotrix/debug"
+	"github.com/chanbakjsd/gotrix/encrypt/olm"
+	"github.com/chanbakjsd/gotrix/event"
+	"github.com/chanbakjsd/gotrix/matrix"
+)
+
+// ErrDeviceMismatch is returned when the keys of a device are missing or belong to another device.
+var ErrDeviceMismatch = errors.New("device keys do not match the device")
+
+// Encryption algorithms supported by the Machine.
+const (
+	AlgorithmOlm    = "m.olm.v1.curve25519-aes-sha2"
+	AlgorithmMegolm = "m.megolm.v1.aes-sha2"
+)
+
+// KeyAlgorithmSignedCurve25519 is the algorithm of one-time keys and fallback keys.
+const KeyAlgorithmSignedCurve25519 = "signed_curve25519"
+
+// Machine manages the encryption keys of a device.
+type Machine struct {
+	Client *api.Client
+	Store  Store
+
+	// VerificationCallbacks are called as key verifications with other devices progress.
+	VerificationCallbacks VerificationCallbacks
+	// SendRoomEvent sends the events of in-room verifications. The events are sent unencrypted using Client if
+	// it is nil.
+	SendRoomEvent func(ctx context.Context, roomID matrix.RoomID, eventType event.Type,
+		content interface{}) (matrix.EventID, error)
+
+	mu      sync.Mutex
+	account *Account
+	// roomMu protects roomLocks, which serialise the use of the outbound Megolm session of each room. The room
+	// locks are held while sharing the session so mu must not be held while locking them.
+	roomMu    sync.Mutex
+	roomLocks map[matrix.RoomID]*sync.Mutex
+	// messageIndexes maps the Megolm message indexes that have been decrypted to their entry in
+	// messageIndexLRU to detect replay attacks. Only the most recently used maxMessageIndexes are kept.
+	messageIndexes  map[string]*list.Element
+	messageIndexLRU *list.List // of *messageIndex, most recently used at the front.
+
+	// deviceMu protects lists. It is separate from mu so that devices can be queried while encrypting.
+	deviceMu sync.Mutex
+	lists    *DeviceLists
+
+	verificationMu sync.Mutex
+	verifications  map[verificationKey]*Verification
+
+	// backupMu protects the key backup state. Sessions are queued while holding mu so it must not be held
+	// while locking mu.
+	backupMu    sync.Mutex
+	backup      *KeyBackup
+	backupQueue []*InboundGroupSession
+	// backupScanned is true once all sessions in the store have been checked for the current backup.
+	backupScanned bool
+
+	secretMu       sync.Mutex
+	secretRequests map[string]*secretRequest
+
+	// keyRequests maps the sessions whose keys have been requested to the request.
+	keyRequestMu sync.Mutex
+	keyRequests  map[roomKeyRequestKey]*roomKeyRequest
+}
+
+// NewMachine creates a Machine for the device the client is logged in as. The account is loaded from the
+// store or created and saved if there is none.
+func NewMachine(client *api.Client, store Store) (*Machine, error) {
+	account, err := store.LoadAccount()
+	if err != nil {
+		return nil, fmt.Errorf("error loading account: %w", err)
+	}
+	if account == nil {
+		olmAccount, err := olm.NewAccount()
+		if err != nil {
+			return nil, fmt.Errorf("error creating account: %w", err)
+		}
+		account = &Account{Account: olmAccount}
+		if err := store.SaveAccount(account); err != nil {
+			return nil, fmt.Errorf("error saving account: %w", err)
+		}
+	}
+
+	backup, err := store.LoadKeyBackup()
+	if err != nil {
+		return nil, fmt.Errorf("error loading key backup: %w", err)
+	}
+
+	return &Machine{
+		Client:  client,
+		Store:   store,
+		account: account,
+		backup:  backup,
+	}, nil
+}
+
+// IdentityKeys returns the Curve25519 and Ed25519 identity keys of the device in base64.
+func (m *Machine) IdentityKeys() (curve25519 string, ed25519 string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.account.IdentityKeys()
+}
+
+// DeviceKeys returns the signed device keys of the device.
+func (m *Machine) DeviceKeys() (*api.DeviceKeys, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.deviceKeys()
+}
+
+func (m *Machine) deviceKeys() (*api.DeviceKeys, error) {
+	curve, ed := m.account.IdentityKeys()
+	keys := &api.DeviceKeys{
+		UserID:     m.Client.UserID,
+		DeviceID:   m.Client.DeviceID,
+		Algorithms: []string{AlgorithmOlm, AlgorithmMegolm},
+		Keys: map[string]string{
+			"curve25519:" + string(m.Client.DeviceID): curve,
+			"ed25519:" + string(m.Client.DeviceID):    ed,
+		},
+	}
+
+	var err error
+	keys.Signatures, err = signJSON(keys, m.account.Account, m.Client.UserID, "ed25519:"+string(m.Client.DeviceID))
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// ShareKeys uploads the device keys if they have not been uploaded yet and tops up the one-time keys on the
+// homeserver.
+func (m *Machine) ShareKeys(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	req := api.KeysUploadArg{}
+	if !m.account.Shared {
+		var err error
+		req.DeviceKeys, err = m.deviceKeys()
+		if err != nil {
+			return err
+		}
+	}
+
+	// Uploading nothing returns the number of one-time keys on the homeserver.
+	counts, err := m.Client.KeysUploadContext(ctx, req)
+	if err != nil {
+		return err
+	}
+	if !m.account.Shared {
+		m.account.Shared = true
+		if err := m.Store.SaveAccount(m.account); err != nil {
+			return fmt.Errorf("error saving account: %w", err)
+		}
+	}
+	return m.uploadKeys(ctx, counts[KeyAlgorithmSignedCurve25519], false)
+}
+
+// HandleEvent handles the key verification, secret sharing and room key sharing events received from other
+// devices, and requests the keys of events that could not be decrypted. It should be called with every event
+// after it has been decrypted.
+func (m *Machine) HandleEvent(ctx context.Context, e event.Event) error {
+	switch e := e.(type) {
+	case *event.UndecryptableEvent:
+		return m.handleUndecryptable(ctx, e)
+	case *event.RoomKeyEvent:
+		if e.Encryption == nil {
+			return nil
+		}
+		return m.cancelRoomKeyRequest(ctx, e.RoomID, e.SessionID)
+	case *event.ForwardedRoomKeyEvent:
+		if e.Encryption == nil {
+			return nil
+		}
+		// The key is not saved if it has been forwarded by an untrusted device so another device may still
+		// answer the request.
+		m.mu.Lock()
+		session, err := m.Store.LoadInboundGroupSession(e.RoomID, e.SessionID)
+		m.mu.Unlock()
+		if err != nil {
+			return fmt.Errorf("error loading inbound group session: %w", err)
+		}
+		if session == nil {
+			return nil
+		}
+		return m.cancelRoomKeyRequest(ctx, e.RoomID, e.SessionID)
+	case *event.RoomKeyRequestEvent:
+		return m.handleRoomKeyRequest(ctx, e)
+	case *event.RoomKeyWithheldEvent:
+		m.Client.Logger.Debug("room key withheld", debug.UserID(e.Sender), debug.RoomID(e.RoomID),
+			debug.Any("session_id", e.SessionID), debug.Any("code", e.Code))
+		return nil
+	case *event.SecretRequestEvent:
+		return m.handleSecretRequest(ctx, e)
+	case *event.SecretSendEvent:
+		return m.handleSecretSend(ctx, e)
+	}
+	return m.HandleVerificationEvent(ctx, e)
+}
+
+// ProcessSync marks the users whose devices have changed as outdated, then tops up the one-time keys and
+// replaces the fallback key according to the counts in the sync response. since is the sync token the
+// response was requested with.
+func (m *Machine) ProcessSync(ctx context.Context, since string, resp *api.SyncResponse) error {
+	if err := m.updateDeviceLists(ctx, since, resp); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Some homeservers omit the counts when they have not changed.
+	if resp.DeviceOneTimeKeysCount == nil && resp.DeviceUnusedFallbackKeyTypes == nil {
+		return nil
+	}
+
+	count, ok := resp.DeviceOneTimeKeysCount[KeyAlgorithmSignedCurve25519]
+	if !ok {
+		count = m.account.MaxNumberOfOneTimeKeys() / 2
+	}
+
+	// A nil list means that the homeserver does not support fallback keys.
+	newFallback := resp.DeviceUnusedFallbackKeyTypes != nil
+	for _, v := range resp.DeviceUnusedFallbackKeyTypes {
+		if v == KeyAlgorithmSignedCurve25519 {
+			newFallback = false
+		}
+	}
+
+	return m.uploadKeys(ctx, count, newFallback)
+}
+
+// uploadKeys generates and uploads enough one-time keys for the homeserver to have half of the maximum
+// number of keys, and a new fallback key if requested. Keys that failed to upload previously are uploaded
+// again.
+func (m *Machine) uploadKeys(ctx context.Context, serverCount int, newFallback bool) error {
+	unpublished := len(m.account.OneTimeKeys())
+	if toGenerate := m.account.MaxNumberOfOneTimeKeys()/2 - serverCount - unpublished; toGenerate > 0 {
+		if err := m.account.GenerateOneTimeKeys(toGenerate); err != nil {
+			return fmt.Errorf("error generating one-time keys: %w", err)
+		}
+	}
+	if newFallback {
+		if err := m.account.GenerateFallbackKey(); err != nil {
+			return fmt.Errorf("error generating fallback key: %w", err)
+		}
+	}
+
+	oneTimeKeys, err := m.signKeys(m.account.OneTimeKeys(), false)
+	if err != nil {
+		return err
+	}
+	fallbackKeys, err := m.signKeys(m.account.FallbackKey(), true)
+	if err != nil {
+		return err
+	}
+	if len(oneTimeKeys) == 0 && len(fallbackKeys) == 0 {
+		return nil
+	}
+
+	// Save the keys first so that we can decrypt messages sent using them even if we crash.
+	if err := m.Store.SaveAccount(m.account); err != nil {
+		return fmt.Errorf("error saving account: %w", err)
+	}
+
+	m.Client.Logger.Debug("uploading keys",
+		debug.Any("one_time_keys", len(oneTimeKeys)), debug.Any("fallback_keys", len(fallbackKeys)))
+	_, err = m.Client.KeysUploadContext(ctx, api.KeysUploadArg{
+		OneTimeKeys:  oneTimeKeys,
+		FallbackKeys: fallbackKeys,
+	})
+	if err != nil {
+		return err
+	}
+
+	m.account.MarkKeysAsPublished()
+	if err := m.Store.SaveAccount(m.account); err != nil {
+		return fmt.Errorf("error saving account: %w", err)
+	}
+	return nil
+}
+
+// signKeys signs the provided keys in the format returned by olm.Account.OneTimeKeys.
+func (m *Machine) signKeys(keys map[string]string, fallback bool) (map[string]api.OneTimeKey, error) {
+	signed := make(map[string]api.OneTimeKey, len(keys))
+	for id, key := range keys {
+		v := api.OneTimeKey{
+			Key:      key,
+			Fallback: fallback,
+		}
+		var err error
+		v.Signatures, err = signJSON(v, m.account.Account, m.Client.UserID, "ed25519:"+string(m.Client.DeviceID))
+		if err != nil {
+			return nil, err
+		}
+		signed[KeyAlgorithmSignedCurve25519+":"+id] = v
+	}
+	return signed, nil
+}
@@ -0,0 +1,209 @@
+package e2ee
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/chanbakjsd/gotrix/debug"
+	"github.com/chanbakjsd/gotrix/encrypt/olm"
+	"github.com/chanbakjsd/gotrix/event"
+	"github.com/chanbakjsd/gotrix/matrix"
+)
+
+// EncryptRoomEvent encrypts the event with the outbound Megolm session of the room. The session is rotated
+// according to the encryption settings and shared with the devices of the users that have not received it
+// yet. users should be the members of the room that are allowed to read the event.
+func (m *Machine) EncryptRoomEvent(ctx context.Context, roomID matrix.RoomID, settings *event.RoomEncryptionEvent,
+	users []matrix.UserID, eventType event.Type, content interface{}) (*MegolmEncryptedContent, error) {
+	rawContent, err := json.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := json.Marshal(megolmPayload{
+		Type:    eventType,
+		Content: rawContent,
+		RoomID:  roomID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	unlock := m.lockRoom(roomID)
+	defer unlock()
+
+	devices, err := m.UsersDevices(ctx, users)
+	if err != nil {
+		return nil, fmt.Errorf("error querying devices: %w", err)
+	}
+
+	m.mu.Lock()
+	session, err := m.outboundGroupSession(roomID, settings, devices)
+	m.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if err := m.shareGroupSession(ctx, session, devices); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ciphertext := session.Encrypt(plaintext)
+	if err := m.Store.SaveOutboundGroupSession(session); err != nil {
+		return nil, fmt.Errorf("error saving outbound group session: %w", err)
+	}
+
+	curve, _ := m.account.IdentityKeys()
+	return &MegolmEncryptedContent{
+		Algorithm:  AlgorithmMegolm,
+		SenderKey:  curve,
+		Ciphertext: ciphertext,
+		SessionID:  session.ID(),
+		DeviceID:   m.Client.DeviceID,
+	}, nil
+}
+
+// lockRoom locks the outbound Megolm session of the room and returns the function to unlock it.
+func (m *Machine) lockRoom(roomID matrix.RoomID) func() {
+	m.roomMu.Lock()
+	if m.roomLocks == nil {
+		m.roomLocks = make(map[matrix.RoomID]*sync.Mutex)
+	}
+	lock, ok := m.roomLocks[roomID]
+	if !ok {
+		lock = &sync.Mutex{}
+		m.roomLocks[roomID] = lock
+	}
+	m.roomMu.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+// DiscardGroupSession discards the outbound Megolm session of the room so that a new one is created for the
+// next message.
+func (m *Machine) DiscardGroupSession(roomID matrix.RoomID) error {
+	unlock := m.lockRoom(roomID)
+	defer unlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.Store.RemoveOutboundGroupSession(roomID)
+}
+
+// outboundGroupSession returns the outbound Megolm session of the room, creating a new one if there is none
+// or if the current one has to be rotated. devices are the devices that are allowed to read the messages.
+// m.mu must be held.
+func (m *Machine) outboundGroupSession(roomID matrix.RoomID, settings *event.RoomEncryptionEvent,
+	devices map[matrix.UserID]map[matrix.DeviceID]*Device) (*OutboundGroupSession, error) {
+	session, err := m.Store.LoadOutboundGroupSession(roomID)
+	if err != nil {
+		return nil, fmt.Errorf("error loading outbound group session: %w", err)
+	}
+	if session != nil && !session.Expired(settings) && !sharedWithOthers(session, devices) {
+		return session, nil
+	}
+
+	olmSession, err := olm.NewOutboundGroupSession()
+	if err != nil {
+		return nil, fmt.Errorf("error creating outbound group session: %w", err)
+	}
+	session = &OutboundGroupSession{
+		OutboundGroupSession: olmSession,
+		RoomID:               roomID,
+		CreatedAt:            time.Now(),
+		SharedWith:           make(map[matrix.UserID]map[matrix.DeviceID]string),
+	}
+	m.Client.Logger.Debug("created outbound group session", debug.RoomID(roomID),
+		debug.Any("session_id", session.ID()))
+
+	// Keep an inbound session so that we can decrypt our own messages.
+	inbound, err := olm.NewInboundGroupSession(session.SessionKey())
+	if err != nil {
+		return nil, fmt.Errorf("error creating inbound group session: %w", err)
+	}
+	curve, ed := m.account.IdentityKeys()
+	inboundSession := &InboundGroupSession{
+		InboundGroupSession: inbound,
+		RoomID:              roomID,
+		SenderKey:           curve,
+		SigningKey:          ed,
+	}
+	if err := m.Store.SaveInboundGroupSession(inboundSession); err != nil {
+		return nil, fmt.Errorf("error saving inbound group session: %w", err)
+	}
+	m.queueBackup(inboundSession)
+
+	if err := m.Store.SaveOutboundGroupSession(session); err != nil {
+		return nil, fmt.Errorf("error saving outbound group session: %w", err)
+	}
+	return session, nil
+}
+
+// sharedWithOthers returns true if the session has been shared with a device that is no longer in devices or
+// whose identity key has changed, in which case it has to be rotated so that it cannot read new messages.
+// This happens when a user leaves the room or when a device is removed.
+func sharedWithOthers(session *OutboundGroupSession, devices map[matrix.UserID]map[matrix.DeviceID]*Device) bool {
+	for userID, sharedDevices := range session.SharedWith {
+		for deviceID, identityKey := range sharedDevices {
+			device, ok := devices[userID][deviceID]
+			if !ok || device.IdentityKey != identityKey {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// shareGroupSession sends the session key to the devices that have not received it yet. The room of the
+// session must be locked but m.mu must not be held.
+func (m *Machine) shareGroupSession(ctx context.Context, session *OutboundGroupSession,
+	devices map[matrix.UserID]map[matrix.DeviceID]*Device) error {
+	var targets []*Device
+	for userID, userDevices := range devices {
+		for deviceID, device := range userDevices {
+			if userID == m.Client.UserID && deviceID == m.Client.DeviceID {
+				continue
+			}
+			if _, ok := session.SharedWith[userID][deviceID]; !ok {
+				targets = append(targets, device)
+			}
+		}
+	}
+	if len(targets) == 0 {
+		return nil
+	}
+
+	// The session is not used by anyone else while the room is locked.
+	messages, err := m.encryptOlm(ctx, targets, event.TypeRoomKey, event.RoomKeyEvent{
+		Algorithm:  AlgorithmMegolm,
+		RoomID:     session.RoomID,
+		SessionID:  session.ID(),
+		SessionKey: session.SessionKey(),
+	})
+	if err != nil {
+		return err
+	}
+	if len(messages) > 0 {
+		if err := m.Client.SendToDeviceContext(ctx, event.TypeRoomEncrypted, messages); err != nil {
+			return fmt.Errorf("error sending room key: %w", err)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for userID, userDevices := range messages {
+		if session.SharedWith[userID] == nil {
+			session.SharedWith[userID] = make(map[matrix.DeviceID]string)
+		}
+		for deviceID := range userDevices {
+			session.SharedWith[userID][deviceID] = devices[userID][deviceID].IdentityKey
+		}
+	}
+	m.Client.Logger.Debug("shared group session", debug.RoomID(session.RoomID),
+		debug.Any("session_id", session.ID()), debug.Any("devices", len(targets)))
+	return m.Store.SaveOutboundGroupSession(session)
+}
@@ -0,0 +1,159 @@
+package e2ee
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/chanbakjsd/gotrix/api"
+	"github.com/chanbakjsd/gotrix/debug"
+	"github.com/chanbakjsd/gotrix/event"
+	"github.com/chanbakjsd/gotrix/matrix"
+)
+
+// latestOlmSession returns the most recently used Olm session with the device, or nil if there is none.
+func (m *Machine) latestOlmSession(device *Device) (*OlmSession, error) {
+	sessions, err := m.Store.LoadOlmSessions(device.IdentityKey)
+	if err != nil {
+		return nil, fmt.Errorf("error loading Olm sessions: %w", err)
+	}
+	var latest *OlmSession
+	for _, v := range sessions {
+		if latest == nil || v.LastUsed.After(latest.LastUsed) {
+			latest = v
+		}
+	}
+	return latest, nil
+}
+
+// claimOneTimeKeys claims a one-time key for each of the devices. Devices that have run out of one-time keys
+// or returned keys that are not correctly signed are left out of the returned map.
+func (m *Machine) claimOneTimeKeys(ctx context.Context, devices []*Device) (map[*Device]string, error) {
+	keys := make(map[*Device]string, len(devices))
+	if len(devices) == 0 {
+		return keys, nil
+	}
+
+	claim := make(map[matrix.UserID]map[matrix.DeviceID]string)
+	for _, v := range devices {
+		if claim[v.UserID] == nil {
+			claim[v.UserID] = make(map[matrix.DeviceID]string)
+		}
+		claim[v.UserID][v.DeviceID] = KeyAlgorithmSignedCurve25519
+	}
+	resp, err := m.Client.KeysClaimContext(ctx, claim, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, device := range devices {
+		log := m.Client.Logger.With(debug.UserID(device.UserID), debug.Any("device_id", device.DeviceID))
+		for _, key := range resp.OneTimeKeys[device.UserID][device.DeviceID] {
+			err := VerifySignature(key, key.Signatures, device.UserID, "ed25519:"+string(device.DeviceID),
+				device.SigningKey)
+			if err != nil {
+				log.Warn("invalid signature on one-time key", debug.Err(err))
+				break
+			}
+			keys[device] = key.Key
+			break
+		}
+		if _, ok := keys[device]; !ok {
+			log.Debug("no one-time key claimed for device")
+		}
+	}
+	return keys, nil
+}
+
+// encryptOlm encrypts the to-device event for each of the devices, creating Olm sessions with them if needed.
+// The devices that the event could not be encrypted for are left out of the returned messages.
+//
+// It locks m.mu while using the Olm sessions but one-time keys are claimed without holding it, so m.mu must
+// not be held when calling it.
+func (m *Machine) encryptOlm(ctx context.Context, devices []*Device, eventType event.Type,
+	content interface{}) (api.DeviceMessages, error) {
+	rawContent, err := json.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []*Device
+	m.mu.Lock()
+	for _, v := range devices {
+		session, err := m.latestOlmSession(v)
+		if err != nil {
+			m.mu.Unlock()
+			return nil, err
+		}
+		if session == nil {
+			missing = append(missing, v)
+		}
+	}
+	m.mu.Unlock()
+
+	oneTimeKeys, err := m.claimOneTimeKeys(ctx, missing)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ourCurve, ourEd := m.account.IdentityKeys()
+	messages := make(api.DeviceMessages)
+	for _, device := range devices {
+		// The sessions are loaded again as they may have been used while m.mu was not held.
+		session, err := m.latestOlmSession(device)
+		if err != nil {
+			return nil, err
+		}
+		if oneTimeKey, ok := oneTimeKeys[device]; session == nil && ok {
+			created, err := m.account.NewOutboundSession(device.IdentityKey, oneTimeKey)
+			if err != nil {
+				m.Client.Logger.Warn("error creating Olm session", debug.Err(err), debug.UserID(device.UserID),
+					debug.Any("device_id", device.DeviceID))
+				continue
+			}
+			// The session is saved once the message is encrypted.
+			session = &OlmSession{Session: created}
+		}
+		if session == nil {
+			continue
+		}
+
+		plaintext, err := json.Marshal(olmPayload{
+			Type:          eventType,
+			Content:       rawContent,
+			Sender:        m.Client.UserID,
+			SenderDevice:  m.Client.DeviceID,
+			Recipient:     device.UserID,
+			RecipientKeys: map[string]string{"ed25519": device.SigningKey},
+			Keys:          map[string]string{"ed25519": ourEd},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		msgType, body, err := session.Encrypt(plaintext)
+		if err != nil {
+			return nil, fmt.Errorf("error encrypting Olm message: %w", err)
+		}
+		session.LastUsed = time.Now()
+		if err := m.Store.SaveOlmSession(device.IdentityKey, session); err != nil {
+			return nil, fmt.Errorf("error saving Olm session: %w", err)
+		}
+
+		if messages[device.UserID] == nil {
+			messages[device.UserID] = make(map[matrix.DeviceID]interface{})
+		}
+		messages[device.UserID][device.DeviceID] = OlmEncryptedContent{
+			Algorithm: AlgorithmOlm,
+			SenderKey: ourCurve,
+			Ciphertext: map[string]event.OlmCiphertext{
+				device.IdentityKey: {Type: msgType, Body: body},
+			},
+		}
+	}
+	return messages, nil
+}
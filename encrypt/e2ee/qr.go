@@ -0,0 +1,312 @@
+package e2ee
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/chanbakjsd/gotrix/encrypt/olm"
+	"github.com/chanbakjsd/gotrix/event"
+)
+
+// ErrBadQRCode is returned when a scanned QR code is not a valid verification QR code for the verification.
+var ErrBadQRCode = errors.New("invalid verification QR code")
+
+// QRCodeMode is what a verification QR code is used for. It determines the meaning of the keys in the QR code.
+type QRCodeMode byte
+
+// List of QR code modes defined in the spec.
+const (
+	// QRCodeVerifyUser is used to verify another user. Key1 is the master key of the user showing the QR code
+	// and Key2 is what they think the master key of the other user is.
+	QRCodeVerifyUser QRCodeMode = iota
+	// QRCodeSelfTrusted is used to verify a device of the same user when the device showing the QR code
+	// trusts the master key. Key1 is the master key and Key2 is what the device thinks the Ed25519 key of the
+	// other device is.
+	QRCodeSelfTrusted
+	// QRCodeSelfUntrusted is used to verify a device of the same user when the device showing the QR code does
+	// not trust the master key. Key1 is the Ed25519 key of the device and Key2 is what the device thinks the
+	// master key is.
+	QRCodeSelfUntrusted
+)
+
+// qrCodePrefix is the start of the binary payload of verification QR codes.
+const qrCodePrefix = "MATRIX"
+
+// qrCodeVersion is the version of the binary payload of verification QR codes.
+const qrCodeVersion = 0x02
+
+// qrCodeSecretLength is the length of the shared secret in the QR codes generated by the Machine.
+const qrCodeSecretLength = 16
+
+// qrCodeMinSecretLength is the minimum length of the shared secret required by the spec.
+const qrCodeMinSecretLength = 8
+
+// QRCode is the content of a verification QR code.
+type QRCode struct {
+	Mode          QRCodeMode
+	TransactionID string
+	// Key1 and Key2 are Ed25519 public keys in base64. Their meaning depends on Mode.
+	Key1 string
+	Key2 string
+	// Secret is sent back by the device that scans the QR code to prove that it has scanned it.
+	Secret []byte
+}
+
+// Encode encodes the QR code into its binary payload, which should be rendered as a byte mode QR code.
+func (q *QRCode) Encode() ([]byte, error) {
+	key1, err := olm.DecodeBase64(q.Key1)
+	if err != nil || len(key1) != 32 {
+		return nil, fmt.Errorf("%w: invalid first key", ErrBadQRCode)
+	}
+	key2, err := olm.DecodeBase64(q.Key2)
+	if err != nil || len(key2) != 32 {
+		return nil, fmt.Errorf("%w: invalid second key", ErrBadQRCode)
+	}
+	if len(q.TransactionID) > 0xffff {
+		return nil, fmt.Errorf("%w: transaction ID is too long", ErrBadQRCode)
+	}
+	if len(q.Secret) < qrCodeMinSecretLength {
+		return nil, fmt.Errorf("%w: secret is too short", ErrBadQRCode)
+	}
+
+	var b bytes.Buffer
+	b.WriteString(qrCodePrefix)
+	b.WriteByte(qrCodeVersion)
+	b.WriteByte(byte(q.Mode))
+	_ = binary.Write(&b, binary.BigEndian, uint16(len(q.TransactionID)))
+	b.WriteString(q.TransactionID)
+	b.Write(key1)
+	b.Write(key2)
+	b.Write(q.Secret)
+	return b.Bytes(), nil
+}
+
+// DecodeQRCode decodes the binary payload of a scanned verification QR code.
+func DecodeQRCode(b []byte) (*QRCode, error) {
+	const header = len(qrCodePrefix) + 1 + 1 + 2
+	if len(b) < header || string(b[:len(qrCodePrefix)]) != qrCodePrefix {
+		return nil, ErrBadQRCode
+	}
+	if b[len(qrCodePrefix)] != qrCodeVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrBadQRCode, b[len(qrCodePrefix)])
+	}
+	mode := QRCodeMode(b[len(qrCodePrefix)+1])
+	if mode > QRCodeSelfUntrusted {
+		return nil, fmt.Errorf("%w: unknown mode %d", ErrBadQRCode, mode)
+	}
+	idLength := int(binary.BigEndian.Uint16(b[header-2 : header]))
+	b = b[header:]
+	// The transaction ID, the two keys and the secret.
+	if len(b) < idLength+32+32+qrCodeMinSecretLength {
+		return nil, fmt.Errorf("%w: too short", ErrBadQRCode)
+	}
+	return &QRCode{
+		Mode:          mode,
+		TransactionID: string(b[:idLength]),
+		Key1:          olm.EncodeBase64(b[idLength : idLength+32]),
+		Key2:          olm.EncodeBase64(b[idLength+32 : idLength+64]),
+		Secret:        append([]byte(nil), b[idLength+64:]...),
+	}, nil
+}
+
+// QRCode returns the QR code to show to the other device once the request has been accepted, if it supports
+// scanning QR codes. The OnQRScanned callback is called once the other device has scanned it. The same QR code
+// is returned on subsequent calls.
+//
+// ErrNoUserIdentity is returned if the cross-signing keys needed for the QR code are not known or trusted.
+func (v *Verification) QRCode(ctx context.Context) (*QRCode, error) {
+	v.mu.Lock()
+	defer v.unlock()
+	if v.qrCode != nil {
+		return v.qrCode, nil
+	}
+	if v.state != VerificationReady || !containsString(v.Methods, VerificationMethodQRScan) {
+		return nil, ErrVerificationState
+	}
+
+	qr := &QRCode{
+		TransactionID: v.ID,
+		Secret:        make([]byte, qrCodeSecretLength),
+	}
+	if _, err := io.ReadFull(rand.Reader, qr.Secret); err != nil {
+		return nil, err
+	}
+	devices, err := v.m.Devices(ctx, v.OtherUser)
+	if err != nil {
+		return nil, fmt.Errorf("error querying devices: %w", err)
+	}
+	ourMaster, err := v.m.trustedMasterKey(v.m.Client.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case v.OtherUser != v.m.Client.UserID:
+		identity, err := v.m.Store.LoadUserIdentity(v.OtherUser)
+		if err != nil {
+			return nil, fmt.Errorf("error loading user identity: %w", err)
+		}
+		if identity == nil || ourMaster == "" {
+			return nil, ErrNoUserIdentity
+		}
+		qr.Mode, qr.Key1, qr.Key2 = QRCodeVerifyUser, ourMaster, identity.MasterKey()
+	case ourMaster != "":
+		device, ok := devices[v.OtherDevice]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown device", ErrVerificationState)
+		}
+		qr.Mode, qr.Key1, qr.Key2 = QRCodeSelfTrusted, ourMaster, device.SigningKey
+	default:
+		identity, err := v.m.Store.LoadUserIdentity(v.m.Client.UserID)
+		if err != nil {
+			return nil, fmt.Errorf("error loading user identity: %w", err)
+		}
+		if identity == nil {
+			return nil, ErrNoUserIdentity
+		}
+		_, ed := v.m.IdentityKeys()
+		qr.Mode, qr.Key1, qr.Key2 = QRCodeSelfUntrusted, ed, identity.MasterKey()
+	}
+	v.qrCode = qr
+	return qr, nil
+}
+
+// ScanQRCode continues the verification with the QR code shown by the other device once the request has been
+// accepted. The keys in the QR code are checked against the known keys and marked as verified if they match,
+// in which case the other device is asked to confirm that the QR code has been scanned.
+//
+// An error wrapping ErrBadQRCode is returned without cancelling the verification if the QR code is not for
+// this verification so that another one can be scanned.
+func (v *Verification) ScanQRCode(ctx context.Context, data []byte) error {
+	v.mu.Lock()
+	defer v.unlock()
+	if v.state != VerificationReady {
+		return ErrVerificationState
+	}
+	qr, err := DecodeQRCode(data)
+	if err != nil {
+		return err
+	}
+	if qr.TransactionID != v.ID {
+		return fmt.Errorf("%w: transaction ID does not match", ErrBadQRCode)
+	}
+	own := v.OtherUser == v.m.Client.UserID
+	if (qr.Mode == QRCodeVerifyUser) == own {
+		return v.cancel(ctx, event.VerificationCancelUserMismatch, "QR code mode does not match the users")
+	}
+
+	devices, err := v.m.Devices(ctx, v.OtherUser)
+	if err != nil {
+		return fmt.Errorf("error querying devices: %w", err)
+	}
+	identity, err := v.m.Store.LoadUserIdentity(v.OtherUser)
+	if err != nil {
+		return fmt.Errorf("error loading user identity: %w", err)
+	}
+	ourMaster, err := v.m.trustedMasterKey(v.m.Client.UserID)
+	if err != nil {
+		return err
+	}
+
+	var match bool
+	switch qr.Mode {
+	case QRCodeVerifyUser:
+		match = identity != nil && identity.MasterKey() == qr.Key1 && ourMaster != "" && ourMaster == qr.Key2
+	case QRCodeSelfTrusted:
+		_, ed := v.m.IdentityKeys()
+		match = identity != nil && identity.MasterKey() == qr.Key1 && ed == qr.Key2
+	case QRCodeSelfUntrusted:
+		device, ok := devices[v.OtherDevice]
+		match = ok && device.SigningKey == qr.Key1 && ourMaster != "" && ourMaster == qr.Key2
+	}
+	if !match {
+		return v.cancel(ctx, event.VerificationCancelKeyMismatch, "keys in the QR code do not match")
+	}
+
+	err = v.send(ctx, event.TypeVerificationStart, event.VerificationStartEvent{
+		VerificationFlow: v.flow(),
+		FromDevice:       v.m.Client.DeviceID,
+		Method:           VerificationMethodReciprocate,
+		Secret:           olm.EncodeBase64(qr.Secret),
+	})
+	if err != nil {
+		return fmt.Errorf("error starting verification: %w", err)
+	}
+	v.state = VerificationConfirmed
+
+	switch qr.Mode {
+	case QRCodeVerifyUser:
+		err = v.m.setIdentityVerified(v.OtherUser, qr.Key1)
+		if err == nil {
+			v.crossSign(ctx, false, true)
+		}
+	case QRCodeSelfTrusted:
+		err = v.m.setIdentityVerified(v.OtherUser, qr.Key1)
+	case QRCodeSelfUntrusted:
+		err = v.m.SetDeviceVerified(v.OtherUser, v.OtherDevice, true)
+		if err == nil {
+			v.crossSign(ctx, true, false)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return v.sendDone(ctx)
+}
+
+// handleReciprocate checks the secret sent by the other device after scanning our QR code and calls the
+// OnQRScanned callback.
+func (v *Verification) handleReciprocate(ctx context.Context, e *event.VerificationStartEvent) error {
+	if v.qrCode == nil {
+		return v.cancel(ctx, event.VerificationCancelUnknownMethod, "no QR code has been shown")
+	}
+	secret, err := olm.DecodeBase64(e.Secret)
+	if err != nil || !hmac.Equal(secret, v.qrCode.Secret) {
+		return v.cancel(ctx, event.VerificationCancelKeyMismatch, "shared secret does not match")
+	}
+
+	v.qrScanned = true
+	v.state = VerificationKeysExchanged
+	if cb := v.m.VerificationCallbacks.OnQRScanned; cb != nil {
+		v.callbacks = append(v.callbacks, func() { cb(v) })
+	}
+	return nil
+}
+
+// confirmQR marks the keys in the QR code shown by us as verified once the user has confirmed that the other
+// device has scanned it.
+func (v *Verification) confirmQR(ctx context.Context) error {
+	var err error
+	switch v.qrCode.Mode {
+	case QRCodeVerifyUser:
+		err = v.m.setIdentityVerified(v.OtherUser, v.qrCode.Key2)
+		if err == nil {
+			v.crossSign(ctx, false, true)
+		}
+	case QRCodeSelfTrusted:
+		devices, err := v.m.Devices(ctx, v.OtherUser)
+		if err != nil {
+			return fmt.Errorf("error querying devices: %w", err)
+		}
+		if device, ok := devices[v.OtherDevice]; !ok || device.SigningKey != v.qrCode.Key2 {
+			return v.cancel(ctx, event.VerificationCancelKeyMismatch, "device key has changed")
+		}
+		if err := v.m.SetDeviceVerified(v.OtherUser, v.OtherDevice, true); err != nil {
+			return err
+		}
+		v.crossSign(ctx, true, false)
+	case QRCodeSelfUntrusted:
+		err = v.m.setIdentityVerified(v.OtherUser, v.qrCode.Key2)
+	}
+	if err != nil {
+		return err
+	}
+	v.state = VerificationConfirmed
+	return v.sendDone(ctx)
+}
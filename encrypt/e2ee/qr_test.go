@@ -0,0 +1,142 @@
+package e2ee
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/chanbakjsd/gotrix/encrypt/olm"
+)
+
+// sequence returns n bytes counting up from start.
+func sequence(start byte, n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = start + byte(i)
+	}
+	return b
+}
+
+func TestQRCodeSpecExample(t *testing.T) {
+	// The example in the spec, with the elided parts of the transaction ID and the keys filled in.
+	transactionID := "$ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqr"
+	payload := []byte{0x4D, 0x41, 0x54, 0x52, 0x49, 0x58, 0x02, 0x00, 0x00, 0x2D}
+	payload = append(payload, transactionID...)
+	payload = append(payload, sequence(0x00, 32)...)
+	payload = append(payload, sequence(0x10, 32)...)
+	payload = append(payload, sequence(0x20, 8)...)
+
+	qr, err := DecodeQRCode(payload)
+	if err != nil {
+		t.Fatalf("unexpected error decoding QR code: %v", err)
+	}
+	if qr.Mode != QRCodeVerifyUser || qr.TransactionID != transactionID {
+		t.Errorf("unexpected mode %d or transaction ID %q", qr.Mode, qr.TransactionID)
+	}
+	if !strings.HasPrefix(qr.Key1, "AAECAwQFBg") || !strings.HasPrefix(qr.Key2, "EBESExQVFh") {
+		t.Errorf("unexpected keys %q and %q", qr.Key1, qr.Key2)
+	}
+	if secret := olm.EncodeBase64(qr.Secret); secret != "ICEiIyQlJic" {
+		t.Errorf("unexpected secret %q", secret)
+	}
+
+	encoded, err := qr.Encode()
+	if err != nil {
+		t.Fatalf("unexpected error encoding QR code: %v", err)
+	}
+	if !bytes.Equal(encoded, payload) {
+		t.Errorf("encoded QR code does not match the spec example\nexpected: %x\ngot: %x", payload, encoded)
+	}
+}
+
+func TestQRCodeRoundTrip(t *testing.T) {
+	modes := []QRCodeMode{QRCodeVerifyUser, QRCodeSelfTrusted, QRCodeSelfUntrusted}
+	for _, mode := range modes {
+		qr := &QRCode{
+			Mode:          mode,
+			TransactionID: "transaction",
+			Key1:          olm.EncodeBase64(sequence(0x40, 32)),
+			Key2:          olm.EncodeBase64(sequence(0x80, 32)),
+			Secret:        sequence(0xC0, qrCodeSecretLength),
+		}
+		encoded, err := qr.Encode()
+		if err != nil {
+			t.Fatalf("mode %d: unexpected error encoding QR code: %v", mode, err)
+		}
+		decoded, err := DecodeQRCode(encoded)
+		if err != nil {
+			t.Fatalf("mode %d: unexpected error decoding QR code: %v", mode, err)
+		}
+		if !reflect.DeepEqual(decoded, qr) {
+			t.Errorf("mode %d: round trip mismatch\nexpected: %+v\ngot: %+v", mode, qr, decoded)
+		}
+	}
+}
+
+func TestQRCodeInvalid(t *testing.T) {
+	valid := QRCode{
+		TransactionID: "transaction",
+		Key1:          olm.EncodeBase64(sequence(0x40, 32)),
+		Key2:          olm.EncodeBase64(sequence(0x80, 32)),
+		Secret:        sequence(0xC0, 8),
+	}
+	shortSecret := valid
+	shortSecret.Secret = sequence(0xC0, 7)
+	shortKey := valid
+	shortKey.Key2 = olm.EncodeBase64(sequence(0x80, 31))
+	for name, qr := range map[string]QRCode{"short secret": shortSecret, "short key": shortKey} {
+		if _, err := qr.Encode(); !errors.Is(err, ErrBadQRCode) {
+			t.Errorf("%s: expected ErrBadQRCode, got %v", name, err)
+		}
+	}
+
+	encoded, err := valid.Encode()
+	if err != nil {
+		t.Fatalf("unexpected error encoding QR code: %v", err)
+	}
+	tests := map[string][]byte{
+		"truncated":       encoded[:len(encoded)-1],
+		"bad prefix":      append([]byte("MATRIY"), encoded[6:]...),
+		"unknown version": append(append([]byte("MATRIX"), 0x01), encoded[7:]...),
+		"unknown mode":    append(append([]byte("MATRIX"), 0x02, 0x03), encoded[8:]...),
+	}
+	for name, payload := range tests {
+		if _, err := DecodeQRCode(payload); !errors.Is(err, ErrBadQRCode) {
+			t.Errorf("%s: expected ErrBadQRCode, got %v", name, err)
+		}
+	}
+}
+
+func TestReadyMethods(t *testing.T) {
+	ours := []string{
+		VerificationMethodSAS, VerificationMethodQRShow, VerificationMethodQRScan, VerificationMethodReciprocate,
+	}
+	tests := []struct {
+		theirs   []string
+		expected []string
+	}{
+		{
+			theirs:   []string{VerificationMethodSAS},
+			expected: []string{VerificationMethodSAS},
+		},
+		{
+			theirs:   []string{VerificationMethodQRShow, VerificationMethodReciprocate},
+			expected: []string{VerificationMethodQRScan, VerificationMethodReciprocate},
+		},
+		{
+			theirs:   []string{VerificationMethodSAS, VerificationMethodQRScan, VerificationMethodReciprocate},
+			expected: []string{VerificationMethodSAS, VerificationMethodQRShow, VerificationMethodReciprocate},
+		},
+		{
+			theirs:   []string{"org.example.method"},
+			expected: nil,
+		},
+	}
+	for _, test := range tests {
+		if got := readyMethods(ours, test.theirs); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("methods for %v\nexpected: %v\ngot: %v", test.theirs, test.expected, got)
+		}
+	}
+}
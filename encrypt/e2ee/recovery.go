@@ -0,0 +1,107 @@
+package e2ee
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+)
+
+// ErrBadRecoveryKey is returned when a recovery key cannot be decoded.
+var ErrBadRecoveryKey = errors.New("invalid recovery key")
+
+// recoveryKeyPrefix is prepended to the key before encoding it as a recovery key.
+var recoveryKeyPrefix = []byte{0x8b, 0x01}
+
+// recoveryKeyLength is the length of the key encoded in a recovery key.
+const recoveryKeyLength = 32
+
+// base58Alphabet is the alphabet used by Bitcoin, which recovery keys are encoded with.
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// EncodeRecoveryKey encodes the 32 bytes private key into a recovery key that can be shown to the user.
+func EncodeRecoveryKey(key []byte) string {
+	b := make([]byte, 0, len(recoveryKeyPrefix)+len(key)+1)
+	b = append(b, recoveryKeyPrefix...)
+	b = append(b, key...)
+	b = append(b, parity(b))
+
+	// The key is split into groups of 4 characters to make it easier to read.
+	encoded := base58Encode(b)
+	var sb strings.Builder
+	for i := 0; i < len(encoded); i += 4 {
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		end := i + 4
+		if end > len(encoded) {
+			end = len(encoded)
+		}
+		sb.WriteString(encoded[i:end])
+	}
+	return sb.String()
+}
+
+// DecodeRecoveryKey decodes the recovery key into the private key. Whitespaces in the recovery key are
+// ignored.
+func DecodeRecoveryKey(recoveryKey string) ([]byte, error) {
+	b, ok := base58Decode(strings.Join(strings.Fields(recoveryKey), ""))
+	if !ok || len(b) != len(recoveryKeyPrefix)+recoveryKeyLength+1 {
+		return nil, ErrBadRecoveryKey
+	}
+	if b[0] != recoveryKeyPrefix[0] || b[1] != recoveryKeyPrefix[1] || parity(b) != 0 {
+		return nil, ErrBadRecoveryKey
+	}
+	return b[len(recoveryKeyPrefix) : len(b)-1], nil
+}
+
+// parity returns all bytes XORed together.
+func parity(b []byte) byte {
+	var p byte
+	for _, v := range b {
+		p ^= v
+	}
+	return p
+}
+
+// base58Encode encodes b into base58, keeping leading zeroes.
+func base58Encode(b []byte) string {
+	n := new(big.Int).SetBytes(b)
+	radix := big.NewInt(58)
+	mod := new(big.Int)
+
+	var out []byte
+	for n.Sign() > 0 {
+		n.DivMod(n, radix, mod)
+		out = append(out, base58Alphabet[mod.Int64()])
+	}
+	for _, v := range b {
+		if v != 0 {
+			break
+		}
+		out = append(out, base58Alphabet[0])
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return string(out)
+}
+
+// base58Decode decodes s from base58. false is returned if s contains invalid characters.
+func base58Decode(s string) ([]byte, bool) {
+	n := new(big.Int)
+	radix := big.NewInt(58)
+	for _, c := range s {
+		i := strings.IndexRune(base58Alphabet, c)
+		if i < 0 {
+			return nil, false
+		}
+		n.Mul(n, radix)
+		n.Add(n, big.NewInt(int64(i)))
+	}
+
+	zeroes := 0
+	for zeroes < len(s) && s[zeroes] == base58Alphabet[0] {
+		zeroes++
+	}
+	return append(make([]byte, zeroes), n.Bytes()...), true
+}
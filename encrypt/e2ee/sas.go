@@ -0,0 +1,66 @@
+package e2ee
+
+// Short authentication string methods.
+const (
+	SASEmoji   = "emoji"
+	SASDecimal = "decimal"
+)
+
+// sasBytes is the number of bytes generated to compute the short authentication string. Emoji use 42 bits
+// while decimals use 39 bits.
+const sasBytes = 6
+
+// SASEmojiSymbol is an emoji of the short authentication string along with its English description.
+type SASEmojiSymbol struct {
+	Emoji       string
+	Description string
+}
+
+// sasEmojiTable is the list of emoji defined in the spec.
+var sasEmojiTable = [64]SASEmojiSymbol{
+	{"🐶", "Dog"}, {"🐱", "Cat"}, {"🦁", "Lion"}, {"🐎", "Horse"},
+	{"🦄", "Unicorn"}, {"🐷", "Pig"}, {"🐘", "Elephant"}, {"🐰", "Rabbit"},
+	{"🐼", "Panda"}, {"🐓", "Rooster"}, {"🐧", "Penguin"}, {"🐢", "Turtle"},
+	{"🐟", "Fish"}, {"🐙", "Octopus"}, {"🦋", "Butterfly"}, {"🌷", "Flower"},
+	{"🌳", "Tree"}, {"🌵", "Cactus"}, {"🍄", "Mushroom"}, {"🌏", "Globe"},
+	{"🌙", "Moon"}, {"☁️", "Cloud"}, {"🔥", "Fire"}, {"🍌", "Banana"},
+	{"🍎", "Apple"}, {"🍓", "Strawberry"}, {"🌽", "Corn"}, {"🍕", "Pizza"},
+	{"🎂", "Cake"}, {"❤️", "Heart"}, {"😀", "Smiley"}, {"🤖", "Robot"},
+	{"🎩", "Hat"}, {"👓", "Glasses"}, {"🔧", "Spanner"}, {"🎅", "Santa"},
+	{"👍", "Thumbs Up"}, {"☂️", "Umbrella"}, {"⌛", "Hourglass"}, {"⏰", "Clock"},
+	{"🎁", "Gift"}, {"💡", "Light Bulb"}, {"📕", "Book"}, {"✏️", "Pencil"},
+	{"📎", "Paperclip"}, {"✂️", "Scissors"}, {"🔒", "Lock"}, {"🔑", "Key"},
+	{"🔨", "Hammer"}, {"☎️", "Telephone"}, {"🏁", "Flag"}, {"🚂", "Train"},
+	{"🚲", "Bicycle"}, {"✈️", "Aeroplane"}, {"🚀", "Rocket"}, {"🏆", "Trophy"},
+	{"⚽", "Ball"}, {"🎸", "Guitar"}, {"🎺", "Trumpet"}, {"🔔", "Bell"},
+	{"⚓", "Anchor"}, {"🎧", "Headphones"}, {"📁", "Folder"}, {"📌", "Pin"},
+}
+
+// SAS is the short authentication string both users have to compare.
+type SAS struct {
+	// Emoji are the 7 emoji to compare. It is nil if the emoji method has not been agreed on.
+	Emoji []SASEmojiSymbol
+	// Decimal are the 3 numbers between 1000 and 9191 to compare. They are always available.
+	Decimal [3]int
+}
+
+// newSAS computes the short authentication string from the generated bytes.
+func newSAS(b []byte, emoji bool) SAS {
+	var sas SAS
+	sas.Decimal[0] = (int(b[0])<<5 | int(b[1])>>3) + 1000
+	sas.Decimal[1] = (int(b[1]&0x7)<<10 | int(b[2])<<2 | int(b[3])>>6) + 1000
+	sas.Decimal[2] = (int(b[3]&0x3f)<<7 | int(b[4])>>1) + 1000
+
+	if emoji {
+		// The first 42 bits are split into 7 groups of 6 bits.
+		var bits uint64
+		for _, v := range b[:sasBytes] {
+			bits = bits<<8 | uint64(v)
+		}
+		sas.Emoji = make([]SASEmojiSymbol, 7)
+		for i := range sas.Emoji {
+			sas.Emoji[i] = sasEmojiTable[(bits>>(42-6*uint(i)))&0x3f]
+		}
+	}
+	return sas
+}
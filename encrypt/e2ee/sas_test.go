@@ -0,0 +1,55 @@
+package e2ee
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestNewSAS(t *testing.T) {
+	tests := []struct {
+		bytes   string
+		decimal [3]int
+		emoji   [7]string
+	}{
+		{
+			bytes:   "000000000000",
+			decimal: [3]int{1000, 1000, 1000},
+			emoji:   [7]string{"Dog", "Dog", "Dog", "Dog", "Dog", "Dog", "Dog"},
+		},
+		{
+			bytes:   "ffffffffffff",
+			decimal: [3]int{9191, 9191, 9191},
+			emoji:   [7]string{"Pin", "Pin", "Pin", "Pin", "Pin", "Pin", "Pin"},
+		},
+		{
+			// Emoji indices 2, 17, 58, 31, 5, 13 and 47.
+			bytes:   "091e9f14dbed",
+			decimal: [3]int{1291, 7780, 3669},
+			emoji:   [7]string{"Lion", "Cactus", "Trumpet", "Robot", "Pig", "Octopus", "Key"},
+		},
+	}
+	for _, test := range tests {
+		b, err := hex.DecodeString(test.bytes)
+		if err != nil {
+			t.Fatalf("unexpected error decoding bytes: %v", err)
+		}
+
+		sas := newSAS(b, false)
+		if sas.Decimal != test.decimal {
+			t.Errorf("%s: expected decimals %v, got %v", test.bytes, test.decimal, sas.Decimal)
+		}
+		if sas.Emoji != nil {
+			t.Errorf("%s: expected no emoji, got %v", test.bytes, sas.Emoji)
+		}
+
+		sas = newSAS(b, true)
+		if len(sas.Emoji) != len(test.emoji) {
+			t.Fatalf("%s: expected %d emoji, got %d", test.bytes, len(test.emoji), len(sas.Emoji))
+		}
+		for i, v := range sas.Emoji {
+			if v.Description != test.emoji[i] {
+				t.Errorf("%s: expected emoji %d to be %s, got %s", test.bytes, i, test.emoji[i], v.Description)
+			}
+		}
+	}
+}
@@ -0,0 +1,325 @@
+package e2ee
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/chanbakjsd/gotrix/api"
+	"github.com/chanbakjsd/gotrix/debug"
+	"github.com/chanbakjsd/gotrix/encrypt/olm"
+	"github.com/chanbakjsd/gotrix/event"
+)
+
+// Names of the secrets known by the Machine. The private keys are encoded in unpadded base64.
+const (
+	SecretCrossSigningMaster      = "m.cross_signing.master"
+	SecretCrossSigningSelfSigning = "m.cross_signing.self_signing"
+	SecretCrossSigningUserSigning = "m.cross_signing.user_signing"
+	SecretMegolmBackup            = "m.megolm_backup.v1"
+)
+
+// knownSecrets are the secrets stored and imported by StoreKnownSecrets and ImportKnownSecrets.
+var knownSecrets = []string{
+	SecretCrossSigningMaster,
+	SecretCrossSigningSelfSigning,
+	SecretCrossSigningUserSigning,
+	SecretMegolmBackup,
+}
+
+// ErrSecretMismatch is returned when a received secret does not match the public key it is the private key of.
+var ErrSecretMismatch = errors.New("secret does not match the public key")
+
+// secretRequest is a secret requested from the other devices of the user.
+type secretRequest struct {
+	name   string
+	result chan string
+}
+
+// StoreKnownSecrets stores the private cross-signing keys and the key backup key that are known by this device
+// into secret storage.
+func (m *Machine) StoreKnownSecrets(ctx context.Context, key *SecretStorageKey) error {
+	for _, name := range knownSecrets {
+		secret, err := m.localSecret(name)
+		if err != nil {
+			return err
+		}
+		if secret == "" {
+			continue
+		}
+		if err := m.StoreSecret(ctx, key, name, secret); err != nil {
+			return fmt.Errorf("error storing %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// ImportKnownSecrets fetches the private cross-signing keys and the key backup key from secret storage and
+// saves them into the store. Secrets that are not stored are skipped. The key backup is enabled if its key
+// is imported.
+func (m *Machine) ImportKnownSecrets(ctx context.Context, key *SecretStorageKey) error {
+	for _, name := range knownSecrets {
+		secret, err := m.FetchSecret(ctx, key, name)
+		if errors.Is(err, ErrSecretNotStored) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error fetching %s: %w", name, err)
+		}
+		if err := m.importSecret(ctx, name, secret); err != nil {
+			return fmt.Errorf("error importing %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// localSecret returns the secret if it is known by this device, or an empty string otherwise.
+func (m *Machine) localSecret(name string) (string, error) {
+	switch name {
+	case SecretCrossSigningMaster, SecretCrossSigningSelfSigning, SecretCrossSigningUserSigning:
+		keys, err := m.Store.LoadCrossSigningKeys()
+		if err != nil {
+			return "", fmt.Errorf("error loading cross-signing keys: %w", err)
+		}
+		if keys == nil {
+			return "", nil
+		}
+		key := map[string]*olm.PKSigning{
+			SecretCrossSigningMaster:      keys.Master,
+			SecretCrossSigningSelfSigning: keys.SelfSigning,
+			SecretCrossSigningUserSigning: keys.UserSigning,
+		}[name]
+		if key == nil {
+			return "", nil
+		}
+		return olm.EncodeBase64(key.Seed()), nil
+	case SecretMegolmBackup:
+		backup := m.KeyBackup()
+		if backup == nil || backup.Key == nil {
+			return "", nil
+		}
+		return olm.EncodeBase64(backup.Key.PrivateKey()), nil
+	}
+	return "", nil
+}
+
+// importSecret checks the secret against the public keys of the user and saves it. Unknown secrets are
+// ignored.
+func (m *Machine) importSecret(ctx context.Context, name, secret string) error {
+	switch name {
+	case SecretCrossSigningMaster, SecretCrossSigningSelfSigning, SecretCrossSigningUserSigning:
+		seed, err := olm.DecodeBase64(secret)
+		if err != nil {
+			return err
+		}
+		key, err := olm.NewPKSigningFromSeed(seed)
+		if err != nil {
+			return err
+		}
+		// Make sure that the public keys are up to date.
+		if _, err := m.Devices(ctx, m.Client.UserID); err != nil {
+			return fmt.Errorf("error querying devices: %w", err)
+		}
+		identity, err := m.Store.LoadUserIdentity(m.Client.UserID)
+		if err != nil {
+			return fmt.Errorf("error loading user identity: %w", err)
+		}
+		if identity == nil {
+			return ErrNoUserIdentity
+		}
+
+		keys := &CrossSigningKeys{}
+		var public *api.CrossSigningKey
+		switch name {
+		case SecretCrossSigningMaster:
+			keys.Master, public = key, &identity.Master
+		case SecretCrossSigningSelfSigning:
+			keys.SelfSigning, public = key, identity.SelfSigning
+		case SecretCrossSigningUserSigning:
+			keys.UserSigning, public = key, identity.UserSigning
+		}
+		if public == nil || public.PublicKey() != key.PublicKey() {
+			return ErrSecretMismatch
+		}
+		return m.SetCrossSigningKeys(keys)
+	case SecretMegolmBackup:
+		private, err := olm.DecodeBase64(secret)
+		if err != nil {
+			return err
+		}
+		key, err := olm.NewPKDecryptionFromPrivateKey(private)
+		if err != nil {
+			return err
+		}
+		info, authData, err := m.latestKeyBackup(ctx)
+		if err != nil {
+			return err
+		}
+		if authData.PublicKey != key.PublicKey() {
+			return ErrBackupKeyMismatch
+		}
+		return m.setKeyBackup(&KeyBackup{
+			Version:   info.Version,
+			PublicKey: authData.PublicKey,
+			Key:       key,
+		})
+	}
+	return nil
+}
+
+// RequestSecret requests the secret from the other devices of the user and waits until a trusted device
+// sends it or the context is done. Known secrets are checked and saved into the store before being returned.
+//
+// The secret is received through to-device events so the sync loop has to be running.
+func (m *Machine) RequestSecret(ctx context.Context, name string) (string, error) {
+	id, err := randomString()
+	if err != nil {
+		return "", err
+	}
+	req := &secretRequest{
+		name:   name,
+		result: make(chan string, 1),
+	}
+	m.secretMu.Lock()
+	if m.secretRequests == nil {
+		m.secretRequests = make(map[string]*secretRequest)
+	}
+	m.secretRequests[id] = req
+	m.secretMu.Unlock()
+	defer func() {
+		m.secretMu.Lock()
+		delete(m.secretRequests, id)
+		m.secretMu.Unlock()
+	}()
+
+	err = m.sendSecretRequest(ctx, event.SecretRequestEvent{
+		Action:             event.SecretRequestActionRequest,
+		Name:               name,
+		RequestingDeviceID: m.Client.DeviceID,
+		RequestID:          id,
+	})
+	if err != nil {
+		return "", fmt.Errorf("error requesting secret: %w", err)
+	}
+
+	select {
+	case secret := <-req.result:
+		return secret, nil
+	case <-ctx.Done():
+		// Use a new context as the request context is done.
+		err := m.sendSecretRequest(m.Client.Context(), event.SecretRequestEvent{
+			Action:             event.SecretRequestActionCancellation,
+			RequestingDeviceID: m.Client.DeviceID,
+			RequestID:          id,
+		})
+		if err != nil {
+			m.Client.Logger.Warn("error cancelling secret request", debug.Err(err))
+		}
+		return "", ctx.Err()
+	}
+}
+
+// sendSecretRequest sends the request to all other devices of the user.
+func (m *Machine) sendSecretRequest(ctx context.Context, content event.SecretRequestEvent) error {
+	return m.Client.SendToDeviceContext(ctx, event.TypeSecretRequest, api.DeviceMessages{
+		m.Client.UserID: {"*": content},
+	})
+}
+
+// handleSecretRequest sends the requested secret to the device of the user that requested it if the device
+// is trusted.
+func (m *Machine) handleSecretRequest(ctx context.Context, e *event.SecretRequestEvent) error {
+	if e.Sender != m.Client.UserID || e.RequestingDeviceID == m.Client.DeviceID ||
+		e.Action != event.SecretRequestActionRequest {
+		return nil
+	}
+	log := m.Client.Logger.With(debug.Any("device_id", e.RequestingDeviceID), debug.Any("name", e.Name))
+
+	secret, err := m.localSecret(e.Name)
+	if err != nil || secret == "" {
+		return err
+	}
+	devices, err := m.Devices(ctx, m.Client.UserID)
+	if err != nil {
+		return fmt.Errorf("error querying devices: %w", err)
+	}
+	device, ok := devices[e.RequestingDeviceID]
+	if !ok {
+		log.Warn("ignoring secret request from unknown device")
+		return nil
+	}
+	trusted, err := m.DeviceTrusted(device)
+	if err != nil {
+		return err
+	}
+	if !trusted {
+		log.Warn("ignoring secret request from untrusted device")
+		return nil
+	}
+
+	messages, err := m.encryptOlm(ctx, []*Device{device}, event.TypeSecretSend, event.SecretSendEvent{
+		RequestID: e.RequestID,
+		Secret:    secret,
+	})
+	if err != nil {
+		return err
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+	log.Debug("sending secret")
+	if err := m.Client.SendToDeviceContext(ctx, event.TypeRoomEncrypted, messages); err != nil {
+		return fmt.Errorf("error sending secret: %w", err)
+	}
+	return nil
+}
+
+// handleSecretSend completes the request the secret has been sent for if it has been sent by a trusted device
+// of the user.
+func (m *Machine) handleSecretSend(ctx context.Context, e *event.SecretSendEvent) error {
+	// Secrets must only be accepted from our own devices through Olm.
+	if e.Sender != m.Client.UserID || e.Encryption == nil || e.Encryption.Algorithm != AlgorithmOlm {
+		return nil
+	}
+	m.secretMu.Lock()
+	req, ok := m.secretRequests[e.RequestID]
+	m.secretMu.Unlock()
+	if !ok {
+		return nil
+	}
+	log := m.Client.Logger.With(debug.Any("name", req.name))
+
+	device, err := m.deviceByKey(m.Client.UserID, e.Encryption.SenderKey)
+	if err != nil {
+		return err
+	}
+	trusted := false
+	if device != nil {
+		trusted, err = m.DeviceTrusted(device)
+		if err != nil {
+			return err
+		}
+	}
+	if !trusted {
+		log.Warn("ignoring secret sent by untrusted device")
+		return nil
+	}
+
+	if err := m.importSecret(ctx, req.name, e.Secret); err != nil {
+		log.Warn("ignoring invalid secret", debug.Err(err))
+		return nil
+	}
+	select {
+	case req.result <- e.Secret:
+	default:
+		// Another device has already sent the secret.
+		return nil
+	}
+
+	// Let the other devices know that they do not have to send the secret anymore.
+	return m.sendSecretRequest(ctx, event.SecretRequestEvent{
+		Action:             event.SecretRequestActionCancellation,
+		RequestingDeviceID: m.Client.DeviceID,
+		RequestID:          e.RequestID,
+	})
+}
@@ -0,0 +1,443 @@
+package e2ee
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/chanbakjsd/gotrix/encrypt/olm"
+	"github.com/chanbakjsd/gotrix/matrix"
+)
+
+// Algorithms used by secret storage.
+const (
+	SecretStorageAlgorithmAESHMACSHA2 = "m.secret_storage.v1.aes-hmac-sha2"
+	PassphraseAlgorithmPBKDF2         = "m.pbkdf2"
+)
+
+// Account data types used by secret storage. The description of a key is stored in the account data with the
+// key ID appended to secretStorageKeyPrefix.
+const (
+	secretStorageDefaultKey = "m.secret_storage.default_key"
+	secretStorageKeyPrefix  = "m.secret_storage.key."
+)
+
+// Errors returned when using secret storage.
+var (
+	// ErrNoSecretStorageKey is returned when the secret storage key does not exist.
+	ErrNoSecretStorageKey = errors.New("no secret storage key")
+	// ErrSecretStorageKeyMismatch is returned when the provided key or passphrase is not the secret storage
+	// key.
+	ErrSecretStorageKeyMismatch = errors.New("key does not match the secret storage key")
+	// ErrSecretNotStored is returned when the secret is not stored in secret storage, or is not encrypted with
+	// the provided key.
+	ErrSecretNotStored = errors.New("secret is not stored with this key")
+	// ErrBadSecretMAC is returned when the MAC of an encrypted secret does not match.
+	ErrBadSecretMAC = errors.New("MAC of the secret does not match")
+	// ErrBadPassphraseParams is returned when the number of iterations or the key length of a passphrase is
+	// invalid or too large.
+	ErrBadPassphraseParams = errors.New("invalid passphrase parameters")
+)
+
+// secretStorageKeyLength is the length of secret storage keys in bytes.
+const secretStorageKeyLength = 32
+
+// pbkdf2Iterations is the number of PBKDF2 iterations used to derive new keys from passphrases.
+const pbkdf2Iterations = 500000
+
+// Limits of the passphrase parameters accepted from a key description. Deriving a key takes time proportional
+// to both so a hostile description could otherwise hang the client.
+const (
+	maxPBKDF2Iterations = 10 * pbkdf2Iterations
+	maxPassphraseBits   = 512
+)
+
+// SecretStorageKeyDescription is the description of a secret storage key stored in the account data.
+type SecretStorageKeyDescription struct {
+	Name      string `json:"name,omitempty"`
+	Algorithm string `json:"algorithm"`
+	// Passphrase is set if the key is derived from a passphrase.
+	Passphrase *SecretStoragePassphrase `json:"passphrase,omitempty"`
+	// IV and MAC are the result of encrypting 32 zero bytes with the key and are used to check the key.
+	IV  string `json:"iv,omitempty"`
+	MAC string `json:"mac,omitempty"`
+}
+
+// SecretStoragePassphrase describes how a secret storage key is derived from a passphrase.
+type SecretStoragePassphrase struct {
+	Algorithm  string `json:"algorithm"`
+	Salt       string `json:"salt"`
+	Iterations int    `json:"iterations"`
+	// Bits is the length of the derived key. It defaults to 256.
+	Bits int `json:"bits,omitempty"`
+}
+
+// SecretStorageKey is a secret storage key that has been checked against its description.
+type SecretStorageKey struct {
+	ID          string
+	Description SecretStorageKeyDescription
+
+	key []byte
+}
+
+// RecoveryKey returns the key encoded as a recovery key that the user can write down.
+func (k *SecretStorageKey) RecoveryKey() string {
+	return EncodeRecoveryKey(k.key)
+}
+
+// encryptedSecret is a secret encrypted with m.secret_storage.v1.aes-hmac-sha2.
+type encryptedSecret struct {
+	IV         string `json:"iv"`
+	Ciphertext string `json:"ciphertext"`
+	MAC        string `json:"mac"`
+}
+
+// storedSecret is the account data of a secret, containing the secret encrypted with each key, indexed by
+// their ID.
+type storedSecret struct {
+	Encrypted map[string]encryptedSecret `json:"encrypted"`
+}
+
+// CreateSecretStorageKey generates a new secret storage key and makes it the default key. The key is derived
+// from the passphrase if it is not empty, and is random otherwise. The key can always be entered as a
+// recovery key.
+//
+// Secrets stored with the previous default key are not encrypted with the new key automatically.
+func (m *Machine) CreateSecretStorageKey(ctx context.Context, name, passphrase string) (*SecretStorageKey,
+	error) {
+	id, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+	desc := SecretStorageKeyDescription{
+		Name:      name,
+		Algorithm: SecretStorageAlgorithmAESHMACSHA2,
+	}
+
+	var key []byte
+	if passphrase != "" {
+		salt, err := randomString()
+		if err != nil {
+			return nil, err
+		}
+		desc.Passphrase = &SecretStoragePassphrase{
+			Algorithm:  PassphraseAlgorithmPBKDF2,
+			Salt:       salt,
+			Iterations: pbkdf2Iterations,
+			Bits:       secretStorageKeyLength * 8,
+		}
+		key = pbkdf2SHA512([]byte(passphrase), []byte(salt), pbkdf2Iterations, secretStorageKeyLength)
+	} else {
+		key = make([]byte, secretStorageKeyLength)
+		if _, err := io.ReadFull(rand.Reader, key); err != nil {
+			return nil, err
+		}
+	}
+
+	check, err := encryptSecret(key, "", make([]byte, secretStorageKeyLength))
+	if err != nil {
+		return nil, err
+	}
+	desc.IV = check.IV
+	desc.MAC = check.MAC
+
+	if err := m.Client.ClientConfigSetContext(ctx, secretStorageKeyPrefix+id, desc); err != nil {
+		return nil, err
+	}
+	err = m.Client.ClientConfigSetContext(ctx, secretStorageDefaultKey, map[string]string{
+		"key": id,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &SecretStorageKey{
+		ID:          id,
+		Description: desc,
+		key:         key,
+	}, nil
+}
+
+// DefaultSecretStorageKeyID returns the ID of the default secret storage key.
+func (m *Machine) DefaultSecretStorageKeyID(ctx context.Context) (string, error) {
+	var content struct {
+		Key string `json:"key"`
+	}
+	err := m.Client.ClientConfigContext(ctx, secretStorageDefaultKey, &content)
+	if errors.Is(err, matrix.CodeNotFound) || (err == nil && content.Key == "") {
+		return "", ErrNoSecretStorageKey
+	}
+	if err != nil {
+		return "", err
+	}
+	return content.Key, nil
+}
+
+// SecretStorageKeyDescription returns the description of the secret storage key. The default key is used if
+// keyID is empty.
+func (m *Machine) SecretStorageKeyDescription(ctx context.Context, keyID string) (string,
+	*SecretStorageKeyDescription, error) {
+	if keyID == "" {
+		var err error
+		keyID, err = m.DefaultSecretStorageKeyID(ctx)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	desc := &SecretStorageKeyDescription{}
+	err := m.Client.ClientConfigContext(ctx, secretStorageKeyPrefix+keyID, desc)
+	if errors.Is(err, matrix.CodeNotFound) {
+		return "", nil, ErrNoSecretStorageKey
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	if desc.Algorithm != SecretStorageAlgorithmAESHMACSHA2 {
+		return "", nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, desc.Algorithm)
+	}
+	return keyID, desc, nil
+}
+
+// SecretStorageKeyFromRecoveryKey returns the secret storage key encoded in the recovery key after checking
+// it. The default key is used if keyID is empty.
+func (m *Machine) SecretStorageKeyFromRecoveryKey(ctx context.Context, keyID,
+	recoveryKey string) (*SecretStorageKey, error) {
+	key, err := DecodeRecoveryKey(recoveryKey)
+	if err != nil {
+		return nil, err
+	}
+	keyID, desc, err := m.SecretStorageKeyDescription(ctx, keyID)
+	if err != nil {
+		return nil, err
+	}
+	return newSecretStorageKey(keyID, desc, key)
+}
+
+// SecretStorageKeyFromPassphrase derives the secret storage key from the passphrase and checks it. The default
+// key is used if keyID is empty.
+func (m *Machine) SecretStorageKeyFromPassphrase(ctx context.Context, keyID,
+	passphrase string) (*SecretStorageKey, error) {
+	keyID, desc, err := m.SecretStorageKeyDescription(ctx, keyID)
+	if err != nil {
+		return nil, err
+	}
+	params := desc.Passphrase
+	if params == nil {
+		return nil, fmt.Errorf("%w: key is not derived from a passphrase", ErrSecretStorageKeyMismatch)
+	}
+	key, err := params.deriveKey(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return newSecretStorageKey(keyID, desc, key)
+}
+
+// deriveKey derives the key from the passphrase after checking the parameters.
+func (p *SecretStoragePassphrase) deriveKey(passphrase string) ([]byte, error) {
+	if p.Algorithm != PassphraseAlgorithmPBKDF2 {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, p.Algorithm)
+	}
+	bits := p.Bits
+	if bits == 0 {
+		bits = secretStorageKeyLength * 8
+	}
+	if p.Iterations < 1 || p.Iterations > maxPBKDF2Iterations {
+		return nil, fmt.Errorf("%w: %d iterations", ErrBadPassphraseParams, p.Iterations)
+	}
+	if bits < 0 || bits > maxPassphraseBits || bits%8 != 0 {
+		return nil, fmt.Errorf("%w: %d bits", ErrBadPassphraseParams, bits)
+	}
+	return pbkdf2SHA512([]byte(passphrase), []byte(p.Salt), p.Iterations, bits/8), nil
+}
+
+// newSecretStorageKey checks the key against its description.
+func newSecretStorageKey(id string, desc *SecretStorageKeyDescription, key []byte) (*SecretStorageKey, error) {
+	// Keys without a MAC cannot be checked.
+	if desc.MAC != "" {
+		expected, err := encryptSecretWithIV(key, "", make([]byte, secretStorageKeyLength), desc.IV)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrSecretStorageKeyMismatch, err)
+		}
+		if !base64Equal(expected.MAC, desc.MAC) {
+			return nil, ErrSecretStorageKeyMismatch
+		}
+	}
+	return &SecretStorageKey{
+		ID:          id,
+		Description: *desc,
+		key:         key,
+	}, nil
+}
+
+// StoreSecret encrypts the secret with the key and stores it in the account data. The encryptions of the
+// secret with other keys are kept.
+func (m *Machine) StoreSecret(ctx context.Context, key *SecretStorageKey, name, secret string) error {
+	var stored storedSecret
+	err := m.Client.ClientConfigContext(ctx, name, &stored)
+	if err != nil && !errors.Is(err, matrix.CodeNotFound) {
+		return err
+	}
+	if stored.Encrypted == nil {
+		stored.Encrypted = make(map[string]encryptedSecret)
+	}
+
+	encrypted, err := encryptSecret(key.key, name, []byte(secret))
+	if err != nil {
+		return err
+	}
+	stored.Encrypted[key.ID] = *encrypted
+	return m.Client.ClientConfigSetContext(ctx, name, stored)
+}
+
+// FetchSecret fetches the secret from the account data and decrypts it with the key.
+func (m *Machine) FetchSecret(ctx context.Context, key *SecretStorageKey, name string) (string, error) {
+	var stored storedSecret
+	err := m.Client.ClientConfigContext(ctx, name, &stored)
+	if errors.Is(err, matrix.CodeNotFound) {
+		return "", ErrSecretNotStored
+	}
+	if err != nil {
+		return "", err
+	}
+	encrypted, ok := stored.Encrypted[key.ID]
+	if !ok {
+		return "", ErrSecretNotStored
+	}
+	plaintext, err := decryptSecret(key.key, name, encrypted)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// encryptSecret encrypts the secret using m.secret_storage.v1.aes-hmac-sha2 with a random IV.
+func encryptSecret(key []byte, name string, plaintext []byte) (*encryptedSecret, error) {
+	iv := make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return nil, err
+	}
+	// Bit 63 is cleared to work around differences in AES-CTR implementations.
+	iv[8] &= 0x7f
+	return encryptSecretWithIV(key, name, plaintext, olm.EncodeBase64(iv))
+}
+
+// encryptSecretWithIV encrypts the secret using m.secret_storage.v1.aes-hmac-sha2 with the IV in base64.
+func encryptSecretWithIV(key []byte, name string, plaintext []byte, iv string) (*encryptedSecret, error) {
+	rawIV, err := olm.DecodeBase64(iv)
+	if err != nil {
+		return nil, err
+	}
+	if len(rawIV) != aes.BlockSize {
+		return nil, olm.ErrBadKey
+	}
+	aesKey, macKey := secretKeys(key, name)
+	block, err := aes.NewCipher(aesKey)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext := make([]byte, len(plaintext))
+	cipher.NewCTR(block, rawIV).XORKeyStream(ciphertext, plaintext)
+
+	mac := hmac.New(sha256.New, macKey)
+	_, _ = mac.Write(ciphertext)
+	return &encryptedSecret{
+		IV:         iv,
+		Ciphertext: olm.EncodeBase64(ciphertext),
+		MAC:        olm.EncodeBase64(mac.Sum(nil)),
+	}, nil
+}
+
+// decryptSecret decrypts a secret encrypted with encryptSecret.
+func decryptSecret(key []byte, name string, encrypted encryptedSecret) ([]byte, error) {
+	iv, err := olm.DecodeBase64(encrypted.IV)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := olm.DecodeBase64(encrypted.Ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	macValue, err := olm.DecodeBase64(encrypted.MAC)
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != aes.BlockSize {
+		return nil, olm.ErrBadKey
+	}
+
+	aesKey, macKey := secretKeys(key, name)
+	mac := hmac.New(sha256.New, macKey)
+	_, _ = mac.Write(ciphertext)
+	if !hmac.Equal(mac.Sum(nil), macValue) {
+		return nil, ErrBadSecretMAC
+	}
+
+	block, err := aes.NewCipher(aesKey)
+	if err != nil {
+		return nil, err
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCTR(block, iv).XORKeyStream(plaintext, ciphertext)
+	return plaintext, nil
+}
+
+// secretKeys derives the AES key and the MAC key used to encrypt the secret with the name.
+func secretKeys(key []byte, name string) (aesKey, macKey []byte) {
+	derived := olm.HKDF(key, make([]byte, 32), []byte(name), 64)
+	return derived[:32], derived[32:]
+}
+
+// base64Equal compares two base64 strings, ignoring padding.
+func base64Equal(a, b string) bool {
+	rawA, errA := olm.DecodeBase64(a)
+	rawB, errB := olm.DecodeBase64(b)
+	return errA == nil && errB == nil && hmac.Equal(rawA, rawB)
+}
+
+// randomString returns a random string that can be used as an identifier.
+func randomString() (string, error) {
+	b := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// pbkdf2SHA512 derives a key of keyLen bytes from the password using PBKDF2 with HMAC-SHA-512 (RFC 8018).
+func pbkdf2SHA512(password, salt []byte, iterations, keyLen int) []byte {
+	return pbkdf2(sha512.New, password, salt, iterations, keyLen)
+}
+
+// pbkdf2 derives a key of keyLen bytes from the password using PBKDF2 with HMAC of the hash.
+func pbkdf2(h func() hash.Hash, password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(h, password)
+	out := make([]byte, 0, keyLen+prf.Size())
+	block := make([]byte, 4)
+	for i := uint32(1); len(out) < keyLen; i++ {
+		binary.BigEndian.PutUint32(block, i)
+		prf.Reset()
+		_, _ = prf.Write(salt)
+		_, _ = prf.Write(block)
+		u := prf.Sum(nil)
+		t := append([]byte(nil), u...)
+		for n := 1; n < iterations; n++ {
+			prf.Reset()
+			_, _ = prf.Write(u)
+			u = prf.Sum(u[:0])
+			for k := range t {
+				t[k] ^= u[k]
+			}
+		}
+		out = append(out, t...)
+	}
+	return out[:keyLen]
+}
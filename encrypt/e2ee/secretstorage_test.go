@@ -0,0 +1,146 @@
+package e2ee
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestPBKDF2SHA512(t *testing.T) {
+	// The test vectors of RFC 6070 with HMAC-SHA-512 instead of HMAC-SHA-1.
+	tests := []struct {
+		password, salt string
+		iterations     int
+		expected       string
+	}{
+		{"password", "salt", 1, "867f70cf1ade02cff3752599a3a53dc4af34c7a669815ae5d513554e1c8cf252" +
+			"c02d470a285a0501bad999bfe943c08f050235d7d68b1da55e63f73b60a57fce"},
+		{"password", "salt", 2, "e1d9c16aa681708a45f5c7c4e215ceb66e011a2e9f0040713f18aefdb866d53c" +
+			"f76cab2868a39b9f7840edce4fef5a82be67335c77a6068e04112754f27ccf4e"},
+		{"password", "salt", 4096, "d197b1b33db0143e018b12f3d1d1479e6cdebdcc97c5c0f87f6902e072f457b5" +
+			"143f30602641b3d55cd335988cb36b84376060ecd532e039b742a239434af2d5"},
+		{"passwordPASSWORDpassword", "saltSALTsaltSALTsaltSALTsaltSALTsalt", 4096,
+			"8c0511f4c6e597c6ac6315d8f0362e225f3c501495ba23b868c005174dc4ee71" +
+				"115b59f9e60cd9532fa33e0f75aefe30225c583a186cd82bd4daea9724a3d3b8"},
+	}
+	for _, test := range tests {
+		got := pbkdf2SHA512([]byte(test.password), []byte(test.salt), test.iterations, 64)
+		if hex.EncodeToString(got) != test.expected {
+			t.Errorf("%s/%s/%d: unexpected key\nexpected: %s\ngot: %x",
+				test.password, test.salt, test.iterations, test.expected, got)
+		}
+	}
+}
+
+// testSecretStorageKey is the key made of the bytes 0 to 31 with the key check computed from the
+// m.secret_storage.v1.aes-hmac-sha2 specification.
+var testSecretStorageKey = SecretStorageKeyDescription{
+	Algorithm: SecretStorageAlgorithmAESHMACSHA2,
+	IV:        "QEFCQ0RFRkdISUpLTE1OTw==",
+	MAC:       "sGsV5Pjg7MBgo7NOvOahviPj/tV1MvF2xvCcZMMKPFY=",
+}
+
+func TestSecretStorageKeyCheck(t *testing.T) {
+	key := make([]byte, secretStorageKeyLength)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	if _, err := newSecretStorageKey("key", &testSecretStorageKey, key); err != nil {
+		t.Errorf("unexpected error checking key: %v", err)
+	}
+
+	// The check is the encryption of 32 zero bytes with an empty name.
+	check, err := encryptSecretWithIV(key, "", make([]byte, secretStorageKeyLength), testSecretStorageKey.IV)
+	if err != nil {
+		t.Fatalf("unexpected error encrypting: %v", err)
+	}
+	if check.Ciphertext != "Eu+R21Q1XpdXTAApFlFVL8aCHQWM6DZ7t/ijHK7J58s" {
+		t.Errorf("unexpected key check ciphertext %s", check.Ciphertext)
+	}
+
+	key[0] ^= 1
+	_, err = newSecretStorageKey("key", &testSecretStorageKey, key)
+	if !errors.Is(err, ErrSecretStorageKeyMismatch) {
+		t.Errorf("expected ErrSecretStorageKeyMismatch for the wrong key, got %v", err)
+	}
+}
+
+func TestDecryptSecret(t *testing.T) {
+	key := make([]byte, secretStorageKeyLength)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	const (
+		name   = "m.cross_signing.master"
+		secret = "bWFzdGVyIGtleSBzZWVk"
+	)
+	encrypted := encryptedSecret{
+		IV:         "YGFiY2RlZmdoaWprbG1ubw==",
+		Ciphertext: "IZeh5NmWq59CGRzc/wx5TegFjaI=",
+		MAC:        "YayDXF96jB9vedYwFtLq9g22/IE8cew1y29TPWmLLfg=",
+	}
+	plaintext, err := decryptSecret(key, name, encrypted)
+	if err != nil {
+		t.Fatalf("unexpected error decrypting: %v", err)
+	}
+	if string(plaintext) != secret {
+		t.Errorf("expected %q, got %q", secret, plaintext)
+	}
+
+	// The name of the secret is part of the key derivation.
+	if _, err := decryptSecret(key, "m.cross_signing.self_signing", encrypted); !errors.Is(err, ErrBadSecretMAC) {
+		t.Errorf("expected ErrBadSecretMAC for the wrong name, got %v", err)
+	}
+	tampered := encrypted
+	tampered.Ciphertext = "IZeh5NmWq59CGRzc/wx5TegFjaE="
+	if _, err := decryptSecret(key, name, tampered); !errors.Is(err, ErrBadSecretMAC) {
+		t.Errorf("expected ErrBadSecretMAC for a modified ciphertext, got %v", err)
+	}
+
+	roundTrip, err := encryptSecret(key, name, []byte(secret))
+	if err != nil {
+		t.Fatalf("unexpected error encrypting: %v", err)
+	}
+	if plaintext, err := decryptSecret(key, name, *roundTrip); err != nil || string(plaintext) != secret {
+		t.Errorf("expected %q after a round trip, got %q (error: %v)", secret, plaintext, err)
+	}
+}
+
+func TestSecretStoragePassphrase(t *testing.T) {
+	const expected = "d94be677bb025c56625bb57bd8bcc06966db15a6eeeb6517ba8a7babd9a9b6b7"
+	params := SecretStoragePassphrase{
+		Algorithm:  PassphraseAlgorithmPBKDF2,
+		Salt:       "saltysalt",
+		Iterations: 1000,
+	}
+	key, err := params.deriveKey("correct horse battery staple")
+	if err != nil {
+		t.Fatalf("unexpected error deriving key: %v", err)
+	}
+	if hex.EncodeToString(key) != expected {
+		t.Errorf("unexpected key\nexpected: %s\ngot: %x", expected, key)
+	}
+
+	tests := []struct {
+		iterations, bits int
+	}{
+		{0, 256},
+		{-1, 256},
+		{maxPBKDF2Iterations + 1, 256},
+		{1000, 255},
+		{1000, -8},
+		{1000, maxPassphraseBits + 8},
+	}
+	for _, test := range tests {
+		params.Iterations, params.Bits = test.iterations, test.bits
+		if _, err := params.deriveKey("passphrase"); !errors.Is(err, ErrBadPassphraseParams) {
+			t.Errorf("%d iterations and %d bits: expected ErrBadPassphraseParams, got %v",
+				test.iterations, test.bits, err)
+		}
+	}
+
+	params = SecretStoragePassphrase{Algorithm: "org.example.scrypt", Iterations: 1000}
+	if _, err := params.deriveKey("passphrase"); !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Errorf("expected ErrUnsupportedAlgorithm, got %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package e2ee
+
+import (
+	"time"
+
+	"github.com/chanbakjsd/gotrix/encrypt/olm"
+	"github.com/chanbakjsd/gotrix/event"
+	"github.com/chanbakjsd/gotrix/matrix"
+)
+
+// Defaults used when the m.room.encryption event does not specify how often sessions are rotated.
+const (
+	DefaultRotationPeriod         = 7 * 24 * time.Hour
+	DefaultRotationPeriodMessages = 100
+)
+
+// OlmSession is an Olm session with another device.
+type OlmSession struct {
+	*olm.Session
+	// LastUsed is the last time the session was used to encrypt or decrypt a message.
+	LastUsed time.Time
+}
+
+// OutboundGroupSession is the Megolm session used to encrypt messages sent to a room.
+type OutboundGroupSession struct {
+	*olm.OutboundGroupSession
+	RoomID    matrix.RoomID
+	CreatedAt time.Time
+	// SharedWith contains the devices the session key has been sent to, mapped to the Curve25519 identity key
+	// it has been sent to.
+	SharedWith map[matrix.UserID]map[matrix.DeviceID]string
+}
+
+// Expired returns true if the session should be rotated according to the encryption settings of the room.
+func (o *OutboundGroupSession) Expired(settings *event.RoomEncryptionEvent) bool {
+	period := DefaultRotationPeriod
+	messages := DefaultRotationPeriodMessages
+	if settings != nil && settings.RotationPeriod > 0 {
+		period = settings.RotationPeriod.Duration()
+	}
+	if settings != nil && settings.RotationPeriodMessages > 0 {
+		messages = settings.RotationPeriodMessages
+	}
+	return time.Since(o.CreatedAt) >= period || int(o.MessageIndex()) >= messages
+}
+
+// InboundGroupSession is a Megolm session used to decrypt messages sent by a device to a room.
+type InboundGroupSession struct {
+	*olm.InboundGroupSession
+	RoomID matrix.RoomID
+	// SenderKey is the Curve25519 identity key of the device that created the session.
+	SenderKey string
+	// SigningKey is the Ed25519 identity key the device that created the session claims to own.
+	SigningKey string
+	// ForwardingChain contains the Curve25519 identity keys of the devices that forwarded the session to us.
+	// It is empty if the session was received from the device that created it.
+	ForwardingChain []string
+	// BackupVersion is the version of the key backup the session has been uploaded to.
+	BackupVersion string
+	// Imported is true if the session was restored from a key backup or a key export instead of being received
+	// from another device. The keys of the sending device cannot be authenticated in that case.
+	Imported bool
+}
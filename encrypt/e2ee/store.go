@@ -0,0 +1,838 @@
+package e2ee
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/chanbakjsd/gotrix/encrypt/olm"
+	"github.com/chanbakjsd/gotrix/matrix"
+)
+
+// Account is the Olm account of the device along with whether its device keys have been uploaded.
+type Account struct {
+	*olm.Account
+	// Shared is true once the device keys have been uploaded to the homeserver.
+	Shared bool
+}
+
+// Store persists the key material of a device. A store must only be used for a single device.
+//
+// Implementations have to be safe for concurrent use.
+//
+// Values returned by the Load methods may be shared with the store (as in MemoryStore) or be copies of the saved
+// values (as in FileStore). Callers must not rely on either: modifications are only persisted after they are passed
+// to the corresponding Save method, and values must not be modified while they are being used by another goroutine.
+type Store interface {
+	// LoadAccount returns the saved account. (nil, nil) should be returned if there is no saved account.
+	LoadAccount() (*Account, error)
+	// SaveAccount saves the account, replacing the previously saved one.
+	SaveAccount(*Account) error
+
+	// LoadOlmSessions returns the Olm sessions with the device that owns the Curve25519 identity key.
+	LoadOlmSessions(senderKey string) ([]*OlmSession, error)
+	// SaveOlmSession saves the Olm session with the device that owns the Curve25519 identity key, replacing
+	// the saved session with the same ID.
+	SaveOlmSession(senderKey string, session *OlmSession) error
+
+	// LoadOutboundGroupSession returns the outbound Megolm session of the room. (nil, nil) should be returned
+	// if there is none.
+	LoadOutboundGroupSession(roomID matrix.RoomID) (*OutboundGroupSession, error)
+	// SaveOutboundGroupSession saves the outbound Megolm session, replacing the one of the same room.
+	SaveOutboundGroupSession(session *OutboundGroupSession) error
+	// RemoveOutboundGroupSession removes the outbound Megolm session of the room.
+	RemoveOutboundGroupSession(roomID matrix.RoomID) error
+
+	// LoadInboundGroupSession returns the inbound Megolm session of the room with the provided ID. (nil, nil)
+	// should be returned if there is none.
+	LoadInboundGroupSession(roomID matrix.RoomID, sessionID string) (*InboundGroupSession, error)
+	// SaveInboundGroupSession saves the inbound Megolm session, replacing the one of the same room with the
+	// same ID.
+	SaveInboundGroupSession(session *InboundGroupSession) error
+	// LoadInboundGroupSessions returns all saved inbound Megolm sessions.
+	LoadInboundGroupSessions() ([]*InboundGroupSession, error)
+
+	// LoadDeviceLists returns the state of the device tracker. (nil, nil) should be returned if there is none.
+	LoadDeviceLists() (*DeviceLists, error)
+	// SaveDeviceLists saves the state of the device tracker, replacing the previously saved one.
+	SaveDeviceLists(lists *DeviceLists) error
+	// LoadDevices returns the devices of the user. (nil, nil) should be returned if there are none.
+	LoadDevices(userID matrix.UserID) (map[matrix.DeviceID]*Device, error)
+	// SaveDevices saves the devices of the user, replacing the previously saved ones.
+	SaveDevices(userID matrix.UserID, devices map[matrix.DeviceID]*Device) error
+
+	// LoadCrossSigningKeys returns the private cross-signing keys of the user. (nil, nil) should be returned if
+	// there are none.
+	LoadCrossSigningKeys() (*CrossSigningKeys, error)
+	// SaveCrossSigningKeys saves the private cross-signing keys, replacing the previously saved ones.
+	SaveCrossSigningKeys(keys *CrossSigningKeys) error
+	// LoadUserIdentity returns the public cross-signing keys of the user. (nil, nil) should be returned if
+	// there are none.
+	LoadUserIdentity(userID matrix.UserID) (*UserIdentity, error)
+	// SaveUserIdentity saves the public cross-signing keys of the user, replacing the previously saved ones.
+	SaveUserIdentity(identity *UserIdentity) error
+	// RemoveUserIdentity removes the public cross-signing keys of the user.
+	RemoveUserIdentity(userID matrix.UserID) error
+
+	// LoadKeyBackup returns the key backup room keys are uploaded to. (nil, nil) should be returned if there
+	// is none.
+	LoadKeyBackup() (*KeyBackup, error)
+	// SaveKeyBackup saves the key backup, replacing the previously saved one. The saved key backup is removed
+	// if backup is nil.
+	SaveKeyBackup(backup *KeyBackup) error
+}
+
+// MemoryStore is a Store that keeps everything in memory. Key material is lost once the process exits,
+// making it only useful for bots that log in again every time or for testing.
+//
+// Values are not copied so the values returned by the Load methods are the ones that have been saved.
+type MemoryStore struct {
+	mu       sync.Mutex
+	account  *Account
+	olm      map[string][]*OlmSession
+	outbound map[matrix.RoomID]*OutboundGroupSession
+	inbound  map[inboundKey]*InboundGroupSession
+	lists    *DeviceLists
+	devices  map[matrix.UserID]map[matrix.DeviceID]*Device
+	keys     *CrossSigningKeys
+	identity map[matrix.UserID]*UserIdentity
+	backup   *KeyBackup
+}
+
+// inboundKey identifies an inbound Megolm session.
+type inboundKey struct {
+	RoomID    matrix.RoomID
+	SessionID string
+}
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		olm:      make(map[string][]*OlmSession),
+		outbound: make(map[matrix.RoomID]*OutboundGroupSession),
+		inbound:  make(map[inboundKey]*InboundGroupSession),
+		devices:  make(map[matrix.UserID]map[matrix.DeviceID]*Device),
+		identity: make(map[matrix.UserID]*UserIdentity),
+	}
+}
+
+// LoadAccount implements Store.
+func (m *MemoryStore) LoadAccount() (*Account, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.account, nil
+}
+
+// SaveAccount implements Store.
+func (m *MemoryStore) SaveAccount(account *Account) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.account = account
+	return nil
+}
+
+// LoadOlmSessions implements Store.
+func (m *MemoryStore) LoadOlmSessions(senderKey string) ([]*OlmSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*OlmSession(nil), m.olm[senderKey]...), nil
+}
+
+// SaveOlmSession implements Store.
+func (m *MemoryStore) SaveOlmSession(senderKey string, session *OlmSession) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k, v := range m.olm[senderKey] {
+		if v.ID() == session.ID() {
+			m.olm[senderKey][k] = session
+			return nil
+		}
+	}
+	m.olm[senderKey] = append(m.olm[senderKey], session)
+	return nil
+}
+
+// LoadOutboundGroupSession implements Store.
+func (m *MemoryStore) LoadOutboundGroupSession(roomID matrix.RoomID) (*OutboundGroupSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.outbound[roomID], nil
+}
+
+// SaveOutboundGroupSession implements Store.
+func (m *MemoryStore) SaveOutboundGroupSession(session *OutboundGroupSession) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.outbound[session.RoomID] = session
+	return nil
+}
+
+// RemoveOutboundGroupSession implements Store.
+func (m *MemoryStore) RemoveOutboundGroupSession(roomID matrix.RoomID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.outbound, roomID)
+	return nil
+}
+
+// LoadInboundGroupSession implements Store.
+func (m *MemoryStore) LoadInboundGroupSession(roomID matrix.RoomID, sessionID string) (*InboundGroupSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.inbound[inboundKey{roomID, sessionID}], nil
+}
+
+// SaveInboundGroupSession implements Store.
+func (m *MemoryStore) SaveInboundGroupSession(session *InboundGroupSession) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inbound[inboundKey{session.RoomID, session.ID()}] = session
+	return nil
+}
+
+// LoadInboundGroupSessions implements Store.
+func (m *MemoryStore) LoadInboundGroupSessions() ([]*InboundGroupSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sessions := make([]*InboundGroupSession, 0, len(m.inbound))
+	for _, v := range m.inbound {
+		sessions = append(sessions, v)
+	}
+	return sessions, nil
+}
+
+// LoadDeviceLists implements Store.
+func (m *MemoryStore) LoadDeviceLists() (*DeviceLists, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lists, nil
+}
+
+// SaveDeviceLists implements Store.
+func (m *MemoryStore) SaveDeviceLists(lists *DeviceLists) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lists = lists
+	return nil
+}
+
+// LoadDevices implements Store.
+func (m *MemoryStore) LoadDevices(userID matrix.UserID) (map[matrix.DeviceID]*Device, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.devices[userID], nil
+}
+
+// SaveDevices implements Store.
+func (m *MemoryStore) SaveDevices(userID matrix.UserID, devices map[matrix.DeviceID]*Device) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.devices[userID] = devices
+	return nil
+}
+
+// LoadCrossSigningKeys implements Store.
+func (m *MemoryStore) LoadCrossSigningKeys() (*CrossSigningKeys, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.keys, nil
+}
+
+// SaveCrossSigningKeys implements Store.
+func (m *MemoryStore) SaveCrossSigningKeys(keys *CrossSigningKeys) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys = keys
+	return nil
+}
+
+// LoadUserIdentity implements Store.
+func (m *MemoryStore) LoadUserIdentity(userID matrix.UserID) (*UserIdentity, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.identity[userID], nil
+}
+
+// SaveUserIdentity implements Store.
+func (m *MemoryStore) SaveUserIdentity(identity *UserIdentity) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.identity[identity.UserID] = identity
+	return nil
+}
+
+// RemoveUserIdentity implements Store.
+func (m *MemoryStore) RemoveUserIdentity(userID matrix.UserID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.identity, userID)
+	return nil
+}
+
+// LoadKeyBackup implements Store.
+func (m *MemoryStore) LoadKeyBackup() (*KeyBackup, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.backup, nil
+}
+
+// SaveKeyBackup implements Store.
+func (m *MemoryStore) SaveKeyBackup(backup *KeyBackup) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.backup = backup
+	return nil
+}
+
+// FileStore is a Store that saves the key material into a directory. Olm state is pickled with the
+// pickle key before being written.
+type FileStore struct {
+	dir       string
+	pickleKey []byte
+	mu        sync.Mutex
+}
+
+// NewFileStore creates a FileStore that saves into dir, creating it if it does not exist.
+func NewFileStore(dir string, pickleKey []byte) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("error creating crypto store directory: %w", err)
+	}
+	return &FileStore{
+		dir:       dir,
+		pickleKey: pickleKey,
+	}, nil
+}
+
+// fileAccount is the structure of the account file.
+type fileAccount struct {
+	Pickle string `json:"pickle"`
+	Shared bool   `json:"shared"`
+}
+
+// LoadAccount implements Store.
+func (f *FileStore) LoadAccount() (*Account, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var saved fileAccount
+	ok, err := f.readJSON("account.json", &saved)
+	if err != nil || !ok {
+		return nil, err
+	}
+	account, err := olm.UnpickleAccount(saved.Pickle, f.pickleKey)
+	if err != nil {
+		return nil, fmt.Errorf("error unpickling account: %w", err)
+	}
+	return &Account{
+		Account: account,
+		Shared:  saved.Shared,
+	}, nil
+}
+
+// SaveAccount implements Store.
+func (f *FileStore) SaveAccount(account *Account) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	pickled, err := account.Pickle(f.pickleKey)
+	if err != nil {
+		return fmt.Errorf("error pickling account: %w", err)
+	}
+	return f.writeJSON("account.json", fileAccount{
+		Pickle: pickled,
+		Shared: account.Shared,
+	})
+}
+
+// fileOlmSession is the structure of an Olm session in an Olm session file.
+type fileOlmSession struct {
+	Pickle   string    `json:"pickle"`
+	LastUsed time.Time `json:"last_used"`
+}
+
+// olmSessionsFile returns the name of the file containing the Olm sessions with the device.
+func olmSessionsFile(senderKey string) string {
+	return filepath.Join("olm", fileKey(senderKey)+".json")
+}
+
+// LoadOlmSessions implements Store.
+func (f *FileStore) LoadOlmSessions(senderKey string) ([]*OlmSession, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.loadOlmSessions(senderKey)
+}
+
+func (f *FileStore) loadOlmSessions(senderKey string) ([]*OlmSession, error) {
+	var saved []fileOlmSession
+	if _, err := f.readJSON(olmSessionsFile(senderKey), &saved); err != nil {
+		return nil, err
+	}
+
+	sessions := make([]*OlmSession, 0, len(saved))
+	for _, v := range saved {
+		session, err := olm.UnpickleSession(v.Pickle, f.pickleKey)
+		if err != nil {
+			return nil, fmt.Errorf("error unpickling Olm session: %w", err)
+		}
+		sessions = append(sessions, &OlmSession{
+			Session:  session,
+			LastUsed: v.LastUsed,
+		})
+	}
+	return sessions, nil
+}
+
+// SaveOlmSession implements Store.
+func (f *FileStore) SaveOlmSession(senderKey string, session *OlmSession) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	sessions, err := f.loadOlmSessions(senderKey)
+	if err != nil {
+		return err
+	}
+	replaced := false
+	for k, v := range sessions {
+		if v.ID() == session.ID() {
+			sessions[k] = session
+			replaced = true
+		}
+	}
+	if !replaced {
+		sessions = append(sessions, session)
+	}
+
+	saved := make([]fileOlmSession, 0, len(sessions))
+	for _, v := range sessions {
+		pickled, err := v.Pickle(f.pickleKey)
+		if err != nil {
+			return fmt.Errorf("error pickling Olm session: %w", err)
+		}
+		saved = append(saved, fileOlmSession{
+			Pickle:   pickled,
+			LastUsed: v.LastUsed,
+		})
+	}
+	return f.writeJSON(olmSessionsFile(senderKey), saved)
+}
+
+// fileOutboundGroupSession is the structure of an outbound Megolm session file.
+type fileOutboundGroupSession struct {
+	Pickle     string                                       `json:"pickle"`
+	RoomID     matrix.RoomID                                `json:"room_id"`
+	CreatedAt  time.Time                                    `json:"created_at"`
+	SharedWith map[matrix.UserID]map[matrix.DeviceID]string `json:"shared_with"`
+}
+
+// outboundGroupSessionFile returns the name of the file containing the outbound Megolm session of the room.
+func outboundGroupSessionFile(roomID matrix.RoomID) string {
+	return filepath.Join("outbound", fileKey(string(roomID))+".json")
+}
+
+// LoadOutboundGroupSession implements Store.
+func (f *FileStore) LoadOutboundGroupSession(roomID matrix.RoomID) (*OutboundGroupSession, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var saved fileOutboundGroupSession
+	ok, err := f.readJSON(outboundGroupSessionFile(roomID), &saved)
+	if err != nil || !ok {
+		return nil, err
+	}
+	session, err := olm.UnpickleOutboundGroupSession(saved.Pickle, f.pickleKey)
+	if err != nil {
+		return nil, fmt.Errorf("error unpickling outbound group session: %w", err)
+	}
+	return &OutboundGroupSession{
+		OutboundGroupSession: session,
+		RoomID:               saved.RoomID,
+		CreatedAt:            saved.CreatedAt,
+		SharedWith:           saved.SharedWith,
+	}, nil
+}
+
+// SaveOutboundGroupSession implements Store.
+func (f *FileStore) SaveOutboundGroupSession(session *OutboundGroupSession) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	pickled, err := session.Pickle(f.pickleKey)
+	if err != nil {
+		return fmt.Errorf("error pickling outbound group session: %w", err)
+	}
+	return f.writeJSON(outboundGroupSessionFile(session.RoomID), fileOutboundGroupSession{
+		Pickle:     pickled,
+		RoomID:     session.RoomID,
+		CreatedAt:  session.CreatedAt,
+		SharedWith: session.SharedWith,
+	})
+}
+
+// RemoveOutboundGroupSession implements Store.
+func (f *FileStore) RemoveOutboundGroupSession(roomID matrix.RoomID) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	err := os.Remove(filepath.Join(f.dir, outboundGroupSessionFile(roomID)))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error removing outbound group session: %w", err)
+	}
+	return nil
+}
+
+// fileInboundGroupSession is the structure of an inbound Megolm session file.
+type fileInboundGroupSession struct {
+	Pickle          string        `json:"pickle"`
+	RoomID          matrix.RoomID `json:"room_id"`
+	SenderKey       string        `json:"sender_key"`
+	SigningKey      string        `json:"signing_key"`
+	ForwardingChain []string      `json:"forwarding_chain,omitempty"`
+	BackupVersion   string        `json:"backup_version,omitempty"`
+	Imported        bool          `json:"imported,omitempty"`
+}
+
+// inboundGroupSessionFile returns the name of the file containing the inbound Megolm session.
+func inboundGroupSessionFile(roomID matrix.RoomID, sessionID string) string {
+	return filepath.Join("inbound", fileKey(string(roomID)+"\x00"+sessionID)+".json")
+}
+
+// LoadInboundGroupSession implements Store.
+func (f *FileStore) LoadInboundGroupSession(roomID matrix.RoomID, sessionID string) (*InboundGroupSession, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.loadInboundGroupSession(inboundGroupSessionFile(roomID, sessionID))
+}
+
+func (f *FileStore) loadInboundGroupSession(name string) (*InboundGroupSession, error) {
+	var saved fileInboundGroupSession
+	ok, err := f.readJSON(name, &saved)
+	if err != nil || !ok {
+		return nil, err
+	}
+	session, err := olm.UnpickleInboundGroupSession(saved.Pickle, f.pickleKey)
+	if err != nil {
+		return nil, fmt.Errorf("error unpickling inbound group session: %w", err)
+	}
+	return &InboundGroupSession{
+		InboundGroupSession: session,
+		RoomID:              saved.RoomID,
+		SenderKey:           saved.SenderKey,
+		SigningKey:          saved.SigningKey,
+		ForwardingChain:     saved.ForwardingChain,
+		BackupVersion:       saved.BackupVersion,
+		Imported:            saved.Imported,
+	}, nil
+}
+
+// SaveInboundGroupSession implements Store.
+func (f *FileStore) SaveInboundGroupSession(session *InboundGroupSession) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	pickled, err := session.Pickle(f.pickleKey)
+	if err != nil {
+		return fmt.Errorf("error pickling inbound group session: %w", err)
+	}
+	name := inboundGroupSessionFile(session.RoomID, session.ID())
+	return f.writeJSON(name, fileInboundGroupSession{
+		Pickle:          pickled,
+		RoomID:          session.RoomID,
+		SenderKey:       session.SenderKey,
+		SigningKey:      session.SigningKey,
+		ForwardingChain: session.ForwardingChain,
+		BackupVersion:   session.BackupVersion,
+		Imported:        session.Imported,
+	})
+}
+
+// LoadInboundGroupSessions implements Store.
+func (f *FileStore) LoadInboundGroupSessions() ([]*InboundGroupSession, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	files, err := ioutil.ReadDir(filepath.Join(f.dir, "inbound"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error listing inbound group sessions: %w", err)
+	}
+	sessions := make([]*InboundGroupSession, 0, len(files))
+	for _, v := range files {
+		if v.IsDir() || filepath.Ext(v.Name()) != ".json" {
+			continue
+		}
+		session, err := f.loadInboundGroupSession(filepath.Join("inbound", v.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if session != nil {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, nil
+}
+
+// LoadDeviceLists implements Store.
+func (f *FileStore) LoadDeviceLists() (*DeviceLists, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var lists DeviceLists
+	ok, err := f.readJSON("device_lists.json", &lists)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return &lists, nil
+}
+
+// SaveDeviceLists implements Store.
+func (f *FileStore) SaveDeviceLists(lists *DeviceLists) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.writeJSON("device_lists.json", lists)
+}
+
+// devicesFile returns the name of the file containing the devices of the user.
+func devicesFile(userID matrix.UserID) string {
+	return filepath.Join("devices", fileKey(string(userID))+".json")
+}
+
+// LoadDevices implements Store.
+func (f *FileStore) LoadDevices(userID matrix.UserID) (map[matrix.DeviceID]*Device, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var devices map[matrix.DeviceID]*Device
+	if _, err := f.readJSON(devicesFile(userID), &devices); err != nil {
+		return nil, err
+	}
+	return devices, nil
+}
+
+// SaveDevices implements Store.
+func (f *FileStore) SaveDevices(userID matrix.UserID, devices map[matrix.DeviceID]*Device) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.writeJSON(devicesFile(userID), devices)
+}
+
+// fileCrossSigningKeys is the structure of the cross-signing keys file. Each key is pickled.
+type fileCrossSigningKeys struct {
+	Master      string `json:"master,omitempty"`
+	SelfSigning string `json:"self_signing,omitempty"`
+	UserSigning string `json:"user_signing,omitempty"`
+}
+
+// LoadCrossSigningKeys implements Store.
+func (f *FileStore) LoadCrossSigningKeys() (*CrossSigningKeys, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var saved fileCrossSigningKeys
+	ok, err := f.readJSON("cross_signing.json", &saved)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	keys := &CrossSigningKeys{}
+	for _, v := range []struct {
+		pickled string
+		key     **olm.PKSigning
+	}{
+		{saved.Master, &keys.Master},
+		{saved.SelfSigning, &keys.SelfSigning},
+		{saved.UserSigning, &keys.UserSigning},
+	} {
+		if v.pickled == "" {
+			continue
+		}
+		*v.key, err = olm.UnpicklePKSigning(v.pickled, f.pickleKey)
+		if err != nil {
+			return nil, fmt.Errorf("error unpickling cross-signing key: %w", err)
+		}
+	}
+	return keys, nil
+}
+
+// SaveCrossSigningKeys implements Store.
+func (f *FileStore) SaveCrossSigningKeys(keys *CrossSigningKeys) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var saved fileCrossSigningKeys
+	for _, v := range []struct {
+		key     *olm.PKSigning
+		pickled *string
+	}{
+		{keys.Master, &saved.Master},
+		{keys.SelfSigning, &saved.SelfSigning},
+		{keys.UserSigning, &saved.UserSigning},
+	} {
+		if v.key == nil {
+			continue
+		}
+		var err error
+		*v.pickled, err = v.key.Pickle(f.pickleKey)
+		if err != nil {
+			return fmt.Errorf("error pickling cross-signing key: %w", err)
+		}
+	}
+	return f.writeJSON("cross_signing.json", saved)
+}
+
+// identityFile returns the name of the file containing the cross-signing keys of the user.
+func identityFile(userID matrix.UserID) string {
+	return filepath.Join("identities", fileKey(string(userID))+".json")
+}
+
+// LoadUserIdentity implements Store.
+func (f *FileStore) LoadUserIdentity(userID matrix.UserID) (*UserIdentity, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var identity UserIdentity
+	ok, err := f.readJSON(identityFile(userID), &identity)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+// SaveUserIdentity implements Store.
+func (f *FileStore) SaveUserIdentity(identity *UserIdentity) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.writeJSON(identityFile(identity.UserID), identity)
+}
+
+// RemoveUserIdentity implements Store.
+func (f *FileStore) RemoveUserIdentity(userID matrix.UserID) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	err := os.Remove(filepath.Join(f.dir, identityFile(userID)))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error removing user identity: %w", err)
+	}
+	return nil
+}
+
+// fileKeyBackup is the structure of the key backup file.
+type fileKeyBackup struct {
+	Version   string `json:"version"`
+	PublicKey string `json:"public_key"`
+	// Key is the pickled private key.
+	Key string `json:"key,omitempty"`
+}
+
+// LoadKeyBackup implements Store.
+func (f *FileStore) LoadKeyBackup() (*KeyBackup, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var saved fileKeyBackup
+	ok, err := f.readJSON("key_backup.json", &saved)
+	if err != nil || !ok {
+		return nil, err
+	}
+	backup := &KeyBackup{
+		Version:   saved.Version,
+		PublicKey: saved.PublicKey,
+	}
+	if saved.Key != "" {
+		backup.Key, err = olm.UnpicklePKDecryption(saved.Key, f.pickleKey)
+		if err != nil {
+			return nil, fmt.Errorf("error unpickling key backup key: %w", err)
+		}
+	}
+	return backup, nil
+}
+
+// SaveKeyBackup implements Store.
+func (f *FileStore) SaveKeyBackup(backup *KeyBackup) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if backup == nil {
+		err := os.Remove(filepath.Join(f.dir, "key_backup.json"))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error removing key backup: %w", err)
+		}
+		return nil
+	}
+	saved := fileKeyBackup{
+		Version:   backup.Version,
+		PublicKey: backup.PublicKey,
+	}
+	if backup.Key != nil {
+		var err error
+		saved.Key, err = backup.Key.Pickle(f.pickleKey)
+		if err != nil {
+			return fmt.Errorf("error pickling key backup key: %w", err)
+		}
+	}
+	return f.writeJSON("key_backup.json", saved)
+}
+
+// fileKey returns a name that can be used in a path for the key.
+func fileKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+// readJSON decodes the file into v. false is returned if the file does not exist.
+func (f *FileStore) readJSON(name string, v interface{}) (bool, error) {
+	content, err := ioutil.ReadFile(filepath.Join(f.dir, name))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error reading %s: %w", name, err)
+	}
+	if err := json.Unmarshal(content, v); err != nil {
+		return false, fmt.Errorf("error decoding %s: %w", name, err)
+	}
+	return true, nil
+}
+
+// writeJSON atomically replaces the file with the JSON encoding of v.
+func (f *FileStore) writeJSON(name string, v interface{}) error {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(f.dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("error creating directory for %s: %w", name, err)
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "tmp-")
+	if err != nil {
+		return fmt.Errorf("error creating temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("error writing %s: %w", name, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("error writing %s: %w", name, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing %s: %w", name, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("error replacing %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,445 @@
+package e2ee
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/chanbakjsd/gotrix/api"
+	"github.com/chanbakjsd/gotrix/encrypt/olm"
+	"github.com/chanbakjsd/gotrix/matrix"
+)
+
+// testStores returns a constructor of an empty store for every Store implementation.
+func testStores() map[string]func(t *testing.T) Store {
+	return map[string]func(t *testing.T) Store{
+		"MemoryStore": func(t *testing.T) Store {
+			return NewMemoryStore()
+		},
+		"FileStore": func(t *testing.T) Store {
+			store, err := NewFileStore(t.TempDir(), []byte("pickle key"))
+			if err != nil {
+				t.Fatalf("error creating store: %v", err)
+			}
+			return store
+		},
+	}
+}
+
+// runStoreTest runs the test against an empty store of every implementation.
+func runStoreTest(t *testing.T, test func(t *testing.T, store Store)) {
+	for name, newStore := range testStores() {
+		t.Run(name, func(t *testing.T) {
+			test(t, newStore(t))
+		})
+	}
+}
+
+// testOlmSession creates an outbound Olm session with a new account.
+func testOlmSession(t *testing.T) *OlmSession {
+	t.Helper()
+	alice, err := olm.NewAccount()
+	if err != nil {
+		t.Fatalf("error creating account: %v", err)
+	}
+	bob, err := olm.NewAccount()
+	if err != nil {
+		t.Fatalf("error creating account: %v", err)
+	}
+	if err := bob.GenerateOneTimeKeys(1); err != nil {
+		t.Fatalf("error generating one-time keys: %v", err)
+	}
+	bobCurve, _ := bob.IdentityKeys()
+	var oneTimeKey string
+	for _, v := range bob.OneTimeKeys() {
+		oneTimeKey = v
+	}
+	session, err := alice.NewOutboundSession(bobCurve, oneTimeKey)
+	if err != nil {
+		t.Fatalf("error creating Olm session: %v", err)
+	}
+	return &OlmSession{
+		Session:  session,
+		LastUsed: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+}
+
+func TestStoreAccount(t *testing.T) {
+	runStoreTest(t, func(t *testing.T, store Store) {
+		if account, err := store.LoadAccount(); account != nil || err != nil {
+			t.Fatalf("expected no account in empty store, got %v, %v", account, err)
+		}
+		olmAccount, err := olm.NewAccount()
+		if err != nil {
+			t.Fatalf("error creating account: %v", err)
+		}
+		if err := store.SaveAccount(&Account{Account: olmAccount, Shared: true}); err != nil {
+			t.Fatalf("error saving account: %v", err)
+		}
+		account, err := store.LoadAccount()
+		if err != nil || account == nil {
+			t.Fatalf("error loading account: %v", err)
+		}
+		wantCurve, wantEd := olmAccount.IdentityKeys()
+		if curve, ed := account.IdentityKeys(); curve != wantCurve || ed != wantEd || !account.Shared {
+			t.Errorf("loaded account does not match the saved one")
+		}
+	})
+}
+
+func TestStoreOlmSessions(t *testing.T) {
+	runStoreTest(t, func(t *testing.T, store Store) {
+		const senderKey = "sender+curve25519+key"
+		if sessions, err := store.LoadOlmSessions(senderKey); len(sessions) != 0 || err != nil {
+			t.Fatalf("expected no sessions in empty store, got %v, %v", sessions, err)
+		}
+
+		first, second := testOlmSession(t), testOlmSession(t)
+		for _, v := range []*OlmSession{first, second} {
+			if err := store.SaveOlmSession(senderKey, v); err != nil {
+				t.Fatalf("error saving Olm session: %v", err)
+			}
+		}
+		// Saving a session with the same ID replaces it.
+		updated := &OlmSession{Session: first.Session, LastUsed: first.LastUsed.Add(time.Hour)}
+		if err := store.SaveOlmSession(senderKey, updated); err != nil {
+			t.Fatalf("error saving Olm session: %v", err)
+		}
+
+		sessions, err := store.LoadOlmSessions(senderKey)
+		if err != nil {
+			t.Fatalf("error loading Olm sessions: %v", err)
+		}
+		if len(sessions) != 2 {
+			t.Fatalf("expected 2 sessions, got %d", len(sessions))
+		}
+		for _, v := range sessions {
+			var want *OlmSession
+			switch v.ID() {
+			case first.ID():
+				want = updated
+			case second.ID():
+				want = second
+			default:
+				t.Fatalf("unexpected session %s", v.ID())
+			}
+			if !v.LastUsed.Equal(want.LastUsed) {
+				t.Errorf("session %s: expected last used at %v, got %v", v.ID(), want.LastUsed, v.LastUsed)
+			}
+		}
+		if other, err := store.LoadOlmSessions("other+curve25519+key"); len(other) != 0 || err != nil {
+			t.Errorf("expected no sessions with another device, got %v, %v", other, err)
+		}
+	})
+}
+
+func TestStoreOutboundGroupSession(t *testing.T) {
+	runStoreTest(t, func(t *testing.T, store Store) {
+		const roomID matrix.RoomID = "!room:example.org"
+		if session, err := store.LoadOutboundGroupSession(roomID); session != nil || err != nil {
+			t.Fatalf("expected no session in empty store, got %v, %v", session, err)
+		}
+
+		olmSession, err := olm.NewOutboundGroupSession()
+		if err != nil {
+			t.Fatalf("error creating outbound group session: %v", err)
+		}
+		olmSession.Encrypt([]byte("message"))
+		saved := &OutboundGroupSession{
+			OutboundGroupSession: olmSession,
+			RoomID:               roomID,
+			CreatedAt:            time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+			SharedWith: map[matrix.UserID]map[matrix.DeviceID]string{
+				"@bob:example.org": {"BOB": "bob+curve25519+key"},
+			},
+		}
+		if err := store.SaveOutboundGroupSession(saved); err != nil {
+			t.Fatalf("error saving outbound group session: %v", err)
+		}
+
+		session, err := store.LoadOutboundGroupSession(roomID)
+		if err != nil || session == nil {
+			t.Fatalf("error loading outbound group session: %v", err)
+		}
+		switch {
+		case session.ID() != saved.ID() || session.MessageIndex() != saved.MessageIndex():
+			t.Errorf("loaded session does not match the saved one")
+		case session.RoomID != roomID || !session.CreatedAt.Equal(saved.CreatedAt):
+			t.Errorf("unexpected metadata %v, %v", session.RoomID, session.CreatedAt)
+		case !reflect.DeepEqual(session.SharedWith, saved.SharedWith):
+			t.Errorf("expected shared with %v, got %v", saved.SharedWith, session.SharedWith)
+		}
+
+		if err := store.RemoveOutboundGroupSession(roomID); err != nil {
+			t.Fatalf("error removing outbound group session: %v", err)
+		}
+		if session, err := store.LoadOutboundGroupSession(roomID); session != nil || err != nil {
+			t.Errorf("expected session to be removed, got %v, %v", session, err)
+		}
+		if err := store.RemoveOutboundGroupSession(roomID); err != nil {
+			t.Errorf("unexpected error removing missing session: %v", err)
+		}
+	})
+}
+
+func TestStoreInboundGroupSessions(t *testing.T) {
+	runStoreTest(t, func(t *testing.T, store Store) {
+		if sessions, err := store.LoadInboundGroupSessions(); len(sessions) != 0 || err != nil {
+			t.Fatalf("expected no sessions in empty store, got %v, %v", sessions, err)
+		}
+
+		saved := make(map[string]*InboundGroupSession)
+		for i, roomID := range []matrix.RoomID{"!first:example.org", "!second:example.org"} {
+			outbound, err := olm.NewOutboundGroupSession()
+			if err != nil {
+				t.Fatalf("error creating outbound group session: %v", err)
+			}
+			inbound, err := olm.NewInboundGroupSession(outbound.SessionKey())
+			if err != nil {
+				t.Fatalf("error creating inbound group session: %v", err)
+			}
+			session := &InboundGroupSession{
+				InboundGroupSession: inbound,
+				RoomID:              roomID,
+				SenderKey:           "sender+curve25519+key",
+				SigningKey:          "sender+ed25519+key",
+				BackupVersion:       "1",
+				Imported:            i == 1,
+			}
+			if i == 1 {
+				session.ForwardingChain = []string{"forwarder+curve25519+key"}
+			}
+			if err := store.SaveInboundGroupSession(session); err != nil {
+				t.Fatalf("error saving inbound group session: %v", err)
+			}
+			saved[session.ID()] = session
+		}
+
+		check := func(got *InboundGroupSession) {
+			t.Helper()
+			want := saved[got.ID()]
+			switch {
+			case want == nil:
+				t.Errorf("unexpected session %s", got.ID())
+			case got.RoomID != want.RoomID || got.SenderKey != want.SenderKey || got.SigningKey != want.SigningKey ||
+				got.BackupVersion != want.BackupVersion || got.Imported != want.Imported:
+				t.Errorf("session %s: expected %+v, got %+v", got.ID(), want, got)
+			case !reflect.DeepEqual(got.ForwardingChain, want.ForwardingChain):
+				t.Errorf("session %s: expected forwarding chain %v, got %v", got.ID(), want.ForwardingChain,
+					got.ForwardingChain)
+			}
+		}
+		for id, want := range saved {
+			got, err := store.LoadInboundGroupSession(want.RoomID, id)
+			if err != nil || got == nil {
+				t.Fatalf("error loading inbound group session: %v", err)
+			}
+			check(got)
+			// Sessions are identified by both the room and the session ID.
+			if other, err := store.LoadInboundGroupSession("!other:example.org", id); other != nil || err != nil {
+				t.Errorf("expected no session in another room, got %v, %v", other, err)
+			}
+		}
+
+		all, err := store.LoadInboundGroupSessions()
+		if err != nil {
+			t.Fatalf("error loading inbound group sessions: %v", err)
+		}
+		if len(all) != len(saved) {
+			t.Fatalf("expected %d sessions, got %d", len(saved), len(all))
+		}
+		for _, v := range all {
+			check(v)
+		}
+	})
+}
+
+func TestStoreDevices(t *testing.T) {
+	runStoreTest(t, func(t *testing.T, store Store) {
+		if lists, err := store.LoadDeviceLists(); lists != nil || err != nil {
+			t.Fatalf("expected no device lists in empty store, got %v, %v", lists, err)
+		}
+		wantLists := &DeviceLists{
+			Tracked:   map[matrix.UserID]bool{"@alice:example.org": false, "@bob:example.org": true},
+			SyncToken: "s123",
+		}
+		if err := store.SaveDeviceLists(wantLists); err != nil {
+			t.Fatalf("error saving device lists: %v", err)
+		}
+		lists, err := store.LoadDeviceLists()
+		if err != nil {
+			t.Fatalf("error loading device lists: %v", err)
+		}
+		if !reflect.DeepEqual(lists, wantLists) {
+			t.Errorf("expected device lists %+v, got %+v", wantLists, lists)
+		}
+
+		const userID matrix.UserID = "@bob:example.org"
+		if devices, err := store.LoadDevices(userID); len(devices) != 0 || err != nil {
+			t.Fatalf("expected no devices in empty store, got %v, %v", devices, err)
+		}
+		wantDevices := map[matrix.DeviceID]*Device{
+			"BOB": {
+				UserID:        userID,
+				DeviceID:      "BOB",
+				IdentityKey:   "bob+curve25519+key",
+				SigningKey:    "bob+ed25519+key",
+				DisplayName:   "Bob's phone",
+				Verified:      true,
+				CrossSignedBy: "bob+self+signing+key",
+			},
+			"BOB2": {UserID: userID, DeviceID: "BOB2"},
+		}
+		if err := store.SaveDevices(userID, wantDevices); err != nil {
+			t.Fatalf("error saving devices: %v", err)
+		}
+		devices, err := store.LoadDevices(userID)
+		if err != nil {
+			t.Fatalf("error loading devices: %v", err)
+		}
+		if !reflect.DeepEqual(devices, wantDevices) {
+			t.Errorf("expected devices %+v, got %+v", wantDevices, devices)
+		}
+	})
+}
+
+func TestStoreCrossSigning(t *testing.T) {
+	runStoreTest(t, func(t *testing.T, store Store) {
+		if keys, err := store.LoadCrossSigningKeys(); keys != nil || err != nil {
+			t.Fatalf("expected no cross-signing keys in empty store, got %v, %v", keys, err)
+		}
+		master, err := olm.NewPKSigning()
+		if err != nil {
+			t.Fatalf("error creating key: %v", err)
+		}
+		selfSigning, err := olm.NewPKSigning()
+		if err != nil {
+			t.Fatalf("error creating key: %v", err)
+		}
+		// The user-signing key is not known.
+		if err := store.SaveCrossSigningKeys(&CrossSigningKeys{Master: master, SelfSigning: selfSigning}); err != nil {
+			t.Fatalf("error saving cross-signing keys: %v", err)
+		}
+		keys, err := store.LoadCrossSigningKeys()
+		if err != nil || keys == nil {
+			t.Fatalf("error loading cross-signing keys: %v", err)
+		}
+		if keys.Master == nil || keys.Master.PublicKey() != master.PublicKey() ||
+			keys.SelfSigning == nil || keys.SelfSigning.PublicKey() != selfSigning.PublicKey() ||
+			keys.UserSigning != nil {
+			t.Errorf("loaded cross-signing keys do not match the saved ones")
+		}
+
+		const userID matrix.UserID = "@bob:example.org"
+		if identity, err := store.LoadUserIdentity(userID); identity != nil || err != nil {
+			t.Fatalf("expected no identity in empty store, got %v, %v", identity, err)
+		}
+		saved := &UserIdentity{
+			UserID: userID,
+			Master: api.CrossSigningKey{
+				UserID: userID,
+				Usage:  []string{"master"},
+				Keys:   map[string]string{"ed25519:" + master.PublicKey(): master.PublicKey()},
+			},
+			SelfSigning: &api.CrossSigningKey{
+				UserID: userID,
+				Usage:  []string{"self_signing"},
+				Keys:   map[string]string{"ed25519:" + selfSigning.PublicKey(): selfSigning.PublicKey()},
+			},
+			Verified: true,
+		}
+		if err := store.SaveUserIdentity(saved); err != nil {
+			t.Fatalf("error saving identity: %v", err)
+		}
+		identity, err := store.LoadUserIdentity(userID)
+		if err != nil || identity == nil {
+			t.Fatalf("error loading identity: %v", err)
+		}
+		if identity.UserID != userID || identity.MasterKey() != master.PublicKey() || identity.SelfSigning == nil ||
+			identity.SelfSigning.PublicKey() != selfSigning.PublicKey() || identity.UserSigning != nil ||
+			!identity.Verified {
+			t.Errorf("expected identity %+v, got %+v", saved, identity)
+		}
+
+		if err := store.RemoveUserIdentity(userID); err != nil {
+			t.Fatalf("error removing identity: %v", err)
+		}
+		if identity, err := store.LoadUserIdentity(userID); identity != nil || err != nil {
+			t.Errorf("expected identity to be removed, got %v, %v", identity, err)
+		}
+	})
+}
+
+func TestStoreKeyBackup(t *testing.T) {
+	runStoreTest(t, func(t *testing.T, store Store) {
+		if backup, err := store.LoadKeyBackup(); backup != nil || err != nil {
+			t.Fatalf("expected no key backup in empty store, got %v, %v", backup, err)
+		}
+		key, err := olm.NewPKDecryption()
+		if err != nil {
+			t.Fatalf("error creating key: %v", err)
+		}
+
+		for _, saved := range []*KeyBackup{
+			{Version: "1", PublicKey: key.PublicKey(), Key: key},
+			// The private key is not known if the backup has been created by another device.
+			{Version: "2", PublicKey: key.PublicKey()},
+		} {
+			if err := store.SaveKeyBackup(saved); err != nil {
+				t.Fatalf("error saving key backup: %v", err)
+			}
+			backup, err := store.LoadKeyBackup()
+			if err != nil || backup == nil {
+				t.Fatalf("error loading key backup: %v", err)
+			}
+			if backup.Version != saved.Version || backup.PublicKey != saved.PublicKey ||
+				(backup.Key == nil) != (saved.Key == nil) ||
+				(backup.Key != nil && backup.Key.PublicKey() != saved.Key.PublicKey()) {
+				t.Errorf("expected key backup %+v, got %+v", saved, backup)
+			}
+		}
+
+		if err := store.SaveKeyBackup(nil); err != nil {
+			t.Fatalf("error removing key backup: %v", err)
+		}
+		if backup, err := store.LoadKeyBackup(); backup != nil || err != nil {
+			t.Errorf("expected key backup to be removed, got %v, %v", backup, err)
+		}
+	})
+}
+
+func TestFileStoreReopen(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir, []byte("pickle key"))
+	if err != nil {
+		t.Fatalf("error creating store: %v", err)
+	}
+	olmAccount, err := olm.NewAccount()
+	if err != nil {
+		t.Fatalf("error creating account: %v", err)
+	}
+	if err := store.SaveAccount(&Account{Account: olmAccount}); err != nil {
+		t.Fatalf("error saving account: %v", err)
+	}
+
+	reopened, err := NewFileStore(dir, []byte("pickle key"))
+	if err != nil {
+		t.Fatalf("error opening store: %v", err)
+	}
+	account, err := reopened.LoadAccount()
+	if err != nil || account == nil {
+		t.Fatalf("error loading account: %v", err)
+	}
+	wantCurve, _ := olmAccount.IdentityKeys()
+	if curve, _ := account.IdentityKeys(); curve != wantCurve {
+		t.Errorf("loaded account does not match the saved one")
+	}
+
+	wrongKey, err := NewFileStore(dir, []byte("wrong key"))
+	if err != nil {
+		t.Fatalf("error opening store: %v", err)
+	}
+	if _, err := wrongKey.LoadAccount(); err == nil {
+		t.Errorf("expected error loading account with the wrong pickle key")
+	}
+}
@@ -0,0 +1,979 @@
+package e2ee
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chanbakjsd/gotrix/api"
+	"github.com/chanbakjsd/gotrix/debug"
+	"github.com/chanbakjsd/gotrix/encrypt/olm"
+	"github.com/chanbakjsd/gotrix/event"
+	"github.com/chanbakjsd/gotrix/matrix"
+)
+
+// Key verification methods and algorithms supported by the Machine.
+const (
+	VerificationMethodSAS         = "m.sas.v1"
+	VerificationMethodQRShow      = "m.qr_code.show.v1"
+	VerificationMethodQRScan      = "m.qr_code.scan.v1"
+	VerificationMethodReciprocate = "m.reciprocate.v1"
+
+	KeyAgreementCurve25519HKDFSHA256 = "curve25519-hkdf-sha256"
+	HashSHA256                       = "sha256"
+	MACHKDFHMACSHA256V2              = "hkdf-hmac-sha256.v2"
+)
+
+// verificationTimeout is how long a verification request stays valid.
+const verificationTimeout = 10 * time.Minute
+
+// Errors returned when verifying devices.
+var (
+	// ErrNoDevicesToVerify is returned when requesting a verification with a user that has no other device.
+	ErrNoDevicesToVerify = errors.New("no devices to verify")
+	// ErrVerificationState is returned when an action is not allowed in the current state of the verification.
+	ErrVerificationState = errors.New("action not allowed in the current verification state")
+)
+
+// VerificationState is the progress of a key verification.
+type VerificationState int
+
+// List of verification states in the order they are reached.
+const (
+	// VerificationRequested means that the request has been sent but not accepted yet.
+	VerificationRequested VerificationState = iota
+	// VerificationReady means that the request has been accepted and a method can be started.
+	VerificationReady
+	// VerificationStarted means that a method has been started.
+	VerificationStarted
+	// VerificationKeysExchanged means that the short authentication string is available to be compared, or
+	// that the other device has scanned the QR code shown by this device.
+	VerificationKeysExchanged
+	// VerificationConfirmed means that the user has confirmed that the short authentication strings match or
+	// that the QR code has been scanned.
+	VerificationConfirmed
+	// VerificationDone means that the other device has been verified.
+	VerificationDone
+	// VerificationCancelled means that the verification has been cancelled by either party.
+	VerificationCancelled
+)
+
+// VerificationCancelError is the reason a verification has been cancelled.
+type VerificationCancelError struct {
+	Code   event.VerificationCancelCode
+	Reason string
+	// ByUs is true if the verification has been cancelled by this device.
+	ByUs bool
+}
+
+func (v *VerificationCancelError) Error() string {
+	return fmt.Sprintf("verification cancelled (%s): %s", v.Code, v.Reason)
+}
+
+// VerificationCallbacks are called as key verifications progress. Nil callbacks are ignored.
+//
+// They are called synchronously while handling sync events so they must not block for a long time.
+type VerificationCallbacks struct {
+	// OnRequest is called when another device requests a verification. Accept or Cancel should be called to
+	// answer it. Requests are left unanswered if it is nil.
+	OnRequest func(v *Verification)
+	// OnReady is called when the other device accepts a request sent by us. StartSAS, QRCode or ScanQRCode
+	// should be called to continue. SAS verification is started automatically if it is nil.
+	OnReady func(v *Verification)
+	// OnSAS is called once the short authentication string is available. Confirm should be called if it
+	// matches the one displayed by the other device and Reject if it does not.
+	OnSAS func(v *Verification, sas SAS)
+	// OnQRScanned is called once the other device has scanned the QR code shown by this device. Confirm should
+	// be called if the user confirms that it has been scanned and Reject if it has not.
+	OnQRScanned func(v *Verification)
+	// OnDone is called once the other device has been verified.
+	OnDone func(v *Verification)
+	// OnCancel is called when the verification is cancelled by either party.
+	OnCancel func(v *Verification, err *VerificationCancelError)
+}
+
+// verificationKey identifies a verification.
+type verificationKey struct {
+	UserID matrix.UserID
+	ID     string
+}
+
+// Verification is an interactive key verification with a device of another user or of the same user.
+//
+// It is carried over to-device events, or over room events if RoomID is set.
+type Verification struct {
+	m  *Machine
+	mu sync.Mutex
+
+	// ID is the transaction ID of the verification. It is the event ID of the request for in-room
+	// verifications.
+	ID string
+	// RoomID is the room in-room verifications take place in. It is empty for to-device verifications.
+	RoomID matrix.RoomID
+	// OtherUser is the user being verified.
+	OtherUser matrix.UserID
+	// OtherDevice is the device of the other user taking part in the verification. It is empty until one of
+	// their devices accepts the request if the request has been sent to all of their devices.
+	OtherDevice matrix.DeviceID
+	// Incoming is true if the other user sent the request.
+	Incoming bool
+	// Methods are the verification methods supported by the other device.
+	Methods []string
+
+	state     VerificationState
+	cancelErr *VerificationCancelError
+	// requestedDevices are the devices the request has been sent to.
+	requestedDevices []matrix.DeviceID
+	// callbacks are queued while the verification is locked and called once it is unlocked.
+	callbacks []func()
+
+	// SAS state.
+	startedByUs  bool
+	startContent []byte
+	sas          *olm.SAS
+	emoji        bool
+	commitment   string
+	theirKey     string
+	theirMAC     *event.VerificationMACEvent
+
+	// QR code state.
+	qrCode    *QRCode
+	qrScanned bool
+
+	// verified is true once we have verified the other device and doneReceived once they have verified us.
+	verified     bool
+	doneReceived bool
+}
+
+// State returns the progress of the verification.
+func (v *Verification) State() VerificationState {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.state
+}
+
+// Err returns the reason the verification has been cancelled, or nil if it has not.
+func (v *Verification) Err() *VerificationCancelError {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.cancelErr
+}
+
+// unlock unlocks the verification and calls the callbacks that have been queued while it was locked.
+func (v *Verification) unlock() {
+	callbacks := v.callbacks
+	v.callbacks = nil
+	v.mu.Unlock()
+	for _, f := range callbacks {
+		f()
+	}
+}
+
+// Verification returns the ongoing verification with the user, or nil if there is none.
+func (m *Machine) Verification(userID matrix.UserID, id string) *Verification {
+	m.verificationMu.Lock()
+	defer m.verificationMu.Unlock()
+	return m.verifications[verificationKey{userID, id}]
+}
+
+// addVerification adds the verification to the ongoing ones.
+func (m *Machine) addVerification(v *Verification) {
+	m.verificationMu.Lock()
+	defer m.verificationMu.Unlock()
+	if m.verifications == nil {
+		m.verifications = make(map[verificationKey]*Verification)
+	}
+	m.verifications[verificationKey{v.OtherUser, v.ID}] = v
+}
+
+// removeVerification removes the verification from the ongoing ones.
+func (m *Machine) removeVerification(v *Verification) {
+	m.verificationMu.Lock()
+	defer m.verificationMu.Unlock()
+	delete(m.verifications, verificationKey{v.OtherUser, v.ID})
+}
+
+// sendRoomEvent sends an event to the room using SendRoomEvent if it is set.
+func (m *Machine) sendRoomEvent(ctx context.Context, roomID matrix.RoomID, eventType event.Type,
+	content interface{}) (matrix.EventID, error) {
+	if m.SendRoomEvent != nil {
+		return m.SendRoomEvent(ctx, roomID, eventType, content)
+	}
+	return m.Client.RoomEventSendContext(ctx, roomID, eventType, content)
+}
+
+// RequestVerification requests a to-device verification with the provided devices of the user. The request
+// is sent to every device of the user except this one if devices is nil.
+func (m *Machine) RequestVerification(ctx context.Context, userID matrix.UserID,
+	devices []matrix.DeviceID) (*Verification, error) {
+	if devices == nil {
+		userDevices, err := m.Devices(ctx, userID)
+		if err != nil {
+			return nil, fmt.Errorf("error querying devices: %w", err)
+		}
+		for id := range userDevices {
+			if userID != m.Client.UserID || id != m.Client.DeviceID {
+				devices = append(devices, id)
+			}
+		}
+	}
+	if len(devices) == 0 {
+		return nil, ErrNoDevicesToVerify
+	}
+
+	v := &Verification{
+		m:                m,
+		ID:               api.NextTransactionID(),
+		OtherUser:        userID,
+		requestedDevices: devices,
+	}
+	if len(devices) == 1 {
+		v.OtherDevice = devices[0]
+	}
+
+	v.mu.Lock()
+	defer v.unlock()
+	// The verification is added first so that the answer is not missed if it arrives before sending returns.
+	m.addVerification(v)
+	err := v.send(ctx, event.TypeVerificationRequest, event.VerificationRequestEvent{
+		VerificationFlow: v.flow(),
+		FromDevice:       m.Client.DeviceID,
+		Methods:          m.verificationMethods(),
+		Timestamp:        matrix.Timestamp(time.Now().UnixNano() / int64(time.Millisecond)),
+	})
+	if err != nil {
+		m.removeVerification(v)
+		return nil, fmt.Errorf("error sending verification request: %w", err)
+	}
+	return v, nil
+}
+
+// RequestRoomVerification requests an in-room verification with the user in the provided room, which should
+// be a direct message room with them.
+func (m *Machine) RequestRoomVerification(ctx context.Context, roomID matrix.RoomID,
+	userID matrix.UserID) (*Verification, error) {
+	id, err := m.sendRoomEvent(ctx, roomID, event.TypeRoomMessage, event.VerificationRequestEvent{
+		Body: string(m.Client.UserID) +
+			" is requesting to verify your key, but your client does not support in-chat key verification.",
+		MessageType: event.RoomMessageVerificationRequest,
+		FromDevice:  m.Client.DeviceID,
+		Methods:     m.verificationMethods(),
+		To:          userID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error sending verification request: %w", err)
+	}
+
+	v := &Verification{
+		m:         m,
+		ID:        string(id),
+		RoomID:    roomID,
+		OtherUser: userID,
+	}
+	m.addVerification(v)
+	return v, nil
+}
+
+// verificationMethods returns the verification methods supported by the Machine.
+func (m *Machine) verificationMethods() []string {
+	return []string{
+		VerificationMethodSAS,
+		VerificationMethodQRShow,
+		VerificationMethodQRScan,
+		VerificationMethodReciprocate,
+	}
+}
+
+// HandleVerificationEvent processes a key verification event, which may be a to-device event or a room event.
+// In-room verification requests are RoomMessageEvent. Other events are ignored.
+func (m *Machine) HandleVerificationEvent(ctx context.Context, e event.Event) error {
+	re, ok := e.(event.RoomEvent)
+	if !ok {
+		return nil
+	}
+	info := re.RoomInfo()
+	// Our own in-room events are echoed back to us.
+	if info.RoomID != "" && info.Sender == m.Client.UserID {
+		return nil
+	}
+
+	switch e := e.(type) {
+	case *event.RoomMessageEvent:
+		if e.MessageType != event.RoomMessageVerificationRequest {
+			return nil
+		}
+		var raw struct {
+			Content event.VerificationRequestEvent `json:"content"`
+		}
+		if err := json.Unmarshal(e.Raw, &raw); err != nil {
+			return fmt.Errorf("error decoding verification request: %w", err)
+		}
+		if raw.Content.To != m.Client.UserID {
+			return nil
+		}
+		raw.Content.RoomEventInfo = e.RoomEventInfo
+		return m.receiveRequest(&raw.Content, string(e.ID))
+	case *event.VerificationRequestEvent:
+		if info.RoomID != "" {
+			return nil
+		}
+		return m.receiveRequest(e, e.TransactionID)
+	}
+
+	flow, ok := e.(interface{ FlowID() string })
+	if !ok {
+		return nil
+	}
+	v := m.Verification(info.Sender, flow.FlowID())
+	if v == nil {
+		m.Client.Logger.Debug("ignoring event of unknown verification", debug.Any("type", e.Info().Type),
+			debug.UserID(info.Sender), debug.Any("transaction_id", flow.FlowID()))
+		return nil
+	}
+
+	v.mu.Lock()
+	defer v.unlock()
+	if v.RoomID != info.RoomID || v.state == VerificationDone || v.state == VerificationCancelled {
+		return nil
+	}
+	return v.handle(ctx, e)
+}
+
+// receiveRequest creates a verification for the request and calls the OnRequest callback.
+func (m *Machine) receiveRequest(e *event.VerificationRequestEvent, id string) error {
+	log := m.Client.Logger.With(debug.UserID(e.Sender), debug.Any("transaction_id", id))
+	if id == "" || e.FromDevice == "" {
+		log.Warn("ignoring invalid verification request")
+		return nil
+	}
+	if e.Sender == m.Client.UserID && e.FromDevice == m.Client.DeviceID {
+		return nil
+	}
+	if e.RoomID == "" {
+		age := time.Since(e.Timestamp.Time())
+		if age > verificationTimeout || age < -verificationTimeout/2 {
+			log.Debug("ignoring expired verification request")
+			return nil
+		}
+	}
+
+	v := &Verification{
+		m:           m,
+		ID:          id,
+		RoomID:      e.RoomID,
+		OtherUser:   e.Sender,
+		OtherDevice: e.FromDevice,
+		Incoming:    true,
+		Methods:     e.Methods,
+	}
+	m.addVerification(v)
+	log.Debug("received verification request")
+	if m.VerificationCallbacks.OnRequest != nil {
+		m.VerificationCallbacks.OnRequest(v)
+	}
+	return nil
+}
+
+// Accept accepts the verification request sent by the other user.
+func (v *Verification) Accept(ctx context.Context) error {
+	v.mu.Lock()
+	defer v.unlock()
+	if !v.Incoming || v.state != VerificationRequested {
+		return ErrVerificationState
+	}
+
+	methods := readyMethods(v.m.verificationMethods(), v.Methods)
+	if len(methods) == 0 {
+		return v.cancel(ctx, event.VerificationCancelUnknownMethod, "no common verification method")
+	}
+	err := v.send(ctx, event.TypeVerificationReady, event.VerificationReadyEvent{
+		VerificationFlow: v.flow(),
+		FromDevice:       v.m.Client.DeviceID,
+		Methods:          methods,
+	})
+	if err != nil {
+		return fmt.Errorf("error accepting verification: %w", err)
+	}
+	v.state = VerificationReady
+	return nil
+}
+
+// StartSAS starts a SAS verification once the request has been accepted. It is called automatically when the
+// other device accepts a request sent by us.
+func (v *Verification) StartSAS(ctx context.Context) error {
+	v.mu.Lock()
+	defer v.unlock()
+	return v.startSAS(ctx)
+}
+
+func (v *Verification) startSAS(ctx context.Context) error {
+	if v.state != VerificationReady {
+		return ErrVerificationState
+	}
+
+	sas, err := olm.NewSAS()
+	if err != nil {
+		return fmt.Errorf("error creating SAS: %w", err)
+	}
+	content := event.VerificationStartEvent{
+		VerificationFlow:           v.flow(),
+		FromDevice:                 v.m.Client.DeviceID,
+		Method:                     VerificationMethodSAS,
+		KeyAgreementProtocols:      []string{KeyAgreementCurve25519HKDFSHA256},
+		Hashes:                     []string{HashSHA256},
+		MessageAuthenticationCodes: []string{MACHKDFHMACSHA256V2},
+		ShortAuthenticationString:  []string{SASDecimal, SASEmoji},
+	}
+	startContent, err := CanonicalJSON(content)
+	if err != nil {
+		return err
+	}
+	if err := v.send(ctx, event.TypeVerificationStart, content); err != nil {
+		return fmt.Errorf("error starting verification: %w", err)
+	}
+
+	v.sas = sas
+	v.startContent = startContent
+	v.startedByUs = true
+	v.state = VerificationStarted
+	return nil
+}
+
+// Confirm confirms that the short authentication string matches the one displayed by the other device, or
+// that the other device has scanned the QR code shown by this device. The other device is verified once it
+// confirms as well.
+func (v *Verification) Confirm(ctx context.Context) error {
+	v.mu.Lock()
+	defer v.unlock()
+	if v.state != VerificationKeysExchanged {
+		return ErrVerificationState
+	}
+	if v.qrScanned {
+		return v.confirmQR(ctx)
+	}
+
+	ourKeys := map[string]string{}
+	_, ed := v.m.IdentityKeys()
+	ourKeys["ed25519:"+string(v.m.Client.DeviceID)] = ed
+	// Our master key is only sent if we trust it so that the other user can trust our other devices.
+	if master, err := v.m.trustedMasterKey(v.m.Client.UserID); err != nil {
+		v.m.Client.Logger.Warn("error loading master key", debug.Err(err))
+	} else if master != "" {
+		ourKeys["ed25519:"+master] = master
+	}
+
+	info := "MATRIX_KEY_VERIFICATION_MAC" + string(v.m.Client.UserID) + string(v.m.Client.DeviceID) +
+		string(v.OtherUser) + string(v.OtherDevice) + v.ID
+	content := event.VerificationMACEvent{
+		VerificationFlow: v.flow(),
+		MAC:              make(map[string]string, len(ourKeys)),
+	}
+	keyIDs := make([]string, 0, len(ourKeys))
+	for keyID, key := range ourKeys {
+		mac, err := v.sas.CalculateMAC(key, info+keyID)
+		if err != nil {
+			return err
+		}
+		content.MAC[keyID] = mac
+		keyIDs = append(keyIDs, keyID)
+	}
+	sort.Strings(keyIDs)
+	var err error
+	content.Keys, err = v.sas.CalculateMAC(strings.Join(keyIDs, ","), info+"KEY_IDS")
+	if err != nil {
+		return err
+	}
+
+	if err := v.send(ctx, event.TypeVerificationMAC, content); err != nil {
+		return fmt.Errorf("error sending MAC: %w", err)
+	}
+	v.state = VerificationConfirmed
+	if v.theirMAC != nil {
+		return v.verifyMAC(ctx)
+	}
+	return nil
+}
+
+// Reject cancels the verification because the short authentication string does not match the one displayed
+// by the other device, or because the other device has not scanned the QR code shown by this device.
+func (v *Verification) Reject(ctx context.Context) error {
+	v.mu.Lock()
+	defer v.unlock()
+	if v.state != VerificationKeysExchanged {
+		return ErrVerificationState
+	}
+	if v.qrScanned {
+		return v.cancel(ctx, event.VerificationCancelUser, "QR code has not been scanned")
+	}
+	return v.cancel(ctx, event.VerificationCancelMismatchedSAS, "short authentication strings do not match")
+}
+
+// Cancel cancels the verification with the provided code and reason.
+func (v *Verification) Cancel(ctx context.Context, code event.VerificationCancelCode, reason string) error {
+	v.mu.Lock()
+	defer v.unlock()
+	if v.state == VerificationDone || v.state == VerificationCancelled {
+		return ErrVerificationState
+	}
+	return v.cancel(ctx, code, reason)
+}
+
+// cancel sends a cancel event to the other device and marks the verification as cancelled.
+func (v *Verification) cancel(ctx context.Context, code event.VerificationCancelCode, reason string) error {
+	v.cancelled(&VerificationCancelError{
+		Code:   code,
+		Reason: reason,
+		ByUs:   true,
+	})
+	err := v.send(ctx, event.TypeVerificationCancel, event.VerificationCancelEvent{
+		VerificationFlow: v.flow(),
+		Code:             code,
+		Reason:           reason,
+	})
+	if err != nil {
+		return fmt.Errorf("error cancelling verification: %w", err)
+	}
+	return nil
+}
+
+// cancelled marks the verification as cancelled and queues the OnCancel callback.
+func (v *Verification) cancelled(err *VerificationCancelError) {
+	v.m.Client.Logger.Debug("verification cancelled", debug.UserID(v.OtherUser),
+		debug.Any("transaction_id", v.ID), debug.Any("code", err.Code), debug.Any("by_us", err.ByUs))
+	v.state = VerificationCancelled
+	v.cancelErr = err
+	v.m.removeVerification(v)
+	if cb := v.m.VerificationCallbacks.OnCancel; cb != nil {
+		v.callbacks = append(v.callbacks, func() { cb(v, err) })
+	}
+}
+
+// handle processes an event of the verification.
+func (v *Verification) handle(ctx context.Context, e event.Event) error {
+	switch e := e.(type) {
+	case *event.VerificationReadyEvent:
+		return v.handleReady(ctx, e)
+	case *event.VerificationStartEvent:
+		return v.handleStart(ctx, e)
+	case *event.VerificationAcceptEvent:
+		return v.handleAccept(ctx, e)
+	case *event.VerificationKeyEvent:
+		return v.handleKey(ctx, e)
+	case *event.VerificationMACEvent:
+		return v.handleMAC(ctx, e)
+	case *event.VerificationDoneEvent:
+		v.doneReceived = true
+		v.finish()
+		return nil
+	case *event.VerificationCancelEvent:
+		v.cancelled(&VerificationCancelError{
+			Code:   e.Code,
+			Reason: e.Reason,
+		})
+		return nil
+	}
+	return nil
+}
+
+func (v *Verification) handleReady(ctx context.Context, e *event.VerificationReadyEvent) error {
+	if v.Incoming || v.state != VerificationRequested {
+		return v.cancel(ctx, event.VerificationCancelUnexpectedMessage, "unexpected ready event")
+	}
+	if v.RoomID == "" && !containsDevice(v.requestedDevices, e.FromDevice) {
+		return nil
+	}
+
+	// The request has been sent to multiple devices and the others have to be told that it is not for them
+	// anymore.
+	var others []matrix.DeviceID
+	for _, d := range v.requestedDevices {
+		if d != e.FromDevice {
+			others = append(others, d)
+		}
+	}
+	if v.RoomID == "" && len(others) > 0 {
+		messages := api.DeviceMessages{v.OtherUser: make(map[matrix.DeviceID]interface{}, len(others))}
+		for _, d := range others {
+			messages[v.OtherUser][d] = event.VerificationCancelEvent{
+				VerificationFlow: v.flow(),
+				Code:             event.VerificationCancelAccepted,
+				Reason:           "verification request accepted by another device",
+			}
+		}
+		if err := v.m.Client.SendToDeviceContext(ctx, event.TypeVerificationCancel, messages); err != nil {
+			v.m.Client.Logger.Warn("error cancelling verification on other devices", debug.Err(err))
+		}
+	}
+
+	v.OtherDevice = e.FromDevice
+	v.Methods = e.Methods
+	v.state = VerificationReady
+	if len(readyMethods(v.m.verificationMethods(), v.Methods)) == 0 {
+		return v.cancel(ctx, event.VerificationCancelUnknownMethod, "no common verification method")
+	}
+	if cb := v.m.VerificationCallbacks.OnReady; cb != nil {
+		v.callbacks = append(v.callbacks, func() { cb(v) })
+		return nil
+	}
+	if !containsString(v.Methods, VerificationMethodSAS) {
+		return v.cancel(ctx, event.VerificationCancelUnknownMethod, "SAS verification is not supported")
+	}
+	return v.startSAS(ctx)
+}
+
+func (v *Verification) handleStart(ctx context.Context, e *event.VerificationStartEvent) error {
+	if e.Method == VerificationMethodReciprocate {
+		if v.state != VerificationReady || e.FromDevice != v.OtherDevice {
+			return v.cancel(ctx, event.VerificationCancelUnexpectedMessage, "unexpected start event")
+		}
+		return v.handleReciprocate(ctx, e)
+	}
+
+	switch {
+	case v.state == VerificationStarted && v.startedByUs:
+		// Both devices started at the same time. The start of the device with the lowest user ID and device
+		// ID wins.
+		ourUser, ourDevice := v.m.Client.UserID, v.m.Client.DeviceID
+		if ourUser < v.OtherUser || (ourUser == v.OtherUser && ourDevice < v.OtherDevice) {
+			return nil
+		}
+		v.startedByUs = false
+		v.sas = nil
+	case v.state != VerificationReady:
+		return v.cancel(ctx, event.VerificationCancelUnexpectedMessage, "unexpected start event")
+	}
+	if e.FromDevice != v.OtherDevice {
+		return v.cancel(ctx, event.VerificationCancelUnexpectedMessage, "start event from another device")
+	}
+
+	if e.Method != VerificationMethodSAS ||
+		!containsString(e.KeyAgreementProtocols, KeyAgreementCurve25519HKDFSHA256) ||
+		!containsString(e.Hashes, HashSHA256) ||
+		!containsString(e.MessageAuthenticationCodes, MACHKDFHMACSHA256V2) ||
+		!containsString(e.ShortAuthenticationString, SASDecimal) {
+		return v.cancel(ctx, event.VerificationCancelUnknownMethod, "unsupported verification method")
+	}
+
+	startContent, err := rawContent(e)
+	if err != nil {
+		return v.cancel(ctx, event.VerificationCancelInvalidMessage, "invalid start event")
+	}
+	sas, err := olm.NewSAS()
+	if err != nil {
+		return fmt.Errorf("error creating SAS: %w", err)
+	}
+
+	v.sas = sas
+	v.startContent = startContent
+	v.emoji = containsString(e.ShortAuthenticationString, SASEmoji)
+	v.state = VerificationStarted
+
+	sasMethods := []string{SASDecimal}
+	if v.emoji {
+		sasMethods = append(sasMethods, SASEmoji)
+	}
+	err = v.send(ctx, event.TypeVerificationAccept, event.VerificationAcceptEvent{
+		VerificationFlow:          v.flow(),
+		Method:                    VerificationMethodSAS,
+		KeyAgreementProtocol:      KeyAgreementCurve25519HKDFSHA256,
+		Hash:                      HashSHA256,
+		MessageAuthenticationCode: MACHKDFHMACSHA256V2,
+		ShortAuthenticationString: sasMethods,
+		Commitment:                sasCommitment(sas.PublicKey(), startContent),
+	})
+	if err != nil {
+		return fmt.Errorf("error accepting verification: %w", err)
+	}
+	return nil
+}
+
+func (v *Verification) handleAccept(ctx context.Context, e *event.VerificationAcceptEvent) error {
+	if v.state != VerificationStarted || !v.startedByUs || v.commitment != "" {
+		return v.cancel(ctx, event.VerificationCancelUnexpectedMessage, "unexpected accept event")
+	}
+	if e.Method != VerificationMethodSAS || e.KeyAgreementProtocol != KeyAgreementCurve25519HKDFSHA256 ||
+		e.Hash != HashSHA256 || e.MessageAuthenticationCode != MACHKDFHMACSHA256V2 ||
+		!containsString(e.ShortAuthenticationString, SASDecimal) || e.Commitment == "" {
+		return v.cancel(ctx, event.VerificationCancelUnknownMethod, "unsupported verification method")
+	}
+
+	v.commitment = e.Commitment
+	v.emoji = containsString(e.ShortAuthenticationString, SASEmoji)
+	err := v.send(ctx, event.TypeVerificationKey, event.VerificationKeyEvent{
+		VerificationFlow: v.flow(),
+		Key:              v.sas.PublicKey(),
+	})
+	if err != nil {
+		return fmt.Errorf("error sending key: %w", err)
+	}
+	return nil
+}
+
+func (v *Verification) handleKey(ctx context.Context, e *event.VerificationKeyEvent) error {
+	if v.state != VerificationStarted || v.theirKey != "" || (v.startedByUs && v.commitment == "") {
+		return v.cancel(ctx, event.VerificationCancelUnexpectedMessage, "unexpected key event")
+	}
+	if v.startedByUs && sasCommitment(e.Key, v.startContent) != v.commitment {
+		return v.cancel(ctx, event.VerificationCancelMismatchedCommit, "commitment does not match the key")
+	}
+	if err := v.sas.SetTheirKey(e.Key); err != nil {
+		return v.cancel(ctx, event.VerificationCancelInvalidMessage, "invalid key")
+	}
+	v.theirKey = e.Key
+
+	if !v.startedByUs {
+		err := v.send(ctx, event.TypeVerificationKey, event.VerificationKeyEvent{
+			VerificationFlow: v.flow(),
+			Key:              v.sas.PublicKey(),
+		})
+		if err != nil {
+			return fmt.Errorf("error sending key: %w", err)
+		}
+	}
+
+	b, err := v.sas.GenerateBytes(v.sasInfo(), sasBytes)
+	if err != nil {
+		return err
+	}
+	sas := newSAS(b, v.emoji)
+	v.state = VerificationKeysExchanged
+	if cb := v.m.VerificationCallbacks.OnSAS; cb != nil {
+		v.callbacks = append(v.callbacks, func() { cb(v, sas) })
+	}
+	return nil
+}
+
+// sasInfo returns the info used to generate the short authentication string.
+func (v *Verification) sasInfo() string {
+	ourUser, ourDevice, ourKey := string(v.m.Client.UserID), string(v.m.Client.DeviceID), v.sas.PublicKey()
+	theirUser, theirDevice, theirKey := string(v.OtherUser), string(v.OtherDevice), v.theirKey
+	if v.startedByUs {
+		return "MATRIX_KEY_VERIFICATION_SAS|" + ourUser + "|" + ourDevice + "|" + ourKey + "|" +
+			theirUser + "|" + theirDevice + "|" + theirKey + "|" + v.ID
+	}
+	return "MATRIX_KEY_VERIFICATION_SAS|" + theirUser + "|" + theirDevice + "|" + theirKey + "|" +
+		ourUser + "|" + ourDevice + "|" + ourKey + "|" + v.ID
+}
+
+func (v *Verification) handleMAC(ctx context.Context, e *event.VerificationMACEvent) error {
+	if (v.state != VerificationKeysExchanged && v.state != VerificationConfirmed) || v.theirMAC != nil {
+		return v.cancel(ctx, event.VerificationCancelUnexpectedMessage, "unexpected MAC event")
+	}
+	v.theirMAC = e
+	// The MAC is checked once the user has confirmed the short authentication string.
+	if v.state == VerificationConfirmed {
+		return v.verifyMAC(ctx)
+	}
+	return nil
+}
+
+// verifyMAC checks the MACs sent by the other device and marks their device as verified if they match.
+func (v *Verification) verifyMAC(ctx context.Context) error {
+	info := "MATRIX_KEY_VERIFICATION_MAC" + string(v.OtherUser) + string(v.OtherDevice) +
+		string(v.m.Client.UserID) + string(v.m.Client.DeviceID) + v.ID
+
+	keyIDs := make([]string, 0, len(v.theirMAC.MAC))
+	for keyID := range v.theirMAC.MAC {
+		keyIDs = append(keyIDs, keyID)
+	}
+	sort.Strings(keyIDs)
+	expected, err := v.sas.CalculateMAC(strings.Join(keyIDs, ","), info+"KEY_IDS")
+	if err != nil {
+		return err
+	}
+	if expected != v.theirMAC.Keys {
+		return v.cancel(ctx, event.VerificationCancelKeyMismatch, "MAC of the key IDs does not match")
+	}
+
+	devices, err := v.m.Devices(ctx, v.OtherUser)
+	if err != nil {
+		return fmt.Errorf("error querying devices: %w", err)
+	}
+	device, ok := devices[v.OtherDevice]
+	if !ok {
+		return v.cancel(ctx, event.VerificationCancelKeyMismatch, "unknown device")
+	}
+
+	identity, err := v.m.Store.LoadUserIdentity(v.OtherUser)
+	if err != nil {
+		return fmt.Errorf("error loading user identity: %w", err)
+	}
+
+	var deviceVerified, masterVerified bool
+	for _, keyID := range keyIDs {
+		var key string
+		switch {
+		case keyID == "ed25519:"+string(v.OtherDevice):
+			key = device.SigningKey
+		case identity != nil && keyID == "ed25519:"+identity.MasterKey():
+			key = identity.MasterKey()
+		default:
+			// Keys that are not known are skipped.
+			continue
+		}
+		expected, err := v.sas.CalculateMAC(key, info+keyID)
+		if err != nil {
+			return err
+		}
+		if expected != v.theirMAC.MAC[keyID] {
+			return v.cancel(ctx, event.VerificationCancelKeyMismatch, "MAC of "+keyID+" does not match")
+		}
+		if key == device.SigningKey {
+			deviceVerified = true
+		} else {
+			masterVerified = true
+		}
+	}
+	if !deviceVerified && !masterVerified {
+		return v.cancel(ctx, event.VerificationCancelKeyMismatch, "no key has been verified")
+	}
+
+	if deviceVerified {
+		if err := v.m.SetDeviceVerified(v.OtherUser, v.OtherDevice, true); err != nil {
+			return err
+		}
+	}
+	if masterVerified {
+		if err := v.m.setIdentityVerified(v.OtherUser, identity.MasterKey()); err != nil {
+			return err
+		}
+	}
+	v.crossSign(ctx, deviceVerified, masterVerified)
+	return v.sendDone(ctx)
+}
+
+// sendDone lets the other device know that it has been verified.
+func (v *Verification) sendDone(ctx context.Context) error {
+	v.verified = true
+	err := v.send(ctx, event.TypeVerificationDone, event.VerificationDoneEvent{
+		VerificationFlow: v.flow(),
+	})
+	if err != nil {
+		return fmt.Errorf("error sending done: %w", err)
+	}
+	v.finish()
+	return nil
+}
+
+// crossSign signs the verified keys with our cross-signing keys if they are available so that other devices
+// trust them too. Failures are only logged as the verification itself has succeeded.
+func (v *Verification) crossSign(ctx context.Context, deviceVerified, masterVerified bool) {
+	var err error
+	switch {
+	case v.OtherUser == v.m.Client.UserID && deviceVerified:
+		err = v.m.SignOwnDevice(ctx, v.OtherDevice)
+	case v.OtherUser != v.m.Client.UserID && masterVerified:
+		err = v.m.SignUser(ctx, v.OtherUser)
+	}
+	if err != nil && !errors.Is(err, ErrNoCrossSigningKeys) {
+		v.m.Client.Logger.Warn("error cross-signing verified keys", debug.Err(err), debug.UserID(v.OtherUser))
+	}
+}
+
+// finish marks the verification as done once both devices have verified each other.
+func (v *Verification) finish() {
+	if !v.verified || !v.doneReceived {
+		return
+	}
+	v.m.Client.Logger.Debug("verification done", debug.UserID(v.OtherUser),
+		debug.Any("device_id", v.OtherDevice), debug.Any("transaction_id", v.ID))
+	v.state = VerificationDone
+	v.m.removeVerification(v)
+	if cb := v.m.VerificationCallbacks.OnDone; cb != nil {
+		v.callbacks = append(v.callbacks, func() { cb(v) })
+	}
+}
+
+// flow returns the field identifying the verification in its events.
+func (v *Verification) flow() event.VerificationFlow {
+	if v.RoomID != "" {
+		return event.VerificationFlow{
+			RelatesTo: &event.VerificationRelatesTo{
+				RelType: "m.reference",
+				EventID: matrix.EventID(v.ID),
+			},
+		}
+	}
+	return event.VerificationFlow{TransactionID: v.ID}
+}
+
+// send sends an event of the verification to the other device. To-device events are sent to every device
+// the request has been sent to if no device has accepted it yet.
+func (v *Verification) send(ctx context.Context, eventType event.Type, content interface{}) error {
+	if v.RoomID != "" {
+		_, err := v.m.sendRoomEvent(ctx, v.RoomID, eventType, content)
+		return err
+	}
+
+	devices := v.requestedDevices
+	if v.OtherDevice != "" {
+		devices = []matrix.DeviceID{v.OtherDevice}
+	}
+	messages := api.DeviceMessages{v.OtherUser: make(map[matrix.DeviceID]interface{}, len(devices))}
+	for _, d := range devices {
+		messages[v.OtherUser][d] = content
+	}
+	return v.m.Client.SendToDeviceContext(ctx, eventType, messages)
+}
+
+// sasCommitment returns the commitment to the public key and the canonical JSON of the start event content.
+func sasCommitment(key string, startContent []byte) string {
+	hash := sha256.Sum256(append([]byte(key), startContent...))
+	return olm.EncodeBase64(hash[:])
+}
+
+// rawContent returns the canonical JSON of the content of the event as it was received.
+func rawContent(e event.Event) ([]byte, error) {
+	var raw struct {
+		Content json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(e.Info().Raw, &raw); err != nil {
+		return nil, err
+	}
+	return CanonicalJSON(raw.Content)
+}
+
+// readyMethods returns the methods in ours that can be used with a device supporting theirs. SAS has to be
+// supported by both devices while QR codes can be scanned if the other device can show them and shown if the
+// other device can scan them. m.reciprocate.v1 is added if QR codes can be used.
+func readyMethods(ours, theirs []string) []string {
+	var methods []string
+	usable := func(method string, required string) bool {
+		ok := containsString(ours, method) && containsString(theirs, required)
+		if ok {
+			methods = append(methods, method)
+		}
+		return ok
+	}
+	usable(VerificationMethodSAS, VerificationMethodSAS)
+	scan := usable(VerificationMethodQRScan, VerificationMethodQRShow)
+	show := usable(VerificationMethodQRShow, VerificationMethodQRScan)
+	if scan || show {
+		usable(VerificationMethodReciprocate, VerificationMethodReciprocate)
+	}
+	return methods
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsDevice(list []matrix.DeviceID, id matrix.DeviceID) bool {
+	for _, v := range list {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
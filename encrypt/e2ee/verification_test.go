@@ -0,0 +1,442 @@
+package e2ee
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/chanbakjsd/gotrix/api"
+	"github.com/chanbakjsd/gotrix/encrypt/olm"
+	"github.com/chanbakjsd/gotrix/event"
+	"github.com/chanbakjsd/gotrix/matrix"
+)
+
+// toDeviceMessage is a to-device event waiting to be delivered by a verificationNetwork.
+type toDeviceMessage struct {
+	Sender    matrix.UserID
+	Recipient matrix.UserID
+	Type      event.Type
+	Content   map[string]interface{}
+}
+
+// verificationNetwork relays the to-device events and answers the key queries of the machines of @alice and
+// @bob. Events are queued until deliver is called so that the machines can be driven step by step.
+type verificationNetwork struct {
+	t        *testing.T
+	machines map[matrix.UserID]*Machine
+	// tamper is called on every message before it is delivered. The message is dropped if it returns false.
+	tamper func(msg *toDeviceMessage) bool
+
+	mu    sync.Mutex
+	queue []toDeviceMessage
+
+	// requests, sas, cancels and done are recorded by the callbacks of the machines.
+	requests map[matrix.UserID]*Verification
+	sas      map[matrix.UserID]SAS
+	cancels  map[matrix.UserID]*VerificationCancelError
+	done     map[matrix.UserID]bool
+}
+
+func newVerificationNetwork(t *testing.T) *verificationNetwork {
+	n := &verificationNetwork{
+		t:        t,
+		machines: make(map[matrix.UserID]*Machine),
+		requests: make(map[matrix.UserID]*Verification),
+		sas:      make(map[matrix.UserID]SAS),
+		cancels:  make(map[matrix.UserID]*VerificationCancelError),
+		done:     make(map[matrix.UserID]bool),
+	}
+	for userID, deviceID := range map[matrix.UserID]matrix.DeviceID{
+		"@alice:example.org": "ALICE",
+		"@bob:example.org":   "BOB",
+	} {
+		userID := userID
+		m := newTestMachine(t, userID, deviceID, func(w http.ResponseWriter, r *http.Request) {
+			n.serve(userID, w, r)
+		})
+		m.VerificationCallbacks = VerificationCallbacks{
+			OnRequest: func(v *Verification) { n.requests[userID] = v },
+			OnSAS:     func(v *Verification, sas SAS) { n.sas[userID] = sas },
+			OnDone:    func(v *Verification) { n.done[userID] = true },
+			OnCancel: func(v *Verification, err *VerificationCancelError) {
+				n.cancels[userID] = err
+			},
+		}
+		n.machines[userID] = m
+	}
+	return n
+}
+
+func (n *verificationNetwork) alice() *Machine { return n.machines["@alice:example.org"] }
+func (n *verificationNetwork) bob() *Machine   { return n.machines["@bob:example.org"] }
+
+// serve handles the requests made by the machine of the sender.
+func (n *verificationNetwork) serve(sender matrix.UserID, w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.Contains(r.URL.Path, "/sendToDevice/"):
+		var body struct {
+			Messages map[matrix.UserID]map[matrix.DeviceID]map[string]interface{} `json:"messages"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		parts := strings.Split(r.URL.Path, "/")
+		n.mu.Lock()
+		for userID, devices := range body.Messages {
+			for deviceID, content := range devices {
+				if m := n.machines[userID]; m == nil || m.Client.DeviceID != deviceID {
+					continue
+				}
+				n.queue = append(n.queue, toDeviceMessage{
+					Sender:    sender,
+					Recipient: userID,
+					Type:      event.Type(parts[len(parts)-2]),
+					Content:   content,
+				})
+			}
+		}
+		n.mu.Unlock()
+		_, _ = w.Write([]byte("{}"))
+	case strings.HasSuffix(r.URL.Path, "/keys/query"):
+		var body struct {
+			DeviceKeys map[matrix.UserID][]matrix.DeviceID `json:"device_keys"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp := api.KeysQueryResponse{DeviceKeys: make(map[matrix.UserID]map[matrix.DeviceID]api.DeviceKeys)}
+		for userID := range body.DeviceKeys {
+			m := n.machines[userID]
+			if m == nil {
+				continue
+			}
+			keys, err := m.DeviceKeys()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			resp.DeviceKeys[userID] = map[matrix.DeviceID]api.DeviceKeys{m.Client.DeviceID: *keys}
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"errcode":"M_NOT_FOUND","error":"not found"}`))
+	}
+}
+
+// deliver delivers the queued events, including the ones sent while handling them, until none are left.
+func (n *verificationNetwork) deliver() {
+	n.t.Helper()
+	for {
+		n.mu.Lock()
+		if len(n.queue) == 0 {
+			n.mu.Unlock()
+			return
+		}
+		msg := n.queue[0]
+		n.queue = n.queue[1:]
+		n.mu.Unlock()
+
+		if n.tamper != nil && !n.tamper(&msg) {
+			continue
+		}
+		raw, err := json.Marshal(map[string]interface{}{
+			"type":    msg.Type,
+			"sender":  msg.Sender,
+			"content": msg.Content,
+		})
+		if err != nil {
+			n.t.Fatalf("error encoding event: %v", err)
+		}
+		e, err := event.Parse(raw)
+		if err != nil {
+			n.t.Fatalf("error parsing event: %v", err)
+		}
+		if err := n.machines[msg.Recipient].HandleEvent(context.Background(), e); err != nil {
+			n.t.Fatalf("error handling %s sent to %s: %v", msg.Type, msg.Recipient, err)
+		}
+	}
+}
+
+// exchangeKeys makes @alice request a verification with @bob and drives it until the short authentication
+// strings are available. The verifications of @alice and @bob are returned.
+func (n *verificationNetwork) exchangeKeys() (*Verification, *Verification) {
+	n.t.Helper()
+	ctx := context.Background()
+	v, err := n.alice().RequestVerification(ctx, n.bob().Client.UserID, []matrix.DeviceID{"BOB"})
+	if err != nil {
+		n.t.Fatalf("error requesting verification: %v", err)
+	}
+	n.deliver()
+	request := n.requests[n.bob().Client.UserID]
+	if request == nil || request.ID != v.ID || !request.Incoming || request.OtherDevice != "ALICE" {
+		n.t.Fatalf("expected request to be received by @bob, got %+v", request)
+	}
+	if err := request.Accept(ctx); err != nil {
+		n.t.Fatalf("error accepting request: %v", err)
+	}
+	// @alice starts SAS automatically as OnReady is not set.
+	n.deliver()
+
+	for _, v := range []*Verification{v, request} {
+		if state := v.State(); state != VerificationKeysExchanged {
+			n.t.Fatalf("expected keys to be exchanged, got state %d (%v)", state, v.Err())
+		}
+	}
+	return v, request
+}
+
+// deviceVerified returns true if the machine has verified the only device of the user.
+func deviceVerified(t *testing.T, m *Machine, userID matrix.UserID) bool {
+	t.Helper()
+	devices, err := m.Store.LoadDevices(userID)
+	if err != nil {
+		t.Fatalf("error loading devices: %v", err)
+	}
+	for _, device := range devices {
+		return device.Verified
+	}
+	return false
+}
+
+func TestVerificationSAS(t *testing.T) {
+	ctx := context.Background()
+	n := newVerificationNetwork(t)
+	alice, bob := n.exchangeKeys()
+
+	aliceSAS, bobSAS := n.sas[alice.m.Client.UserID], n.sas[bob.m.Client.UserID]
+	if !reflect.DeepEqual(aliceSAS, bobSAS) {
+		t.Fatalf("short authentication strings do not match: %v and %v", aliceSAS, bobSAS)
+	}
+	if len(aliceSAS.Emoji) != 7 {
+		t.Errorf("expected emoji to be agreed on, got %v", aliceSAS.Emoji)
+	}
+
+	// Either side may confirm first.
+	if err := bob.Confirm(ctx); err != nil {
+		t.Fatalf("error confirming on @bob: %v", err)
+	}
+	n.deliver()
+	if state := alice.State(); state != VerificationKeysExchanged {
+		t.Fatalf("expected @alice to wait for confirmation, got state %d", state)
+	}
+	if err := alice.Confirm(ctx); err != nil {
+		t.Fatalf("error confirming on @alice: %v", err)
+	}
+	n.deliver()
+
+	for _, v := range []*Verification{alice, bob} {
+		user := v.m.Client.UserID
+		if state := v.State(); state != VerificationDone {
+			t.Errorf("%s: expected verification to be done, got state %d (%v)", user, state, v.Err())
+		}
+		if !n.done[user] {
+			t.Errorf("%s: expected OnDone to be called", user)
+		}
+		if !deviceVerified(t, v.m, v.OtherUser) {
+			t.Errorf("%s: expected device of %s to be verified", user, v.OtherUser)
+		}
+		if v.m.Verification(v.OtherUser, v.ID) != nil {
+			t.Errorf("%s: expected verification to be removed once done", user)
+		}
+	}
+}
+
+func TestVerificationSimultaneousStart(t *testing.T) {
+	ctx := context.Background()
+	n := newVerificationNetwork(t)
+	// Leave starting to the test.
+	n.alice().VerificationCallbacks.OnReady = func(*Verification) {}
+
+	alice, err := n.alice().RequestVerification(ctx, n.bob().Client.UserID, []matrix.DeviceID{"BOB"})
+	if err != nil {
+		t.Fatalf("error requesting verification: %v", err)
+	}
+	n.deliver()
+	bob := n.requests[n.bob().Client.UserID]
+	if err := bob.Accept(ctx); err != nil {
+		t.Fatalf("error accepting request: %v", err)
+	}
+	n.deliver()
+
+	// Both start before receiving the start of the other.
+	if err := alice.StartSAS(ctx); err != nil {
+		t.Fatalf("error starting on @alice: %v", err)
+	}
+	if err := bob.StartSAS(ctx); err != nil {
+		t.Fatalf("error starting on @bob: %v", err)
+	}
+	n.deliver()
+
+	for _, v := range []*Verification{alice, bob} {
+		if state := v.State(); state != VerificationKeysExchanged {
+			t.Fatalf("%s: expected keys to be exchanged, got state %d (%v)", v.m.Client.UserID, state, v.Err())
+		}
+	}
+	// The start of @alice is kept as @alice has the lowest user ID.
+	if !alice.startedByUs || bob.startedByUs {
+		t.Errorf("expected start of @alice to win, got %t for @alice and %t for @bob",
+			alice.startedByUs, bob.startedByUs)
+	}
+	if !reflect.DeepEqual(n.sas[alice.m.Client.UserID], n.sas[bob.m.Client.UserID]) {
+		t.Errorf("short authentication strings do not match")
+	}
+}
+
+func TestVerificationCancel(t *testing.T) {
+	// otherKey returns a valid public key that is not the one of the SAS of either device.
+	otherKey := func(t *testing.T) string {
+		sas, err := olm.NewSAS()
+		if err != nil {
+			t.Fatalf("error creating SAS: %v", err)
+		}
+		return sas.PublicKey()
+	}
+
+	tests := []struct {
+		name string
+		// tamper modifies the messages sent by the network.
+		tamper func(t *testing.T, msg *toDeviceMessage) bool
+		// act is called once the keys have been exchanged, or right away if the keys are not exchanged.
+		act     func(ctx context.Context, alice, bob *Verification) error
+		noKeys  bool
+		code    event.VerificationCancelCode
+		byAlice bool
+	}{
+		{
+			name: "commitment mismatch",
+			tamper: func(t *testing.T, msg *toDeviceMessage) bool {
+				// The key of @bob no longer matches the commitment in the accept event of @bob.
+				if msg.Type == event.TypeVerificationKey && msg.Sender == "@bob:example.org" {
+					msg.Content["key"] = otherKey(t)
+				}
+				return true
+			},
+			noKeys:  true,
+			code:    event.VerificationCancelMismatchedCommit,
+			byAlice: true,
+		},
+		{
+			name: "SAS mismatch",
+			act: func(ctx context.Context, alice, bob *Verification) error {
+				return alice.Reject(ctx)
+			},
+			code:    event.VerificationCancelMismatchedSAS,
+			byAlice: true,
+		},
+		{
+			name: "cancelled by user",
+			act: func(ctx context.Context, alice, bob *Verification) error {
+				return bob.Cancel(ctx, event.VerificationCancelUser, "user cancelled")
+			},
+			code: event.VerificationCancelUser,
+		},
+		{
+			name: "key IDs MAC mismatch",
+			tamper: func(t *testing.T, msg *toDeviceMessage) bool {
+				if msg.Type == event.TypeVerificationMAC && msg.Sender == "@bob:example.org" {
+					msg.Content["keys"] = "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"
+				}
+				return true
+			},
+			act:     confirmBoth,
+			code:    event.VerificationCancelKeyMismatch,
+			byAlice: true,
+		},
+		{
+			name: "device key MAC mismatch",
+			tamper: func(t *testing.T, msg *toDeviceMessage) bool {
+				if msg.Type == event.TypeVerificationMAC && msg.Sender == "@bob:example.org" {
+					macs := msg.Content["mac"].(map[string]interface{})
+					macs["ed25519:BOB"] = "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"
+				}
+				return true
+			},
+			act:     confirmBoth,
+			code:    event.VerificationCancelKeyMismatch,
+			byAlice: true,
+		},
+		{
+			name: "unexpected message",
+			tamper: func(t *testing.T, msg *toDeviceMessage) bool {
+				// @bob sends a key again after the keys have been exchanged.
+				if msg.Type == event.TypeVerificationMAC && msg.Sender == "@bob:example.org" {
+					msg.Type = event.TypeVerificationKey
+					msg.Content["key"] = otherKey(t)
+				}
+				return true
+			},
+			act:     confirmBoth,
+			code:    event.VerificationCancelUnexpectedMessage,
+			byAlice: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			n := newVerificationNetwork(t)
+			if test.tamper != nil {
+				n.tamper = func(msg *toDeviceMessage) bool { return test.tamper(t, msg) }
+			}
+
+			var alice, bob *Verification
+			if test.noKeys {
+				var err error
+				alice, err = n.alice().RequestVerification(ctx, n.bob().Client.UserID, []matrix.DeviceID{"BOB"})
+				if err != nil {
+					t.Fatalf("error requesting verification: %v", err)
+				}
+				n.deliver()
+				bob = n.requests[n.bob().Client.UserID]
+				if err := bob.Accept(ctx); err != nil {
+					t.Fatalf("error accepting request: %v", err)
+				}
+				n.deliver()
+			} else {
+				alice, bob = n.exchangeKeys()
+			}
+			if test.act != nil {
+				if err := test.act(ctx, alice, bob); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				n.deliver()
+			}
+
+			for _, v := range []*Verification{alice, bob} {
+				user := v.m.Client.UserID
+				byUs := test.byAlice == (user == "@alice:example.org")
+				if state := v.State(); state != VerificationCancelled {
+					t.Errorf("%s: expected verification to be cancelled, got state %d", user, state)
+					continue
+				}
+				err := v.Err()
+				if err == nil || err.Code != test.code || err.ByUs != byUs {
+					t.Errorf("%s: expected cancellation with %s (by us: %t), got %+v", user, test.code, byUs, err)
+				}
+				if n.cancels[user] != err {
+					t.Errorf("%s: expected OnCancel to be called with %v, got %v", user, err, n.cancels[user])
+				}
+				if v.m.Verification(v.OtherUser, v.ID) != nil {
+					t.Errorf("%s: expected verification to be removed once cancelled", user)
+				}
+			}
+			if deviceVerified(t, n.alice(), "@bob:example.org") {
+				t.Errorf("expected device of @bob not to be verified")
+			}
+		})
+	}
+}
+
+// confirmBoth confirms the verification on both sides.
+func confirmBoth(ctx context.Context, alice, bob *Verification) error {
+	if err := bob.Confirm(ctx); err != nil {
+		return err
+	}
+	return alice.Confirm(ctx)
+}
@@ -1,9 +1,30 @@
 package encrypt
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+
 	"github.com/chanbakjsd/gotrix/matrix"
 )
 
+// Errors returned when decrypting a File.
+var (
+	// ErrUnsupportedFile is returned when the File uses an unsupported version, key or algorithm.
+	ErrUnsupportedFile = errors.New("unsupported encrypted file")
+	// ErrHashMismatch is returned when the ciphertext does not match the SHA-256 hash of the File.
+	// The decrypted content should be discarded if this is returned.
+	ErrHashMismatch = errors.New("encrypted file hash mismatch")
+)
+
 // File represents an encrypted file.
 type File struct {
 	URL        matrix.URL        `json:"url"`
@@ -13,4 +34,124 @@ type File struct {
 	Version    string            `json:"v"`
 }
 
-// TODO Add helper functions for encryption/decryption.
+// EncryptFile generates a new key and returns a reader that reads the encrypted content of r along with the
+// File describing how to decrypt it.
+//
+// The SHA-256 hash in the returned File is only filled in once the returned reader has been read until io.EOF.
+// The URL of the File should be set to the URL the encrypted content is uploaded to.
+func EncryptFile(r io.Reader) (io.Reader, *File, error) {
+	// The key is 256 bits. The first 64 bits of the IV are random while the 64-bit counter starts at 0.
+	var key [32]byte
+	var iv [aes.BlockSize]byte
+	if _, err := io.ReadFull(rand.Reader, key[:]); err != nil {
+		return nil, nil, fmt.Errorf("error generating key: %w", err)
+	}
+	if _, err := io.ReadFull(rand.Reader, iv[:8]); err != nil {
+		return nil, nil, fmt.Errorf("error generating IV: %w", err)
+	}
+
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	file := &File{
+		Key: JSONWebKey{
+			KeyType:       "oct",
+			KeyOperations: []string{"encrypt", "decrypt"},
+			Algorithm:     "A256CTR",
+			Key:           base64.RawURLEncoding.EncodeToString(key[:]),
+			Extractable:   true,
+		},
+		InitVector: base64.RawStdEncoding.EncodeToString(iv[:]),
+		Hashes:     make(map[string]string),
+		Version:    "v2",
+	}
+
+	return &encryptReader{
+		src:    r,
+		stream: cipher.NewCTR(block, iv[:]),
+		hash:   sha256.New(),
+		file:   file,
+	}, file, nil
+}
+
+// encryptReader encrypts the content of src and hashes the ciphertext.
+type encryptReader struct {
+	src    io.Reader
+	stream cipher.Stream
+	hash   hash.Hash
+	file   *File
+}
+
+func (e *encryptReader) Read(p []byte) (int, error) {
+	n, err := e.src.Read(p)
+	e.stream.XORKeyStream(p[:n], p[:n])
+	_, _ = e.hash.Write(p[:n])
+	if errors.Is(err, io.EOF) {
+		e.file.Hashes["sha256"] = base64.RawStdEncoding.EncodeToString(e.hash.Sum(nil))
+	}
+	return n, err
+}
+
+// DecryptFile returns a reader that reads the decrypted content of the encrypted content r.
+//
+// The ciphertext is checked against the SHA-256 hash of the File once r reaches io.EOF. If it does not match,
+// the reader returns ErrHashMismatch instead of io.EOF and everything read from it should be discarded.
+func DecryptFile(f *File, r io.Reader) (io.Reader, error) {
+	if f.Version != "v2" || f.Key.KeyType != "oct" || f.Key.Algorithm != "A256CTR" {
+		return nil, ErrUnsupportedFile
+	}
+
+	key, err := decodeBase64(f.Key.Key)
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("%w: invalid key", ErrUnsupportedFile)
+	}
+	iv, err := decodeBase64(f.InitVector)
+	if err != nil || len(iv) != aes.BlockSize {
+		return nil, fmt.Errorf("%w: invalid IV", ErrUnsupportedFile)
+	}
+	expectedHash, err := decodeBase64(f.Hashes["sha256"])
+	if err != nil || len(expectedHash) != sha256.Size {
+		return nil, fmt.Errorf("%w: missing SHA-256 hash", ErrUnsupportedFile)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &decryptReader{
+		src:          r,
+		stream:       cipher.NewCTR(block, iv),
+		hash:         sha256.New(),
+		expectedHash: expectedHash,
+	}, nil
+}
+
+// decryptReader hashes the ciphertext from src and decrypts it.
+type decryptReader struct {
+	src          io.Reader
+	stream       cipher.Stream
+	hash         hash.Hash
+	expectedHash []byte
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	n, err := d.src.Read(p)
+	_, _ = d.hash.Write(p[:n])
+	d.stream.XORKeyStream(p[:n], p[:n])
+	if errors.Is(err, io.EOF) && subtle.ConstantTimeCompare(d.hash.Sum(nil), d.expectedHash) != 1 {
+		return n, ErrHashMismatch
+	}
+	return n, err
+}
+
+// decodeBase64 decodes base64 in both the standard and the URL-safe alphabet, with or without padding.
+func decodeBase64(s string) ([]byte, error) {
+	s = strings.TrimRight(s, "=")
+	if strings.ContainsAny(s, "-_") {
+		return base64.RawURLEncoding.DecodeString(s)
+	}
+	return base64.RawStdEncoding.DecodeString(s)
+}
@@ -0,0 +1,160 @@
+package encrypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+// fileVectors are the test vectors of matrix-encrypt-attachment, the library used by Element.
+var fileVectors = []struct {
+	file       string
+	plaintext  string
+	ciphertext string
+}{
+	{
+		file: `{"v":"v2","key":{"alg":"A256CTR","k":"__________________________________________8",` +
+			`"key_ops":["encrypt","decrypt"],"kty":"oct"},"iv":"/////////////////////w",` +
+			`"hashes":{"sha256":"47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU"}}`,
+		plaintext:  "",
+		ciphertext: "",
+	},
+	{
+		file: `{"v":"v2","key":{"alg":"A256CTR","k":"__________________________________________8",` +
+			`"key_ops":["encrypt","decrypt"],"kty":"oct"},"iv":"//////////8AAAAAAAAAAA",` +
+			`"hashes":{"sha256":"YzF08lARDdOCzJpzuSwsjTNlQc4pHxpdHcXiD/wpK6k"}}`,
+		plaintext:  "SGVsbG8sIFdvcmxk",
+		ciphertext: "5xJZTt5cQicm+9f4",
+	},
+	{
+		file: `{"v":"v2","key":{"alg":"A256CTR","k":"__________________________________________8",` +
+			`"key_ops":["encrypt","decrypt"],"kty":"oct"},"iv":"/////////////////////w",` +
+			`"hashes":{"sha256":"/K4w3G4zlLK312k66KxNPKDkWCn2QAH5aphAkuncTrQ"}}`,
+		plaintext:  "YWxwaGFudW1lcmljYWxseWFscGhhbnVtZXJpY2FsbHlhbHBoYW51bWVyaWNhbGx5YWxwaGFudW1lcmljYWxseQ",
+		ciphertext: "tJVNBVJ/vl36UQt4Y5e5myqUL3M8OtjRVQljZ+LlwbJeucRIM7CeKDJGGOjlJ1bqpqUdl6zytXJ3dCyvnUi4eQ",
+	},
+}
+
+// decodeFileVector decodes the File and the base64 plaintext and ciphertext of the vector.
+func decodeFileVector(t *testing.T, i int) (*File, []byte, []byte) {
+	t.Helper()
+	v := fileVectors[i]
+	var f File
+	if err := json.Unmarshal([]byte(v.file), &f); err != nil {
+		t.Fatalf("vector %d: unexpected error decoding file: %v", i, err)
+	}
+	plaintext, err := base64.RawStdEncoding.DecodeString(v.plaintext)
+	if err != nil {
+		t.Fatalf("vector %d: unexpected error decoding plaintext: %v", i, err)
+	}
+	ciphertext, err := base64.RawStdEncoding.DecodeString(v.ciphertext)
+	if err != nil {
+		t.Fatalf("vector %d: unexpected error decoding ciphertext: %v", i, err)
+	}
+	return &f, plaintext, ciphertext
+}
+
+func TestDecryptFile(t *testing.T) {
+	for i := range fileVectors {
+		f, plaintext, ciphertext := decodeFileVector(t, i)
+		r, err := DecryptFile(f, bytes.NewReader(ciphertext))
+		if err != nil {
+			t.Errorf("vector %d: unexpected error: %v", i, err)
+			continue
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Errorf("vector %d: unexpected error decrypting: %v", i, err)
+			continue
+		}
+		if !bytes.Equal(got, plaintext) {
+			t.Errorf("vector %d: expected %q, got %q", i, plaintext, got)
+		}
+	}
+}
+
+func TestDecryptFileHashMismatch(t *testing.T) {
+	f, _, ciphertext := decodeFileVector(t, 2)
+	ciphertext[len(ciphertext)-1] ^= 1
+
+	r, err := DecryptFile(f, iotest.OneByteReader(bytes.NewReader(ciphertext)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The mismatch can only be detected once all of the ciphertext has been read.
+	buf := make([]byte, 1)
+	for i := range ciphertext {
+		if _, err := r.Read(buf); err != nil {
+			t.Fatalf("unexpected error reading byte %d: %v", i, err)
+		}
+	}
+	if _, err := r.Read(buf); !errors.Is(err, ErrHashMismatch) {
+		t.Errorf("expected ErrHashMismatch at the end of the file, got %v", err)
+	}
+}
+
+func TestDecryptFileUnsupported(t *testing.T) {
+	tests := map[string]func(f *File){
+		"version":   func(f *File) { f.Version = "v1" },
+		"algorithm": func(f *File) { f.Key.Algorithm = "A128CTR" },
+		"key":       func(f *File) { f.Key.Key = "AAAA" },
+		"iv":        func(f *File) { f.InitVector = "AAAA" },
+		"hash":      func(f *File) { delete(f.Hashes, "sha256") },
+	}
+	for name, modify := range tests {
+		f, _, ciphertext := decodeFileVector(t, 1)
+		modify(f)
+		if _, err := DecryptFile(f, bytes.NewReader(ciphertext)); !errors.Is(err, ErrUnsupportedFile) {
+			t.Errorf("%s: expected ErrUnsupportedFile, got %v", name, err)
+		}
+	}
+}
+
+func TestEncryptFileRoundTrip(t *testing.T) {
+	plaintext := make([]byte, 100000)
+	if _, err := io.ReadFull(rand.Reader, plaintext); err != nil {
+		t.Fatalf("unexpected error generating plaintext: %v", err)
+	}
+
+	r, f, err := EncryptFile(bytes.NewReader(plaintext))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := f.Hashes["sha256"]; ok {
+		t.Error("expected hash to be set only once the content has been read")
+	}
+	ciphertext, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("unexpected error encrypting: %v", err)
+	}
+	if bytes.Equal(ciphertext, plaintext) {
+		t.Error("expected ciphertext to differ from plaintext")
+	}
+
+	// The File has to survive being sent in an event.
+	raw, err := json.Marshal(f)
+	if err != nil {
+		t.Fatalf("unexpected error encoding file: %v", err)
+	}
+	var decoded File
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		t.Fatalf("unexpected error decoding file: %v", err)
+	}
+
+	dr, err := DecryptFile(&decoded, bytes.NewReader(ciphertext))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	decrypted, err := io.ReadAll(dr)
+	if err != nil {
+		t.Fatalf("unexpected error decrypting: %v", err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Error("decrypted content does not match plaintext")
+	}
+}
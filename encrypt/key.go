@@ -3,7 +3,8 @@ package encrypt
 // JSONWebKey represents a JSON web key.
 type JSONWebKey struct {
 	KeyType       string   `json:"kty"`     // Type of key. Must be "oct".
-	KeyOperations []string `json:"key_ops"` // Key operations.
-	Algorithm     string   `json:"alg"`     // URLSafe unpadded base64 key.
+	KeyOperations []string `json:"key_ops"` // Key operations. Must at least contain "encrypt" and "decrypt".
+	Algorithm     string   `json:"alg"`     // Algorithm of the key. Must be "A256CTR".
+	Key           string   `json:"k"`       // URLSafe unpadded base64 key.
 	Extractable   bool     `json:"ext"`     // Must be true.
 }
@@ -0,0 +1,222 @@
+package olm
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+)
+
+// ErrUnknownOneTimeKey is returned by NewInboundSession when the pre-key message uses a one-time key that the
+// account does not have.
+var ErrUnknownOneTimeKey = errors.New("olm: unknown one-time key")
+
+// maxOneTimeKeys is the maximum number of one-time keys an account keeps, the same as libolm.
+const maxOneTimeKeys = 100
+
+// Account is an Olm account. It holds the identity keys of a device and its one-time keys.
+// It is not safe for concurrent use.
+type Account struct {
+	s accountState
+}
+
+type accountState struct {
+	IdentityKey curveKeyPair `json:"identity_key"`
+	SigningKey  []byte       `json:"signing_key"` // Ed25519 seed.
+
+	OneTimeKeys         []oneTimeKey `json:"one_time_keys"`
+	FallbackKey         *oneTimeKey  `json:"fallback_key,omitempty"`
+	PreviousFallbackKey *oneTimeKey  `json:"previous_fallback_key,omitempty"`
+	NextKeyID           uint32       `json:"next_key_id"`
+}
+
+// oneTimeKey is a one-time or fallback key of an account.
+type oneTimeKey struct {
+	ID        uint32       `json:"id"`
+	Key       curveKeyPair `json:"key"`
+	Published bool         `json:"published"`
+}
+
+// keyID returns the key ID of the one-time key, which is its ID encoded in base64.
+func (k oneTimeKey) keyID() string {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], k.ID)
+	return EncodeBase64(b[:])
+}
+
+// NewAccount creates a new account with newly generated identity keys.
+func NewAccount() (*Account, error) {
+	identity, err := newCurveKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	_, signing, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Account{
+		s: accountState{
+			IdentityKey: identity,
+			SigningKey:  signing.Seed(),
+			NextKeyID:   1,
+		},
+	}, nil
+}
+
+// UnpickleAccount decrypts an account pickled with Account.Pickle.
+func UnpickleAccount(pickled string, key []byte) (*Account, error) {
+	var a Account
+	if err := unpickle(pickled, key, &a.s); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// Pickle encrypts the account with the key so that it can be stored.
+func (a *Account) Pickle(key []byte) (string, error) {
+	return pickle(a.s, key)
+}
+
+// IdentityKeys returns the Curve25519 and Ed25519 public identity keys of the account in base64.
+func (a *Account) IdentityKeys() (curve25519 string, ed25519 string) {
+	return EncodeBase64(a.s.IdentityKey.Public), EncodeBase64(a.signingKey().Public().(ed25519PublicKey))
+}
+
+// Sign signs the message with the Ed25519 identity key of the account and returns the signature in base64.
+func (a *Account) Sign(message []byte) string {
+	return EncodeBase64(ed25519.Sign(a.signingKey(), message))
+}
+
+func (a *Account) signingKey() ed25519.PrivateKey {
+	return ed25519.NewKeyFromSeed(a.s.SigningKey)
+}
+
+// MaxNumberOfOneTimeKeys returns the maximum number of one-time keys the account can hold.
+// Clients are expected to keep about half of it uploaded to the homeserver.
+func (a *Account) MaxNumberOfOneTimeKeys() int {
+	return maxOneTimeKeys
+}
+
+// GenerateOneTimeKeys generates n new one-time keys. The oldest keys are dropped if the account has more than
+// MaxNumberOfOneTimeKeys keys afterwards.
+func (a *Account) GenerateOneTimeKeys(n int) error {
+	for i := 0; i < n; i++ {
+		key, err := newCurveKeyPair()
+		if err != nil {
+			return err
+		}
+		a.s.OneTimeKeys = append(a.s.OneTimeKeys, oneTimeKey{
+			ID:  a.s.NextKeyID,
+			Key: key,
+		})
+		a.s.NextKeyID++
+	}
+	if len(a.s.OneTimeKeys) > maxOneTimeKeys {
+		a.s.OneTimeKeys = a.s.OneTimeKeys[len(a.s.OneTimeKeys)-maxOneTimeKeys:]
+	}
+	return nil
+}
+
+// OneTimeKeys returns the one-time keys that have not been published yet as a map from key ID to the
+// Curve25519 public key in base64.
+func (a *Account) OneTimeKeys() map[string]string {
+	keys := make(map[string]string)
+	for _, v := range a.s.OneTimeKeys {
+		if !v.Published {
+			keys[v.keyID()] = EncodeBase64(v.Key.Public)
+		}
+	}
+	return keys
+}
+
+// GenerateFallbackKey generates a new fallback key. The previous fallback key is kept until
+// ForgetOldFallbackKey is called so that messages already encrypted with it can be decrypted.
+func (a *Account) GenerateFallbackKey() error {
+	key, err := newCurveKeyPair()
+	if err != nil {
+		return err
+	}
+	a.s.PreviousFallbackKey = a.s.FallbackKey
+	a.s.FallbackKey = &oneTimeKey{
+		ID:  a.s.NextKeyID,
+		Key: key,
+	}
+	a.s.NextKeyID++
+	return nil
+}
+
+// FallbackKey returns the fallback key if it has not been published yet as a map from key ID to the
+// Curve25519 public key in base64.
+func (a *Account) FallbackKey() map[string]string {
+	keys := make(map[string]string)
+	if a.s.FallbackKey != nil && !a.s.FallbackKey.Published {
+		keys[a.s.FallbackKey.keyID()] = EncodeBase64(a.s.FallbackKey.Key.Public)
+	}
+	return keys
+}
+
+// ForgetOldFallbackKey forgets the previous fallback key.
+func (a *Account) ForgetOldFallbackKey() {
+	a.s.PreviousFallbackKey = nil
+}
+
+// MarkKeysAsPublished marks the current one-time keys and fallback key as published so that they are no longer
+// returned by OneTimeKeys and FallbackKey.
+func (a *Account) MarkKeysAsPublished() {
+	for k := range a.s.OneTimeKeys {
+		a.s.OneTimeKeys[k].Published = true
+	}
+	if a.s.FallbackKey != nil {
+		a.s.FallbackKey.Published = true
+	}
+}
+
+// RemoveOneTimeKeys removes the one-time key used by the inbound session so that it cannot be used again.
+// Fallback keys are not removed.
+func (a *Account) RemoveOneTimeKeys(s *Session) {
+	for k, v := range a.s.OneTimeKeys {
+		if bytes.Equal(v.Key.Public, s.s.BobOneTimeKey) {
+			a.s.OneTimeKeys = append(a.s.OneTimeKeys[:k], a.s.OneTimeKeys[k+1:]...)
+			return
+		}
+	}
+}
+
+// findKey returns the private key of the one-time or fallback key with the provided public key.
+func (a *Account) findKey(public []byte) (curveKeyPair, bool) {
+	for _, v := range a.s.OneTimeKeys {
+		if bytes.Equal(v.Key.Public, public) {
+			return v.Key, true
+		}
+	}
+	for _, v := range []*oneTimeKey{a.s.FallbackKey, a.s.PreviousFallbackKey} {
+		if v != nil && bytes.Equal(v.Key.Public, public) {
+			return v.Key, true
+		}
+	}
+	return curveKeyPair{}, false
+}
+
+// ed25519PublicKey is an alias to keep IdentityKeys short.
+type ed25519PublicKey = ed25519.PublicKey
+
+// VerifySignature checks if the signature (in base64) of the message was made by the Ed25519 key (in base64).
+func VerifySignature(key string, message []byte, signature string) error {
+	pub, err := DecodeBase64(key)
+	if err != nil {
+		return err
+	}
+	if len(pub) != ed25519.PublicKeySize {
+		return ErrBadKey
+	}
+	sig, err := DecodeBase64(signature)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(pub, message, sig) {
+		return ErrBadSignature
+	}
+	return nil
+}
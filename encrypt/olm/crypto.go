@@ -0,0 +1,286 @@
+// Package olm is a pure Go implementation of the Olm and Megolm cryptographic ratchets and the related
+// primitives used by Matrix end-to-end encryption.
+//
+// The wire formats of the messages and keys are compatible with libolm. Pickles are not: they can only be
+// read back by this package.
+package olm
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strings"
+)
+
+// Errors returned when decoding or decrypting messages.
+var (
+	// ErrBadMessageFormat is returned when the message cannot be decoded.
+	ErrBadMessageFormat = errors.New("olm: bad message format")
+	// ErrBadMessageVersion is returned when the message version is not supported.
+	ErrBadMessageVersion = errors.New("olm: bad message version")
+	// ErrBadMessageMAC is returned when the MAC of the message does not match.
+	ErrBadMessageMAC = errors.New("olm: bad message MAC")
+	// ErrBadSignature is returned when a signature does not match.
+	ErrBadSignature = errors.New("olm: bad signature")
+	// ErrBadBase64 is returned when the input is not valid base64.
+	ErrBadBase64 = errors.New("olm: bad base64")
+	// ErrBadKey is returned when a key is not the right size.
+	ErrBadKey = errors.New("olm: bad key")
+)
+
+// Key derivation infos used by Olm and Megolm.
+var (
+	infoRoot       = []byte("OLM_ROOT")
+	infoRatchet    = []byte("OLM_RATCHET")
+	infoOlmKeys    = []byte("OLM_KEYS")
+	infoMegolmKeys = []byte("MEGOLM_KEYS")
+	infoPickle     = []byte("Pickle")
+)
+
+// macLength is the length of the truncated MAC appended to messages.
+const macLength = 8
+
+// Curve25519KeyLength is the length of a Curve25519 public key in bytes.
+const Curve25519KeyLength = 32
+
+// HKDF derives length bytes from secret using HKDF-SHA-256 (RFC 5869).
+func HKDF(secret, salt, info []byte, length int) []byte {
+	// HMAC pads the key with zeroes so an empty salt is the same as a salt of zeroes, as specified.
+	extract := hmac.New(sha256.New, salt)
+	_, _ = extract.Write(secret)
+	prk := extract.Sum(nil)
+
+	out := make([]byte, 0, length+sha256.Size)
+	var prev []byte
+	for i := byte(1); len(out) < length; i++ {
+		expand := hmac.New(sha256.New, prk)
+		_, _ = expand.Write(prev)
+		_, _ = expand.Write(info)
+		_, _ = expand.Write([]byte{i})
+		prev = expand.Sum(nil)
+		out = append(out, prev...)
+	}
+	return out[:length]
+}
+
+// hmacSHA256 returns HMAC-SHA-256 of the input.
+func hmacSHA256(key []byte, input ...[]byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	for _, v := range input {
+		_, _ = mac.Write(v)
+	}
+	return mac.Sum(nil)
+}
+
+// EncodeBase64 encodes b into unpadded standard base64 as used by Matrix.
+func EncodeBase64(b []byte) string {
+	return base64.RawStdEncoding.EncodeToString(b)
+}
+
+// DecodeBase64 decodes unpadded or padded standard base64.
+func DecodeBase64(s string) ([]byte, error) {
+	b, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, ErrBadBase64
+	}
+	return b, nil
+}
+
+// cipherKeys are the keys derived from a message key for the aes_sha_256 cipher of libolm.
+type cipherKeys struct {
+	aesKey []byte
+	macKey []byte
+	iv     []byte
+}
+
+// deriveCipherKeys derives the AES key, MAC key and IV from the key.
+func deriveCipherKeys(key, info []byte) cipherKeys {
+	derived := HKDF(key, nil, info, 32+32+aes.BlockSize)
+	return cipherKeys{
+		aesKey: derived[:32],
+		macKey: derived[32:64],
+		iv:     derived[64:],
+	}
+}
+
+// encrypt encrypts the plaintext using AES-256-CBC with PKCS#7 padding.
+func (k cipherKeys) encrypt(plaintext []byte) []byte {
+	block, _ := aes.NewCipher(k.aesKey)
+	padding := aes.BlockSize - len(plaintext)%aes.BlockSize
+	padded := make([]byte, len(plaintext)+padding)
+	copy(padded, plaintext)
+	for i := len(plaintext); i < len(padded); i++ {
+		padded[i] = byte(padding)
+	}
+	cipher.NewCBCEncrypter(block, k.iv).CryptBlocks(padded, padded)
+	return padded
+}
+
+// decrypt decrypts ciphertext encrypted with encrypt.
+func (k cipherKeys) decrypt(ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, ErrBadMessageFormat
+	}
+	block, _ := aes.NewCipher(k.aesKey)
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, k.iv).CryptBlocks(plaintext, ciphertext)
+
+	padding := int(plaintext[len(plaintext)-1])
+	if padding == 0 || padding > aes.BlockSize || padding > len(plaintext) {
+		return nil, ErrBadMessageFormat
+	}
+	for _, v := range plaintext[len(plaintext)-padding:] {
+		if int(v) != padding {
+			return nil, ErrBadMessageFormat
+		}
+	}
+	return plaintext[:len(plaintext)-padding], nil
+}
+
+// mac returns the truncated MAC of the input.
+func (k cipherKeys) mac(input []byte) []byte {
+	return hmacSHA256(k.macKey, input)[:macLength]
+}
+
+// verifyMAC checks if the MAC of the input matches.
+func (k cipherKeys) verifyMAC(input, mac []byte) bool {
+	return hmac.Equal(k.mac(input), mac)
+}
+
+// curveKeyPair is a Curve25519 key pair.
+type curveKeyPair struct {
+	Private []byte `json:"private"`
+	Public  []byte `json:"public"`
+}
+
+// newCurveKeyPair generates a new Curve25519 key pair.
+func newCurveKeyPair() (curveKeyPair, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return curveKeyPair{}, err
+	}
+	return curveKeyPair{
+		Private: key.Bytes(),
+		Public:  key.PublicKey().Bytes(),
+	}, nil
+}
+
+// sharedSecret computes the X25519 shared secret between the key pair and the public key.
+func (k curveKeyPair) sharedSecret(public []byte) ([]byte, error) {
+	return sharedSecret(k.Private, public)
+}
+
+// sharedSecret computes the X25519 shared secret between the private key and the public key.
+func sharedSecret(private, public []byte) ([]byte, error) {
+	priv, err := ecdh.X25519().NewPrivateKey(private)
+	if err != nil {
+		return nil, ErrBadKey
+	}
+	pub, err := ecdh.X25519().NewPublicKey(public)
+	if err != nil {
+		return nil, ErrBadKey
+	}
+	return priv.ECDH(pub)
+}
+
+// decodeCurveKey decodes a base64 Curve25519 public key.
+func decodeCurveKey(s string) ([]byte, error) {
+	b, err := DecodeBase64(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != Curve25519KeyLength {
+		return nil, ErrBadKey
+	}
+	return b, nil
+}
+
+// randomBytes returns n random bytes.
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Messages are encoded in a protobuf-like format where each field is a tag followed by either a varint or a
+// length-prefixed byte string.
+
+// messageWriter writes the fields of a message.
+type messageWriter struct {
+	bytes.Buffer
+}
+
+func (w *messageWriter) writeVarint(v uint64) {
+	for v >= 0x80 {
+		w.WriteByte(byte(v) | 0x80)
+		v >>= 7
+	}
+	w.WriteByte(byte(v))
+}
+
+func (w *messageWriter) writeIntField(tag byte, v uint64) {
+	w.WriteByte(tag)
+	w.writeVarint(v)
+}
+
+func (w *messageWriter) writeBytesField(tag byte, b []byte) {
+	w.WriteByte(tag)
+	w.writeVarint(uint64(len(b)))
+	w.Write(b)
+}
+
+// readFields decodes the fields of a message, calling onInt or onBytes for each field.
+// Unknown fields are skipped.
+func readFields(b []byte, onInt func(tag byte, v uint64), onBytes func(tag byte, v []byte)) error {
+	for len(b) > 0 {
+		tag := b[0]
+		b = b[1:]
+
+		v, n := readVarint(b)
+		if n == 0 {
+			return ErrBadMessageFormat
+		}
+		b = b[n:]
+
+		// The lowest 3 bits of the tag are the wire type.
+		switch tag & 0x7 {
+		case 0:
+			onInt(tag, v)
+		case 2:
+			if v > uint64(len(b)) {
+				return ErrBadMessageFormat
+			}
+			onBytes(tag, b[:v])
+			b = b[v:]
+		default:
+			return ErrBadMessageFormat
+		}
+	}
+	return nil
+}
+
+// readVarint decodes a varint, returning the value and the number of bytes read. n is 0 if the varint is
+// invalid.
+func readVarint(b []byte) (v uint64, n int) {
+	var shift uint
+	for i, c := range b {
+		if i == 10 {
+			return 0, 0
+		}
+		v |= uint64(c&0x7f) << shift
+		if c < 0x80 {
+			return v, i + 1
+		}
+		shift += 7
+	}
+	return 0, 0
+}
@@ -0,0 +1,109 @@
+package olm
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+// Curve25519 key pairs from RFC 7748, section 6.1.
+var (
+	alicePrivate = mustDecodeHex("77076d0a7318a57d3c16c17251b26645df4c2f87ebc0992ab177fba51db92c2a")
+	alicePublic  = mustDecodeHex("8520f0098930a754748b7ddcb43ef75a0dbf3a0d26381af4eba4a98eaa9b4e6a")
+	bobPrivate   = mustDecodeHex("5dab087e624a8a4b79e17f8b83800ee66f3bb1292618b6fd1c2f8b27ff88e0eb")
+	bobPublic    = mustDecodeHex("de9edb7d7b7dc1b4d35b61c2ece435373f8343c85b78674dadfc7e146f882b4f")
+)
+
+// ed25519Seed is the secret key of test 1 from RFC 8032, section 7.1.
+var ed25519Seed = mustDecodeHex("9d61b19deffd5a60ba844af492ec2cc44449c5697b326919703bac031cae7f60")
+
+func mustDecodeHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// sequence returns n bytes counting up from start.
+func sequence(start byte, n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = start + byte(i)
+	}
+	return b
+}
+
+// testCurveKeyPair returns the Curve25519 key pair of the private key.
+func testCurveKeyPair(t *testing.T, private []byte) curveKeyPair {
+	t.Helper()
+	key, err := ecdh.X25519().NewPrivateKey(private)
+	if err != nil {
+		t.Fatalf("unexpected error creating key: %v", err)
+	}
+	return curveKeyPair{Private: key.Bytes(), Public: key.PublicKey().Bytes()}
+}
+
+func TestHKDF(t *testing.T) {
+	// Test cases 1 and 3 from RFC 5869, appendix A.
+	tests := []struct {
+		secret, salt, info []byte
+		expected           string
+	}{
+		{
+			secret: bytes.Repeat([]byte{0x0b}, 22),
+			salt:   sequence(0x00, 13),
+			info:   sequence(0xf0, 10),
+			expected: "3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf" +
+				"34007208d5b887185865",
+		},
+		{
+			secret: bytes.Repeat([]byte{0x0b}, 22),
+			expected: "8da4e775a563c18f715f802a063c5a31b8a11f5c5ee1879ec3454e5f3c738d2d" +
+				"9d201395faa4b61a96c8",
+		},
+	}
+	for i, test := range tests {
+		got := HKDF(test.secret, test.salt, test.info, 42)
+		if hex.EncodeToString(got) != test.expected {
+			t.Errorf("case %d: unexpected output\nexpected: %s\ngot: %x", i, test.expected, got)
+		}
+	}
+}
+
+func TestSharedSecret(t *testing.T) {
+	// Shared secret from RFC 7748, section 6.1.
+	expected := mustDecodeHex("4a5d9d5ba4ce2de1728e3bf480350f25e07e21c947d19e3376f09b3c1e161742")
+	alice := testCurveKeyPair(t, alicePrivate)
+	if !bytes.Equal(alice.Public, alicePublic) {
+		t.Errorf("unexpected public key\nexpected: %x\ngot: %x", alicePublic, alice.Public)
+	}
+	secret, err := alice.sharedSecret(bobPublic)
+	if err != nil {
+		t.Fatalf("unexpected error computing shared secret: %v", err)
+	}
+	if !bytes.Equal(secret, expected) {
+		t.Errorf("unexpected shared secret\nexpected: %x\ngot: %x", expected, secret)
+	}
+}
+
+func TestBase64(t *testing.T) {
+	for _, s := range []string{"aGVsbG8", "aGVsbG8="} {
+		b, err := DecodeBase64(s)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", s, err)
+			continue
+		}
+		if string(b) != "hello" {
+			t.Errorf("%s: expected hello, got %q", s, b)
+		}
+	}
+	if _, err := DecodeBase64("aGVs*G8"); !errors.Is(err, ErrBadBase64) {
+		t.Errorf("expected ErrBadBase64, got %v", err)
+	}
+	if got := EncodeBase64([]byte("hello")); got != "aGVsbG8" {
+		t.Errorf("expected unpadded base64, got %s", got)
+	}
+}
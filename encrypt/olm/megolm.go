@@ -0,0 +1,357 @@
+package olm
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+)
+
+// Errors returned by Megolm sessions.
+var (
+	// ErrUnknownMessageIndex is returned when the message was encrypted before the first index known to the
+	// inbound session.
+	ErrUnknownMessageIndex = errors.New("olm: unknown message index")
+	// ErrBadSessionKey is returned when a session key or an exported session cannot be decoded.
+	ErrBadSessionKey = errors.New("olm: bad session key")
+)
+
+const (
+	megolmRatchetParts      = 4
+	megolmRatchetPartLength = 32
+	megolmRatchetLength     = megolmRatchetParts * megolmRatchetPartLength
+
+	sessionKeyVersion    = 2
+	sessionExportVersion = 1
+)
+
+// megolmRatchet is the hash ratchet of Megolm.
+type megolmRatchet struct {
+	Data    []byte `json:"data"`
+	Counter uint32 `json:"counter"`
+}
+
+// rehash replaces part to of the ratchet with a hash of part from.
+func (r *megolmRatchet) rehash(from, to int) {
+	part := r.Data[from*megolmRatchetPartLength : (from+1)*megolmRatchetPartLength]
+	copy(r.Data[to*megolmRatchetPartLength:], hmacSHA256(part, []byte{byte(to)}))
+}
+
+// advance advances the ratchet by one step.
+func (r *megolmRatchet) advance() {
+	r.Counter++
+
+	// Find the highest part that has to be rehashed.
+	h := 0
+	mask := uint32(0x00FFFFFF)
+	for h < megolmRatchetParts && r.Counter&mask != 0 {
+		h++
+		mask >>= 8
+	}
+	for i := megolmRatchetParts - 1; i >= h; i-- {
+		r.rehash(h, i)
+	}
+}
+
+// advanceTo advances the ratchet to the provided index, which may be smaller than the current counter
+// if the counter would wrap around.
+func (r *megolmRatchet) advanceTo(index uint32) {
+	for j := 0; j < megolmRatchetParts; j++ {
+		shift := uint(megolmRatchetParts-j-1) * 8
+		mask := ^uint32(0) << shift
+
+		steps := ((index >> shift) - (r.Counter >> shift)) & 0xFF
+		if steps == 0 {
+			if index >= r.Counter {
+				continue
+			}
+			steps = 0x100
+		}
+
+		// All but the last step only have to rehash part j itself.
+		for ; steps > 1; steps-- {
+			r.rehash(j, j)
+		}
+		for k := megolmRatchetParts - 1; k >= j; k-- {
+			r.rehash(j, k)
+		}
+		r.Counter = index & mask
+	}
+}
+
+// clone returns a deep copy of the ratchet.
+func (r megolmRatchet) clone() megolmRatchet {
+	r.Data = append([]byte(nil), r.Data...)
+	return r
+}
+
+// OutboundGroupSession is an outbound Megolm session used to encrypt messages sent to a room.
+// It is not safe for concurrent use.
+type OutboundGroupSession struct {
+	s outboundGroupState
+}
+
+type outboundGroupState struct {
+	Ratchet    megolmRatchet `json:"ratchet"`
+	SigningKey []byte        `json:"signing_key"` // Ed25519 seed.
+}
+
+// NewOutboundGroupSession creates a new outbound Megolm session with random keys.
+func NewOutboundGroupSession() (*OutboundGroupSession, error) {
+	data, err := randomBytes(megolmRatchetLength)
+	if err != nil {
+		return nil, err
+	}
+	_, signing, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &OutboundGroupSession{
+		s: outboundGroupState{
+			Ratchet:    megolmRatchet{Data: data},
+			SigningKey: signing.Seed(),
+		},
+	}, nil
+}
+
+// UnpickleOutboundGroupSession decrypts a session pickled with OutboundGroupSession.Pickle.
+func UnpickleOutboundGroupSession(pickled string, key []byte) (*OutboundGroupSession, error) {
+	var s OutboundGroupSession
+	if err := unpickle(pickled, key, &s.s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Pickle encrypts the session with the key so that it can be stored.
+func (s *OutboundGroupSession) Pickle(key []byte) (string, error) {
+	return pickle(s.s, key)
+}
+
+func (s *OutboundGroupSession) signingKey() ed25519.PrivateKey {
+	return ed25519.NewKeyFromSeed(s.s.SigningKey)
+}
+
+// ID returns the ID of the session, which is its Ed25519 public key in base64.
+func (s *OutboundGroupSession) ID() string {
+	return EncodeBase64(s.signingKey().Public().(ed25519PublicKey))
+}
+
+// MessageIndex returns the index that will be used for the next message.
+func (s *OutboundGroupSession) MessageIndex() uint32 {
+	return s.s.Ratchet.Counter
+}
+
+// SessionKey returns the key (in base64) that has to be shared with other devices for them to decrypt messages
+// starting from the current message index.
+func (s *OutboundGroupSession) SessionKey() string {
+	b := make([]byte, 0, 1+4+megolmRatchetLength+ed25519.PublicKeySize+ed25519.SignatureSize)
+	b = append(b, sessionKeyVersion)
+	b = binary.BigEndian.AppendUint32(b, s.s.Ratchet.Counter)
+	b = append(b, s.s.Ratchet.Data...)
+	b = append(b, s.signingKey().Public().(ed25519PublicKey)...)
+	b = append(b, ed25519.Sign(s.signingKey(), b)...)
+	return EncodeBase64(b)
+}
+
+// Encrypt encrypts the plaintext and returns the message in base64.
+func (s *OutboundGroupSession) Encrypt(plaintext []byte) string {
+	keys := deriveCipherKeys(s.s.Ratchet.Data, infoMegolmKeys)
+
+	var w messageWriter
+	w.WriteByte(messageVersion)
+	w.writeIntField(0x08, uint64(s.s.Ratchet.Counter))
+	w.writeBytesField(0x12, keys.encrypt(plaintext))
+	_, _ = w.Write(keys.mac(w.Bytes()))
+	_, _ = w.Write(ed25519.Sign(s.signingKey(), w.Bytes()))
+
+	s.s.Ratchet.advance()
+	return EncodeBase64(w.Bytes())
+}
+
+// InboundGroupSession is an inbound Megolm session used to decrypt messages from a device in a room.
+// It is not safe for concurrent use.
+type InboundGroupSession struct {
+	s inboundGroupState
+}
+
+type inboundGroupState struct {
+	InitialRatchet megolmRatchet `json:"initial_ratchet"`
+	LatestRatchet  megolmRatchet `json:"latest_ratchet"`
+	SigningKey     []byte        `json:"signing_key"` // Ed25519 public key.
+	// SigningKeyVerified is true if the session was created from a session key signed by the signing key
+	// instead of an export.
+	SigningKeyVerified bool `json:"signing_key_verified"`
+}
+
+// NewInboundGroupSession creates an inbound session from a session key (in base64) created with
+// OutboundGroupSession.SessionKey.
+func NewInboundGroupSession(sessionKey string) (*InboundGroupSession, error) {
+	b, err := DecodeBase64(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	const length = 1 + 4 + megolmRatchetLength + ed25519.PublicKeySize + ed25519.SignatureSize
+	if len(b) != length || b[0] != sessionKeyVersion {
+		return nil, ErrBadSessionKey
+	}
+
+	signed, signature := b[:length-ed25519.SignatureSize], b[length-ed25519.SignatureSize:]
+	publicKey := b[1+4+megolmRatchetLength : length-ed25519.SignatureSize]
+	if !ed25519.Verify(publicKey, signed, signature) {
+		return nil, ErrBadSignature
+	}
+	return newInboundGroupSession(b[1:length-ed25519.SignatureSize], true), nil
+}
+
+// ImportInboundGroupSession creates an inbound session from a session (in base64) exported with
+// InboundGroupSession.Export.
+func ImportInboundGroupSession(exported string) (*InboundGroupSession, error) {
+	b, err := DecodeBase64(exported)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != 1+4+megolmRatchetLength+ed25519.PublicKeySize || b[0] != sessionExportVersion {
+		return nil, ErrBadSessionKey
+	}
+	return newInboundGroupSession(b[1:], false), nil
+}
+
+// newInboundGroupSession creates an inbound session from the counter, ratchet and public key.
+func newInboundGroupSession(b []byte, verified bool) *InboundGroupSession {
+	ratchet := megolmRatchet{
+		Counter: binary.BigEndian.Uint32(b),
+		Data:    append([]byte(nil), b[4:4+megolmRatchetLength]...),
+	}
+	return &InboundGroupSession{
+		s: inboundGroupState{
+			InitialRatchet:     ratchet,
+			LatestRatchet:      ratchet.clone(),
+			SigningKey:         append([]byte(nil), b[4+megolmRatchetLength:]...),
+			SigningKeyVerified: verified,
+		},
+	}
+}
+
+// UnpickleInboundGroupSession decrypts a session pickled with InboundGroupSession.Pickle.
+func UnpickleInboundGroupSession(pickled string, key []byte) (*InboundGroupSession, error) {
+	var s InboundGroupSession
+	if err := unpickle(pickled, key, &s.s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Pickle encrypts the session with the key so that it can be stored.
+func (s *InboundGroupSession) Pickle(key []byte) (string, error) {
+	return pickle(s.s, key)
+}
+
+// ID returns the ID of the session, which is the Ed25519 public key of the sender in base64.
+func (s *InboundGroupSession) ID() string {
+	return EncodeBase64(s.s.SigningKey)
+}
+
+// FirstKnownIndex returns the first message index the session can decrypt.
+func (s *InboundGroupSession) FirstKnownIndex() uint32 {
+	return s.s.InitialRatchet.Counter
+}
+
+// IsVerified returns true if the session was created from a session key signed by the sender, or if a
+// message has been decrypted using the session.
+func (s *InboundGroupSession) IsVerified() bool {
+	return s.s.SigningKeyVerified
+}
+
+// Export exports the session (in base64) starting from the provided message index so that it can be imported
+// with ImportInboundGroupSession. ErrUnknownMessageIndex is returned if the index is before FirstKnownIndex.
+func (s *InboundGroupSession) Export(index uint32) (string, error) {
+	ratchet, err := s.ratchetAt(index)
+	if err != nil {
+		return "", err
+	}
+
+	b := make([]byte, 0, 1+4+megolmRatchetLength+ed25519.PublicKeySize)
+	b = append(b, sessionExportVersion)
+	b = binary.BigEndian.AppendUint32(b, ratchet.Counter)
+	b = append(b, ratchet.Data...)
+	b = append(b, s.s.SigningKey...)
+	return EncodeBase64(b), nil
+}
+
+// ratchetAt returns a copy of the ratchet advanced to the index.
+func (s *InboundGroupSession) ratchetAt(index uint32) (megolmRatchet, error) {
+	if index-s.s.InitialRatchet.Counter >= 1<<31 {
+		return megolmRatchet{}, ErrUnknownMessageIndex
+	}
+
+	// Use the latest ratchet if it is before the index as it saves some hashing.
+	ratchet := s.s.InitialRatchet.clone()
+	if index-s.s.LatestRatchet.Counter < 1<<31 {
+		ratchet = s.s.LatestRatchet.clone()
+	}
+	ratchet.advanceTo(index)
+	return ratchet, nil
+}
+
+// Decrypt decrypts a message (in base64), returning the plaintext and its message index.
+func (s *InboundGroupSession) Decrypt(message string) ([]byte, uint32, error) {
+	raw, err := DecodeBase64(message)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(raw) < 1+macLength+ed25519.SignatureSize {
+		return nil, 0, ErrBadMessageFormat
+	}
+	if raw[0] != messageVersion {
+		return nil, 0, ErrBadMessageVersion
+	}
+
+	signed := raw[:len(raw)-ed25519.SignatureSize]
+	if !ed25519.Verify(s.s.SigningKey, signed, raw[len(signed):]) {
+		return nil, 0, ErrBadSignature
+	}
+	authenticated, mac := signed[:len(signed)-macLength], signed[len(signed)-macLength:]
+
+	var (
+		index      uint32
+		hasIndex   bool
+		ciphertext []byte
+	)
+	err = readFields(authenticated[1:], func(tag byte, v uint64) {
+		if tag == 0x08 {
+			index = uint32(v)
+			hasIndex = true
+		}
+	}, func(tag byte, v []byte) {
+		if tag == 0x12 {
+			ciphertext = v
+		}
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	if !hasIndex || ciphertext == nil {
+		return nil, 0, ErrBadMessageFormat
+	}
+
+	ratchet, err := s.ratchetAt(index)
+	if err != nil {
+		return nil, 0, err
+	}
+	keys := deriveCipherKeys(ratchet.Data, infoMegolmKeys)
+	if !keys.verifyMAC(authenticated, mac) {
+		return nil, 0, ErrBadMessageMAC
+	}
+	plaintext, err := keys.decrypt(ciphertext)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// The signature proves that the sender owns the session.
+	s.s.SigningKeyVerified = true
+	if index-s.s.LatestRatchet.Counter < 1<<31 {
+		s.s.LatestRatchet = ratchet
+	}
+	return plaintext, index, nil
+}
@@ -0,0 +1,260 @@
+package olm
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+// Known answers computed from the Megolm specification with the ratchet initialised to the bytes 0 to 127 and
+// the signing key of test 1 from RFC 8032.
+const (
+	megolmSessionID  = "11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo"
+	megolmSessionKey = "AgAAAAAAAQIDBAUGBwgJCgsMDQ4PEBESExQVFhcYGRobHB0eHyAhIiMkJSYnKCkqKywtLi8wMTIzNDU2Nzg5Ojs8PT4/" +
+		"QEFCQ0RFRkdISUpLTE1OT1BRUlNUVVZXWFlaW1xdXl9gYWJjZGVmZ2hpamtsbW5vcHFyc3R1dnd4eXp7fH1+f9damAGCsQq31Uv+" +
+		"08lkBzoO4XLz2qYjJa8CGmj3B1EaTdJROWDP1lJ3Z2U3r3xfUUF49yy3SpYBMgBHB9Q+56Nz7DEf6nM5tWeyPKJbhV7sO3bQQ/jo" +
+		"DxKWaNXgDXrmDA"
+	megolmExport0 = "AQAAAAAAAQIDBAUGBwgJCgsMDQ4PEBESExQVFhcYGRobHB0eHyAhIiMkJSYnKCkqKywtLi8wMTIzNDU2Nzg5Ojs8PT4/" +
+		"QEFCQ0RFRkdISUpLTE1OT1BRUlNUVVZXWFlaW1xdXl9gYWJjZGVmZ2hpamtsbW5vcHFyc3R1dnd4eXp7fH1+f9damAGCsQq31Uv+" +
+		"08lkBzoO4XLz2qYjJa8CGmj3B1Ea"
+	megolmExport5 = "AQAAAAUAAQIDBAUGBwgJCgsMDQ4PEBESExQVFhcYGRobHB0eHyAhIiMkJSYnKCkqKywtLi8wMTIzNDU2Nzg5Ojs8PT4/" +
+		"QEFCQ0RFRkdISUpLTE1OT1BRUlNUVVZXWFlaW1xdXl/RqeTAHqYfLqL3YfQJW3ALqkkDt3lB60Ds3hFvl/Hg99damAGCsQq31Uv+" +
+		"08lkBzoO4XLz2qYjJa8CGmj3B1Ea"
+
+	megolmPlaintext0 = "Hello, world!"
+	megolmMessage0   = "AwgAEhDMVhGyCXJucdkNoyCyrtYjmtX5Y3d2VQTheACt7yWF7PUhqR0dG1ag42sSM+PaSaSquYYTGayNd10nD/3uP+pa" +
+		"PqBjPq4DOXJbDXxIPqHIOfisk6EzjcMH"
+	megolmPlaintext5 = `{"type":"m.room.message","content":{"body":"Hi","msgtype":"m.text"}}`
+	megolmMessage5   = "AwgFElCpbGYU0ZmeOB3eEBasXl6y2o5wavUERvQYc2LxB5Z2Q7OTyOzy9iAfE4QUe0iRXtUKgh0L84IlkNmuzUKF2M5a" +
+		"vSefBuu8yOPa5U8ccvB+LXnXlBoqfYyTW1EwHiABsLogY95EEuNIqoG9RAyd1AG+2q/nI6VEin05XQqZXPMQqGfZGNYRNerRf+5I" +
+		"G+i7cmCnLhWkjxddDg"
+)
+
+func testOutboundGroupSession() *OutboundGroupSession {
+	return &OutboundGroupSession{
+		s: outboundGroupState{
+			Ratchet:    megolmRatchet{Data: sequence(0, megolmRatchetLength)},
+			SigningKey: ed25519Seed,
+		},
+	}
+}
+
+func TestMegolmRatchetAdvanceTo(t *testing.T) {
+	tests := []struct {
+		index    uint32
+		expected string
+	}{
+		{0x1, "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f" +
+			"202122232425262728292a2b2c2d2e2f303132333435363738393a3b3c3d3e3f" +
+			"404142434445464748494a4b4c4d4e4f505152535455565758595a5b5c5d5e5f" +
+			"550744e334115fa1fd73d3b71176d4157288631cb37045a49fd62cd0608c8f5d"},
+		{0x100, "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f" +
+			"202122232425262728292a2b2c2d2e2f303132333435363738393a3b3c3d3e3f" +
+			"f0bdefbbad3cf097dccb03f2c87159f7608e2480543eef7741c8f2e7f226e78f" +
+			"07e90dc411406c1ba86b898435b0512f7014666a3ecd3af93f13c32ccaf13050"},
+		{0x10000, "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f" +
+			"797f21ad97515c3ed5cb1f8e6ec28cd83627aa8dc4ced0717120abbb03397159" +
+			"193070746b7983cf7a579a6d82328168da7241f7c96bf450b6c90b9a137bec31" +
+			"5fc7847b9c651ea645d27a333a8023f7a841a6eb66a9f8d7aca523cc4d416795"},
+		{0x1000000, "e711546e3faad4c7c4aa756bc26cad6abea8241984a0f6b0839c70ca61c4ef88" +
+			"9b4c8120a4823a95f47cde17a244f4507244ee6e3957d1fab9fa29b44d3829b7" +
+			"4304c22c84a53755ab08ead8d97a8d429be5efa480682d7ad1da27f73e1fbe1d" +
+			"2a24d008789d3c74daf5e02636c675df8f09ec5e740c1bdf6305f9261f7b1c32"},
+		{0x2a3b4c5d, "8340705d69c4b7ee7ad049180bcce37cdf359f250ba99b662f6ff819bc82a2f9" +
+			"f49356d8fe2f730cf4eb3d9002549d77924dfc6fdffa38ca8a56a6c4211a329a" +
+			"d3f68458a95c479bd24238b1a8af0165e260cb4a72ae3ae085a095411d58bfbe" +
+			"b8018829202906bf310e0f6c76d45ec3ffbf768879beb3c22c4a985227961da3"},
+	}
+
+	// Advancing in one go and from the previous index must give the same result.
+	incremental := megolmRatchet{Data: sequence(0, megolmRatchetLength)}
+	for _, test := range tests {
+		r := megolmRatchet{Data: sequence(0, megolmRatchetLength)}
+		r.advanceTo(test.index)
+		if r.Counter != test.index {
+			t.Errorf("%#x: expected counter %#x, got %#x", test.index, test.index, r.Counter)
+		}
+		if hex.EncodeToString(r.Data) != test.expected {
+			t.Errorf("%#x: unexpected ratchet\nexpected: %s\ngot: %x", test.index, test.expected, r.Data)
+		}
+
+		incremental.advanceTo(test.index)
+		if hex.EncodeToString(incremental.Data) != test.expected {
+			t.Errorf("%#x: unexpected ratchet when advancing incrementally\nexpected: %s\ngot: %x",
+				test.index, test.expected, incremental.Data)
+		}
+	}
+
+	// Advancing one step at a time must match too.
+	r := megolmRatchet{Data: sequence(0, megolmRatchetLength)}
+	for r.Counter < 0x100 {
+		r.advance()
+	}
+	if hex.EncodeToString(r.Data) != tests[1].expected {
+		t.Errorf("unexpected ratchet when advancing step by step\nexpected: %s\ngot: %x", tests[1].expected, r.Data)
+	}
+}
+
+func TestOutboundGroupSession(t *testing.T) {
+	s := testOutboundGroupSession()
+	if id := s.ID(); id != megolmSessionID {
+		t.Errorf("unexpected session ID\nexpected: %s\ngot: %s", megolmSessionID, id)
+	}
+	if key := s.SessionKey(); key != megolmSessionKey {
+		t.Errorf("unexpected session key\nexpected: %s\ngot: %s", megolmSessionKey, key)
+	}
+
+	if msg := s.Encrypt([]byte(megolmPlaintext0)); msg != megolmMessage0 {
+		t.Errorf("unexpected message at index 0\nexpected: %s\ngot: %s", megolmMessage0, msg)
+	}
+	for s.MessageIndex() < 5 {
+		s.Encrypt([]byte("skipped"))
+	}
+	if msg := s.Encrypt([]byte(megolmPlaintext5)); msg != megolmMessage5 {
+		t.Errorf("unexpected message at index 5\nexpected: %s\ngot: %s", megolmMessage5, msg)
+	}
+}
+
+func TestInboundGroupSession(t *testing.T) {
+	s, err := NewInboundGroupSession(megolmSessionKey)
+	if err != nil {
+		t.Fatalf("unexpected error creating session: %v", err)
+	}
+	if s.ID() != megolmSessionID || s.FirstKnownIndex() != 0 || !s.IsVerified() {
+		t.Errorf("unexpected session %s starting at %d (verified: %t)", s.ID(), s.FirstKnownIndex(), s.IsVerified())
+	}
+
+	// Decrypt out of order to use both the latest and the initial ratchet.
+	tests := []struct {
+		message   string
+		plaintext string
+		index     uint32
+	}{
+		{megolmMessage5, megolmPlaintext5, 5},
+		{megolmMessage0, megolmPlaintext0, 0},
+	}
+	for _, test := range tests {
+		plaintext, index, err := s.Decrypt(test.message)
+		if err != nil {
+			t.Errorf("index %d: unexpected error decrypting: %v", test.index, err)
+			continue
+		}
+		if string(plaintext) != test.plaintext || index != test.index {
+			t.Errorf("expected %q at index %d, got %q at index %d", test.plaintext, test.index, plaintext, index)
+		}
+	}
+
+	for index, expected := range map[uint32]string{0: megolmExport0, 5: megolmExport5} {
+		exported, err := s.Export(index)
+		if err != nil {
+			t.Errorf("index %d: unexpected error exporting: %v", index, err)
+			continue
+		}
+		if exported != expected {
+			t.Errorf("index %d: unexpected export\nexpected: %s\ngot: %s", index, expected, exported)
+		}
+	}
+}
+
+func TestImportInboundGroupSession(t *testing.T) {
+	s, err := ImportInboundGroupSession(megolmExport5)
+	if err != nil {
+		t.Fatalf("unexpected error importing session: %v", err)
+	}
+	if s.ID() != megolmSessionID || s.FirstKnownIndex() != 5 || s.IsVerified() {
+		t.Errorf("unexpected session %s starting at %d (verified: %t)", s.ID(), s.FirstKnownIndex(), s.IsVerified())
+	}
+
+	if _, _, err := s.Decrypt(megolmMessage0); !errors.Is(err, ErrUnknownMessageIndex) {
+		t.Errorf("expected ErrUnknownMessageIndex decrypting index 0, got %v", err)
+	}
+	if _, err := s.Export(0); !errors.Is(err, ErrUnknownMessageIndex) {
+		t.Errorf("expected ErrUnknownMessageIndex exporting index 0, got %v", err)
+	}
+
+	plaintext, _, err := s.Decrypt(megolmMessage5)
+	if err != nil {
+		t.Fatalf("unexpected error decrypting: %v", err)
+	}
+	if string(plaintext) != megolmPlaintext5 {
+		t.Errorf("expected %q, got %q", megolmPlaintext5, plaintext)
+	}
+	if !s.IsVerified() {
+		t.Error("expected session to be verified after decrypting a signed message")
+	}
+	if exported, _ := s.Export(5); exported != megolmExport5 {
+		t.Errorf("unexpected export\nexpected: %s\ngot: %s", megolmExport5, exported)
+	}
+}
+
+func TestInboundGroupSessionRejects(t *testing.T) {
+	raw, err := DecodeBase64(megolmMessage0)
+	if err != nil {
+		t.Fatalf("unexpected error decoding message: %v", err)
+	}
+	signed := raw[:len(raw)-ed25519.SignatureSize]
+
+	badSignature := append([]byte(nil), raw...)
+	badSignature[len(badSignature)-1] ^= 1
+
+	// The MAC is changed and the message signed again so that only the MAC is wrong.
+	badMAC := append([]byte(nil), signed...)
+	badMAC[len(badMAC)-1] ^= 1
+	badMAC = append(badMAC, ed25519.Sign(ed25519.NewKeyFromSeed(ed25519Seed), badMAC)...)
+
+	badVersion := append([]byte(nil), signed...)
+	badVersion[0] = 2
+	badVersion = append(badVersion, ed25519.Sign(ed25519.NewKeyFromSeed(ed25519Seed), badVersion)...)
+
+	tests := []struct {
+		message  []byte
+		expected error
+	}{
+		{badSignature, ErrBadSignature},
+		{badMAC, ErrBadMessageMAC},
+		{badVersion, ErrBadMessageVersion},
+		{raw[:10], ErrBadMessageFormat},
+	}
+	for i, test := range tests {
+		s, err := NewInboundGroupSession(megolmSessionKey)
+		if err != nil {
+			t.Fatalf("unexpected error creating session: %v", err)
+		}
+		if _, _, err := s.Decrypt(EncodeBase64(test.message)); !errors.Is(err, test.expected) {
+			t.Errorf("case %d: expected %v, got %v", i, test.expected, err)
+		}
+	}
+}
+
+func TestNewInboundGroupSessionRejects(t *testing.T) {
+	key, err := DecodeBase64(megolmSessionKey)
+	if err != nil {
+		t.Fatalf("unexpected error decoding session key: %v", err)
+	}
+	badSignature := append([]byte(nil), key...)
+	badSignature[len(badSignature)-1] ^= 1
+	// Changing the ratchet invalidates the signature.
+	badRatchet := append([]byte(nil), key...)
+	badRatchet[5] ^= 1
+	badVersion := append([]byte(nil), key...)
+	badVersion[0] = sessionExportVersion
+
+	tests := []struct {
+		key      []byte
+		expected error
+	}{
+		{badSignature, ErrBadSignature},
+		{badRatchet, ErrBadSignature},
+		{badVersion, ErrBadSessionKey},
+		{key[:len(key)-1], ErrBadSessionKey},
+	}
+	for i, test := range tests {
+		if _, err := NewInboundGroupSession(EncodeBase64(test.key)); !errors.Is(err, test.expected) {
+			t.Errorf("case %d: expected %v, got %v", i, test.expected, err)
+		}
+	}
+	if _, err := ImportInboundGroupSession(megolmSessionKey); !errors.Is(err, ErrBadSessionKey) {
+		t.Errorf("expected ErrBadSessionKey importing a session key, got %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+package olm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrBadPickleKey is returned when a pickle cannot be decrypted with the provided key.
+var ErrBadPickleKey = errors.New("olm: bad pickle key")
+
+// pickleVersion is the version of the pickle format. It is increased when the pickled structures change in
+// an incompatible way.
+const pickleVersion = 1
+
+// pickled is the structure that is encrypted into a pickle.
+type pickled struct {
+	Version int             `json:"version"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// pickle encrypts the JSON encoding of v using the key.
+func pickle(v interface{}, key []byte) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	raw, err := json.Marshal(pickled{
+		Version: pickleVersion,
+		Data:    data,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	keys := deriveCipherKeys(key, infoPickle)
+	ciphertext := keys.encrypt(raw)
+	return EncodeBase64(append(ciphertext, keys.mac(ciphertext)...)), nil
+}
+
+// unpickle decrypts the pickle with the key and decodes it into v.
+func unpickle(s string, key []byte, v interface{}) error {
+	b, err := DecodeBase64(s)
+	if err != nil {
+		return err
+	}
+	if len(b) < macLength {
+		return ErrBadMessageFormat
+	}
+
+	keys := deriveCipherKeys(key, infoPickle)
+	ciphertext, mac := b[:len(b)-macLength], b[len(b)-macLength:]
+	if !keys.verifyMAC(ciphertext, mac) {
+		return ErrBadPickleKey
+	}
+	raw, err := keys.decrypt(ciphertext)
+	if err != nil {
+		return err
+	}
+
+	var p pickled
+	if err := json.Unmarshal(raw, &p); err != nil {
+		return fmt.Errorf("olm: error decoding pickle: %w", err)
+	}
+	if p.Version != pickleVersion {
+		return fmt.Errorf("olm: unsupported pickle version %d", p.Version)
+	}
+	if err := json.Unmarshal(p.Data, v); err != nil {
+		return fmt.Errorf("olm: error decoding pickle: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package olm
+
+import (
+	"errors"
+	"testing"
+)
+
+var (
+	pickleKey      = []byte("pickle key")
+	wrongPickleKey = []byte("wrong key")
+)
+
+func TestPickleRoundTrip(t *testing.T) {
+	bob := testBobAccount(t)
+	session, err := bob.NewInboundSession(olmAliceIdentityKey, olmMessage2)
+	if err != nil {
+		t.Fatalf("unexpected error creating session: %v", err)
+	}
+	// Decrypting the third message stores skipped message keys in the session.
+	if _, err := session.Decrypt(MessageTypePreKey, olmMessage2); err != nil {
+		t.Fatalf("unexpected error decrypting: %v", err)
+	}
+	inbound, err := ImportInboundGroupSession(megolmExport0)
+	if err != nil {
+		t.Fatalf("unexpected error importing group session: %v", err)
+	}
+	signing, err := NewPKSigningFromSeed(ed25519Seed)
+	if err != nil {
+		t.Fatalf("unexpected error creating signing key: %v", err)
+	}
+	decryption, err := NewPKDecryptionFromPrivateKey(bobPrivate)
+	if err != nil {
+		t.Fatalf("unexpected error creating decryption: %v", err)
+	}
+
+	// Each test pickles a value and checks that the unpickled value behaves the same.
+	tests := map[string]func() error{
+		"account": func() error {
+			pickled, err := bob.Pickle(pickleKey)
+			if err != nil {
+				return err
+			}
+			a, err := UnpickleAccount(pickled, pickleKey)
+			if err != nil {
+				return err
+			}
+			if curve, ed := a.IdentityKeys(); curve != EncodeBase64(bobPublic) || ed != megolmSessionID {
+				return errors.New("identity keys changed")
+			}
+			_, err = a.NewInboundSession(olmAliceIdentityKey, olmMessage0)
+			return err
+		},
+		"session": func() error {
+			pickled, err := session.Pickle(pickleKey)
+			if err != nil {
+				return err
+			}
+			s, err := UnpickleSession(pickled, pickleKey)
+			if err != nil {
+				return err
+			}
+			plaintext, err := s.Decrypt(MessageTypePreKey, olmMessage0)
+			if err == nil && string(plaintext) != olmPlaintext0 {
+				return errors.New("unexpected plaintext")
+			}
+			return err
+		},
+		"outbound group session": func() error {
+			pickled, err := testOutboundGroupSession().Pickle(pickleKey)
+			if err != nil {
+				return err
+			}
+			s, err := UnpickleOutboundGroupSession(pickled, pickleKey)
+			if err != nil {
+				return err
+			}
+			if s.Encrypt([]byte(megolmPlaintext0)) != megolmMessage0 {
+				return errors.New("unexpected message")
+			}
+			return nil
+		},
+		"inbound group session": func() error {
+			pickled, err := inbound.Pickle(pickleKey)
+			if err != nil {
+				return err
+			}
+			s, err := UnpickleInboundGroupSession(pickled, pickleKey)
+			if err != nil {
+				return err
+			}
+			if exported, err := s.Export(0); err != nil || exported != megolmExport0 {
+				return errors.New("unexpected export")
+			}
+			return nil
+		},
+		"pk signing": func() error {
+			pickled, err := signing.Pickle(pickleKey)
+			if err != nil {
+				return err
+			}
+			p, err := UnpicklePKSigning(pickled, pickleKey)
+			if err != nil {
+				return err
+			}
+			if p.PublicKey() != megolmSessionID {
+				return errors.New("public key changed")
+			}
+			return nil
+		},
+		"pk decryption": func() error {
+			pickled, err := decryption.Pickle(pickleKey)
+			if err != nil {
+				return err
+			}
+			p, err := UnpicklePKDecryption(pickled, pickleKey)
+			if err != nil {
+				return err
+			}
+			plaintext, err := p.Decrypt(&pkMessage)
+			if err == nil && string(plaintext) != pkPlaintext {
+				return errors.New("unexpected plaintext")
+			}
+			return err
+		},
+	}
+	for name, test := range tests {
+		if err := test(); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestUnpickleRejects(t *testing.T) {
+	pickled, err := testOutboundGroupSession().Pickle(pickleKey)
+	if err != nil {
+		t.Fatalf("unexpected error pickling: %v", err)
+	}
+	if _, err := UnpickleOutboundGroupSession(pickled, wrongPickleKey); !errors.Is(err, ErrBadPickleKey) {
+		t.Errorf("expected ErrBadPickleKey with the wrong key, got %v", err)
+	}
+
+	raw, err := DecodeBase64(pickled)
+	if err != nil {
+		t.Fatalf("unexpected error decoding pickle: %v", err)
+	}
+	raw[0] ^= 1
+	if _, err := UnpickleOutboundGroupSession(EncodeBase64(raw), pickleKey); !errors.Is(err, ErrBadPickleKey) {
+		t.Errorf("expected ErrBadPickleKey for a modified pickle, got %v", err)
+	}
+	if _, err := UnpickleOutboundGroupSession("AAAA", pickleKey); !errors.Is(err, ErrBadMessageFormat) {
+		t.Errorf("expected ErrBadMessageFormat for a short pickle, got %v", err)
+	}
+}
@@ -0,0 +1,185 @@
+package olm
+
+import (
+	"crypto/ecdh"
+	"crypto/ed25519"
+)
+
+// PKSigning is a standalone Ed25519 signing key, such as a cross-signing key.
+type PKSigning struct {
+	s pkSigningState
+}
+
+type pkSigningState struct {
+	Seed []byte `json:"seed"`
+}
+
+// NewPKSigning creates a PKSigning with a newly generated key.
+func NewPKSigning() (*PKSigning, error) {
+	seed, err := randomBytes(ed25519.SeedSize)
+	if err != nil {
+		return nil, err
+	}
+	return &PKSigning{s: pkSigningState{Seed: seed}}, nil
+}
+
+// NewPKSigningFromSeed creates a PKSigning from the 32 bytes Ed25519 seed of the private key.
+func NewPKSigningFromSeed(seed []byte) (*PKSigning, error) {
+	if len(seed) != ed25519.SeedSize {
+		return nil, ErrBadKey
+	}
+	return &PKSigning{s: pkSigningState{Seed: append([]byte(nil), seed...)}}, nil
+}
+
+// UnpicklePKSigning decrypts a key pickled with PKSigning.Pickle.
+func UnpicklePKSigning(pickled string, key []byte) (*PKSigning, error) {
+	var p PKSigning
+	if err := unpickle(pickled, key, &p.s); err != nil {
+		return nil, err
+	}
+	if len(p.s.Seed) != ed25519.SeedSize {
+		return nil, ErrBadKey
+	}
+	return &p, nil
+}
+
+// Pickle encrypts the key with the pickle key so that it can be stored.
+func (p *PKSigning) Pickle(key []byte) (string, error) {
+	return pickle(p.s, key)
+}
+
+// Seed returns the Ed25519 seed of the private key.
+func (p *PKSigning) Seed() []byte {
+	return append([]byte(nil), p.s.Seed...)
+}
+
+// PublicKey returns the Ed25519 public key in base64.
+func (p *PKSigning) PublicKey() string {
+	return EncodeBase64(p.privateKey().Public().(ed25519PublicKey))
+}
+
+// Sign signs the message and returns the signature in base64.
+func (p *PKSigning) Sign(message []byte) string {
+	return EncodeBase64(ed25519.Sign(p.privateKey(), message))
+}
+
+func (p *PKSigning) privateKey() ed25519.PrivateKey {
+	return ed25519.NewKeyFromSeed(p.s.Seed)
+}
+
+// PKMessage is a message encrypted with PKEncryption. All fields are in base64.
+type PKMessage struct {
+	Ciphertext string `json:"ciphertext"`
+	MAC        string `json:"mac"`
+	// Ephemeral is the ephemeral Curve25519 public key used to encrypt the message.
+	Ephemeral string `json:"ephemeral"`
+}
+
+// PKEncryption encrypts messages to the owner of a Curve25519 key pair, such as a key backup.
+type PKEncryption struct {
+	recipient []byte
+}
+
+// NewPKEncryption creates a PKEncryption that encrypts messages to the Curve25519 public key in base64.
+func NewPKEncryption(recipientKey string) (*PKEncryption, error) {
+	public, err := decodeCurveKey(recipientKey)
+	if err != nil {
+		return nil, err
+	}
+	return &PKEncryption{recipient: public}, nil
+}
+
+// Encrypt encrypts the plaintext with a newly generated ephemeral key.
+func (p *PKEncryption) Encrypt(plaintext []byte) (*PKMessage, error) {
+	ephemeral, err := newCurveKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	secret, err := ephemeral.sharedSecret(p.recipient)
+	if err != nil {
+		return nil, err
+	}
+	keys := deriveCipherKeys(secret, nil)
+	return &PKMessage{
+		Ciphertext: EncodeBase64(keys.encrypt(plaintext)),
+		// libolm computes the MAC over an empty string instead of the ciphertext. The spec keeps this
+		// behaviour for compatibility.
+		MAC:       EncodeBase64(keys.mac(nil)),
+		Ephemeral: EncodeBase64(ephemeral.Public),
+	}, nil
+}
+
+// PKDecryption decrypts messages encrypted with PKEncryption.
+type PKDecryption struct {
+	key curveKeyPair
+}
+
+// NewPKDecryption creates a PKDecryption with a newly generated key pair.
+func NewPKDecryption() (*PKDecryption, error) {
+	key, err := newCurveKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	return &PKDecryption{key: key}, nil
+}
+
+// NewPKDecryptionFromPrivateKey creates a PKDecryption from the 32 bytes Curve25519 private key.
+func NewPKDecryptionFromPrivateKey(private []byte) (*PKDecryption, error) {
+	key, err := ecdh.X25519().NewPrivateKey(private)
+	if err != nil {
+		return nil, ErrBadKey
+	}
+	return &PKDecryption{key: curveKeyPair{
+		Private: key.Bytes(),
+		Public:  key.PublicKey().Bytes(),
+	}}, nil
+}
+
+// PublicKey returns the Curve25519 public key in base64.
+func (p *PKDecryption) PublicKey() string {
+	return EncodeBase64(p.key.Public)
+}
+
+// PrivateKey returns the Curve25519 private key.
+func (p *PKDecryption) PrivateKey() []byte {
+	return append([]byte(nil), p.key.Private...)
+}
+
+// Decrypt decrypts the message.
+func (p *PKDecryption) Decrypt(msg *PKMessage) ([]byte, error) {
+	ephemeral, err := decodeCurveKey(msg.Ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	mac, err := DecodeBase64(msg.MAC)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := DecodeBase64(msg.Ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := p.key.sharedSecret(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	keys := deriveCipherKeys(secret, nil)
+	if !keys.verifyMAC(nil, mac) {
+		return nil, ErrBadMessageMAC
+	}
+	return keys.decrypt(ciphertext)
+}
+
+// UnpicklePKDecryption decrypts a key pickled with PKDecryption.Pickle.
+func UnpicklePKDecryption(pickled string, key []byte) (*PKDecryption, error) {
+	var p PKDecryption
+	if err := unpickle(pickled, key, &p.key); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// Pickle encrypts the key pair with the pickle key so that it can be stored.
+func (p *PKDecryption) Pickle(key []byte) (string, error) {
+	return pickle(p.key, key)
+}
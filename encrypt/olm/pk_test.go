@@ -0,0 +1,110 @@
+package olm
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+// pkMessage is a message encrypted to Bob's RFC 7748 key with Alice's RFC 7748 key as the ephemeral key,
+// computed from the specification of the m.megolm_backup.v1.curve25519-aes-sha2 backup algorithm.
+var pkMessage = PKMessage{
+	Ciphertext: "9lq9DgATQh0Ey5ZaVGHfoeMtfpavaYtV17dAmUZKJ5KP8WdLcGpW32I+81DhjjSTt7FEixHndMIBbtx+KuN6OsZDtzLuQGfd1nEC" +
+		"SLyf40k",
+	MAC:       "zpzU6BkZcNI",
+	Ephemeral: "hSDwCYkwp1R0i33ctD73Wg2/Og0mOBr066SpjqqbTmo",
+}
+
+const pkPlaintext = `{"algorithm":"m.megolm.v1.aes-sha2","sender_key":"key","session_key":"key"}`
+
+func TestPKDecryption(t *testing.T) {
+	p, err := NewPKDecryptionFromPrivateKey(bobPrivate)
+	if err != nil {
+		t.Fatalf("unexpected error creating decryption: %v", err)
+	}
+	if p.PublicKey() != EncodeBase64(bobPublic) {
+		t.Errorf("unexpected public key %s", p.PublicKey())
+	}
+	plaintext, err := p.Decrypt(&pkMessage)
+	if err != nil {
+		t.Fatalf("unexpected error decrypting: %v", err)
+	}
+	if string(plaintext) != pkPlaintext {
+		t.Errorf("expected %q, got %q", pkPlaintext, plaintext)
+	}
+
+	// The MAC only depends on the keys so it is the ephemeral key that has to be wrong.
+	badMAC := pkMessage
+	badMAC.MAC = "zpzU6BkZcNM"
+	badEphemeral := pkMessage
+	badEphemeral.Ephemeral = EncodeBase64(bobPublic)
+	for _, msg := range []PKMessage{badMAC, badEphemeral} {
+		if _, err := p.Decrypt(&msg); !errors.Is(err, ErrBadMessageMAC) {
+			t.Errorf("expected ErrBadMessageMAC, got %v", err)
+		}
+	}
+}
+
+func TestPKEncryption(t *testing.T) {
+	p, err := NewPKDecryption()
+	if err != nil {
+		t.Fatalf("unexpected error creating decryption: %v", err)
+	}
+	e, err := NewPKEncryption(p.PublicKey())
+	if err != nil {
+		t.Fatalf("unexpected error creating encryption: %v", err)
+	}
+	msg, err := e.Encrypt([]byte(pkPlaintext))
+	if err != nil {
+		t.Fatalf("unexpected error encrypting: %v", err)
+	}
+	plaintext, err := p.Decrypt(msg)
+	if err != nil {
+		t.Fatalf("unexpected error decrypting: %v", err)
+	}
+	if string(plaintext) != pkPlaintext {
+		t.Errorf("expected %q, got %q", pkPlaintext, plaintext)
+	}
+
+	if _, err := NewPKEncryption("c2hvcnQ"); !errors.Is(err, ErrBadKey) {
+		t.Errorf("expected ErrBadKey for a short key, got %v", err)
+	}
+}
+
+func TestPKSigning(t *testing.T) {
+	// Test 1 from RFC 8032, section 7.1.
+	const (
+		publicKey = "11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo"
+		signature = "e5564300c360ac729086e2cc806e828a84877f1eb8e5d974d873e065224901555fb8821590a33bacc61e39701cf9b46b" +
+			"d25bf5f0595bbe24655141438e7a100b"
+	)
+	p, err := NewPKSigningFromSeed(ed25519Seed)
+	if err != nil {
+		t.Fatalf("unexpected error creating signing key: %v", err)
+	}
+	if p.PublicKey() != publicKey {
+		t.Errorf("unexpected public key\nexpected: %s\ngot: %s", publicKey, p.PublicKey())
+	}
+	if !bytes.Equal(p.Seed(), ed25519Seed) {
+		t.Errorf("unexpected seed %x", p.Seed())
+	}
+
+	sig := p.Sign(nil)
+	if got, _ := DecodeBase64(sig); hex.EncodeToString(got) != signature {
+		t.Errorf("unexpected signature\nexpected: %s\ngot: %x", signature, got)
+	}
+	if err := VerifySignature(publicKey, nil, sig); err != nil {
+		t.Errorf("unexpected error verifying signature: %v", err)
+	}
+	if err := VerifySignature(publicKey, []byte("tampered"), sig); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("expected ErrBadSignature for a tampered message, got %v", err)
+	}
+	if err := VerifySignature("c2hvcnQ", nil, sig); !errors.Is(err, ErrBadKey) {
+		t.Errorf("expected ErrBadKey for a short key, got %v", err)
+	}
+
+	if _, err := NewPKSigningFromSeed(ed25519Seed[1:]); !errors.Is(err, ErrBadKey) {
+		t.Errorf("expected ErrBadKey for a short seed, got %v", err)
+	}
+}
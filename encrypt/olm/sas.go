@@ -0,0 +1,66 @@
+package olm
+
+import (
+	"errors"
+)
+
+// ErrTheirKeyNotSet is returned when SAS bytes or MACs are requested before the public key of the other party
+// is set.
+var ErrTheirKeyNotSet = errors.New("olm: public key of the other party is not set")
+
+// SAS is the key agreement of a short authentication string verification. Both parties generate an
+// ephemeral Curve25519 key pair and derive the short authentication string and MAC keys from the shared
+// secret.
+// It is not safe for concurrent use.
+type SAS struct {
+	key    curveKeyPair
+	secret []byte
+}
+
+// NewSAS creates a SAS with a newly generated ephemeral key pair.
+func NewSAS() (*SAS, error) {
+	key, err := newCurveKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	return &SAS{key: key}, nil
+}
+
+// PublicKey returns the ephemeral Curve25519 public key in base64.
+func (s *SAS) PublicKey() string {
+	return EncodeBase64(s.key.Public)
+}
+
+// SetTheirKey sets the ephemeral Curve25519 public key (in base64) of the other party and computes the
+// shared secret.
+func (s *SAS) SetTheirKey(key string) error {
+	public, err := decodeCurveKey(key)
+	if err != nil {
+		return err
+	}
+	secret, err := s.key.sharedSecret(public)
+	if err != nil {
+		return err
+	}
+	s.secret = secret
+	return nil
+}
+
+// GenerateBytes derives n bytes from the shared secret using the info. The bytes are then turned into the
+// short authentication string displayed to the user.
+func (s *SAS) GenerateBytes(info string, n int) ([]byte, error) {
+	if s.secret == nil {
+		return nil, ErrTheirKeyNotSet
+	}
+	return HKDF(s.secret, nil, []byte(info), n), nil
+}
+
+// CalculateMAC returns the MAC of the input in base64 using a key derived from the shared secret and the
+// info. It implements the "hkdf-hmac-sha256.v2" message authentication code.
+func (s *SAS) CalculateMAC(input, info string) (string, error) {
+	if s.secret == nil {
+		return "", ErrTheirKeyNotSet
+	}
+	key := HKDF(s.secret, nil, []byte(info), 32)
+	return EncodeBase64(hmacSHA256(key, []byte(input))), nil
+}
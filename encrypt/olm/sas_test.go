@@ -0,0 +1,65 @@
+package olm
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// testSAS returns the SAS of both parties using the RFC 7748 key pairs.
+func testSAS(t *testing.T) (alice, bob *SAS) {
+	t.Helper()
+	alice = &SAS{key: curveKeyPair{Private: alicePrivate, Public: alicePublic}}
+	bob = &SAS{key: curveKeyPair{Private: bobPrivate, Public: bobPublic}}
+	if err := alice.SetTheirKey(bob.PublicKey()); err != nil {
+		t.Fatalf("unexpected error setting Bob's key: %v", err)
+	}
+	if err := bob.SetTheirKey(alice.PublicKey()); err != nil {
+		t.Fatalf("unexpected error setting Alice's key: %v", err)
+	}
+	return alice, bob
+}
+
+func TestSASGenerateBytes(t *testing.T) {
+	alice, bob := testSAS(t)
+	const info = "MATRIX_KEY_VERIFICATION_SAS|@alice:example.org|ALICE|hSDwCYkwp1R0i33ctD73Wg2/Og0mOBr066SpjqqbTmo|" +
+		"@bob:example.org|BOB|3p7bfXt9wbTTW2HC7OQ1Nz+DQ8hbeGdNrfx+FG+IK08|$txn"
+	expected := mustDecodeHex("091e9f14dbed")
+	for name, sas := range map[string]*SAS{"alice": alice, "bob": bob} {
+		b, err := sas.GenerateBytes(info, len(expected))
+		if err != nil {
+			t.Fatalf("%s: unexpected error generating bytes: %v", name, err)
+		}
+		if !bytes.Equal(b, expected) {
+			t.Errorf("%s: unexpected bytes\nexpected: %x\ngot: %x", name, expected, b)
+		}
+	}
+}
+
+func TestSASCalculateMAC(t *testing.T) {
+	alice, bob := testSAS(t)
+	// hkdf-hmac-sha256.v2 MAC of a key ID.
+	const (
+		info     = "MATRIX_KEY_VERIFICATION_MAC@alice:example.orgALICE@bob:example.orgBOB$txnKEY_IDS"
+		expected = "BJ99n+coOqAAWQDIJwcwAteB1zRPTdmiZmUEDK5K7DA"
+	)
+	for name, sas := range map[string]*SAS{"alice": alice, "bob": bob} {
+		mac, err := sas.CalculateMAC("ed25519:ALICE", info)
+		if err != nil {
+			t.Fatalf("%s: unexpected error calculating MAC: %v", name, err)
+		}
+		if mac != expected {
+			t.Errorf("%s: unexpected MAC\nexpected: %s\ngot: %s", name, expected, mac)
+		}
+	}
+}
+
+func TestSASTheirKeyNotSet(t *testing.T) {
+	sas := &SAS{key: curveKeyPair{Private: alicePrivate, Public: alicePublic}}
+	if _, err := sas.GenerateBytes("info", 6); !errors.Is(err, ErrTheirKeyNotSet) {
+		t.Errorf("expected ErrTheirKeyNotSet from GenerateBytes, got %v", err)
+	}
+	if _, err := sas.CalculateMAC("input", "info"); !errors.Is(err, ErrTheirKeyNotSet) {
+		t.Errorf("expected ErrTheirKeyNotSet from CalculateMAC, got %v", err)
+	}
+}
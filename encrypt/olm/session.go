@@ -0,0 +1,517 @@
+package olm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+)
+
+// Errors returned when decrypting Olm messages.
+var (
+	// ErrUnknownChain is returned when the message key of an old message has already been used or discarded.
+	ErrUnknownChain = errors.New("olm: message key not found")
+	// ErrMessageGapTooLarge is returned when too many messages have been skipped.
+	ErrMessageGapTooLarge = errors.New("olm: too many skipped messages")
+	// ErrSessionMismatch is returned when a pre-key message is not meant for the session or account.
+	ErrSessionMismatch = errors.New("olm: pre-key message does not match session")
+)
+
+// Olm message types.
+const (
+	MessageTypePreKey = 0
+	MessageTypeNormal = 1
+)
+
+// Limits of the ratchet. They are the same as libolm.
+const (
+	maxReceiverChains = 5
+	maxSkippedKeys    = 40
+	maxMessageGap     = 2000
+)
+
+// messageVersion is the version byte of Olm and Megolm messages.
+const messageVersion = 3
+
+// Session is an Olm session between two devices. It is not safe for concurrent use.
+type Session struct {
+	s sessionState
+}
+
+type sessionState struct {
+	RootKey        []byte          `json:"root_key"`
+	SenderChain    *senderChain    `json:"sender_chain,omitempty"`
+	ReceiverChains []receiverChain `json:"receiver_chains"`
+	SkippedKeys    []skippedKey    `json:"skipped_keys"`
+
+	// ReceivedMessage is set after the first message from the other device is decrypted. Until then, messages
+	// are sent as pre-key messages.
+	ReceivedMessage bool `json:"received_message"`
+
+	AliceIdentityKey []byte `json:"alice_identity_key"`
+	AliceBaseKey     []byte `json:"alice_base_key"`
+	BobOneTimeKey    []byte `json:"bob_one_time_key"`
+}
+
+type senderChain struct {
+	RatchetKey curveKeyPair `json:"ratchet_key"`
+	ChainKey   []byte       `json:"chain_key"`
+	Index      uint32       `json:"index"`
+}
+
+type receiverChain struct {
+	RatchetKey []byte `json:"ratchet_key"`
+	ChainKey   []byte `json:"chain_key"`
+	Index      uint32 `json:"index"`
+}
+
+type skippedKey struct {
+	RatchetKey []byte `json:"ratchet_key"`
+	Index      uint32 `json:"index"`
+	MessageKey []byte `json:"message_key"`
+}
+
+// NewOutboundSession creates a session with the device that owns the provided Curve25519 identity key and
+// one-time key, both in base64.
+func (a *Account) NewOutboundSession(theirIdentityKey, theirOneTimeKey string) (*Session, error) {
+	identity, err := decodeCurveKey(theirIdentityKey)
+	if err != nil {
+		return nil, err
+	}
+	oneTime, err := decodeCurveKey(theirOneTimeKey)
+	if err != nil {
+		return nil, err
+	}
+
+	baseKey, err := newCurveKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	ratchetKey, err := newCurveKeyPair()
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := tripleDH(
+		[2][]byte{a.s.IdentityKey.Private, oneTime},
+		[2][]byte{baseKey.Private, identity},
+		[2][]byte{baseKey.Private, oneTime},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	derived := HKDF(secret, nil, infoRoot, 64)
+	return &Session{
+		s: sessionState{
+			RootKey: derived[:32],
+			SenderChain: &senderChain{
+				RatchetKey: ratchetKey,
+				ChainKey:   derived[32:],
+			},
+			AliceIdentityKey: a.s.IdentityKey.Public,
+			AliceBaseKey:     baseKey.Public,
+			BobOneTimeKey:    oneTime,
+		},
+	}, nil
+}
+
+// NewInboundSession creates a session from a pre-key message (in base64) sent to the account. If
+// theirIdentityKey is not empty, it must match the identity key in the message.
+//
+// The message is not decrypted. Session.Decrypt has to be called with the message afterwards. The one-time
+// key used should then be removed using RemoveOneTimeKeys.
+func (a *Account) NewInboundSession(theirIdentityKey string, message string) (*Session, error) {
+	msg, err := decodePreKeyMessage(message)
+	if err != nil {
+		return nil, err
+	}
+	if theirIdentityKey != "" {
+		identity, err := decodeCurveKey(theirIdentityKey)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(identity, msg.IdentityKey) {
+			return nil, ErrSessionMismatch
+		}
+	}
+
+	oneTime, ok := a.findKey(msg.OneTimeKey)
+	if !ok {
+		return nil, ErrUnknownOneTimeKey
+	}
+	inner, err := decodeMessage(msg.Message)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := tripleDH(
+		[2][]byte{oneTime.Private, msg.IdentityKey},
+		[2][]byte{a.s.IdentityKey.Private, msg.BaseKey},
+		[2][]byte{oneTime.Private, msg.BaseKey},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	derived := HKDF(secret, nil, infoRoot, 64)
+	return &Session{
+		s: sessionState{
+			RootKey: derived[:32],
+			ReceiverChains: []receiverChain{{
+				RatchetKey: inner.RatchetKey,
+				ChainKey:   derived[32:],
+			}},
+			AliceIdentityKey: msg.IdentityKey,
+			AliceBaseKey:     msg.BaseKey,
+			BobOneTimeKey:    oneTime.Public,
+		},
+	}, nil
+}
+
+// tripleDH concatenates the shared secrets of the provided private and public key pairs.
+func tripleDH(pairs ...[2][]byte) ([]byte, error) {
+	secret := make([]byte, 0, len(pairs)*Curve25519KeyLength)
+	for _, v := range pairs {
+		shared, err := sharedSecret(v[0], v[1])
+		if err != nil {
+			return nil, err
+		}
+		secret = append(secret, shared...)
+	}
+	return secret, nil
+}
+
+// UnpickleSession decrypts a session pickled with Session.Pickle.
+func UnpickleSession(pickled string, key []byte) (*Session, error) {
+	var s Session
+	if err := unpickle(pickled, key, &s.s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Pickle encrypts the session with the key so that it can be stored.
+func (s *Session) Pickle(key []byte) (string, error) {
+	return pickle(s.s, key)
+}
+
+// ID returns the ID of the session, which is the same on both devices.
+func (s *Session) ID() string {
+	hash := sha256.New()
+	_, _ = hash.Write(s.s.AliceIdentityKey)
+	_, _ = hash.Write(s.s.AliceBaseKey)
+	_, _ = hash.Write(s.s.BobOneTimeKey)
+	return EncodeBase64(hash.Sum(nil))
+}
+
+// HasReceivedMessage returns true if a message from the other device has been decrypted by the session.
+func (s *Session) HasReceivedMessage() bool {
+	return s.s.ReceivedMessage
+}
+
+// MatchesInboundSession checks if the pre-key message (in base64) was sent using this session. If
+// theirIdentityKey is not empty, it must also match the identity key in the message.
+func (s *Session) MatchesInboundSession(theirIdentityKey string, message string) bool {
+	msg, err := decodePreKeyMessage(message)
+	if err != nil {
+		return false
+	}
+	if theirIdentityKey != "" {
+		identity, err := decodeCurveKey(theirIdentityKey)
+		if err != nil || !bytes.Equal(identity, msg.IdentityKey) {
+			return false
+		}
+	}
+	return bytes.Equal(msg.IdentityKey, s.s.AliceIdentityKey) &&
+		bytes.Equal(msg.BaseKey, s.s.AliceBaseKey) &&
+		bytes.Equal(msg.OneTimeKey, s.s.BobOneTimeKey)
+}
+
+// Encrypt encrypts the plaintext, returning the message type and the message in base64.
+// The message is a pre-key message until a message from the other device has been decrypted.
+func (s *Session) Encrypt(plaintext []byte) (msgType int, message string, err error) {
+	if s.s.SenderChain == nil {
+		ratchetKey, err := newCurveKeyPair()
+		if err != nil {
+			return 0, "", err
+		}
+		rootKey, chainKey, err := advanceRootKey(s.s.RootKey, ratchetKey.Private, s.s.ReceiverChains[0].RatchetKey)
+		if err != nil {
+			return 0, "", err
+		}
+		s.s.RootKey = rootKey
+		s.s.SenderChain = &senderChain{
+			RatchetKey: ratchetKey,
+			ChainKey:   chainKey,
+		}
+	}
+
+	chain := s.s.SenderChain
+	messageKey := hmacSHA256(chain.ChainKey, []byte{0x01})
+	keys := deriveCipherKeys(messageKey, infoOlmKeys)
+
+	var w messageWriter
+	w.WriteByte(messageVersion)
+	w.writeBytesField(0x0A, chain.RatchetKey.Public)
+	w.writeIntField(0x10, uint64(chain.Index))
+	w.writeBytesField(0x22, keys.encrypt(plaintext))
+	raw := append(w.Bytes(), keys.mac(w.Bytes())...)
+
+	chain.ChainKey = hmacSHA256(chain.ChainKey, []byte{0x02})
+	chain.Index++
+
+	if s.s.ReceivedMessage {
+		return MessageTypeNormal, EncodeBase64(raw), nil
+	}
+
+	var pre messageWriter
+	pre.WriteByte(messageVersion)
+	pre.writeBytesField(0x0A, s.s.BobOneTimeKey)
+	pre.writeBytesField(0x12, s.s.AliceBaseKey)
+	pre.writeBytesField(0x1A, s.s.AliceIdentityKey)
+	pre.writeBytesField(0x22, raw)
+	return MessageTypePreKey, EncodeBase64(pre.Bytes()), nil
+}
+
+// Decrypt decrypts a message (in base64) of the provided type. The session is left unchanged if decryption
+// fails.
+func (s *Session) Decrypt(msgType int, message string) ([]byte, error) {
+	var raw []byte
+	switch msgType {
+	case MessageTypePreKey:
+		msg, err := decodePreKeyMessage(message)
+		if err != nil {
+			return nil, err
+		}
+		raw = msg.Message
+	case MessageTypeNormal:
+		var err error
+		if raw, err = DecodeBase64(message); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrBadMessageFormat
+	}
+
+	msg, err := decodeMessage(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	var chain *receiverChain
+	for k := range s.s.ReceiverChains {
+		if bytes.Equal(s.s.ReceiverChains[k].RatchetKey, msg.RatchetKey) {
+			chain = &s.s.ReceiverChains[k]
+			break
+		}
+	}
+
+	if chain == nil {
+		return s.decryptNewChain(msg)
+	}
+
+	if msg.Counter < chain.Index {
+		for k, v := range s.s.SkippedKeys {
+			if v.Index != msg.Counter || !bytes.Equal(v.RatchetKey, msg.RatchetKey) {
+				continue
+			}
+			plaintext, err := msg.decrypt(v.MessageKey)
+			if err != nil {
+				return nil, err
+			}
+			s.s.SkippedKeys = append(s.s.SkippedKeys[:k], s.s.SkippedKeys[k+1:]...)
+			s.s.ReceivedMessage = true
+			return plaintext, nil
+		}
+		return nil, ErrUnknownChain
+	}
+
+	updated := *chain
+	plaintext, skipped, err := updated.decrypt(msg)
+	if err != nil {
+		return nil, err
+	}
+	*chain = updated
+	s.addSkippedKeys(skipped)
+	s.s.ReceivedMessage = true
+	return plaintext, nil
+}
+
+// decryptNewChain decrypts a message that uses a new ratchet key from the other device.
+func (s *Session) decryptNewChain(msg *message) ([]byte, error) {
+	if s.s.SenderChain == nil {
+		// We have not sent any message for them to ratchet from.
+		return nil, ErrUnknownChain
+	}
+
+	rootKey, chainKey, err := advanceRootKey(s.s.RootKey, s.s.SenderChain.RatchetKey.Private, msg.RatchetKey)
+	if err != nil {
+		return nil, err
+	}
+	chain := receiverChain{
+		RatchetKey: msg.RatchetKey,
+		ChainKey:   chainKey,
+	}
+	plaintext, skipped, err := chain.decrypt(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	s.s.RootKey = rootKey
+	s.s.ReceiverChains = append([]receiverChain{chain}, s.s.ReceiverChains...)
+	if len(s.s.ReceiverChains) > maxReceiverChains {
+		s.s.ReceiverChains = s.s.ReceiverChains[:maxReceiverChains]
+	}
+	s.s.SenderChain = nil
+	s.addSkippedKeys(skipped)
+	s.s.ReceivedMessage = true
+	return plaintext, nil
+}
+
+// addSkippedKeys stores the message keys of skipped messages, dropping the oldest keys if there are too many.
+func (s *Session) addSkippedKeys(keys []skippedKey) {
+	s.s.SkippedKeys = append(s.s.SkippedKeys, keys...)
+	if len(s.s.SkippedKeys) > maxSkippedKeys {
+		s.s.SkippedKeys = s.s.SkippedKeys[len(s.s.SkippedKeys)-maxSkippedKeys:]
+	}
+}
+
+// decrypt advances the chain to the message and decrypts it, returning the message keys of the messages
+// skipped.
+func (c *receiverChain) decrypt(msg *message) ([]byte, []skippedKey, error) {
+	if msg.Counter-c.Index > maxMessageGap {
+		return nil, nil, ErrMessageGapTooLarge
+	}
+
+	var skipped []skippedKey
+	for c.Index < msg.Counter {
+		// Only the last few keys can be kept so do not bother computing the rest.
+		if msg.Counter-c.Index <= maxSkippedKeys {
+			skipped = append(skipped, skippedKey{
+				RatchetKey: c.RatchetKey,
+				Index:      c.Index,
+				MessageKey: hmacSHA256(c.ChainKey, []byte{0x01}),
+			})
+		}
+		c.ChainKey = hmacSHA256(c.ChainKey, []byte{0x02})
+		c.Index++
+	}
+
+	plaintext, err := msg.decrypt(hmacSHA256(c.ChainKey, []byte{0x01}))
+	if err != nil {
+		return nil, nil, err
+	}
+	c.ChainKey = hmacSHA256(c.ChainKey, []byte{0x02})
+	c.Index++
+	return plaintext, skipped, nil
+}
+
+// advanceRootKey performs a step of the Diffie-Hellman ratchet, returning the new root key and chain key.
+func advanceRootKey(rootKey, ourPrivate, theirPublic []byte) ([]byte, []byte, error) {
+	secret, err := sharedSecret(ourPrivate, theirPublic)
+	if err != nil {
+		return nil, nil, err
+	}
+	derived := HKDF(secret, rootKey, infoRatchet, 64)
+	return derived[:32], derived[32:], nil
+}
+
+// message is a decoded Olm message.
+type message struct {
+	RatchetKey []byte
+	Counter    uint32
+	Ciphertext []byte
+
+	// signed is the part of the message covered by the MAC.
+	signed []byte
+	mac    []byte
+}
+
+// decodeMessage decodes an Olm message.
+func decodeMessage(raw []byte) (*message, error) {
+	if len(raw) < 1+macLength {
+		return nil, ErrBadMessageFormat
+	}
+	if raw[0] != messageVersion {
+		return nil, ErrBadMessageVersion
+	}
+
+	msg := &message{
+		signed: raw[:len(raw)-macLength],
+		mac:    raw[len(raw)-macLength:],
+	}
+	var hasCounter bool
+	err := readFields(msg.signed[1:], func(tag byte, v uint64) {
+		if tag == 0x10 {
+			msg.Counter = uint32(v)
+			hasCounter = true
+		}
+	}, func(tag byte, v []byte) {
+		switch tag {
+		case 0x0A:
+			msg.RatchetKey = v
+		case 0x22:
+			msg.Ciphertext = v
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !hasCounter || len(msg.RatchetKey) != Curve25519KeyLength || msg.Ciphertext == nil {
+		return nil, ErrBadMessageFormat
+	}
+	return msg, nil
+}
+
+// decrypt checks the MAC of the message and decrypts it with the message key.
+func (m *message) decrypt(messageKey []byte) ([]byte, error) {
+	keys := deriveCipherKeys(messageKey, infoOlmKeys)
+	if !keys.verifyMAC(m.signed, m.mac) {
+		return nil, ErrBadMessageMAC
+	}
+	return keys.decrypt(m.Ciphertext)
+}
+
+// preKeyMessage is a decoded Olm pre-key message.
+type preKeyMessage struct {
+	OneTimeKey  []byte
+	BaseKey     []byte
+	IdentityKey []byte
+	Message     []byte
+}
+
+// decodePreKeyMessage decodes a pre-key message in base64.
+func decodePreKeyMessage(s string) (*preKeyMessage, error) {
+	raw, err := DecodeBase64(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) < 1 {
+		return nil, ErrBadMessageFormat
+	}
+	if raw[0] != messageVersion {
+		return nil, ErrBadMessageVersion
+	}
+
+	var msg preKeyMessage
+	err = readFields(raw[1:], func(byte, uint64) {}, func(tag byte, v []byte) {
+		switch tag {
+		case 0x0A:
+			msg.OneTimeKey = v
+		case 0x12:
+			msg.BaseKey = v
+		case 0x1A:
+			msg.IdentityKey = v
+		case 0x22:
+			msg.Message = v
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(msg.OneTimeKey) != Curve25519KeyLength || len(msg.BaseKey) != Curve25519KeyLength ||
+		len(msg.IdentityKey) != Curve25519KeyLength || msg.Message == nil {
+		return nil, ErrBadMessageFormat
+	}
+	return &msg, nil
+}
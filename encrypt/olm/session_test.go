@@ -0,0 +1,176 @@
+package olm
+
+import (
+	"errors"
+	"testing"
+)
+
+// Pre-key messages from Alice to Bob computed from the Olm specification. Alice and Bob use the RFC 7748
+// identity keys, Alice uses the bytes 0x10 to 0x2f as her base key and 0x30 to 0x4f as her ratchet key, and Bob
+// uses 0x50 to 0x6f as his one-time key.
+const (
+	olmAliceIdentityKey = "hSDwCYkwp1R0i33ctD73Wg2/Og0mOBr066SpjqqbTmo"
+	olmSessionID        = "Ma9qq+zz3gmu9cy9oPvSzlA2C0VWBdhTkkJ+Iv0Hx/4"
+
+	olmPlaintext0 = "Hello, Bob!"
+	olmMessage0   = "AwogOS0XSjizsb6vrx/oJIcIQcX6UxvG6v22QCwSRmRIjBwSINieO615Q32+2fhDQYME9GD/Bcf+gf5KlXeoBMuTZ/9mGiCF" +
+		"IPAJiTCnVHSLfdy0PvdaDb86DSY4GvTrpKmOqptOaiI/AwogNOQtSvXvlKB6OoQgG4idTNGnQ8snsRtqEEOKj+uOWEcQACIQ+GHW" +
+		"yEPDdl23SG3NzTEENqCHgJoQdNo+"
+	olmPlaintext2 = "Third message"
+	olmMessage2   = "AwogOS0XSjizsb6vrx/oJIcIQcX6UxvG6v22QCwSRmRIjBwSINieO615Q32+2fhDQYME9GD/Bcf+gf5KlXeoBMuTZ/9mGiCF" +
+		"IPAJiTCnVHSLfdy0PvdaDb86DSY4GvTrpKmOqptOaiI/AwogNOQtSvXvlKB6OoQgG4idTNGnQ8snsRtqEEOKj+uOWEcQAiIQiwGU" +
+		"xVKZiqa/Tm8mf74KVfmlAfAP1nkN"
+)
+
+// testBobAccount returns the account receiving the pre-key messages.
+func testBobAccount(t *testing.T) *Account {
+	t.Helper()
+	return &Account{
+		s: accountState{
+			IdentityKey: testCurveKeyPair(t, bobPrivate),
+			SigningKey:  ed25519Seed,
+			OneTimeKeys: []oneTimeKey{{ID: 1, Key: testCurveKeyPair(t, sequence(0x50, 32)), Published: true}},
+			NextKeyID:   2,
+		},
+	}
+}
+
+func TestSessionDecryptPreKey(t *testing.T) {
+	bob := testBobAccount(t)
+	s, err := bob.NewInboundSession(olmAliceIdentityKey, olmMessage2)
+	if err != nil {
+		t.Fatalf("unexpected error creating inbound session: %v", err)
+	}
+	if s.ID() != olmSessionID {
+		t.Errorf("unexpected session ID\nexpected: %s\ngot: %s", olmSessionID, s.ID())
+	}
+	if !s.MatchesInboundSession(olmAliceIdentityKey, olmMessage0) {
+		t.Error("expected the first message to match the session")
+	}
+
+	// The third message is decrypted first so that the first one has to use a skipped message key.
+	tests := []struct {
+		message, plaintext string
+	}{
+		{olmMessage2, olmPlaintext2},
+		{olmMessage0, olmPlaintext0},
+	}
+	for _, test := range tests {
+		plaintext, err := s.Decrypt(MessageTypePreKey, test.message)
+		if err != nil {
+			t.Fatalf("unexpected error decrypting %q: %v", test.plaintext, err)
+		}
+		if string(plaintext) != test.plaintext {
+			t.Errorf("expected %q, got %q", test.plaintext, plaintext)
+		}
+	}
+	if _, err := s.Decrypt(MessageTypePreKey, olmMessage0); !errors.Is(err, ErrUnknownChain) {
+		t.Errorf("expected ErrUnknownChain decrypting a message twice, got %v", err)
+	}
+	if !s.HasReceivedMessage() {
+		t.Error("expected session to have received a message")
+	}
+
+	bob.RemoveOneTimeKeys(s)
+	if _, err := bob.NewInboundSession(olmAliceIdentityKey, olmMessage0); !errors.Is(err, ErrUnknownOneTimeKey) {
+		t.Errorf("expected ErrUnknownOneTimeKey after removing the key, got %v", err)
+	}
+}
+
+func TestSessionPreKeyRejects(t *testing.T) {
+	bob := testBobAccount(t)
+	if _, err := bob.NewInboundSession(EncodeBase64(bobPublic), olmMessage0); !errors.Is(err, ErrSessionMismatch) {
+		t.Errorf("expected ErrSessionMismatch with the wrong identity key, got %v", err)
+	}
+
+	raw, err := DecodeBase64(olmMessage0)
+	if err != nil {
+		t.Fatalf("unexpected error decoding message: %v", err)
+	}
+	// The MAC is at the end of the inner message, which is the last field of the pre-key message.
+	badMAC := append([]byte(nil), raw...)
+	badMAC[len(badMAC)-1] ^= 1
+	badVersion := append([]byte(nil), raw...)
+	badVersion[0] = 2
+
+	s, err := bob.NewInboundSession(olmAliceIdentityKey, olmMessage0)
+	if err != nil {
+		t.Fatalf("unexpected error creating inbound session: %v", err)
+	}
+	if _, err := s.Decrypt(MessageTypePreKey, EncodeBase64(badMAC)); !errors.Is(err, ErrBadMessageMAC) {
+		t.Errorf("expected ErrBadMessageMAC, got %v", err)
+	}
+	if _, err := s.Decrypt(MessageTypePreKey, EncodeBase64(badVersion)); !errors.Is(err, ErrBadMessageVersion) {
+		t.Errorf("expected ErrBadMessageVersion, got %v", err)
+	}
+	if _, err := s.Decrypt(MessageTypeNormal, olmMessage0); !errors.Is(err, ErrBadMessageFormat) {
+		t.Errorf("expected ErrBadMessageFormat decrypting a pre-key message as a normal message, got %v", err)
+	}
+
+	// Failed decryptions must not change the session.
+	plaintext, err := s.Decrypt(MessageTypePreKey, olmMessage0)
+	if err != nil {
+		t.Fatalf("unexpected error decrypting: %v", err)
+	}
+	if string(plaintext) != olmPlaintext0 {
+		t.Errorf("expected %q, got %q", olmPlaintext0, plaintext)
+	}
+}
+
+func TestSessionConversation(t *testing.T) {
+	alice, err := NewAccount()
+	if err != nil {
+		t.Fatalf("unexpected error creating account: %v", err)
+	}
+	bob, err := NewAccount()
+	if err != nil {
+		t.Fatalf("unexpected error creating account: %v", err)
+	}
+	if err := bob.GenerateOneTimeKeys(1); err != nil {
+		t.Fatalf("unexpected error generating one-time keys: %v", err)
+	}
+	var oneTimeKey string
+	for _, v := range bob.OneTimeKeys() {
+		oneTimeKey = v
+	}
+	aliceCurve, _ := alice.IdentityKeys()
+	bobCurve, _ := bob.IdentityKeys()
+
+	aliceSession, err := alice.NewOutboundSession(bobCurve, oneTimeKey)
+	if err != nil {
+		t.Fatalf("unexpected error creating outbound session: %v", err)
+	}
+	msgType, msg, err := aliceSession.Encrypt([]byte("first"))
+	if err != nil || msgType != MessageTypePreKey {
+		t.Fatalf("expected pre-key message, got type %d and error %v", msgType, err)
+	}
+	bobSession, err := bob.NewInboundSession(aliceCurve, msg)
+	if err != nil {
+		t.Fatalf("unexpected error creating inbound session: %v", err)
+	}
+	if aliceSession.ID() != bobSession.ID() {
+		t.Errorf("session IDs differ: %s and %s", aliceSession.ID(), bobSession.ID())
+	}
+
+	// Each reply ratchets the root key.
+	sender, receiver := aliceSession, bobSession
+	for i, plaintext := range []string{"first", "second", "third", "fourth"} {
+		if i > 0 {
+			msgType, msg, err = sender.Encrypt([]byte(plaintext))
+			if err != nil {
+				t.Fatalf("unexpected error encrypting %q: %v", plaintext, err)
+			}
+			if msgType != MessageTypeNormal {
+				t.Errorf("expected %q to be a normal message, got type %d", plaintext, msgType)
+			}
+		}
+		got, err := receiver.Decrypt(msgType, msg)
+		if err != nil {
+			t.Fatalf("unexpected error decrypting %q: %v", plaintext, err)
+		}
+		if string(got) != plaintext {
+			t.Errorf("expected %q, got %q", plaintext, got)
+		}
+		sender, receiver = receiver, sender
+	}
+}
@@ -0,0 +1,127 @@
+package gotrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/chanbakjsd/gotrix/api"
+	"github.com/chanbakjsd/gotrix/encrypt/e2ee"
+	"github.com/chanbakjsd/gotrix/event"
+	"github.com/chanbakjsd/gotrix/matrix"
+)
+
+// EnableEncryption sets up end-to-end encryption for the device the client is logged in as. The key
+// material is loaded from the store if it has been saved before, and the device keys and one-time keys are
+// uploaded to the homeserver. It must be called after logging in.
+func (c *Client) EnableEncryption(store e2ee.Store) error {
+	return c.EnableEncryptionContext(c.Context(), store)
+}
+
+// EnableEncryptionContext is the same as EnableEncryption but takes a context.
+func (c *Client) EnableEncryptionContext(ctx context.Context, store e2ee.Store) error {
+	machine, err := e2ee.NewMachine(c.Client, store)
+	if err != nil {
+		return err
+	}
+	if err := machine.ShareKeys(ctx); err != nil {
+		return fmt.Errorf("error sharing keys: %w", err)
+	}
+	// In-room verification events have to be encrypted in encrypted rooms.
+	machine.SendRoomEvent = c.RoomEventSendContext
+	c.Crypto = machine
+	return nil
+}
+
+// ErrEncryptionNotEnabled is returned when sending an event to an encrypted room before EnableEncryption
+// is called.
+var ErrEncryptionNotEnabled = errors.New("room is encrypted but encryption is not enabled")
+
+// RoomEventSend sends the provided one-off event to the provided room ID.
+// The event is encrypted if the room is encrypted.
+func (c *Client) RoomEventSend(roomID matrix.RoomID, eventType event.Type, body interface{}) (matrix.EventID, error) {
+	return c.RoomEventSendContext(c.Context(), roomID, eventType, body)
+}
+
+// RoomEventSendContext is the same as RoomEventSend but takes a context.
+func (c *Client) RoomEventSendContext(ctx context.Context, roomID matrix.RoomID, eventType event.Type,
+	body interface{}) (matrix.EventID, error) {
+	settings, err := c.RoomEncryptionContext(ctx, roomID)
+	if err != nil {
+		return "", err
+	}
+	if settings == nil {
+		return c.Client.RoomEventSendContext(ctx, roomID, eventType, body)
+	}
+	if c.Crypto == nil {
+		return "", ErrEncryptionNotEnabled
+	}
+
+	users, err := c.encryptionRecipients(ctx, roomID)
+	if err != nil {
+		return "", err
+	}
+	content, err := c.Crypto.EncryptRoomEvent(ctx, roomID, settings, users, eventType, body)
+	if err != nil {
+		return "", fmt.Errorf("error encrypting event: %w", err)
+	}
+	return c.Client.RoomEventSendContext(ctx, roomID, event.TypeRoomEncrypted, content)
+}
+
+// RoomEncryption returns the encryption settings of the room, or nil if the room is not encrypted.
+// The homeserver is queried if the state of the room has not been received in a sync.
+func (c *Client) RoomEncryption(roomID matrix.RoomID) (*event.RoomEncryptionEvent, error) {
+	return c.RoomEncryptionContext(c.Context(), roomID)
+}
+
+// RoomEncryptionContext is the same as RoomEncryption but takes a context.
+func (c *Client) RoomEncryptionContext(ctx context.Context, roomID matrix.RoomID) (*event.RoomEncryptionEvent,
+	error) {
+	e, err := c.State.RoomState(roomID, event.TypeRoomEncryption, "")
+	// The State also returns (nil, nil) for rooms it knows nothing about. The homeserver is asked in that case
+	// so that events are never sent unencrypted to an encrypted room.
+	if err != nil || (e == nil && !c.roomStateKnown(roomID)) {
+		e, err = c.roomStateFromServer(ctx, roomID, event.TypeRoomEncryption, "")
+		if errors.Is(err, matrix.CodeNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	settings, ok := e.(*event.RoomEncryptionEvent)
+	if !ok || settings == nil {
+		return nil, nil
+	}
+	return settings, nil
+}
+
+// roomStateKnown returns true if the State has the state of the room, which is assumed if it has the create event.
+func (c *Client) roomStateKnown(roomID matrix.RoomID) bool {
+	create, err := c.State.RoomState(roomID, event.TypeRoomCreate, "")
+	return err == nil && create != nil
+}
+
+// encryptionRecipients returns the users that should receive the keys to decrypt events sent to the room,
+// which are the joined and invited members.
+func (c *Client) encryptionRecipients(ctx context.Context, roomID matrix.RoomID) ([]matrix.UserID, error) {
+	raws, err := c.Client.RoomMembersContext(ctx, roomID, api.RoomMemberFilter{
+		NotMembership: event.MemberLeft,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]matrix.UserID, 0, len(raws))
+	for _, v := range raws {
+		e, err := event.Parse(v)
+		if err != nil {
+			continue
+		}
+		member, ok := e.(*event.RoomMemberEvent)
+		if ok && (member.NewState == event.MemberJoined || member.NewState == event.MemberInvited) {
+			users = append(users, member.UserID)
+		}
+	}
+	return users, nil
+}
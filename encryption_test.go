@@ -0,0 +1,118 @@
+package gotrix
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/chanbakjsd/gotrix/api/httputil"
+	"github.com/chanbakjsd/gotrix/encrypt/e2ee"
+	"github.com/chanbakjsd/gotrix/matrix"
+)
+
+const testRoomID matrix.RoomID = "!room:example.org"
+
+// newTestClient returns a client talking to a homeserver served by handler.
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	c, err := NewWithClient(httputil.NewClient(), srv.URL)
+	if err != nil {
+		t.Fatalf("unexpected error creating client: %v", err)
+	}
+	c.UserID = "@alice:example.org"
+	c.DeviceID = "ALICE"
+	c.AccessToken = "token"
+	return c
+}
+
+// writeJSON writes v as the response.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// notFound is the response for endpoints that are not served by the test homeserver.
+var notFound = map[string]string{"errcode": "M_NOT_FOUND", "error": "not found"}
+
+func TestRoomEncryption(t *testing.T) {
+	const (
+		create = `{"type":"m.room.create","event_id":"$create","sender":"@bob:example.org","state_key":"",` +
+			`"content":{"room_version":"11"}}`
+		encryption = `{"type":"m.room.encryption","event_id":"$encryption","sender":"@bob:example.org",` +
+			`"state_key":"","content":{"algorithm":"m.megolm.v1.aes-sha2"}}`
+	)
+
+	tests := []struct {
+		name      string
+		state     []string
+		status    int
+		response  interface{}
+		encrypted bool
+		wantErr   bool
+		requested bool
+	}{
+		{
+			name:      "encrypted in state",
+			state:     []string{create, encryption},
+			encrypted: true,
+		},
+		{
+			name:  "unencrypted in state",
+			state: []string{create},
+		},
+		{
+			name:      "unknown encrypted room",
+			status:    http.StatusOK,
+			response:  map[string]string{"algorithm": e2ee.AlgorithmMegolm},
+			encrypted: true,
+			requested: true,
+		},
+		{
+			name:      "unknown unencrypted room",
+			status:    http.StatusNotFound,
+			response:  notFound,
+			requested: true,
+		},
+		{
+			name:      "unknown room with server error",
+			status:    http.StatusInternalServerError,
+			response:  map[string]string{"errcode": "M_UNKNOWN", "error": "unknown"},
+			wantErr:   true,
+			requested: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var requested bool
+			c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				if !strings.HasSuffix(r.URL.Path, "/state/m.room.encryption/") {
+					writeJSON(w, http.StatusNotFound, notFound)
+					return
+				}
+				requested = true
+				writeJSON(w, test.status, test.response)
+			})
+			c.State = newPushTestState(t, test.state...)
+
+			settings, err := c.RoomEncryption(testRoomID)
+			switch {
+			case test.wantErr && err == nil:
+				t.Fatalf("expected error")
+			case !test.wantErr && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if (settings != nil) != test.encrypted {
+				t.Errorf("expected encrypted to be %t, got settings %v", test.encrypted, settings)
+			}
+			if requested != test.requested {
+				t.Errorf("expected homeserver query to be %t, got %t", test.requested, requested)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package event
+
+import (
+	"encoding/json"
+
+	"github.com/chanbakjsd/gotrix/matrix"
+)
+
+var (
+	_ StateEvent    = &RoomEncryptionEvent{}
+	_ RoomEvent     = &RoomEncryptedEvent{}
+	_ RoomEvent     = &UndecryptableEvent{}
+	_ ToDeviceEvent = &RoomKeyEvent{}
+	_ ToDeviceEvent = &ForwardedRoomKeyEvent{}
+	_ ToDeviceEvent = &RoomKeyRequestEvent{}
+	_ ToDeviceEvent = &RoomKeyWithheldEvent{}
+)
+
+// RoomEncryptionEvent is an event that enables end-to-end encryption in a room.
+// Encryption cannot be disabled once it is enabled.
+type RoomEncryptionEvent struct {
+	StateEventInfo `json:"-"`
+
+	// Algorithm is the algorithm used to encrypt messages in the room. It is "m.megolm.v1.aes-sha2".
+	Algorithm string `json:"algorithm"`
+	// RotationPeriod is how long a session should be used before it is changed. Defaults to a week.
+	RotationPeriod matrix.Duration `json:"rotation_period_ms,omitempty"`
+	// RotationPeriodMessages is how many messages should be sent using a session before it is changed.
+	// Defaults to 100.
+	RotationPeriodMessages int `json:"rotation_period_msgs,omitempty"`
+}
+
+// RoomEncryptedEvent is an encrypted event. It is sent to rooms encrypted with Megolm and to devices
+// encrypted with Olm.
+type RoomEncryptedEvent struct {
+	RoomEventInfo `json:"-"`
+
+	Algorithm string `json:"algorithm"`
+	// SenderKey is the Curve25519 identity key of the sending device.
+	SenderKey string `json:"sender_key,omitempty"`
+	// Ciphertext is a string for Megolm and a map from the Curve25519 identity key of the recipient devices to
+	// OlmCiphertext for Olm.
+	Ciphertext json.RawMessage `json:"ciphertext"`
+	// SessionID and DeviceID are only present for Megolm.
+	SessionID string          `json:"session_id,omitempty"`
+	DeviceID  matrix.DeviceID `json:"device_id,omitempty"`
+}
+
+// OlmCiphertext is an Olm message for a device.
+type OlmCiphertext struct {
+	Type int    `json:"type"`
+	Body string `json:"body"`
+}
+
+// UndecryptableEvent is passed to handlers instead of the decrypted event when a RoomEncryptedEvent cannot be
+// decrypted.
+type UndecryptableEvent struct {
+	RoomEncryptedEvent
+
+	// Err is the reason the event could not be decrypted.
+	Err error
+}
+
+// RoomKeyEvent is a to-device event containing a Megolm session key. It is always encrypted with Olm.
+type RoomKeyEvent struct {
+	ToDeviceEventInfo `json:"-"`
+
+	Algorithm  string        `json:"algorithm"`
+	RoomID     matrix.RoomID `json:"room_id"`
+	SessionID  string        `json:"session_id"`
+	SessionKey string        `json:"session_key"`
+}
+
+// ForwardedRoomKeyEvent is a to-device event containing a Megolm session key forwarded by a device other than
+// the one that created the session. It is always encrypted with Olm.
+type ForwardedRoomKeyEvent struct {
+	ToDeviceEventInfo `json:"-"`
+
+	Algorithm  string        `json:"algorithm"`
+	RoomID     matrix.RoomID `json:"room_id"`
+	SessionID  string        `json:"session_id"`
+	SessionKey string        `json:"session_key"`
+	// SenderKey is the Curve25519 identity key of the device that created the session.
+	SenderKey string `json:"sender_key"`
+	// SenderClaimedSigningKey is the Ed25519 key the device that created the session claims to own.
+	SenderClaimedSigningKey string `json:"sender_claimed_ed25519_key"`
+	// ForwardingChain contains the Curve25519 identity keys of the devices the key went through, excluding
+	// the device that sent this event.
+	ForwardingChain []string `json:"forwarding_curve25519_key_chain"`
+}
+
+// RoomKeyRequestAction is the action of a RoomKeyRequestEvent.
+type RoomKeyRequestAction string
+
+// List of room key request actions defined in the spec.
+const (
+	RoomKeyRequestActionRequest      RoomKeyRequestAction = "request"
+	RoomKeyRequestActionCancellation RoomKeyRequestAction = "request_cancellation"
+)
+
+// RoomKeyRequestEvent is a to-device event sent by a device to request a Megolm session key it is missing, or
+// to cancel a previous request.
+type RoomKeyRequestEvent struct {
+	ToDeviceEventInfo `json:"-"`
+
+	Action RoomKeyRequestAction `json:"action"`
+	// Body is the requested session. It is only present in requests.
+	Body               *RoomKeyRequestBody `json:"body,omitempty"`
+	RequestingDeviceID matrix.DeviceID     `json:"requesting_device_id"`
+	RequestID          string              `json:"request_id"`
+}
+
+// RoomKeyRequestBody identifies the session requested in a RoomKeyRequestEvent.
+type RoomKeyRequestBody struct {
+	Algorithm string        `json:"algorithm"`
+	RoomID    matrix.RoomID `json:"room_id"`
+	// SenderKey is the Curve25519 identity key of the device that created the session.
+	SenderKey string `json:"sender_key"`
+	SessionID string `json:"session_id"`
+}
+
+// RoomKeyWithheldCode is the reason a Megolm session key is withheld.
+type RoomKeyWithheldCode string
+
+// List of withheld codes defined in the spec.
+const (
+	RoomKeyWithheldBlacklisted  RoomKeyWithheldCode = "m.blacklisted"
+	RoomKeyWithheldUnverified   RoomKeyWithheldCode = "m.unverified"
+	RoomKeyWithheldUnauthorised RoomKeyWithheldCode = "m.unauthorised"
+	RoomKeyWithheldUnavailable  RoomKeyWithheldCode = "m.unavailable"
+	RoomKeyWithheldNoOlm        RoomKeyWithheldCode = "m.no_olm"
+)
+
+// RoomKeyWithheldEvent is a to-device event sent to a device to let it know that it will not receive a Megolm
+// session key, either when the session is shared or in response to a RoomKeyRequestEvent.
+type RoomKeyWithheldEvent struct {
+	ToDeviceEventInfo `json:"-"`
+
+	Algorithm string              `json:"algorithm"`
+	Code      RoomKeyWithheldCode `json:"code"`
+	// Reason is a human-readable description of Code.
+	Reason    string        `json:"reason,omitempty"`
+	RoomID    matrix.RoomID `json:"room_id,omitempty"`
+	SessionID string        `json:"session_id,omitempty"`
+	// SenderKey is the Curve25519 identity key of the device that created the session, or of the sender
+	// for m.no_olm.
+	SenderKey string `json:"sender_key"`
+	// FromDevice is the device ID of the sender. It is only present for m.no_olm.
+	FromDevice matrix.DeviceID `json:"from_device,omitempty"`
+}
+
+// TrustState is how much the device that sent an encrypted event is trusted.
+type TrustState int
+
+// List of trust states, from the least trusted to the most trusted.
+const (
+	// TrustUnknown means that the key used to decrypt the event was not received from the device that
+	// created it, or that the device is unknown.
+	TrustUnknown TrustState = iota
+	// TrustUnverified means that the event was sent by the device but the device has not been verified.
+	TrustUnverified
+	// TrustVerified means that the event was sent by a verified device.
+	TrustVerified
+)
+
+// EncryptionInfo describes how a decrypted event was encrypted.
+type EncryptionInfo struct {
+	Algorithm string
+	// SenderKey is the Curve25519 identity key of the device that sent the event.
+	SenderKey string
+	// SenderSigningKey is the Ed25519 identity key the device that sent the event claims to own.
+	SenderSigningKey string
+	// SessionID is the ID of the Megolm session the event was encrypted with.
+	SessionID string
+	Trust     TrustState
+	// Encrypted is the event before it was decrypted.
+	Encrypted *RoomEncryptedEvent
+}
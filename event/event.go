@@ -20,6 +20,8 @@ type EventInfo struct {
 	// Raw is the raw event as the event was received.
 	Raw  RawEvent `json:"-"`
 	Type Type     `json:"type"`
+	// Encryption is set if the event has been decrypted from an m.room.encrypted event.
+	Encryption *EncryptionInfo `json:"-"`
 }
 
 // EventInfo returns itself so it can be embedded into a struct and allow the struct to implement Event.
@@ -59,6 +61,19 @@ func (r *RoomEventInfo) RoomInfo() *RoomEventInfo {
 	return r
 }
 
+// ToDeviceEventInfo contains information present in all to-device events.
+type ToDeviceEventInfo struct {
+	EventInfo
+
+	Sender matrix.UserID `json:"sender,omitempty"`
+}
+
+// ToDeviceInfo returns itself so it can be embedded into a struct and allow the struct to implement
+// ToDeviceEvent.
+func (t *ToDeviceEventInfo) ToDeviceInfo() *ToDeviceEventInfo {
+	return t
+}
+
 // StateEventInfo contains information present in all state events.
 type StateEventInfo struct {
 	RoomEventInfo
@@ -84,6 +99,12 @@ type RoomEvent interface {
 	RoomInfo() *RoomEventInfo
 }
 
+// ToDeviceEvent is an event sent directly to a device instead of a room.
+type ToDeviceEvent interface {
+	Info() *EventInfo
+	ToDeviceInfo() *ToDeviceEventInfo
+}
+
 // StateEvent is an event that records the change of a state.
 type StateEvent interface {
 	Info() *EventInfo
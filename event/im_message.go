@@ -78,7 +78,7 @@ type FileInfo struct {
 	MimeType      string        `json:"mimetype,omitempty"`       // MIME type of image.
 	Size          int           `json:"size,omitempty"`           // Size in bytes.
 	ThumbnailURL  matrix.URL    `json:"thumbnail_url,omitempty"`  // Present if thumbnail is un-encrypted.
-	ThumbnailFile encrypt.File  `json:"thumbnail_file,omitempty"` // Present if thumbnail is encrypted.
+	ThumbnailFile *encrypt.File `json:"thumbnail_file,omitempty"` // Present if thumbnail is encrypted.
 	ThumbnailInfo ThumbnailInfo `json:"thumbnail_info,omitempty"`
 }
 
@@ -89,19 +89,22 @@ type ImageInfo struct {
 	// Intended display size of image. Present if RoomMessageFileInfo is part of RoomMessageImage.
 	Height int `json:"h,omitempty"`
 	Width  int `json:"w,omitempty"`
+
+	// BlurHash is the BlurHash of the image that can be displayed while it is loading (MSC2448).
+	BlurHash string `json:"xyz.amorgan.blurhash,omitempty"`
 }
 
 // AudioInfo stores the info of an audio.
 type AudioInfo struct {
-	Duration int    // Duration of audio in millisecond.
-	MimeType string // MIME type of audio.
-	Size     int    // Size in bytes.
+	Duration int    `json:"duration,omitempty"` // Duration of audio in millisecond.
+	MimeType string `json:"mimetype,omitempty"` // MIME type of audio.
+	Size     int    `json:"size,omitempty"`     // Size in bytes.
 }
 
 // LocationInfo stores the info of a location.
 type LocationInfo struct {
 	ThumbnailURL  matrix.URL    `json:"thumbnail_url,omitempty"`  // Present if thumbnail is un-encrypted.
-	ThumbnailFile encrypt.File  `json:"thumbnail_file,omitempty"` // Present if thumbnail is encrypted.
+	ThumbnailFile *encrypt.File `json:"thumbnail_file,omitempty"` // Present if thumbnail is encrypted.
 	ThumbnailInfo ThumbnailInfo `json:"thumbnail_info,omitempty"`
 }
 
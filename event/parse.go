@@ -70,7 +70,8 @@ func Parse(r RawEvent) (Event, error) {
 	return concrete, nil
 }
 
-// fillInfo is a helper function that backfills EventInfo/RoomEventInfo/StateEventInfo into the provided event.
+// fillInfo is a helper function that backfills EventInfo/RoomEventInfo/StateEventInfo/ToDeviceEventInfo into the
+// provided event.
 func fillInfo(raw RawEvent, v Event) (Event, error) {
 	switch e := v.(type) {
 	case StateEvent:
@@ -83,6 +84,11 @@ func fillInfo(raw RawEvent, v Event) (Event, error) {
 		if err != nil {
 			return nil, err
 		}
+	case ToDeviceEvent:
+		err := json.Unmarshal(raw, e.ToDeviceInfo())
+		if err != nil {
+			return nil, err
+		}
 	default:
 		err := json.Unmarshal(raw, e.Info())
 		if err != nil {
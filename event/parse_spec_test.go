@@ -1549,5 +1549,47 @@ var specExamples = []struct {
 		},
 	},
 
+	{
+		Name: "m.room.encryption",
+		Code: `
+			{
+				"content": {
+					"algorithm": "m.megolm.v1.aes-sha2",
+					"rotation_period_ms": 604800000,
+					"rotation_period_msgs": 100
+				},
+				"event_id": "$143273582443PhrSn:example.org",
+				"origin_server_ts": 1432735824653,
+				"room_id": "!jEsUZKDJdhlrceRyVU:example.org",
+				"sender": "@example:example.org",
+				"state_key": "",
+				"type": "m.room.encryption",
+				"unsigned": {
+					"age": 1234
+				}
+			}
+		`,
+		Expected: &RoomEncryptionEvent{
+			StateEventInfo: StateEventInfo{
+				RoomEventInfo: RoomEventInfo{
+					EventInfo: EventInfo{
+						Type: TypeRoomEncryption,
+					},
+					ID:               "$143273582443PhrSn:example.org",
+					OriginServerTime: 1432735824653,
+					RoomID:           "!jEsUZKDJdhlrceRyVU:example.org",
+					Sender:           "@example:example.org",
+					Unsigned: UnsignedData{
+						Age: 1234,
+					},
+				},
+				StateKey: "",
+			},
+			Algorithm:              "m.megolm.v1.aes-sha2",
+			RotationPeriod:         604800000,
+			RotationPeriodMessages: 100,
+		},
+	},
+
 	// TODO: Add other event types
 }
@@ -10,7 +10,7 @@ type PushRulesEvent struct {
 }
 
 // PushNotifyMessage returns true if the message should be notified by the ruleset. Currently, only
-// the message body is matched.
+// the message body is matched. gotrix.PushEvaluator evaluates the whole ruleset.
 func PushNotifyMessage(ruleset matrix.PushRuleset, message *RoomMessageEvent) (matrix.PushRule, bool) {
 	rule, ok := ruleset.Override.EventMatch(map[string]string{
 		"content.body":           message.Body,
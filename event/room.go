@@ -32,7 +32,8 @@ type RoomCanonicalAliasEvent struct {
 type RoomCreateEvent struct {
 	StateEventInfo `json:"-"`
 
-	// The user ID of the room creator. This is set by the homeserver.
+	// The user ID of the room creator. This is set by the homeserver. It is empty from room version 11
+	// onwards, where the creator is the sender of the event.
 	Creator matrix.UserID `json:"creator"`
 	// Whether users from other servers can join. Defaults to true.
 	Federated *bool `json:"m.federate,omitempty"`
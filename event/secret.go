@@ -0,0 +1,40 @@
+package event
+
+import (
+	"github.com/chanbakjsd/gotrix/matrix"
+)
+
+var (
+	_ ToDeviceEvent = &SecretRequestEvent{}
+	_ ToDeviceEvent = &SecretSendEvent{}
+)
+
+// SecretRequestAction is the action of a SecretRequestEvent.
+type SecretRequestAction string
+
+// List of secret request actions defined in the spec.
+const (
+	SecretRequestActionRequest      SecretRequestAction = "request"
+	SecretRequestActionCancellation SecretRequestAction = "request_cancellation"
+)
+
+// SecretRequestEvent is a to-device event sent by a device to the other devices of the user to request a
+// secret, or to cancel a previous request.
+type SecretRequestEvent struct {
+	ToDeviceEventInfo `json:"-"`
+
+	Action SecretRequestAction `json:"action"`
+	// Name is the name of the requested secret. It is only present in requests.
+	Name               string          `json:"name,omitempty"`
+	RequestingDeviceID matrix.DeviceID `json:"requesting_device_id"`
+	RequestID          string          `json:"request_id"`
+}
+
+// SecretSendEvent is a to-device event sent in response to a SecretRequestEvent. It is always encrypted with
+// Olm.
+type SecretSendEvent struct {
+	ToDeviceEventInfo `json:"-"`
+
+	RequestID string `json:"request_id"`
+	Secret    string `json:"secret"`
+}
@@ -54,6 +54,28 @@ const (
 
 	// Events from the Push Notifications module.
 	TypePushRules Type = "m.push_rules"
+
+	// Events from the End-to-End Encryption module.
+	TypeRoomEncryption   Type = "m.room.encryption"
+	TypeRoomEncrypted    Type = "m.room.encrypted"
+	TypeRoomKey          Type = "m.room_key"
+	TypeForwardedRoomKey Type = "m.forwarded_room_key"
+	TypeRoomKeyRequest   Type = "m.room_key_request"
+	TypeRoomKeyWithheld  Type = "m.room_key.withheld"
+
+	// Events from the Key Verification framework.
+	TypeVerificationRequest Type = "m.key.verification.request"
+	TypeVerificationReady   Type = "m.key.verification.ready"
+	TypeVerificationStart   Type = "m.key.verification.start"
+	TypeVerificationAccept  Type = "m.key.verification.accept"
+	TypeVerificationKey     Type = "m.key.verification.key"
+	TypeVerificationMAC     Type = "m.key.verification.mac"
+	TypeVerificationDone    Type = "m.key.verification.done"
+	TypeVerificationCancel  Type = "m.key.verification.cancel"
+
+	// Events from the Secrets module.
+	TypeSecretRequest Type = "m.secret.request"
+	TypeSecretSend    Type = "m.secret.send"
 )
 
 var parser = map[Type]func(RawEvent, json.RawMessage) (Event, error){
@@ -92,4 +114,23 @@ var parser = map[Type]func(RawEvent, json.RawMessage) (Event, error){
 	TypeRoomTombstone: defaultParse(func() Event { return new(RoomTombstoneEvent) }),
 
 	TypePushRules: defaultParse(func() Event { return new(PushRulesEvent) }),
+
+	TypeRoomEncryption:   defaultParse(func() Event { return new(RoomEncryptionEvent) }),
+	TypeRoomEncrypted:    defaultParse(func() Event { return new(RoomEncryptedEvent) }),
+	TypeRoomKey:          defaultParse(func() Event { return new(RoomKeyEvent) }),
+	TypeForwardedRoomKey: defaultParse(func() Event { return new(ForwardedRoomKeyEvent) }),
+	TypeRoomKeyRequest:   defaultParse(func() Event { return new(RoomKeyRequestEvent) }),
+	TypeRoomKeyWithheld:  defaultParse(func() Event { return new(RoomKeyWithheldEvent) }),
+
+	TypeVerificationRequest: defaultParse(func() Event { return new(VerificationRequestEvent) }),
+	TypeVerificationReady:   defaultParse(func() Event { return new(VerificationReadyEvent) }),
+	TypeVerificationStart:   defaultParse(func() Event { return new(VerificationStartEvent) }),
+	TypeVerificationAccept:  defaultParse(func() Event { return new(VerificationAcceptEvent) }),
+	TypeVerificationKey:     defaultParse(func() Event { return new(VerificationKeyEvent) }),
+	TypeVerificationMAC:     defaultParse(func() Event { return new(VerificationMACEvent) }),
+	TypeVerificationDone:    defaultParse(func() Event { return new(VerificationDoneEvent) }),
+	TypeVerificationCancel:  defaultParse(func() Event { return new(VerificationCancelEvent) }),
+
+	TypeSecretRequest: defaultParse(func() Event { return new(SecretRequestEvent) }),
+	TypeSecretSend:    defaultParse(func() Event { return new(SecretSendEvent) }),
 }
@@ -0,0 +1,165 @@
+package event
+
+import (
+	"github.com/chanbakjsd/gotrix/matrix"
+)
+
+var (
+	_ RoomEvent = &VerificationRequestEvent{}
+	_ RoomEvent = &VerificationReadyEvent{}
+	_ RoomEvent = &VerificationStartEvent{}
+	_ RoomEvent = &VerificationAcceptEvent{}
+	_ RoomEvent = &VerificationKeyEvent{}
+	_ RoomEvent = &VerificationMACEvent{}
+	_ RoomEvent = &VerificationDoneEvent{}
+	_ RoomEvent = &VerificationCancelEvent{}
+)
+
+// Key verification events are sent both as to-device events and as room events. They embed RoomEventInfo so
+// that in-room verifications have their event ID and room ID. Only Sender is set for to-device verifications.
+
+// RoomMessageVerificationRequest is the message type of a RoomMessageEvent that requests an in-room key
+// verification. Its content is a VerificationRequestEvent.
+const RoomMessageVerificationRequest MessageType = "m.key.verification.request"
+
+// VerificationRelatesTo is the relation of in-room verification events to the request that started the
+// verification.
+type VerificationRelatesTo struct {
+	// RelType is always "m.reference".
+	RelType string         `json:"rel_type"`
+	EventID matrix.EventID `json:"event_id"`
+}
+
+// VerificationFlow identifies the verification an event belongs to. TransactionID is set for to-device
+// verifications while RelatesTo is set for in-room verifications.
+type VerificationFlow struct {
+	TransactionID string                 `json:"transaction_id,omitempty"`
+	RelatesTo     *VerificationRelatesTo `json:"m.relates_to,omitempty"`
+}
+
+// FlowID returns the transaction ID of the verification, which is the event ID of the request for in-room
+// verifications.
+func (f VerificationFlow) FlowID() string {
+	if f.RelatesTo != nil {
+		return string(f.RelatesTo.EventID)
+	}
+	return f.TransactionID
+}
+
+// VerificationRequestEvent is a to-device event requesting a key verification.
+//
+// In-room requests are RoomMessageEvent with the RoomMessageVerificationRequest message type instead.
+// Their content can be parsed into this struct.
+type VerificationRequestEvent struct {
+	RoomEventInfo `json:"-"`
+	VerificationFlow
+
+	FromDevice matrix.DeviceID `json:"from_device"`
+	// Methods are the verification methods supported by the sender.
+	Methods []string `json:"methods"`
+	// Timestamp is when the request was made. It is only present in to-device requests.
+	Timestamp matrix.Timestamp `json:"timestamp,omitempty"`
+
+	// These fields are only present in in-room requests.
+	Body        string        `json:"body,omitempty"`
+	MessageType MessageType   `json:"msgtype,omitempty"`
+	To          matrix.UserID `json:"to,omitempty"`
+}
+
+// VerificationReadyEvent is sent in response to a VerificationRequestEvent to accept the request.
+type VerificationReadyEvent struct {
+	RoomEventInfo `json:"-"`
+	VerificationFlow
+
+	FromDevice matrix.DeviceID `json:"from_device"`
+	// Methods are the verification methods supported by the sender that are also supported by the device
+	// that sent the request.
+	Methods []string `json:"methods"`
+}
+
+// VerificationStartEvent begins a key verification using the provided method.
+type VerificationStartEvent struct {
+	RoomEventInfo `json:"-"`
+	VerificationFlow
+
+	FromDevice matrix.DeviceID `json:"from_device"`
+	Method     string          `json:"method"`
+
+	// These fields are present if Method is "m.sas.v1".
+	KeyAgreementProtocols      []string `json:"key_agreement_protocols,omitempty"`
+	Hashes                     []string `json:"hashes,omitempty"`
+	MessageAuthenticationCodes []string `json:"message_authentication_codes,omitempty"`
+	ShortAuthenticationString  []string `json:"short_authentication_string,omitempty"`
+
+	// Secret is the shared secret of the scanned QR code in base64. It is present if Method is
+	// "m.reciprocate.v1".
+	Secret string `json:"secret,omitempty"`
+}
+
+// VerificationAcceptEvent is sent in response to a SAS VerificationStartEvent to pick the algorithms used.
+type VerificationAcceptEvent struct {
+	RoomEventInfo `json:"-"`
+	VerificationFlow
+
+	Method                    string   `json:"method"`
+	KeyAgreementProtocol      string   `json:"key_agreement_protocol"`
+	Hash                      string   `json:"hash"`
+	MessageAuthenticationCode string   `json:"message_authentication_code"`
+	ShortAuthenticationString []string `json:"short_authentication_string"`
+	// Commitment is the hash of the ephemeral public key of the sender and the canonical JSON of the content
+	// of the VerificationStartEvent in base64.
+	Commitment string `json:"commitment"`
+}
+
+// VerificationKeyEvent contains the ephemeral public key of the sender for SAS verification.
+type VerificationKeyEvent struct {
+	RoomEventInfo `json:"-"`
+	VerificationFlow
+
+	Key string `json:"key"`
+}
+
+// VerificationMACEvent contains the MACs of the keys the sender wants to be verified.
+type VerificationMACEvent struct {
+	RoomEventInfo `json:"-"`
+	VerificationFlow
+
+	// MAC contains the MACs of the keys, indexed by their key ID ("ed25519:DEVICEID").
+	MAC map[string]string `json:"mac"`
+	// Keys is the MAC of the comma-separated, sorted list of the key IDs in MAC.
+	Keys string `json:"keys"`
+}
+
+// VerificationDoneEvent is sent once the sender has successfully verified the other party.
+type VerificationDoneEvent struct {
+	RoomEventInfo `json:"-"`
+	VerificationFlow
+}
+
+// VerificationCancelCode is the reason a verification is cancelled.
+type VerificationCancelCode string
+
+// List of verification cancel codes defined in the spec.
+const (
+	VerificationCancelUser               VerificationCancelCode = "m.user"
+	VerificationCancelTimeout            VerificationCancelCode = "m.timeout"
+	VerificationCancelUnknownTransaction VerificationCancelCode = "m.unknown_transaction"
+	VerificationCancelUnknownMethod      VerificationCancelCode = "m.unknown_method"
+	VerificationCancelUnexpectedMessage  VerificationCancelCode = "m.unexpected_message"
+	VerificationCancelKeyMismatch        VerificationCancelCode = "m.key_mismatch"
+	VerificationCancelUserMismatch       VerificationCancelCode = "m.user_mismatch"
+	VerificationCancelInvalidMessage     VerificationCancelCode = "m.invalid_message"
+	VerificationCancelAccepted           VerificationCancelCode = "m.accepted"
+	VerificationCancelMismatchedSAS      VerificationCancelCode = "m.mismatched_sas"
+	VerificationCancelMismatchedCommit   VerificationCancelCode = "m.mismatched_commitment"
+)
+
+// VerificationCancelEvent cancels a key verification.
+type VerificationCancelEvent struct {
+	RoomEventInfo `json:"-"`
+	VerificationFlow
+
+	Code VerificationCancelCode `json:"code"`
+	// Reason is a human-readable description of Code.
+	Reason string `json:"reason"`
+}
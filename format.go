@@ -1,6 +1,7 @@
 package gotrix
 
 import (
+	"context"
 	"fmt"
 	"html"
 	"io"
@@ -15,7 +16,13 @@ import (
 // Adhering to the spec, the spoiler text is uploaded to MXC as a plaintext to be included in the
 // body. This function should therefore not be used in encrypted rooms to prevent leaks.
 func (c *Client) FormatSpoiler(reason string, spoilerText string) (body string, formatted string, _ error) {
-	url, err := c.MediaUpload("text/plain", "spoiler.txt", io.NopCloser(strings.NewReader(spoilerText)))
+	return c.FormatSpoilerContext(c.Context(), reason, spoilerText)
+}
+
+// FormatSpoilerContext is the same as FormatSpoiler but takes a context.
+func (c *Client) FormatSpoilerContext(ctx context.Context, reason string,
+	spoilerText string) (body string, formatted string, _ error) {
+	url, err := c.MediaUploadContext(ctx, "text/plain", "spoiler.txt", io.NopCloser(strings.NewReader(spoilerText)))
 	if err != nil {
 		return "", "", err
 	}
@@ -32,7 +39,13 @@ func (c *Client) FormatSpoiler(reason string, spoilerText string) (body string,
 
 // MentionUser creates the intended mention format for a user in normal body and formatted body.
 func (c *Client) MentionUser(userID matrix.UserID, roomID matrix.RoomID) (body string, formatted string) {
-	e, _ := c.RoomState(roomID, event.TypeRoomMember, string(userID))
+	return c.MentionUserContext(c.Context(), userID, roomID)
+}
+
+// MentionUserContext is the same as MentionUser but takes a context.
+func (c *Client) MentionUserContext(ctx context.Context, userID matrix.UserID,
+	roomID matrix.RoomID) (body string, formatted string) {
+	e, _ := c.RoomStateContext(ctx, roomID, event.TypeRoomMember, string(userID))
 	if e == nil {
 		return formatMention(string(userID), string(userID))
 	}
@@ -47,7 +60,12 @@ func (c *Client) MentionUser(userID matrix.UserID, roomID matrix.RoomID) (body s
 
 // MentionRoom creates the intended mention format for a room in normal body and formatted body.
 func (c *Client) MentionRoom(roomID matrix.RoomID) (body string, formatted string) {
-	e, _ := c.RoomState(roomID, event.TypeRoomCanonicalAlias, "")
+	return c.MentionRoomContext(c.Context(), roomID)
+}
+
+// MentionRoomContext is the same as MentionRoom but takes a context.
+func (c *Client) MentionRoomContext(ctx context.Context, roomID matrix.RoomID) (body string, formatted string) {
+	e, _ := c.RoomStateContext(ctx, roomID, event.TypeRoomCanonicalAlias, "")
 	if e != nil {
 		aliasEvent := e.(*event.RoomCanonicalAliasEvent)
 		if aliasEvent.Alias != "" {
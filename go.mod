@@ -1,5 +1,11 @@
 module github.com/chanbakjsd/gotrix
 
-go 1.16
+go 1.20
 
 require github.com/fatih/color v1.10.0
+
+require (
+	github.com/mattn/go-colorable v0.1.8 // indirect
+	github.com/mattn/go-isatty v0.0.12 // indirect
+	golang.org/x/sys v0.0.0-20200223170610-d5e6a3e2c0ae // indirect
+)
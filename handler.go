@@ -24,27 +24,48 @@ func (c *Client) AddHandler(function interface{}) error {
 
 type defaultHandler struct {
 	mut        sync.RWMutex
-	handlers   map[event.Type][]reflect.Value
+	handlers   map[reflect.Type][]handlerFunc
 	rawHandler []reflect.Value
 }
 
-func (d *defaultHandler) Handle(cli *Client, event event.Event) {
-	debug.Debug("new event: " + event.Info().Type)
+// handlerFunc is a function added through AddHandler.
+type handlerFunc struct {
+	fn reflect.Value
+	// deref is true if the function takes the event struct instead of a pointer to it.
+	deref bool
+}
+
+var (
+	eventInterface = reflect.TypeOf((*event.Event)(nil)).Elem()
+	rawEventType   = reflect.TypeOf(event.RawEvent(nil))
+)
+
+func (d *defaultHandler) Handle(cli *Client, e event.Event) {
+	cli.Logger.Debug("new event", debug.Any("type", e.Info().Type))
 
 	d.mut.RLock()
 	defer d.mut.RUnlock()
 
-	handlers, ok := d.handlers[event.Info().Type]
-	if !ok {
-		return
-	}
-	for _, v := range handlers {
-		go v.Call([]reflect.Value{reflect.ValueOf(cli), reflect.ValueOf(event)})
+	// Handlers are looked up by the Go type of the event instead of its event type as decrypted events,
+	// event.RoomEncryptedEvent and event.UndecryptableEvent can share the same event type.
+	concrete := reflect.TypeOf(e)
+	for typ, handlers := range d.handlers {
+		// Handlers taking an interface are called for every event implementing it.
+		if typ != concrete && (typ.Kind() != reflect.Interface || !concrete.Implements(typ)) {
+			continue
+		}
+		for _, v := range handlers {
+			arg := reflect.ValueOf(e)
+			if v.deref {
+				arg = arg.Elem()
+			}
+			go v.fn.Call([]reflect.Value{reflect.ValueOf(cli), arg})
+		}
 	}
 }
 
 func (d *defaultHandler) HandleRaw(cli *Client, event event.RawEvent) {
-	debug.Debug("new raw event")
+	cli.Logger.Trace("new raw event")
 
 	d.mut.RLock()
 	defer d.mut.RUnlock()
@@ -54,6 +75,9 @@ func (d *defaultHandler) HandleRaw(cli *Client, event event.RawEvent) {
 	}
 }
 
+// AddHandler adds a handler that takes the client and either an event.RawEvent, an event type (such as
+// *event.RoomMessageEvent or event.RoomMessageEvent), or an interface implemented by events (such as
+// event.RoomEvent).
 func (d *defaultHandler) AddHandler(function interface{}) error {
 	typ := reflect.TypeOf(function)
 	val := reflect.ValueOf(function)
@@ -70,33 +94,35 @@ func (d *defaultHandler) AddHandler(function interface{}) error {
 		return fmt.Errorf("AddHandler: expected func(*Client, EventType), got %T instead", function)
 	}
 
-	contentInterface := reflect.Zero(typ.In(1)).Interface()
-	if _, ok := contentInterface.(event.RawEvent); ok {
+	eventType := typ.In(1)
+	if eventType == rawEventType {
 		d.mut.Lock()
 		defer d.mut.Unlock()
 
 		d.rawHandler = append(d.rawHandler, val)
-		debug.Debug("added raw handler")
+		debug.Default().Debug("added raw handler")
 		return nil
 	}
 
-	content, ok := contentInterface.(event.Event)
-	if !ok {
-		return fmt.Errorf(
-			"AddHandler: invalid function input, expected function to take event, takes %T instead",
-			contentInterface,
-		)
+	handler := handlerFunc{fn: val}
+	if !eventType.Implements(eventInterface) {
+		// Events implement event.Event with a pointer receiver so the struct itself does not.
+		if eventType.Kind() != reflect.Struct || !reflect.PtrTo(eventType).Implements(eventInterface) {
+			return fmt.Errorf(
+				"AddHandler: invalid function input, expected function to take event, takes %s instead",
+				eventType,
+			)
+		}
+		eventType = reflect.PtrTo(eventType)
+		handler.deref = true
 	}
 
-	// Get event type
-	eventType := content.Info().Type
-
 	d.mut.Lock()
 	defer d.mut.Unlock()
 
 	// Add it to the list of handlers
-	d.handlers[eventType] = append(d.handlers[eventType], val)
+	d.handlers[eventType] = append(d.handlers[eventType], handler)
 
-	debug.Debug("added handler: event=" + eventType)
+	debug.Default().Debug("added handler", debug.Any("type", eventType.String()))
 	return nil
 }
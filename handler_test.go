@@ -0,0 +1,69 @@
+package gotrix
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/chanbakjsd/gotrix/api"
+	"github.com/chanbakjsd/gotrix/event"
+)
+
+func TestHandlerDispatchesUndecryptableSeparately(t *testing.T) {
+	cli := &Client{
+		Client: &api.Client{},
+		Handler: &defaultHandler{
+			handlers: make(map[reflect.Type][]handlerFunc),
+		},
+	}
+
+	called := make(chan string, 4)
+	handlers := []interface{}{
+		func(_ *Client, _ *event.RoomEncryptedEvent) { called <- "encrypted" },
+		func(_ *Client, _ *event.UndecryptableEvent) { called <- "undecryptable" },
+		func(_ *Client, _ event.RoomEvent) { called <- "room" },
+	}
+	for _, v := range handlers {
+		if err := cli.AddHandler(v); err != nil {
+			t.Fatalf("unexpected error adding handler: %v", err)
+		}
+	}
+
+	// Both events have the type m.room.encrypted.
+	encryptedEvent := &event.RoomEncryptedEvent{}
+	encryptedEvent.Type = event.TypeRoomEncrypted
+	undecryptableEvent := &event.UndecryptableEvent{
+		RoomEncryptedEvent: *encryptedEvent,
+		Err:                errors.New("no session"),
+	}
+
+	tests := []struct {
+		event    event.Event
+		expected string
+	}{
+		{encryptedEvent, "encrypted"},
+		{undecryptableEvent, "undecryptable"},
+	}
+	for _, test := range tests {
+		cli.Handler.Handle(cli, test.event)
+		got := make(map[string]int)
+		for i := 0; i < 2; i++ {
+			select {
+			case v := <-called:
+				got[v]++
+			case <-time.After(time.Second):
+				t.Fatalf("handlers not called for %T, got %v", test.event, got)
+			}
+		}
+		// Handlers are called in goroutines so give unexpected calls some time to arrive.
+		time.Sleep(50 * time.Millisecond)
+		for len(called) > 0 {
+			got[<-called]++
+		}
+		expected := map[string]int{test.expected: 1, "room": 1}
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("unexpected handlers called for %T\nexpected: %v\ngot: %v", test.event, expected, got)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package gotrix
 
 import (
+	"context"
 	"errors"
 
 	"github.com/chanbakjsd/gotrix/event"
@@ -14,7 +15,13 @@ var MemberAvatarNotFound = errors.New("member avatar cannot be found")
 // falling back to their global avatar.
 // An error is returned if the avatar cannot be determined.
 func (c *Client) MemberAvatar(roomID matrix.RoomID, userID matrix.UserID) (*matrix.URL, error) {
-	e, err := c.RoomState(roomID, event.TypeRoomMember, string(userID))
+	return c.MemberAvatarContext(c.Context(), roomID, userID)
+}
+
+// MemberAvatarContext is the same as MemberAvatar but takes a context.
+func (c *Client) MemberAvatarContext(ctx context.Context, roomID matrix.RoomID,
+	userID matrix.UserID) (*matrix.URL, error) {
+	e, err := c.RoomStateContext(ctx, roomID, event.TypeRoomMember, string(userID))
 	if err != nil {
 		return nil, err
 	}
@@ -24,7 +31,7 @@ func (c *Client) MemberAvatar(roomID matrix.RoomID, userID matrix.UserID) (*matr
 		return &memberEvent.AvatarURL, nil
 	}
 
-	return c.Client.AvatarURL(userID)
+	return c.Client.AvatarURLContext(ctx, userID)
 }
 
 // MemberName calculates the display name of a member.
@@ -33,7 +40,12 @@ func (c *Client) MemberAvatar(roomID matrix.RoomID, userID matrix.UserID) (*matr
 //
 // Use the Client.MemberNames variant when generating member name for multiple users to reduce duplicate work.
 func (c *Client) MemberName(roomID matrix.RoomID, userID matrix.UserID) (string, error) {
-	names, err := c.MemberNames(roomID, []matrix.UserID{userID})
+	return c.MemberNameContext(c.Context(), roomID, userID)
+}
+
+// MemberNameContext is the same as MemberName but takes a context.
+func (c *Client) MemberNameContext(ctx context.Context, roomID matrix.RoomID, userID matrix.UserID) (string, error) {
+	names, err := c.MemberNamesContext(ctx, roomID, []matrix.UserID{userID})
 	if err != nil {
 		return "", err
 	}
@@ -42,6 +54,12 @@ func (c *Client) MemberName(roomID matrix.RoomID, userID matrix.UserID) (string,
 
 // MemberNames calculates the display name of all the users provided.
 func (c *Client) MemberNames(roomID matrix.RoomID, userIDs []matrix.UserID) ([]string, error) {
+	return c.MemberNamesContext(c.Context(), roomID, userIDs)
+}
+
+// MemberNamesContext is the same as MemberNames but takes a context.
+func (c *Client) MemberNamesContext(ctx context.Context, roomID matrix.RoomID,
+	userIDs []matrix.UserID) ([]string, error) {
 	// Build the hashmap of display names to locate duplicate display names.
 	dupe := make(map[string]int)
 	err := c.EachRoomState(roomID, event.TypeRoomMember, func(key string, v event.StateEvent) error {
@@ -60,7 +78,7 @@ func (c *Client) MemberNames(roomID matrix.RoomID, userIDs []matrix.UserID) ([]s
 	result := make([]string, 0, len(userIDs))
 	for _, userID := range userIDs {
 		// Step 1: Inspect m.room.member state event.
-		e, _ := c.RoomState(roomID, event.TypeRoomMember, string(userID))
+		e, _ := c.RoomStateContext(ctx, roomID, event.TypeRoomMember, string(userID))
 		if e == nil {
 			result = append(result, string(userID))
 			continue
@@ -1,6 +1,7 @@
 package gotrix
 
 import (
+	"context"
 	"errors"
 	"strconv"
 	"strings"
@@ -16,7 +17,12 @@ var ErrRoomAvatarNotFound = errors.New("user is alone in the room and a room ava
 // It falls back to the profile picture of the first user to join the room that is not the current user otherwise.
 // If the current user is alone, it returns nil and an error.
 func (c *Client) RoomAvatar(roomID matrix.RoomID) (*matrix.URL, error) {
-	e, _ := c.RoomState(roomID, event.TypeRoomAvatar, "")
+	return c.RoomAvatarContext(c.Context(), roomID)
+}
+
+// RoomAvatarContext is the same as RoomAvatar but takes a context.
+func (c *Client) RoomAvatarContext(ctx context.Context, roomID matrix.RoomID) (*matrix.URL, error) {
+	e, _ := c.RoomStateContext(ctx, roomID, event.TypeRoomAvatar, "")
 	if e != nil {
 		avatarEvent := e.(*event.RoomAvatarEvent)
 		return &avatarEvent.URL, nil
@@ -30,13 +36,18 @@ func (c *Client) RoomAvatar(roomID matrix.RoomID) (*matrix.URL, error) {
 		return nil, ErrRoomAvatarNotFound
 	}
 
-	return c.MemberAvatar(roomID, summary.Heroes[0])
+	return c.MemberAvatarContext(ctx, roomID, summary.Heroes[0])
 }
 
 // RoomName calculates the display name of a room.
 func (c *Client) RoomName(roomID matrix.RoomID) (string, error) {
+	return c.RoomNameContext(c.Context(), roomID)
+}
+
+// RoomNameContext is the same as RoomName but takes a context.
+func (c *Client) RoomNameContext(ctx context.Context, roomID matrix.RoomID) (string, error) {
 	// Step 1: Check for m.room.name state event.
-	e, _ := c.RoomState(roomID, event.TypeRoomName, "")
+	e, _ := c.RoomStateContext(ctx, roomID, event.TypeRoomName, "")
 	if e != nil {
 		nameEvent := e.(*event.RoomNameEvent)
 		if nameEvent.Name != "" {
@@ -45,7 +56,7 @@ func (c *Client) RoomName(roomID matrix.RoomID) (string, error) {
 	}
 
 	// Step 2: Check for m.room.canonical_alias state event.
-	e, _ = c.RoomState(roomID, event.TypeRoomCanonicalAlias, "")
+	e, _ = c.RoomStateContext(ctx, roomID, event.TypeRoomCanonicalAlias, "")
 	if e != nil {
 		aliasEvent := e.(*event.RoomCanonicalAliasEvent)
 		if aliasEvent.Alias != "" {
@@ -63,7 +74,7 @@ func (c *Client) RoomName(roomID matrix.RoomID) (string, error) {
 		if k > 4 {
 			break // Sane limit of 5 names displayed.
 		}
-		name, err := c.MemberName(roomID, v)
+		name, err := c.MemberNameContext(ctx, roomID, v)
 		if err != nil {
 			return "", err
 		}
@@ -1,8 +1,18 @@
 package matrix
 
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
 // APIError represents an API error as returned by the Matrix server.
 //
-// It is always wrapped around by HTTPError.
+// It is always wrapped around by HTTPError. It can be matched against an ErrorCode with errors.Is:
+//
+//	if errors.Is(err, matrix.CodeForbidden) {
+//		// ...
+//	}
 type APIError struct {
 	// Code and Message should be included in every API error.
 	Code    ErrorCode `json:"errcode"`
@@ -20,19 +30,47 @@ type APIError struct {
 
 // Error makes API Error implement the `error` interface.
 func (e APIError) Error() string {
+	if e.Message == "" {
+		return string(e.Code)
+	}
 	return e.Message
 }
 
+// Is returns true if target is the ErrorCode of the API error.
+func (e APIError) Is(target error) bool {
+	code, ok := target.(ErrorCode)
+	return ok && code == e.Code
+}
+
 // HTTPError represents an error while decoding response.
 // It contains the status code and the actual error.
 type HTTPError struct {
 	Code            int
 	UnderlyingError error
+
+	// Method and Route identify the request that failed. Route does not include the homeserver.
+	// They are empty if the error was not created from a request.
+	Method string
+	Route  string
+
+	// SoftLogout is true if the homeserver has invalidated the access token but the client may
+	// log back in without purging its cache.
+	SoftLogout bool
+	// RetryAfter is the time the homeserver asked the client to wait before retrying the request.
+	// It is zero if the homeserver did not provide one.
+	RetryAfter time.Duration
 }
 
 // Error makes HTTPError implement the `error` interface.
 func (h HTTPError) Error() string {
-	return h.UnderlyingError.Error()
+	msg := h.UnderlyingError.Error()
+	if h.Method != "" {
+		msg = h.Method + " " + h.Route + ": " + msg
+	}
+	if h.Code > 0 {
+		msg += " (HTTP " + strconv.Itoa(h.Code) + ")"
+	}
+	return msg
 }
 
 // Unwrap allows the underlying error to be exposed.
@@ -41,9 +79,17 @@ func (h HTTPError) Unwrap() error {
 }
 
 // NewHTTPError constructs a new HTTP error with the provided details.
+// SoftLogout and RetryAfter are filled in if the underlying error is an APIError.
 func NewHTTPError(code int, underlyingError error) HTTPError {
-	return HTTPError{
+	h := HTTPError{
 		Code:            code,
 		UnderlyingError: underlyingError,
 	}
+
+	var apiError APIError
+	if errors.As(underlyingError, &apiError) {
+		h.SoftLogout = apiError.SoftLogout
+		h.RetryAfter = time.Duration(apiError.RetryAfterMillisecond) * time.Millisecond
+	}
+	return h
 }
@@ -1,8 +1,16 @@
 package matrix
 
 // ErrorCode represents an error code that is found in REST errors.
+//
+// Every ErrorCode is also a sentinel error that matches API errors with the same code when using
+// errors.Is.
 type ErrorCode string
 
+// Error makes ErrorCode implement the `error` interface so it can be used as a sentinel error.
+func (c ErrorCode) Error() string {
+	return string(c)
+}
+
 // List of official error codes.
 // It can be found at https://spec.matrix.org/v1.2/client-server-api/#standard-error-response.
 const (
@@ -10,14 +18,16 @@ const (
 	CodeForbidden     ErrorCode = "M_FORBIDDEN"
 	CodeUnknownToken  ErrorCode = "M_UNKNOWN_TOKEN"
 	CodeMissingToken  ErrorCode = "M_MISSING_TOKEN"
+	CodeUserLocked    ErrorCode = "M_USER_LOCKED"
+	CodeUserSuspended ErrorCode = "M_USER_SUSPENDED"
 	CodeBadJSON       ErrorCode = "M_BAD_JSON"
 	CodeNotJSON       ErrorCode = "M_NOT_JSON"
 	CodeNotFound      ErrorCode = "M_NOT_FOUND"
 	CodeLimitExceeded ErrorCode = "M_LIMIT_EXCEEDED"
+	CodeUnrecognized  ErrorCode = "M_UNRECOGNIZED"
 	CodeUnknown       ErrorCode = "M_UNKNOWN"
 
 	// Other error codes the client might encounter
-	CodeUnrecognized                 ErrorCode = "M_UNRECOGNIZED"
 	CodeUnauthorized                 ErrorCode = "M_UNAUTHORIZED"
 	CodeUserDeactivated              ErrorCode = "M_USER_DEACTIVATED"
 	CodeUserInUse                    ErrorCode = "M_USER_IN_USE"
@@ -28,6 +38,7 @@ const (
 	CodeThreePIDNotFound             ErrorCode = "M_THREEPID_NOT_FOUND"
 	CodeThreePIDAuthFailed           ErrorCode = "M_THREEPID_AUTH_FAILED"
 	CodeThreePIDDenied               ErrorCode = "M_THREEPID_DENIED"
+	CodeThreePIDMediumNotSupported   ErrorCode = "M_THREEPID_MEDIUM_NOT_SUPPORTED"
 	CodeServerNotTrusted             ErrorCode = "M_SERVER_NOT_TRUSTED"
 	CodeUnsupportedRoomVersion       ErrorCode = "M_UNSUPPORTED_ROOM_VERSION"
 	CodeIncompatibleRoomVersion      ErrorCode = "M_INCOMPATIBLE_ROOM_VERSION"
@@ -41,7 +52,16 @@ const (
 	CodeExclusive                    ErrorCode = "M_EXCLUSIVE"
 	CodeResourceLimitExceeded        ErrorCode = "M_RESOURCE_LIMIT_EXCEEDED"
 	CodeCannotLeaveServiceNoticeRoom ErrorCode = "M_CANNOT_LEAVE_SERVICE_NOTICE_ROOM"
+	CodeUnableToAuthoriseJoin        ErrorCode = "M_UNABLE_TO_AUTHORISE_JOIN"
+	CodeUnableToGrantJoin            ErrorCode = "M_UNABLE_TO_GRANT_JOIN"
+	CodeUnknownPos                   ErrorCode = "M_UNKNOWN_POS"
 
 	// Codes that are documented on other sections
-	CodeWeakPassword ErrorCode = "M_WEAK_PASSWORD"
+	CodeWeakPassword         ErrorCode = "M_WEAK_PASSWORD"
+	CodeBadAlias             ErrorCode = "M_BAD_ALIAS"
+	CodeDuplicateAnnotation  ErrorCode = "M_DUPLICATE_ANNOTATION"
+	CodeNotYetUploaded       ErrorCode = "M_NOT_YET_UPLOADED"
+	CodeCannotOverwriteMedia ErrorCode = "M_CANNOT_OVERWRITE_MEDIA"
+	CodeWrongRoomKeysVersion ErrorCode = "M_WRONG_ROOM_KEYS_VERSION"
+	CodeInvalidSignature     ErrorCode = "M_INVALID_SIGNATURE"
 )
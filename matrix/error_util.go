@@ -2,6 +2,7 @@ package matrix
 
 import (
 	"errors"
+	"time"
 )
 
 // StatusCode takes in an error and return the HTTP status code associated with it.
@@ -43,3 +44,24 @@ func MapAPIError(e error, m ErrorMap) error {
 	}
 	return e
 }
+
+// IsSoftLogout returns true if the error is a HTTPError where the homeserver has indicated that
+// the client can log back in without purging its cache.
+func IsSoftLogout(e error) bool {
+	var err HTTPError
+	if errors.As(e, &err) {
+		return err.SoftLogout
+	}
+	return false
+}
+
+// RetryAfter returns the time the homeserver asked the client to wait before retrying.
+//
+// If it's not a HTTPError or the homeserver did not provide a time, it returns 0 instead.
+func RetryAfter(e error) time.Duration {
+	var err HTTPError
+	if errors.As(e, &err) {
+		return err.RetryAfter
+	}
+	return 0
+}
@@ -4,9 +4,10 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 // PushRuleID is the rule ID for a push rule.
@@ -33,6 +34,18 @@ func (id PushRuleID) IsServerDefault() bool {
 	return strings.HasPrefix(string(id), ".")
 }
 
+// PushRuleKind is the kind of a push rule. It determines the priority of the rule and how it matches events.
+type PushRuleKind string
+
+// List of push rule kinds in order of priority.
+const (
+	OverridePushRule  PushRuleKind = "override"
+	ContentPushRule   PushRuleKind = "content"
+	RoomPushRule      PushRuleKind = "room"
+	SenderPushRule    PushRuleKind = "sender"
+	UnderridePushRule PushRuleKind = "underride"
+)
+
 // PushRuleset describes the global push ruleset inside a PushRulesEvent.
 type PushRuleset struct {
 	// Override rules are checked first. They're user-configured.
@@ -63,15 +76,15 @@ func (r PushRules) Rule(id PushRuleID) (PushRule, bool) {
 	return PushRule{}, false
 }
 
-// PatternMatches returns true if any of the rules have a pattern that matches value. It behaves
-// similarly to EventMatch, except the Pattern field is used instead.
+// PatternMatch finds an enabled rule whose pattern matches a word of str, which should be the body of
+// a message. It behaves similarly to EventMatch, except the Pattern field is used instead.
 func (r PushRules) PatternMatch(str string) (PushRule, bool) {
 	for _, rule := range r {
 		if !rule.Enabled {
 			continue
 		}
 
-		if rule.Pattern.Matches(str) {
+		if rule.Pattern.MatchesWords(str) {
 			return rule, true
 		}
 	}
@@ -98,7 +111,7 @@ func (r PushRules) EventMatch(matchers map[string]string) (PushRule, bool) {
 				continue
 			}
 
-			if condition.Pattern.Matches(str) {
+			if condition.Matches(str) {
 				return rule, true
 			}
 		}
@@ -124,7 +137,8 @@ type PushRule struct {
 	RuleID PushRuleID `json:"rule_id"`
 }
 
-// PushActions describes the list of actions associated with push rules.
+// PushActions describes the list of actions associated with push rules. An empty Action means that the
+// matching event does not notify.
 type PushActions struct {
 	Action PushAction
 	Tweaks map[PushActionTweak]json.RawMessage
@@ -144,26 +158,27 @@ func (a *PushActions) UnmarshalJSON(b []byte) error {
 		return err
 	}
 
-	if len(values) == 0 {
-		return errors.New("actions have no value")
-	}
-
-	if err := json.Unmarshal(values[0], &a.Action); err != nil {
-		return fmt.Errorf("cannot unmarshal first value: %w", err)
-	}
-
-	if len(values) > 1 {
-		a.Tweaks = make(map[PushActionTweak]json.RawMessage, len(values[1:]))
-
-		for _, tweakJSON := range values[1:] {
-			var tweak pushActionTweak
-
-			if err := json.Unmarshal(tweakJSON, &tweak); err != nil {
-				return fmt.Errorf("cannot unmarshal tweak: %w", err)
+	// Newer rules use an empty list instead of dont_notify. Tweaks may also appear before the action.
+	for _, value := range values {
+		if len(value) > 0 && value[0] == '"' {
+			if a.Action != "" {
+				return errors.New("actions have more than one action")
+			}
+			if err := json.Unmarshal(value, &a.Action); err != nil {
+				return fmt.Errorf("cannot unmarshal action: %w", err)
 			}
+			continue
+		}
 
-			a.Tweaks[tweak.SetTweak] = tweak.Value
+		var tweak pushActionTweak
+		if err := json.Unmarshal(value, &tweak); err != nil {
+			return fmt.Errorf("cannot unmarshal tweak: %w", err)
 		}
+
+		if a.Tweaks == nil {
+			a.Tweaks = make(map[PushActionTweak]json.RawMessage, len(values))
+		}
+		a.Tweaks[tweak.SetTweak] = tweak.Value
 	}
 
 	return nil
@@ -173,11 +188,13 @@ func (a *PushActions) UnmarshalJSON(b []byte) error {
 func (a PushActions) MarshalJSON() ([]byte, error) {
 	values := make([]json.RawMessage, 0, 1+len(a.Tweaks))
 
-	action, err := json.Marshal(a.Action)
-	if err != nil {
-		return nil, fmt.Errorf("cannot marshal action: %w", err)
+	if a.Action != "" {
+		action, err := json.Marshal(a.Action)
+		if err != nil {
+			return nil, fmt.Errorf("cannot marshal action: %w", err)
+		}
+		values = append(values, action)
 	}
-	values = append(values, action)
 
 	for key, value := range a.Tweaks {
 		b, err := json.Marshal(pushActionTweak{key, value})
@@ -218,6 +235,11 @@ func (a PushActions) UnmarshalTweak(tweak PushActionTweak, ptr interface{}) bool
 	return json.Unmarshal(raw, ptr) == nil
 }
 
+// Notify returns true if the PushActions cause the matching event to generate a notification.
+func (a PushActions) Notify() bool {
+	return a.Action == NotifyAction || a.Action == CoalesceAction
+}
+
 // Highlight returns true if the PushActions has a HighlightAction tweak with a true value. It
 // handles certain edge cases.
 func (a PushActions) Highlight() bool {
@@ -278,6 +300,12 @@ const (
 	// room, ensuring the sender of the event has high enough power to trigger the notification.
 	// This condition has the Key field.
 	SenderNotificationPermissionCondition PushConditionKind = "sender_notification_permission"
+	// EventPropertyIsCondition is an exact match on a field of the event. The value of the field must be a
+	// string, an integer, a boolean or null. This condition has the fields Key and Value.
+	EventPropertyIsCondition PushConditionKind = "event_property_is"
+	// EventPropertyContainsCondition matches if a field of the event is an array containing the value. This
+	// condition has the fields Key and Value.
+	EventPropertyContainsCondition PushConditionKind = "event_property_contains"
 )
 
 type PushCondition struct {
@@ -290,13 +318,27 @@ type PushCondition struct {
 	//    - EventMatchCondition: it's a dot-separated field of the event to match.
 	//    - SenderNotificationPermissionCondition: it's the field in the power level event that the
 	//      user needs a minimum power level for.
+	//    - EventPropertyIsCondition and EventPropertyContainsCondition: it's a dot-separated field of
+	//      the event to compare.
 	Key string `json:"key,omitempty"`
 	// Kind is the kind of condition to apply.
 	Kind PushConditionKind `json:"kind"`
 	// Pattern is required for event_match conditions (Kind). It is the glob-style pattern to match
-	// against. Patterns with no special glob characters should be treated as having asterisks
-	// prepended and appended when testing the condition.
+	// against. It has to match the whole value of the field except for content.body, where it has to
+	// match a word in the body.
 	Pattern PushPattern `json:"pattern,omitempty"`
+	// Value is required for event_property_is and event_property_contains conditions (Kind). It is the
+	// JSON value to compare against.
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Matches returns true if the pattern of the event_match condition matches value, which is the value of
+// the field named by Key.
+func (c PushCondition) Matches(value string) bool {
+	if c.Key == "content.body" {
+		return c.Pattern.MatchesWords(value)
+	}
+	return c.Pattern.Matches(value)
 }
 
 // IsCmp parses the Is string and compares it with num.
@@ -304,7 +346,8 @@ func (c PushCondition) IsCmp(num int) bool {
 	value := c.Is
 	var valuePrefix string
 
-	for _, prefix := range []string{"==", "<", ">", ">=", "<="} {
+	// Longer prefixes are checked first so that "<=" is not taken for "<".
+	for _, prefix := range []string{"==", "<=", ">=", "<", ">"} {
 		if strings.HasPrefix(value, prefix) {
 			value = strings.TrimPrefix(value, prefix)
 			valuePrefix = prefix
@@ -319,13 +362,13 @@ func (c PushCondition) IsCmp(num int) bool {
 
 	switch valuePrefix {
 	case "<":
-		return i < num
+		return num < i
 	case "<=":
-		return i <= num
+		return num <= i
 	case ">":
-		return i > num
+		return num > i
 	case ">=":
-		return i >= num
+		return num >= i
 	case "==", "":
 		return i == num
 	default:
@@ -333,17 +376,80 @@ func (c PushCondition) IsCmp(num int) bool {
 	}
 }
 
-// PushPattern describes a glob string used to match push rule patterns.
+// PushPattern describes a glob string used to match push rule patterns. "*" matches any number of characters
+// and "?" matches exactly one. Patterns are matched case-insensitively.
 type PushPattern string
 
-// Matches returns true if str matches the Pattern string.
+// Matches returns true if the pattern matches the whole of str.
 func (p PushPattern) Matches(str string) bool {
-	pattern := string(p)
-	if !strings.Contains(pattern, "*") {
-		pattern = "*" + pattern + "*"
+	return matchRegexp(p.regexp(), str, false)
+}
+
+// MatchesWords returns true if the pattern matches a part of str that is surrounded by word boundaries. This is
+// how patterns are matched against the body of a message.
+func (p PushPattern) MatchesWords(str string) bool {
+	return matchRegexp(p.regexp(), str, true)
+}
+
+// regexp returns the pattern converted to a regular expression.
+func (p PushPattern) regexp() string {
+	var expr strings.Builder
+	for _, r := range p {
+		switch r {
+		case '*':
+			expr.WriteString(".*")
+		case '?':
+			expr.WriteString(".")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+		}
 	}
+	return expr.String()
+}
+
+// ContainsWords returns true if str contains words case-insensitively, surrounded by word boundaries. It is used
+// to check whether a message body contains the display name of the user.
+func ContainsWords(str, words string) bool {
+	return words != "" && matchRegexp(regexp.QuoteMeta(words), str, true)
+}
+
+// maxCachedRegexps is the maximum number of compiled push rule patterns kept in memory.
+const maxCachedRegexps = 256
 
-	// "the push rules stuff is known to be terrible"
-	matched, _ := filepath.Match(pattern, str)
-	return matched
+var (
+	regexpCacheMu sync.Mutex
+	regexpCache   = make(map[string]*regexp.Regexp)
+)
+
+// matchRegexp matches the value against the regular expression case-insensitively. The whole value has to
+// match unless words is true, in which case the expression has to match a part of the value surrounded by word
+// boundaries.
+//
+// Compiled expressions are cached as the same patterns are matched against every event.
+func matchRegexp(expr, value string, words bool) bool {
+	if words {
+		expr = `(?is)(?:^|\W)` + expr + `(?:\W|$)`
+	} else {
+		expr = `(?is)^` + expr + `$`
+	}
+
+	regexpCacheMu.Lock()
+	re, ok := regexpCache[expr]
+	regexpCacheMu.Unlock()
+	if !ok {
+		var err error
+		re, err = regexp.Compile(expr)
+		if err != nil {
+			return false
+		}
+
+		regexpCacheMu.Lock()
+		if len(regexpCache) >= maxCachedRegexps {
+			// Patterns rarely change so it is not worth tracking which ones have been used recently.
+			regexpCache = make(map[string]*regexp.Regexp)
+		}
+		regexpCache[expr] = re
+		regexpCacheMu.Unlock()
+	}
+	return re.MatchString(value)
 }
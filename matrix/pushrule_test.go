@@ -0,0 +1,133 @@
+package matrix
+
+import "testing"
+
+func TestPushConditionIsCmp(t *testing.T) {
+	tests := []struct {
+		is       string
+		num      int
+		expected bool
+	}{
+		{"2", 2, true},
+		{"2", 3, false},
+		{"==2", 2, true},
+		{"==2", 1, false},
+		{"<2", 1, true},
+		{"<2", 2, false},
+		{"<=2", 2, true},
+		{"<=2", 3, false},
+		{">2", 3, true},
+		{">2", 2, false},
+		{">=2", 2, true},
+		{">=2", 1, false},
+		{"", 0, false},
+		{">=", 0, false},
+		{"two", 2, false},
+		{"=<2", 2, false},
+	}
+	for _, test := range tests {
+		got := PushCondition{Kind: RoomMemberCountCondition, Is: test.is}.IsCmp(test.num)
+		if got != test.expected {
+			t.Errorf("%q with %d: expected %t, got %t", test.is, test.num, test.expected, got)
+		}
+	}
+}
+
+func TestPushPatternMatches(t *testing.T) {
+	tests := []struct {
+		pattern PushPattern
+		str     string
+		whole   bool
+		words   bool
+	}{
+		{"alice", "alice", true, true},
+		{"alice", "ALICE", true, true},
+		{"Alice", "hello alice!", false, true},
+		{"alice", "malice", false, false},
+		{"alice", "alice_", false, false},
+		{"ali*", "alice", true, true},
+		{"ali*", "hi alice", false, true},
+		{"ali?e", "alice", true, true},
+		{"ali?e", "alie", false, false},
+		{"*", "", true, true},
+		{"m.room.*", "m.room.message", true, true},
+		{"m.room.*", "m.roomxmessage", false, false},
+		// Characters that are special in filepath.Match or regular expressions are matched literally.
+		{"[abc]", "[abc]", true, true},
+		{"[abc]", "a", false, false},
+		{`a\b`, `a\b`, true, true},
+		{"a+", "aa", false, false},
+		{"line", "first\nline", false, true},
+	}
+	for _, test := range tests {
+		if got := test.pattern.Matches(test.str); got != test.whole {
+			t.Errorf("%q matching %q: expected %t, got %t", test.pattern, test.str, test.whole, got)
+		}
+		if got := test.pattern.MatchesWords(test.str); got != test.words {
+			t.Errorf("%q matching words of %q: expected %t, got %t", test.pattern, test.str, test.words, got)
+		}
+	}
+}
+
+func TestContainsWords(t *testing.T) {
+	tests := []struct {
+		str      string
+		words    string
+		expected bool
+	}{
+		{"hi Alice Bob", "alice bob", true},
+		{"hi alice", "alice", true},
+		{"hi malice", "alice", false},
+		{"hi Al*ce", "al*ce", true},
+		{"hi alice", "al*ce", false},
+		{"hi alice", "", false},
+	}
+	for _, test := range tests {
+		if got := ContainsWords(test.str, test.words); got != test.expected {
+			t.Errorf("%q containing %q: expected %t, got %t", test.str, test.words, test.expected, got)
+		}
+	}
+}
+
+func TestPushRulesMatch(t *testing.T) {
+	rules := PushRules{
+		{RuleID: "disabled", Pattern: "hello", Conditions: []PushCondition{
+			{Kind: EventMatchCondition, Key: "content.body", Pattern: "hello"},
+		}},
+		{RuleID: "body", Enabled: true, Pattern: "cake", Conditions: []PushCondition{
+			{Kind: EventMatchCondition, Key: "content.body", Pattern: "cake"},
+		}},
+		{RuleID: "type", Enabled: true, Conditions: []PushCondition{
+			{Kind: EventMatchCondition, Key: "type", Pattern: "m.room.*"},
+		}},
+	}
+
+	tests := []struct {
+		matchers map[string]string
+		expected PushRuleID
+	}{
+		{map[string]string{"content.body": "Any cake?"}, "body"},
+		{map[string]string{"content.body": "cupcake"}, ""},
+		{map[string]string{"content.body": "hello"}, ""},
+		{map[string]string{"type": "m.room.message"}, "type"},
+		// Fields other than the body have to match as a whole.
+		{map[string]string{"type": "org.example.m.room.message"}, ""},
+	}
+	for _, test := range tests {
+		rule, ok := rules.EventMatch(test.matchers)
+		if rule.RuleID != test.expected || ok != (test.expected != "") {
+			t.Errorf("%v: expected rule %q, got %q", test.matchers, test.expected, rule.RuleID)
+		}
+	}
+
+	for str, expected := range map[string]PushRuleID{
+		"Any CAKE?": "body",
+		"cupcake":   "",
+		"hello":     "",
+	} {
+		rule, ok := rules.PatternMatch(str)
+		if rule.RuleID != expected || ok != (expected != "") {
+			t.Errorf("%q: expected rule %q, got %q", str, expected, rule.RuleID)
+		}
+	}
+}
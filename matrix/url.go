@@ -11,11 +11,109 @@ var (
 	ErrInvalidGeoURI = errors.New("invalid geo URI")
 	// ErrAltitudeNotPresent is returned when altitude is requested but not present.
 	ErrAltitudeNotPresent = errors.New("altitude not present")
+	// ErrInvalidMXC represents an error in parsing MXC URI.
+	ErrInvalidMXC = errors.New("invalid MXC URI")
 )
 
 // URL is a URI which is likely to be MXC URI.
 type URL string
 
+// IsMXC returns true if the URL uses the mxc scheme.
+// It does not check if the rest of the URL is valid. Use Parse for that.
+func (u URL) IsMXC() bool {
+	return strings.HasPrefix(string(u), "mxc://")
+}
+
+// Parse returns the server name and media ID of the MXC URI.
+// ErrInvalidMXC is returned if the URL is not a valid MXC URI.
+func (u URL) Parse() (serverName string, mediaID string, err error) {
+	if !u.IsMXC() {
+		return "", "", ErrInvalidMXC
+	}
+
+	split := strings.Split(string(u)[len("mxc://"):], "/")
+	if len(split) != 2 || !isValidServerName(split[0]) || !isValidMediaID(split[1]) {
+		return "", "", ErrInvalidMXC
+	}
+
+	return split[0], split[1], nil
+}
+
+// ServerName returns the server name of the MXC URI.
+func (u URL) ServerName() (string, error) {
+	serverName, _, err := u.Parse()
+	return serverName, err
+}
+
+// MediaID returns the media ID of the MXC URI.
+func (u URL) MediaID() (string, error) {
+	_, mediaID, err := u.Parse()
+	return mediaID, err
+}
+
+// isValidServerName checks if the provided string is a valid server name (hostname with an optional port).
+func isValidServerName(s string) bool {
+	host, port := s, ""
+	if strings.HasPrefix(s, "[") {
+		// IPv6 literal.
+		end := strings.IndexByte(s, ']')
+		if end == -1 {
+			return false
+		}
+		host, port = s[:end+1], s[end+1:]
+		for _, c := range host[1 : len(host)-1] {
+			if !isHexDigit(c) && c != ':' && c != '.' {
+				return false
+			}
+		}
+		if len(host) == 2 {
+			return false
+		}
+	} else {
+		if i := strings.LastIndexByte(s, ':'); i != -1 {
+			host, port = s[:i], s[i:]
+		}
+		if host == "" {
+			return false
+		}
+		for _, c := range host {
+			if !isAlphanumeric(c) && c != '-' && c != '.' {
+				return false
+			}
+		}
+	}
+
+	if port == "" {
+		return true
+	}
+	if port[0] != ':' || len(port) == 1 || len(port) > 6 {
+		return false
+	}
+	_, err := strconv.ParseUint(port[1:], 10, 16)
+	return err == nil
+}
+
+// isValidMediaID checks if the provided string only contains characters allowed in a media ID.
+func isValidMediaID(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if !isAlphanumeric(c) && c != '-' && c != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+func isAlphanumeric(c rune) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func isHexDigit(c rune) bool {
+	return (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F') || (c >= '0' && c <= '9')
+}
+
 // GeoURI is a geographic URI.
 type GeoURI string
 
@@ -0,0 +1,86 @@
+package matrix
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestURLParse(t *testing.T) {
+	tests := []struct {
+		url        URL
+		serverName string
+		mediaID    string
+	}{
+		{"mxc://example.org/abc123", "example.org", "abc123"},
+		{"mxc://example.org/a-b_C", "example.org", "a-b_C"},
+		{"mxc://matrix.example.org:8448/abc", "matrix.example.org:8448", "abc"},
+		{"mxc://localhost/abc", "localhost", "abc"},
+		{"mxc://1.2.3.4/abc", "1.2.3.4", "abc"},
+		{"mxc://1.2.3.4:1234/abc", "1.2.3.4:1234", "abc"},
+		{"mxc://[1234:5678::abcd]/abc", "[1234:5678::abcd]", "abc"},
+		{"mxc://[1234:5678::abcd]:5678/abc", "[1234:5678::abcd]:5678", "abc"},
+
+		// Missing parts.
+		{"mxc://", "", ""},
+		{"mxc:///abc", "", ""},
+		{"mxc://example.org", "", ""},
+		{"mxc://example.org/", "", ""},
+		{"mxc://example.org/abc/def", "", ""},
+
+		// Other schemes.
+		{"", "", ""},
+		{"https://example.org/abc", "", ""},
+		{"MXC://example.org/abc", "", ""},
+		{"mxc:/example.org/abc", "", ""},
+		{"example.org/abc", "", ""},
+
+		// Invalid server names.
+		{"mxc://exa_mple.org/abc", "", ""},
+		{"mxc://user@example.org/abc", "", ""},
+		{"mxc://:8448/abc", "", ""},
+		{"mxc://example.org:/abc", "", ""},
+		{"mxc://example.org:port/abc", "", ""},
+		{"mxc://example.org:65536/abc", "", ""},
+		{"mxc://example.org:1234567/abc", "", ""},
+		{"mxc://[]/abc", "", ""},
+		{"mxc://[1234:5678::abcd/abc", "", ""},
+		{"mxc://[example.org]/abc", "", ""},
+		{"mxc://[::1]8448/abc", "", ""},
+
+		// Invalid media IDs.
+		{"mxc://example.org/abc.def", "", ""},
+		{"mxc://example.org/abc?def", "", ""},
+		{"mxc://example.org/%41bc", "", ""},
+	}
+	for _, test := range tests {
+		serverName, mediaID, err := test.url.Parse()
+		valid := test.serverName != ""
+		switch {
+		case valid && err != nil:
+			t.Errorf("%q: unexpected error: %v", test.url, err)
+		case !valid && !errors.Is(err, ErrInvalidMXC):
+			t.Errorf("%q: expected %v, got %v", test.url, ErrInvalidMXC, err)
+		case serverName != test.serverName || mediaID != test.mediaID:
+			t.Errorf("%q: expected %q and %q, got %q and %q", test.url, test.serverName, test.mediaID,
+				serverName, mediaID)
+		}
+	}
+}
+
+func TestURLIsMXC(t *testing.T) {
+	tests := []struct {
+		url      URL
+		expected bool
+	}{
+		{"mxc://example.org/abc", true},
+		// The rest of the URL is not checked.
+		{"mxc://", true},
+		{"https://example.org/abc", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		if got := test.url.IsMXC(); got != test.expected {
+			t.Errorf("%q: expected %t, got %t", test.url, test.expected, got)
+		}
+	}
+}
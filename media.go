@@ -0,0 +1,51 @@
+package gotrix
+
+import (
+	"context"
+	"io"
+
+	"github.com/chanbakjsd/gotrix/api"
+	"github.com/chanbakjsd/gotrix/matrix"
+	"github.com/chanbakjsd/gotrix/media"
+)
+
+// EnableMediaCache makes the client cache the media it downloads in dir, evicting the least recently used
+// files when the cache grows over maxSize bytes.
+func (c *Client) EnableMediaCache(dir string, maxSize int64) error {
+	cache, err := media.NewCache(dir, maxSize, c.Client)
+	if err != nil {
+		return err
+	}
+	c.MediaCache = cache
+	return nil
+}
+
+// MediaDownload downloads the media at the provided MXC URI and writes it into w.
+// It is read from MediaCache instead if it has been downloaded before.
+func (c *Client) MediaDownload(matrixURL matrix.URL, w io.Writer) (api.MediaInfo, error) {
+	return c.MediaDownloadContext(c.Context(), matrixURL, w)
+}
+
+// MediaDownloadContext is the same as MediaDownload but takes a context.
+func (c *Client) MediaDownloadContext(ctx context.Context, matrixURL matrix.URL, w io.Writer) (api.MediaInfo, error) {
+	if c.MediaCache == nil {
+		return c.Client.MediaDownloadContext(ctx, matrixURL, w)
+	}
+	return c.MediaCache.Download(ctx, matrixURL, w)
+}
+
+// MediaThumbnail downloads a thumbnail of the media at the provided MXC URI and writes it into w.
+// It is read from MediaCache instead if it has been downloaded before.
+func (c *Client) MediaThumbnail(matrixURL matrix.URL, w io.Writer,
+	width int, height int, method api.MediaThumbnailMethod) (api.MediaInfo, error) {
+	return c.MediaThumbnailContext(c.Context(), matrixURL, w, width, height, method)
+}
+
+// MediaThumbnailContext is the same as MediaThumbnail but takes a context.
+func (c *Client) MediaThumbnailContext(ctx context.Context, matrixURL matrix.URL, w io.Writer,
+	width int, height int, method api.MediaThumbnailMethod) (api.MediaInfo, error) {
+	if c.MediaCache == nil {
+		return c.Client.MediaThumbnailContext(ctx, matrixURL, w, width, height, method)
+	}
+	return c.MediaCache.Thumbnail(ctx, matrixURL, w, width, height, method)
+}
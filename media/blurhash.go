@@ -0,0 +1,129 @@
+package media
+
+import (
+	"errors"
+	"image"
+	"math"
+	"strings"
+)
+
+// ErrInvalidComponents is returned by BlurHash when the number of components is out of range.
+var ErrInvalidComponents = errors.New("blurhash components must be between 1 and 9")
+
+// blurHashSize is the size the image is scaled down to before computing the BlurHash.
+// The hash only captures low frequencies so there is no point looking at every pixel.
+const blurHashSize = 64
+
+const base83Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// BlurHash computes the BlurHash (https://blurha.sh) of the image with the provided number of components on
+// each axis. Clients commonly use 4 x 3 components.
+func BlurHash(img image.Image, xComponents, yComponents int) (string, error) {
+	if xComponents < 1 || xComponents > 9 || yComponents < 1 || yComponents > 9 {
+		return "", ErrInvalidComponents
+	}
+
+	small := Thumbnail(img, blurHashSize, blurHashSize)
+	width, height := small.Rect.Dx(), small.Rect.Dy()
+	if width == 0 || height == 0 {
+		return "", errors.New("cannot compute blurhash of empty image")
+	}
+
+	// Convert every pixel to linear RGB once.
+	linear := make([][3]float64, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			pixel := small.Pix[y*small.Stride+x*4:]
+			linear[y*width+x] = [3]float64{
+				srgbToLinear(pixel[0]), srgbToLinear(pixel[1]), srgbToLinear(pixel[2]),
+			}
+		}
+	}
+
+	factors := make([][3]float64, 0, xComponents*yComponents)
+	for j := 0; j < yComponents; j++ {
+		for i := 0; i < xComponents; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1
+			}
+
+			var factor [3]float64
+			for y := 0; y < height; y++ {
+				basisY := math.Cos(math.Pi * float64(j) * float64(y) / float64(height))
+				for x := 0; x < width; x++ {
+					basis := basisY * math.Cos(math.Pi*float64(i)*float64(x)/float64(width))
+					pixel := linear[y*width+x]
+					factor[0] += basis * pixel[0]
+					factor[1] += basis * pixel[1]
+					factor[2] += basis * pixel[2]
+				}
+			}
+
+			scale := normalisation / float64(width*height)
+			factor[0] *= scale
+			factor[1] *= scale
+			factor[2] *= scale
+			factors = append(factors, factor)
+		}
+	}
+
+	var sb strings.Builder
+	encodeBase83(&sb, (xComponents-1)+(yComponents-1)*9, 1)
+
+	dc, ac := factors[0], factors[1:]
+	maximumValue := 1.0
+	if len(ac) > 0 {
+		var actualMax float64
+		for _, v := range ac {
+			for _, c := range v {
+				actualMax = math.Max(actualMax, math.Abs(c))
+			}
+		}
+		quantisedMax := int(math.Max(0, math.Min(82, math.Floor(actualMax*166-0.5))))
+		maximumValue = float64(quantisedMax+1) / 166
+		encodeBase83(&sb, quantisedMax, 1)
+	} else {
+		encodeBase83(&sb, 0, 1)
+	}
+
+	encodeBase83(&sb, linearToSRGB(dc[0])<<16|linearToSRGB(dc[1])<<8|linearToSRGB(dc[2]), 4)
+	for _, v := range ac {
+		quantR := quantiseAC(v[0] / maximumValue)
+		quantG := quantiseAC(v[1] / maximumValue)
+		quantB := quantiseAC(v[2] / maximumValue)
+		encodeBase83(&sb, quantR*19*19+quantG*19+quantB, 2)
+	}
+
+	return sb.String(), nil
+}
+
+// encodeBase83 writes value into sb as length base83 digits.
+func encodeBase83(sb *strings.Builder, value, length int) {
+	for i := 1; i <= length; i++ {
+		digit := (value / int(math.Pow(83, float64(length-i)))) % 83
+		sb.WriteByte(base83Chars[digit])
+	}
+}
+
+// quantiseAC quantises an AC component that has been normalised to [-1, 1] into [0, 18].
+func quantiseAC(value float64) int {
+	signPow := math.Copysign(math.Sqrt(math.Abs(value)), value)
+	return int(math.Max(0, math.Min(18, math.Floor(signPow*9+9.5))))
+}
+
+func srgbToLinear(value uint8) float64 {
+	v := float64(value) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(value float64) int {
+	v := math.Max(0, math.Min(1, value))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
@@ -0,0 +1,83 @@
+package media
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+// testImage returns a 32 x 24 image with a red gradient along the x axis and a green gradient along the y axis.
+func testImage() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 32, 24))
+	for y := 0; y < 24; y++ {
+		for x := 0; x < 32; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 8), G: uint8(y * 10), B: 128, A: 255})
+		}
+	}
+	return img
+}
+
+// splitImage returns a 32 x 24 image whose left half is red and right half is blue.
+func splitImage() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 32, 24))
+	for y := 0; y < 24; y++ {
+		for x := 0; x < 32; x++ {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= 16 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+// offsetImage returns a copy of the image placed in a larger image and then cropped back.
+func offsetImage(img *image.RGBA) image.Image {
+	bounds := img.Rect.Add(image.Pt(8, 8))
+	large := image.NewRGBA(bounds.Inset(-8))
+	draw.Draw(large, bounds, img, image.Point{}, draw.Src)
+	return large.SubImage(bounds)
+}
+
+func TestBlurHash(t *testing.T) {
+	// The expected hashes are the output of the reference encoder of blurha.sh for the same pixels. The images
+	// are smaller than blurHashSize so they are not scaled.
+	tests := []struct {
+		name        string
+		img         image.Image
+		xComponents int
+		yComponents int
+		expected    string
+	}{
+		{"gradient", testImage(), 4, 3, "LxH27k2swxX8mHWWjtf7gJfjfQfj"},
+		{"gradient DC only", testImage(), 1, 1, "00H27k"},
+		{"split", splitImage(), 4, 3, "L~LjfL|TsRJrsXn~jsa}fQfQfQfQ"},
+		// The hash does not depend on where the image is.
+		{"offset", offsetImage(testImage()), 4, 3, "LxH27k2swxX8mHWWjtf7gJfjfQfj"},
+	}
+	for _, test := range tests {
+		hash, err := BlurHash(test.img, test.xComponents, test.yComponents)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if hash != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.name, test.expected, hash)
+		}
+	}
+}
+
+func TestBlurHashInvalid(t *testing.T) {
+	for _, components := range [][2]int{{0, 3}, {4, 0}, {10, 3}, {4, 10}} {
+		_, err := BlurHash(testImage(), components[0], components[1])
+		if !errors.Is(err, ErrInvalidComponents) {
+			t.Errorf("%d x %d: expected ErrInvalidComponents, got %v", components[0], components[1], err)
+		}
+	}
+	if _, err := BlurHash(image.NewRGBA(image.Rect(0, 0, 0, 0)), 4, 3); err == nil {
+		t.Error("expected error for an empty image")
+	}
+}
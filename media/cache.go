@@ -0,0 +1,357 @@
+package media
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chanbakjsd/gotrix/api"
+	"github.com/chanbakjsd/gotrix/matrix"
+)
+
+// metadataSuffix is the suffix of the file storing the metadata of a cached file.
+const metadataSuffix = ".json"
+
+// tempPrefix is the prefix of files that are being downloaded.
+const tempPrefix = "tmp-"
+
+// Downloader downloads media from the homeserver. It is implemented by *api.Client.
+type Downloader interface {
+	MediaDownloadContext(ctx context.Context, matrixURL matrix.URL, w io.Writer) (api.MediaInfo, error)
+	MediaThumbnailContext(ctx context.Context, matrixURL matrix.URL, w io.Writer,
+		width int, height int, method api.MediaThumbnailMethod) (api.MediaInfo, error)
+}
+
+// Cache is a size-capped on-disk cache of media downloaded from the homeserver.
+// Files are keyed by the MXC URI and the thumbnail parameters. The least recently used files are
+// evicted first when the cache grows over its maximum size.
+//
+// It is safe to use a Cache from multiple goroutines but only one Cache should use a directory at once.
+type Cache struct {
+	dir        string
+	maxSize    int64
+	downloader Downloader
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List // of *cacheEntry, most recently used at the front.
+	entries map[string]*list.Element
+}
+
+// cacheEntry is a file stored in the cache.
+type cacheEntry struct {
+	key  string
+	size int64
+	info api.MediaInfo
+}
+
+// cacheMetadata is the metadata stored alongside each cached file.
+type cacheMetadata struct {
+	URL         matrix.URL `json:"url"`
+	ContentType string     `json:"content_type,omitempty"`
+	FileName    string     `json:"file_name,omitempty"`
+}
+
+// NewCache creates a Cache storing files in dir that evicts files once the total size exceeds maxSize bytes.
+// Files already in dir from a previous Cache are kept. dir is created if it does not exist.
+//
+// Media that is not in the cache is downloaded with the provided Downloader, which is usually the *api.Client
+// of the client.
+func NewCache(dir string, maxSize int64, downloader Downloader) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("error creating media cache directory: %w", err)
+	}
+
+	c := &Cache{
+		dir:        dir,
+		maxSize:    maxSize,
+		downloader: downloader,
+		lru:        list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+	if err := c.load(); err != nil {
+		return nil, fmt.Errorf("error loading media cache: %w", err)
+	}
+	return c, nil
+}
+
+// load adds the files already in the cache directory to the LRU list in the order they were last used.
+func (c *Cache) load() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	files, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+
+	type loadedEntry struct {
+		entry   *cacheEntry
+		modTime time.Time
+	}
+	var loaded []loadedEntry
+
+	for _, v := range files {
+		name := v.Name()
+		if strings.HasPrefix(name, tempPrefix) {
+			// Leftover from an interrupted download.
+			_ = os.Remove(filepath.Join(c.dir, name))
+			continue
+		}
+		if !strings.HasSuffix(name, metadataSuffix) {
+			continue
+		}
+
+		key := strings.TrimSuffix(name, metadataSuffix)
+		stat, err := os.Stat(c.path(key))
+		if err != nil {
+			// The file is gone so the metadata is useless.
+			_ = os.Remove(filepath.Join(c.dir, name))
+			continue
+		}
+
+		var meta cacheMetadata
+		raw, err := ioutil.ReadFile(filepath.Join(c.dir, name))
+		if err != nil || json.Unmarshal(raw, &meta) != nil {
+			c.removeFiles(key)
+			continue
+		}
+
+		loaded = append(loaded, loadedEntry{
+			entry: &cacheEntry{
+				key:  key,
+				size: stat.Size(),
+				info: api.MediaInfo{
+					ContentType: meta.ContentType,
+					FileName:    meta.FileName,
+					Length:      stat.Size(),
+				},
+			},
+			modTime: stat.ModTime(),
+		})
+	}
+
+	sort.Slice(loaded, func(i, j int) bool {
+		return loaded[i].modTime.After(loaded[j].modTime)
+	})
+	for _, v := range loaded {
+		c.entries[v.entry.key] = c.lru.PushBack(v.entry)
+		c.size += v.entry.size
+	}
+
+	c.evict(nil)
+	return nil
+}
+
+// Download writes the media at the provided MXC URI into w, downloading it if it is not cached.
+func (c *Cache) Download(ctx context.Context, matrixURL matrix.URL, w io.Writer) (api.MediaInfo, error) {
+	r, info, err := c.Open(ctx, matrixURL)
+	if err != nil {
+		return api.MediaInfo{}, err
+	}
+	return copyTo(w, r, info)
+}
+
+// Thumbnail writes a thumbnail of the media at the provided MXC URI into w, downloading it if it is not cached.
+func (c *Cache) Thumbnail(ctx context.Context, matrixURL matrix.URL, w io.Writer,
+	width int, height int, method api.MediaThumbnailMethod) (api.MediaInfo, error) {
+	r, info, err := c.OpenThumbnail(ctx, matrixURL, width, height, method)
+	if err != nil {
+		return api.MediaInfo{}, err
+	}
+	return copyTo(w, r, info)
+}
+
+// Open returns a reader of the media at the provided MXC URI, downloading it if it is not cached.
+// The returned reader must be closed.
+func (c *Cache) Open(ctx context.Context, matrixURL matrix.URL) (io.ReadCloser, api.MediaInfo, error) {
+	return c.open(ctx, matrixURL, downloadKey(matrixURL), func(w io.Writer) (api.MediaInfo, error) {
+		return c.downloader.MediaDownloadContext(ctx, matrixURL, w)
+	})
+}
+
+// OpenThumbnail returns a reader of a thumbnail of the media at the provided MXC URI, downloading it if it is not
+// cached. The returned reader must be closed.
+func (c *Cache) OpenThumbnail(ctx context.Context, matrixURL matrix.URL,
+	width int, height int, method api.MediaThumbnailMethod) (io.ReadCloser, api.MediaInfo, error) {
+	key := thumbnailKey(matrixURL, width, height, method)
+	return c.open(ctx, matrixURL, key, func(w io.Writer) (api.MediaInfo, error) {
+		return c.downloader.MediaThumbnailContext(ctx, matrixURL, w, width, height, method)
+	})
+}
+
+// Size returns the total size of the files in the cache in bytes.
+func (c *Cache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// Clear removes every file from the cache.
+func (c *Cache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+	}
+}
+
+// copyTo copies r into w and closes r.
+func copyTo(w io.Writer, r io.ReadCloser, info api.MediaInfo) (api.MediaInfo, error) {
+	defer r.Close()
+
+	if _, err := io.Copy(w, r); err != nil {
+		return api.MediaInfo{}, fmt.Errorf("error reading cached media: %w", err)
+	}
+	return info, nil
+}
+
+// open returns a reader of the cached file with the provided key, calling fetch to download it if it is not in
+// the cache.
+func (c *Cache) open(ctx context.Context, matrixURL matrix.URL, key string,
+	fetch func(io.Writer) (api.MediaInfo, error)) (io.ReadCloser, api.MediaInfo, error) {
+	c.mu.Lock()
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*cacheEntry)
+		c.lru.MoveToFront(elem)
+		f, err := os.Open(c.path(key))
+		if err == nil {
+			now := time.Now()
+			_ = os.Chtimes(c.path(key), now, now)
+			c.mu.Unlock()
+			return f, entry.info, nil
+		}
+		// The file is gone from under us. Download it again.
+		c.remove(elem)
+	}
+	c.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, api.MediaInfo{}, err
+	}
+
+	tmp, err := ioutil.TempFile(c.dir, tempPrefix)
+	if err != nil {
+		return nil, api.MediaInfo{}, fmt.Errorf("error creating media cache file: %w", err)
+	}
+	defer func() {
+		// This is a no-op if the file has been renamed.
+		_ = os.Remove(tmp.Name())
+	}()
+
+	info, err := fetch(tmp)
+	if closeErr := tmp.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("error writing media cache file: %w", closeErr)
+	}
+	if err != nil {
+		return nil, api.MediaInfo{}, err
+	}
+
+	meta, err := json.Marshal(cacheMetadata{
+		URL:         matrixURL,
+		ContentType: info.ContentType,
+		FileName:    info.FileName,
+	})
+	if err != nil {
+		return nil, api.MediaInfo{}, fmt.Errorf("error encoding media cache metadata: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		// Someone else downloaded the same file at the same time.
+		c.remove(elem)
+	}
+	if err := ioutil.WriteFile(c.path(key)+metadataSuffix, meta, 0o600); err != nil {
+		return nil, api.MediaInfo{}, fmt.Errorf("error writing media cache metadata: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), c.path(key)); err != nil {
+		_ = os.Remove(c.path(key) + metadataSuffix)
+		return nil, api.MediaInfo{}, fmt.Errorf("error moving media cache file: %w", err)
+	}
+
+	f, err := os.Open(c.path(key))
+	if err != nil {
+		return nil, api.MediaInfo{}, fmt.Errorf("error opening media cache file: %w", err)
+	}
+
+	if stat, err := f.Stat(); err == nil {
+		info.Length = stat.Size()
+	}
+
+	entry := &cacheEntry{
+		key:  key,
+		size: info.Length,
+		info: info,
+	}
+	elem := c.lru.PushFront(entry)
+	c.entries[key] = elem
+	c.size += entry.size
+	// The file is already open so it stays readable even if it is evicted right away.
+	c.evict(elem)
+
+	return f, info, nil
+}
+
+// evict removes the least recently used files until the cache is within its maximum size.
+// keep is not removed even if the cache is still too big afterwards.
+// c.mu must be held.
+func (c *Cache) evict(keep *list.Element) {
+	for c.size > c.maxSize {
+		elem := c.lru.Back()
+		if elem == nil || elem == keep {
+			return
+		}
+		c.remove(elem)
+	}
+}
+
+// remove removes the entry and its files from the cache. c.mu must be held.
+func (c *Cache) remove(elem *list.Element) {
+	entry := elem.Value.(*cacheEntry)
+	c.lru.Remove(elem)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+	c.removeFiles(entry.key)
+}
+
+// removeFiles removes the files of the provided key.
+func (c *Cache) removeFiles(key string) {
+	_ = os.Remove(c.path(key))
+	_ = os.Remove(c.path(key) + metadataSuffix)
+}
+
+// path returns the path of the file with the provided key.
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.dir, key)
+}
+
+// downloadKey returns the cache key of the full media at the provided MXC URI.
+func downloadKey(matrixURL matrix.URL) string {
+	return hashKey(string(matrixURL))
+}
+
+// thumbnailKey returns the cache key of a thumbnail of the media at the provided MXC URI.
+func thumbnailKey(matrixURL matrix.URL, width int, height int, method api.MediaThumbnailMethod) string {
+	return hashKey(string(matrixURL) + "\x00" + strconv.Itoa(width) + "x" + strconv.Itoa(height) + "\x00" +
+		string(method))
+}
+
+func hashKey(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,358 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chanbakjsd/gotrix/api"
+	"github.com/chanbakjsd/gotrix/matrix"
+)
+
+// fakeDownloader serves media from memory and counts the downloads.
+type fakeDownloader struct {
+	mu        sync.Mutex
+	media     map[matrix.URL]string
+	downloads map[string]int
+	// wait, if non-nil, blocks every download until it is closed.
+	wait chan struct{}
+}
+
+func newFakeDownloader(media map[matrix.URL]string) *fakeDownloader {
+	return &fakeDownloader{
+		media:     media,
+		downloads: make(map[string]int),
+	}
+}
+
+func (d *fakeDownloader) MediaDownloadContext(ctx context.Context, matrixURL matrix.URL,
+	w io.Writer) (api.MediaInfo, error) {
+	return d.serve(string(matrixURL), matrixURL, "", w)
+}
+
+func (d *fakeDownloader) MediaThumbnailContext(ctx context.Context, matrixURL matrix.URL, w io.Writer,
+	width int, height int, method api.MediaThumbnailMethod) (api.MediaInfo, error) {
+	suffix := fmt.Sprintf(" %dx%d %s", width, height, method)
+	return d.serve(string(matrixURL)+suffix, matrixURL, suffix, w)
+}
+
+func (d *fakeDownloader) serve(key string, matrixURL matrix.URL, suffix string, w io.Writer) (api.MediaInfo, error) {
+	if d.wait != nil {
+		<-d.wait
+	}
+
+	d.mu.Lock()
+	content, ok := d.media[matrixURL]
+	d.downloads[key]++
+	d.mu.Unlock()
+	if !ok {
+		return api.MediaInfo{}, errors.New("media not found")
+	}
+
+	content += suffix
+	n, err := io.WriteString(w, content)
+	return api.MediaInfo{
+		ContentType: "text/plain",
+		FileName:    "file.txt",
+		Length:      int64(n),
+	}, err
+}
+
+// count returns the number of times the media with the key has been downloaded.
+func (d *fakeDownloader) count(key string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.downloads[key]
+}
+
+// readCached reads the media at the MXC URI through the cache.
+func readCached(t *testing.T, c *Cache, matrixURL matrix.URL) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if _, err := c.Download(context.Background(), matrixURL, &buf); err != nil {
+		t.Fatalf("error downloading %s: %v", matrixURL, err)
+	}
+	return buf.String()
+}
+
+func TestCacheHit(t *testing.T) {
+	const url matrix.URL = "mxc://example.org/a"
+	d := newFakeDownloader(map[matrix.URL]string{url: "content"})
+	c, err := NewCache(t.TempDir(), 1024, d)
+	if err != nil {
+		t.Fatalf("error creating cache: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		var buf bytes.Buffer
+		info, err := c.Download(context.Background(), url, &buf)
+		if err != nil {
+			t.Fatalf("error downloading: %v", err)
+		}
+		if buf.String() != "content" {
+			t.Errorf("expected %q, got %q", "content", buf.String())
+		}
+		if info.ContentType != "text/plain" || info.FileName != "file.txt" || info.Length != 7 {
+			t.Errorf("unexpected media info %+v", info)
+		}
+	}
+	if count := d.count(string(url)); count != 1 {
+		t.Errorf("expected 1 download, got %d", count)
+	}
+	if size := c.Size(); size != 7 {
+		t.Errorf("expected size 7, got %d", size)
+	}
+
+	// Errors from the downloader are returned and nothing is cached.
+	if _, err := c.Download(context.Background(), "mxc://example.org/missing", ioutil.Discard); err == nil {
+		t.Errorf("expected error downloading missing media")
+	}
+	if size := c.Size(); size != 7 {
+		t.Errorf("expected size 7 after failed download, got %d", size)
+	}
+
+	c.Clear()
+	if size := c.Size(); size != 0 {
+		t.Errorf("expected size 0 after clearing, got %d", size)
+	}
+	readCached(t, c, url)
+	if count := d.count(string(url)); count != 2 {
+		t.Errorf("expected download after clearing, got %d downloads", count)
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	d := newFakeDownloader(map[matrix.URL]string{
+		"mxc://example.org/a":   "aaaa",
+		"mxc://example.org/b":   "bbbb",
+		"mxc://example.org/c":   "cccc",
+		"mxc://example.org/big": "bigger than the cache",
+	})
+	dir := t.TempDir()
+	c, err := NewCache(dir, 10, d)
+	if err != nil {
+		t.Fatalf("error creating cache: %v", err)
+	}
+
+	readCached(t, c, "mxc://example.org/a")
+	readCached(t, c, "mxc://example.org/b")
+	// a is now more recently used than b.
+	readCached(t, c, "mxc://example.org/a")
+	// This goes over 10 bytes so b is evicted.
+	readCached(t, c, "mxc://example.org/c")
+	if size := c.Size(); size != 8 {
+		t.Errorf("expected size 8, got %d", size)
+	}
+	if _, err := os.Stat(filepath.Join(dir, downloadKey("mxc://example.org/b"))); !os.IsNotExist(err) {
+		t.Errorf("expected evicted file to be removed, got %v", err)
+	}
+
+	readCached(t, c, "mxc://example.org/a")
+	readCached(t, c, "mxc://example.org/b")
+	for url, expected := range map[string]int{
+		"mxc://example.org/a": 1,
+		"mxc://example.org/b": 2,
+		"mxc://example.org/c": 1,
+	} {
+		if count := d.count(url); count != expected {
+			t.Errorf("%s: expected %d downloads, got %d", url, expected, count)
+		}
+	}
+
+	// A file bigger than the cache is still returned but evicts everything else.
+	if content := readCached(t, c, "mxc://example.org/big"); content != "bigger than the cache" {
+		t.Errorf("unexpected content %q", content)
+	}
+	if size := c.Size(); size != int64(len("bigger than the cache")) {
+		t.Errorf("expected only the big file to be cached, got size %d", size)
+	}
+	readCached(t, c, "mxc://example.org/a")
+	if size := c.Size(); size != 4 {
+		t.Errorf("expected the big file to be evicted, got size %d", size)
+	}
+	if count := d.count("mxc://example.org/a"); count != 2 {
+		t.Errorf("expected a to be downloaded again, got %d downloads", count)
+	}
+}
+
+func TestCacheReload(t *testing.T) {
+	d := newFakeDownloader(map[matrix.URL]string{
+		"mxc://example.org/a": "aaaa",
+		"mxc://example.org/b": "bbbb",
+		"mxc://example.org/c": "cccc",
+	})
+	dir := t.TempDir()
+	c, err := NewCache(dir, 100, d)
+	if err != nil {
+		t.Fatalf("error creating cache: %v", err)
+	}
+	readCached(t, c, "mxc://example.org/a")
+	readCached(t, c, "mxc://example.org/b")
+	readCached(t, c, "mxc://example.org/c")
+
+	// The order is restored from the modification times, which may be too coarse to tell the files apart.
+	base := time.Now().Add(-time.Hour)
+	for i, url := range []matrix.URL{"mxc://example.org/b", "mxc://example.org/a", "mxc://example.org/c"} {
+		modTime := base.Add(time.Duration(i) * time.Minute)
+		if err := os.Chtimes(filepath.Join(dir, downloadKey(url)), modTime, modTime); err != nil {
+			t.Fatalf("error setting modification time: %v", err)
+		}
+	}
+	// Leftovers of an interrupted download are removed.
+	if err := ioutil.WriteFile(filepath.Join(dir, tempPrefix+"leftover"), []byte("partial"), 0o600); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+
+	// The smaller maximum size evicts b, which is the least recently used.
+	reloaded, err := NewCache(dir, 8, d)
+	if err != nil {
+		t.Fatalf("error reloading cache: %v", err)
+	}
+	if size := reloaded.Size(); size != 8 {
+		t.Errorf("expected size 8, got %d", size)
+	}
+	if _, err := os.Stat(filepath.Join(dir, tempPrefix+"leftover")); !os.IsNotExist(err) {
+		t.Errorf("expected leftover file to be removed, got %v", err)
+	}
+
+	var buf bytes.Buffer
+	info, err := reloaded.Download(context.Background(), "mxc://example.org/a", &buf)
+	if err != nil {
+		t.Fatalf("error downloading: %v", err)
+	}
+	if buf.String() != "aaaa" || info.ContentType != "text/plain" || info.FileName != "file.txt" || info.Length != 4 {
+		t.Errorf("unexpected reloaded media %q, %+v", buf.String(), info)
+	}
+	readCached(t, reloaded, "mxc://example.org/c")
+	readCached(t, reloaded, "mxc://example.org/b")
+	for url, expected := range map[string]int{
+		"mxc://example.org/a": 1,
+		"mxc://example.org/b": 2,
+		"mxc://example.org/c": 1,
+	} {
+		if count := d.count(url); count != expected {
+			t.Errorf("%s: expected %d downloads, got %d", url, expected, count)
+		}
+	}
+}
+
+func TestCacheThumbnail(t *testing.T) {
+	const url matrix.URL = "mxc://example.org/a"
+	d := newFakeDownloader(map[matrix.URL]string{url: "image"})
+	c, err := NewCache(t.TempDir(), 1024, d)
+	if err != nil {
+		t.Fatalf("error creating cache: %v", err)
+	}
+
+	thumbnails := []struct {
+		width, height int
+		method        api.MediaThumbnailMethod
+	}{
+		{32, 32, api.MediaThumbnailCrop},
+		{32, 32, api.MediaThumbnailScale},
+		{64, 32, api.MediaThumbnailCrop},
+		{32, 64, api.MediaThumbnailCrop},
+	}
+	for i := 0; i < 2; i++ {
+		for _, v := range thumbnails {
+			var buf bytes.Buffer
+			_, err := c.Thumbnail(context.Background(), url, &buf, v.width, v.height, v.method)
+			if err != nil {
+				t.Fatalf("error downloading thumbnail: %v", err)
+			}
+			expected := fmt.Sprintf("image %dx%d %s", v.width, v.height, v.method)
+			if buf.String() != expected {
+				t.Errorf("expected %q, got %q", expected, buf.String())
+			}
+		}
+	}
+	if content := readCached(t, c, url); content != "image" {
+		t.Errorf("expected full media to be cached separately, got %q", content)
+	}
+
+	for _, v := range thumbnails {
+		key := fmt.Sprintf("%s %dx%d %s", url, v.width, v.height, v.method)
+		if count := d.count(key); count != 1 {
+			t.Errorf("%s: expected 1 download, got %d", key, count)
+		}
+	}
+	if count := d.count(string(url)); count != 1 {
+		t.Errorf("expected 1 download of the full media, got %d", count)
+	}
+}
+
+func TestCacheConcurrentOpen(t *testing.T) {
+	const (
+		url     matrix.URL = "mxc://example.org/a"
+		content            = "content"
+		readers            = 8
+	)
+	d := newFakeDownloader(map[matrix.URL]string{url: content})
+	d.wait = make(chan struct{})
+	c, err := NewCache(t.TempDir(), 1024, d)
+	if err != nil {
+		t.Fatalf("error creating cache: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, readers)
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r, _, err := c.Open(context.Background(), url)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer r.Close()
+			got, err := ioutil.ReadAll(r)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if string(got) != content {
+				errs <- fmt.Errorf("expected %q, got %q", content, got)
+			}
+		}()
+	}
+	// Let the downloads finish at roughly the same time.
+	time.Sleep(10 * time.Millisecond)
+	close(d.wait)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("error opening media: %v", err)
+	}
+
+	// Concurrent downloads of the same media only keep a single copy.
+	if size := c.Size(); size != int64(len(content)) {
+		t.Errorf("expected size %d, got %d", len(content), size)
+	}
+	files, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		t.Fatalf("error reading cache directory: %v", err)
+	}
+	var names []string
+	for _, v := range files {
+		names = append(names, v.Name())
+	}
+	if len(names) != 2 {
+		t.Errorf("expected a file and its metadata, got %s", strings.Join(names, ", "))
+	}
+
+	downloads := d.count(string(url))
+	readCached(t, c, url)
+	if count := d.count(string(url)); count != downloads {
+		t.Errorf("expected media to be cached after concurrent downloads")
+	}
+}
@@ -0,0 +1,69 @@
+package media
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestFitSize(t *testing.T) {
+	tests := []struct {
+		width, height, maxWidth, maxHeight int
+		expectedWidth, expectedHeight      int
+	}{
+		{100, 100, 100, 100, 100, 100},
+		{50, 80, 100, 100, 50, 80},
+		{400, 300, 200, 150, 200, 150},
+		{200, 100, 100, 100, 100, 50},
+		{100, 200, 100, 100, 50, 100},
+		{150, 50, 100, 100, 100, 33},
+		{1, 1000, 100, 100, 1, 100},
+		{1000, 1, 100, 100, 100, 1},
+		// The other side is rounded down to 0 and has to be at least 1.
+		{1, 1000, 10, 10, 1, 10},
+		{1000, 1, 10, 10, 10, 1},
+		{0, 0, 10, 10, 0, 0},
+	}
+	for _, test := range tests {
+		width, height := FitSize(test.width, test.height, test.maxWidth, test.maxHeight)
+		if width != test.expectedWidth || height != test.expectedHeight {
+			t.Errorf("%d x %d in %d x %d: expected %d x %d, got %d x %d",
+				test.width, test.height, test.maxWidth, test.maxHeight,
+				test.expectedWidth, test.expectedHeight, width, height)
+		}
+	}
+}
+
+func TestThumbnail(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for x := 0; x < 4; x++ {
+		img.Set(x, 0, color.RGBA{R: uint8(x * 20), G: 100, B: 0, A: 255})
+		img.Set(x, 1, color.RGBA{R: uint8(x * 20), G: 200, B: 40, A: 255})
+	}
+
+	// Each pixel of the thumbnail averages a 2 x 2 block.
+	thumb := Thumbnail(img, 2, 2)
+	if thumb.Rect != image.Rect(0, 0, 2, 1) {
+		t.Fatalf("expected 2 x 1 thumbnail, got %v", thumb.Rect)
+	}
+	expected := []color.RGBA{{10, 150, 20, 255}, {50, 150, 20, 255}}
+	for x, c := range expected {
+		if got := thumb.RGBAAt(x, 0); got != c {
+			t.Errorf("pixel %d: expected %v, got %v", x, c, got)
+		}
+	}
+
+	// Images that already fit are copied as is, even if they do not start at the origin.
+	sub := img.SubImage(image.Rect(1, 0, 3, 2))
+	thumb = Thumbnail(sub, 10, 10)
+	if thumb.Rect != image.Rect(0, 0, 2, 2) {
+		t.Fatalf("expected 2 x 2 thumbnail, got %v", thumb.Rect)
+	}
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 2; x++ {
+			if got, c := thumb.RGBAAt(x, y), img.RGBAAt(x+1, y); got != c {
+				t.Errorf("pixel %d, %d: expected %v, got %v", x, y, c, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package gotrix
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"github.com/chanbakjsd/gotrix/event"
+	"github.com/chanbakjsd/gotrix/matrix"
+)
+
+// defaultNotificationPowerLevel is the power level needed to trigger notifications if the power levels event
+// does not specify it.
+const defaultNotificationPowerLevel = 50
+
+// PushEvaluator evaluates the push rules of a user against events to find the actions to perform. The room
+// context needed by the conditions is taken from State, which is not queried from the homeserver.
+type PushEvaluator struct {
+	// Ruleset is the global push ruleset of the user, usually from an event.PushRulesEvent.
+	Ruleset matrix.PushRuleset
+	// UserID is the user the push rules belong to.
+	UserID matrix.UserID
+	State  State
+}
+
+// PushEvaluator creates a PushEvaluator for the ruleset of the current user using the State of the client.
+func (c *Client) PushEvaluator(ruleset matrix.PushRuleset) *PushEvaluator {
+	return &PushEvaluator{
+		Ruleset: ruleset,
+		UserID:  c.UserID,
+		State:   c.State,
+	}
+}
+
+// Evaluate runs the enabled rules of the ruleset in priority order against the room event and returns the
+// first rule that matches. The actions to perform are the Actions of the rule. A zero-value PushRule and false
+// are returned if no rule matches, if e is not a room event or if it has been sent by the user.
+func (p *PushEvaluator) Evaluate(e event.Event) (matrix.PushRule, bool) {
+	re, ok := e.(event.RoomEvent)
+	if !ok {
+		return matrix.PushRule{}, false
+	}
+	info := re.RoomInfo()
+	if info.Sender == p.UserID {
+		return matrix.PushRule{}, false
+	}
+
+	ctx := &pushContext{
+		p:      p,
+		roomID: info.RoomID,
+		sender: info.Sender,
+	}
+	if err := json.Unmarshal(info.Raw, &ctx.fields); err != nil || ctx.fields == nil {
+		return matrix.PushRule{}, false
+	}
+	// The room ID is not included in events from sync.
+	if ctx.roomID != "" {
+		ctx.fields["room_id"] = string(ctx.roomID)
+	}
+	// The event type is replaced for decrypted events.
+	ctx.fields["type"] = string(info.Type)
+
+	kinds := []struct {
+		rules   matrix.PushRules
+		matches func(matrix.PushRule) bool
+	}{
+		{p.Ruleset.Override, ctx.conditionsMatch},
+		{p.Ruleset.Content, ctx.patternMatches},
+		{p.Ruleset.Room, func(rule matrix.PushRule) bool { return string(rule.RuleID) == string(ctx.roomID) }},
+		{p.Ruleset.Sender, func(rule matrix.PushRule) bool { return string(rule.RuleID) == string(ctx.sender) }},
+		{p.Ruleset.Underride, ctx.conditionsMatch},
+	}
+	for _, kind := range kinds {
+		for _, rule := range kind.rules {
+			if rule.Enabled && kind.matches(rule) {
+				return rule, true
+			}
+		}
+	}
+	return matrix.PushRule{}, false
+}
+
+// pushContext is the event being evaluated.
+type pushContext struct {
+	p      *PushEvaluator
+	roomID matrix.RoomID
+	sender matrix.UserID
+	// fields is the decoded JSON of the event.
+	fields map[string]interface{}
+}
+
+// conditionsMatch returns true if all conditions of the rule hold for the event.
+func (c *pushContext) conditionsMatch(rule matrix.PushRule) bool {
+	for _, condition := range rule.Conditions {
+		if !c.conditionMatches(condition) {
+			return false
+		}
+	}
+	return true
+}
+
+// patternMatches returns true if the pattern of the content rule matches the body of the event.
+func (c *pushContext) patternMatches(rule matrix.PushRule) bool {
+	body, ok := c.field("content.body").(string)
+	return ok && rule.Pattern.MatchesWords(body)
+}
+
+func (c *pushContext) conditionMatches(condition matrix.PushCondition) bool {
+	switch condition.Kind {
+	case matrix.EventMatchCondition:
+		value, ok := c.field(condition.Key).(string)
+		return ok && condition.Matches(value)
+	case matrix.EventPropertyIsCondition:
+		expected, ok := decodeConditionValue(condition.Value)
+		return ok && reflect.DeepEqual(c.field(condition.Key), expected)
+	case matrix.EventPropertyContainsCondition:
+		expected, ok := decodeConditionValue(condition.Value)
+		values, isArray := c.field(condition.Key).([]interface{})
+		if !ok || !isArray {
+			return false
+		}
+		for _, v := range values {
+			if reflect.DeepEqual(v, expected) {
+				return true
+			}
+		}
+		return false
+	case matrix.ContainsDisplayNameCondition:
+		body, ok := c.field("content.body").(string)
+		return ok && matrix.ContainsWords(body, c.displayName())
+	case matrix.RoomMemberCountCondition:
+		return c.roomID != "" && condition.IsCmp(c.memberCount())
+	case matrix.SenderNotificationPermissionCondition:
+		return c.roomID != "" && c.senderCanNotify(condition.Key)
+	}
+	// Rules with unknown conditions never match.
+	return false
+}
+
+// field returns the value of the dot-separated field of the event, or nil if it does not exist. Dots and
+// backslashes in the names of the fields are escaped with a backslash.
+func (c *pushContext) field(key string) interface{} {
+	var current interface{} = c.fields
+	for _, name := range splitFieldPath(key) {
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = object[name]
+	}
+	return current
+}
+
+// displayName returns the display name of the user in the room.
+func (c *pushContext) displayName() string {
+	e, err := c.p.State.RoomState(c.roomID, event.TypeRoomMember, string(c.p.UserID))
+	if err != nil {
+		return ""
+	}
+	member, ok := e.(*event.RoomMemberEvent)
+	if !ok || member.DisplayName == nil {
+		return ""
+	}
+	return *member.DisplayName
+}
+
+// memberCount returns the number of users joined to the room.
+func (c *pushContext) memberCount() int {
+	summary, err := c.p.State.RoomSummary(c.roomID)
+	if err == nil && summary.JoinedCount > 0 {
+		return summary.JoinedCount
+	}
+
+	count := 0
+	_ = c.p.State.EachRoomState(c.roomID, event.TypeRoomMember, func(_ string, e event.StateEvent) error {
+		if member, ok := e.(*event.RoomMemberEvent); ok && member.NewState == event.MemberJoined {
+			count++
+		}
+		return nil
+	})
+	return count
+}
+
+// senderCanNotify returns true if the power level of the sender is high enough to trigger the notification
+// with the provided key in the power levels event.
+func (c *pushContext) senderCanNotify(key string) bool {
+	e, _ := c.p.State.RoomState(c.roomID, event.TypeRoomPowerLevels, "")
+	levels, ok := e.(*event.RoomPowerLevelsEvent)
+	if !ok {
+		// Without power levels, only the creator of the room has a power level above 0.
+		e, _ := c.p.State.RoomState(c.roomID, event.TypeRoomCreate, "")
+		create, ok := e.(*event.RoomCreateEvent)
+		if !ok {
+			return false
+		}
+		// Room version 11 removed the creator field in favour of the sender of the create event.
+		creator := create.Creator
+		if creator == "" {
+			creator = create.Sender
+		}
+		return creator == c.sender
+	}
+
+	required := defaultNotificationPowerLevel
+	if key == "room" && levels.Notifications.Room != nil {
+		required = *levels.Notifications.Room
+	}
+	level, ok := levels.UserLevel[c.sender]
+	if !ok {
+		level = levels.UserDefault
+	}
+	return level >= required
+}
+
+// splitFieldPath splits the dot-separated path of a field, unescaping the names of the fields.
+func splitFieldPath(key string) []string {
+	var (
+		names   []string
+		current strings.Builder
+	)
+	for i := 0; i < len(key); i++ {
+		switch {
+		case key[i] == '\\' && i+1 < len(key) && (key[i+1] == '.' || key[i+1] == '\\'):
+			i++
+			current.WriteByte(key[i])
+		case key[i] == '.':
+			names = append(names, current.String())
+			current.Reset()
+		default:
+			current.WriteByte(key[i])
+		}
+	}
+	return append(names, current.String())
+}
+
+// decodeConditionValue decodes the value of an event_property condition. false is returned if the value is
+// not a string, an integer, a boolean or null.
+func decodeConditionValue(raw json.RawMessage) (interface{}, bool) {
+	if len(raw) == 0 {
+		return nil, false
+	}
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil, false
+	}
+	switch value.(type) {
+	case string, float64, bool, nil:
+		return value, true
+	}
+	return nil, false
+}
@@ -0,0 +1,329 @@
+package gotrix
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/chanbakjsd/gotrix/api"
+	"github.com/chanbakjsd/gotrix/event"
+	"github.com/chanbakjsd/gotrix/matrix"
+)
+
+const (
+	pushTestRoom  matrix.RoomID = "!room:example.org"
+	pushTestUser  matrix.UserID = "@alice:example.org"
+	pushTestOther matrix.UserID = "@bob:example.org"
+)
+
+// pushTestState is a State containing the state events of a single room.
+type pushTestState struct {
+	events  map[string]event.StateEvent
+	summary api.SyncRoomSummary
+}
+
+// newPushTestState parses the raw state events into a pushTestState.
+func newPushTestState(t *testing.T, raw ...string) *pushTestState {
+	t.Helper()
+	s := &pushTestState{events: make(map[string]event.StateEvent)}
+	for _, v := range raw {
+		e := parseTestEvent(t, v).(event.StateEvent)
+		s.events[string(e.Info().Type)+"|"+e.StateInfo().StateKey] = e
+	}
+	return s
+}
+
+func (s *pushTestState) RoomState(_ matrix.RoomID, eventType event.Type, key string) (event.StateEvent, error) {
+	return s.events[string(eventType)+"|"+key], nil
+}
+
+func (s *pushTestState) EachRoomState(_ matrix.RoomID, eventType event.Type,
+	f func(key string, e event.StateEvent) error) error {
+	for k, v := range s.events {
+		if strings.HasPrefix(k, string(eventType)+"|") {
+			if err := f(v.StateInfo().StateKey, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *pushTestState) RoomSummary(matrix.RoomID) (api.SyncRoomSummary, error) {
+	return s.summary, nil
+}
+
+func (s *pushTestState) AddEvents(*api.SyncResponse) error {
+	return nil
+}
+
+func parseTestEvent(t *testing.T, raw string) event.Event {
+	t.Helper()
+	e, err := event.Parse(event.RawEvent(raw))
+	if err != nil {
+		t.Fatalf("unexpected error parsing %s: %v", raw, err)
+	}
+	return e
+}
+
+// pushTestMessage returns an m.room.message event sent to the test room with the content.
+func pushTestMessage(t *testing.T, sender matrix.UserID, content string) event.Event {
+	t.Helper()
+	return parseTestEvent(t, `{"type":"m.room.message","event_id":"$message","room_id":"`+string(pushTestRoom)+
+		`","sender":"`+string(sender)+`","origin_server_ts":1,"content":`+content+`}`)
+}
+
+func TestPushEvaluatorPriority(t *testing.T) {
+	ruleset := matrix.PushRuleset{
+		Override: matrix.PushRules{{
+			RuleID: "override",
+			Conditions: []matrix.PushCondition{{
+				Kind: matrix.EventMatchCondition, Key: "type", Pattern: "m.room.message",
+			}},
+		}},
+		Content:   matrix.PushRules{{RuleID: "content", Pattern: "hello"}},
+		Room:      matrix.PushRules{{RuleID: matrix.PushRuleID(pushTestRoom)}},
+		Sender:    matrix.PushRules{{RuleID: matrix.PushRuleID(pushTestOther)}},
+		Underride: matrix.PushRules{{RuleID: "underride"}},
+	}
+	kinds := []*matrix.PushRules{
+		&ruleset.Override, &ruleset.Content, &ruleset.Room, &ruleset.Sender, &ruleset.Underride,
+	}
+	p := &PushEvaluator{
+		Ruleset: ruleset,
+		UserID:  pushTestUser,
+		State:   newPushTestState(t),
+	}
+	e := pushTestMessage(t, pushTestOther, `{"msgtype":"m.text","body":"Hello there"}`)
+
+	// Enabling the rules from the lowest priority kind up, the rule just enabled must be the one matching.
+	expected := []matrix.PushRuleID{
+		"underride", matrix.PushRuleID(pushTestOther), matrix.PushRuleID(pushTestRoom), "content", "override",
+	}
+	if _, ok := p.Evaluate(e); ok {
+		t.Error("expected disabled rules not to match")
+	}
+	for i := range kinds {
+		(*kinds[len(kinds)-1-i])[0].Enabled = true
+		rule, ok := p.Evaluate(e)
+		if !ok || rule.RuleID != expected[i] {
+			t.Errorf("expected rule %s to match, got %s (%t)", expected[i], rule.RuleID, ok)
+		}
+	}
+
+	if _, ok := p.Evaluate(pushTestMessage(t, pushTestUser, `{"msgtype":"m.text","body":"Hello"}`)); ok {
+		t.Error("expected events of the user not to match")
+	}
+}
+
+func TestPushConditions(t *testing.T) {
+	const (
+		create = `{"type":"m.room.create","event_id":"$create","sender":"@bob:example.org","state_key":"",` +
+			`"content":{"room_version":"11"}}`
+		createWithCreator = `{"type":"m.room.create","event_id":"$create","sender":"@carol:example.org",` +
+			`"state_key":"","content":{"creator":"@carol:example.org","room_version":"10"}}`
+		powerLevels = `{"type":"m.room.power_levels","event_id":"$levels","sender":"@bob:example.org",` +
+			`"state_key":"","content":{"users":{"@bob:example.org":50}}}`
+		powerLevelsRoom100 = `{"type":"m.room.power_levels","event_id":"$levels","sender":"@bob:example.org",` +
+			`"state_key":"","content":{"users":{"@bob:example.org":50},"notifications":{"room":100}}}`
+		member = `{"type":"m.room.member","event_id":"$member","sender":"@alice:example.org",` +
+			`"state_key":"@alice:example.org","content":{"membership":"join","displayname":"Alice"}}`
+		otherMember = `{"type":"m.room.member","event_id":"$other","sender":"@bob:example.org",` +
+			`"state_key":"@bob:example.org","content":{"membership":"join"}}`
+		content = `{"msgtype":"m.text","body":"Hey Alice! @room","m.relates_to":{"rel_type":"m.thread"},` +
+			`"m.mentions":{"user_ids":["@alice:example.org"],"room":true},"org.example\\":{"count":3},` +
+			`"org.example.list":[1,"two",null]}`
+	)
+
+	tests := []struct {
+		name      string
+		condition matrix.PushCondition
+		state     []string
+		expected  bool
+	}{
+		{
+			name:      "event_match body word",
+			condition: matrix.PushCondition{Kind: matrix.EventMatchCondition, Key: "content.body", Pattern: "ali*"},
+			expected:  true,
+		},
+		{
+			name:      "event_match body partial word",
+			condition: matrix.PushCondition{Kind: matrix.EventMatchCondition, Key: "content.body", Pattern: "lic"},
+		},
+		{
+			name:      "event_match whole value",
+			condition: matrix.PushCondition{Kind: matrix.EventMatchCondition, Key: "type", Pattern: "m.room.*"},
+			expected:  true,
+		},
+		{
+			name:      "event_match partial value",
+			condition: matrix.PushCondition{Kind: matrix.EventMatchCondition, Key: "type", Pattern: "room"},
+		},
+		{
+			name: "event_property_is escaped dot",
+			condition: matrix.PushCondition{
+				Kind: matrix.EventPropertyIsCondition, Key: `content.m\.relates_to.rel_type`,
+				Value: json.RawMessage(`"m.thread"`),
+			},
+			expected: true,
+		},
+		{
+			name: "event_property_is unescaped dot",
+			condition: matrix.PushCondition{
+				Kind: matrix.EventPropertyIsCondition, Key: "content.m.relates_to.rel_type",
+				Value: json.RawMessage(`"m.thread"`),
+			},
+		},
+		{
+			name: "event_property_is escaped backslash",
+			condition: matrix.PushCondition{
+				Kind: matrix.EventPropertyIsCondition, Key: `content.org\.example\\.count`,
+				Value: json.RawMessage(`3`),
+			},
+			expected: true,
+		},
+		{
+			name: "event_property_is boolean",
+			condition: matrix.PushCondition{
+				Kind: matrix.EventPropertyIsCondition, Key: `content.m\.mentions.room`,
+				Value: json.RawMessage(`true`),
+			},
+			expected: true,
+		},
+		{
+			name: "event_property_is wrong type",
+			condition: matrix.PushCondition{
+				Kind: matrix.EventPropertyIsCondition, Key: `content.org\.example\\.count`,
+				Value: json.RawMessage(`"3"`),
+			},
+		},
+		{
+			name: "event_property_is object value",
+			condition: matrix.PushCondition{
+				Kind: matrix.EventPropertyIsCondition, Key: `content.m\.relates_to`,
+				Value: json.RawMessage(`{"rel_type":"m.thread"}`),
+			},
+		},
+		{
+			name: "event_property_contains",
+			condition: matrix.PushCondition{
+				Kind: matrix.EventPropertyContainsCondition, Key: `content.m\.mentions.user_ids`,
+				Value: json.RawMessage(`"@alice:example.org"`),
+			},
+			expected: true,
+		},
+		{
+			name: "event_property_contains null",
+			condition: matrix.PushCondition{
+				Kind: matrix.EventPropertyContainsCondition, Key: `content.org\.example\.list`,
+				Value: json.RawMessage(`null`),
+			},
+			expected: true,
+		},
+		{
+			name: "event_property_contains missing value",
+			condition: matrix.PushCondition{
+				Kind: matrix.EventPropertyContainsCondition, Key: `content.m\.mentions.user_ids`,
+				Value: json.RawMessage(`"@bob:example.org"`),
+			},
+		},
+		{
+			name: "event_property_contains not an array",
+			condition: matrix.PushCondition{
+				Kind: matrix.EventPropertyContainsCondition, Key: `content.m\.mentions.room`,
+				Value: json.RawMessage(`true`),
+			},
+		},
+		{
+			name:      "contains_display_name",
+			condition: matrix.PushCondition{Kind: matrix.ContainsDisplayNameCondition},
+			state:     []string{member},
+			expected:  true,
+		},
+		{
+			name:      "contains_display_name without member event",
+			condition: matrix.PushCondition{Kind: matrix.ContainsDisplayNameCondition},
+		},
+		{
+			name:      "contains_display_name without display name",
+			condition: matrix.PushCondition{Kind: matrix.ContainsDisplayNameCondition},
+			state:     []string{otherMember},
+		},
+		{
+			name:      "room_member_count",
+			condition: matrix.PushCondition{Kind: matrix.RoomMemberCountCondition, Is: "2"},
+			state:     []string{member, otherMember},
+			expected:  true,
+		},
+		{
+			name:      "room_member_count greater",
+			condition: matrix.PushCondition{Kind: matrix.RoomMemberCountCondition, Is: ">2"},
+			state:     []string{member, otherMember},
+		},
+		{
+			name:      "sender_notification_permission",
+			condition: matrix.PushCondition{Kind: matrix.SenderNotificationPermissionCondition, Key: "room"},
+			state:     []string{powerLevels},
+			expected:  true,
+		},
+		{
+			name:      "sender_notification_permission too low",
+			condition: matrix.PushCondition{Kind: matrix.SenderNotificationPermissionCondition, Key: "room"},
+			state:     []string{powerLevelsRoom100},
+		},
+		{
+			name:      "sender_notification_permission room v11 creator",
+			condition: matrix.PushCondition{Kind: matrix.SenderNotificationPermissionCondition, Key: "room"},
+			state:     []string{create},
+			expected:  true,
+		},
+		{
+			name:      "sender_notification_permission not creator",
+			condition: matrix.PushCondition{Kind: matrix.SenderNotificationPermissionCondition, Key: "room"},
+			state:     []string{createWithCreator},
+		},
+		{
+			name:      "sender_notification_permission without state",
+			condition: matrix.PushCondition{Kind: matrix.SenderNotificationPermissionCondition, Key: "room"},
+		},
+		{
+			name:      "unknown condition",
+			condition: matrix.PushCondition{Kind: "org.example.condition"},
+		},
+	}
+	for _, test := range tests {
+		p := &PushEvaluator{
+			Ruleset: matrix.PushRuleset{
+				Override: matrix.PushRules{{
+					RuleID:     "rule",
+					Enabled:    true,
+					Conditions: []matrix.PushCondition{test.condition},
+				}},
+			},
+			UserID: pushTestUser,
+			State:  newPushTestState(t, test.state...),
+		}
+		_, ok := p.Evaluate(pushTestMessage(t, pushTestOther, content))
+		if ok != test.expected {
+			t.Errorf("%s: expected match to be %t, got %t", test.name, test.expected, ok)
+		}
+	}
+}
+
+func TestSplitFieldPath(t *testing.T) {
+	tests := map[string][]string{
+		"content.body":                {"content", "body"},
+		`content.m\.relates_to.rel`:   {"content", "m.relates_to", "rel"},
+		`content.back\\slash`:         {"content", `back\slash`},
+		`content.back\\.slash`:        {"content", `back\`, "slash"},
+		`content.not\escaped`:         {"content", `not\escaped`},
+		"content..empty":              {"content", "", "empty"},
+		`content.org\.example\\.list`: {"content", `org.example\`, "list"},
+	}
+	for key, expected := range tests {
+		got := splitFieldPath(key)
+		if strings.Join(got, "|") != strings.Join(expected, "|") || len(got) != len(expected) {
+			t.Errorf("%s: expected %q, got %q", key, expected, got)
+		}
+	}
+}
@@ -1,23 +1,36 @@
 package gotrix
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"io"
 
+	"github.com/chanbakjsd/gotrix/encrypt"
 	"github.com/chanbakjsd/gotrix/event"
 	"github.com/chanbakjsd/gotrix/matrix"
 )
 
 // SendMessage sends a message to the provided room ID with the provided content.
 func (c *Client) SendMessage(roomID matrix.RoomID, content string) (matrix.EventID, error) {
-	return c.sendMessage(roomID, event.RoomMessageText, content)
+	return c.SendMessageContext(c.Context(), roomID, content)
+}
+
+// SendMessageContext is the same as SendMessage but takes a context.
+func (c *Client) SendMessageContext(ctx context.Context, roomID matrix.RoomID, content string) (matrix.EventID, error) {
+	return c.sendMessage(ctx, roomID, event.RoomMessageText, content)
 }
 
 // SendEmote sends a emote to the provided room ID with the provided content.
 //
 // Emote is a regular message but is sent as someone performing it (/me in IRC).
 func (c *Client) SendEmote(roomID matrix.RoomID, content string) (matrix.EventID, error) {
-	return c.sendMessage(roomID, event.RoomMessageEmote, content)
+	return c.SendEmoteContext(c.Context(), roomID, content)
+}
+
+// SendEmoteContext is the same as SendEmote but takes a context.
+func (c *Client) SendEmoteContext(ctx context.Context, roomID matrix.RoomID, content string) (matrix.EventID, error) {
+	return c.sendMessage(ctx, roomID, event.RoomMessageEmote, content)
 }
 
 // SendNotice sends a notice to the provided room ID with the provided content.
@@ -25,7 +38,12 @@ func (c *Client) SendEmote(roomID matrix.RoomID, content string) (matrix.EventID
 // Notice are the same as messages except they're not intended to be parsed by bots (ie. other bots'
 // messages).
 func (c *Client) SendNotice(roomID matrix.RoomID, content string) (matrix.EventID, error) {
-	return c.sendMessage(roomID, event.RoomMessageNotice, content)
+	return c.SendNoticeContext(c.Context(), roomID, content)
+}
+
+// SendNoticeContext is the same as SendNotice but takes a context.
+func (c *Client) SendNoticeContext(ctx context.Context, roomID matrix.RoomID, content string) (matrix.EventID, error) {
+	return c.sendMessage(ctx, roomID, event.RoomMessageNotice, content)
 }
 
 // File is a file that can be uploaded to Matrix homeserver.
@@ -41,24 +59,52 @@ type File struct {
 	VideoInfo *event.VideoInfo
 }
 
+// ErrPlaintextThumbnail is returned when sending a file with an unencrypted thumbnail to an encrypted room.
+// PrepareRoomImage encrypts the thumbnail if the room is encrypted.
+var ErrPlaintextThumbnail = errors.New("unencrypted thumbnail cannot be sent to an encrypted room")
+
 // SendImage uploads the provided image to the server and sends a message containing it to the designated room.
+// The image is encrypted before being uploaded if the room is encrypted.
 func (c *Client) SendImage(roomID matrix.RoomID, file File) (matrix.EventID, error) {
-	return c.sendFile(roomID, event.RoomMessageImage, file)
+	return c.SendImageContext(c.Context(), roomID, file)
+}
+
+// SendImageContext is the same as SendImage but takes a context.
+func (c *Client) SendImageContext(ctx context.Context, roomID matrix.RoomID, file File) (matrix.EventID, error) {
+	return c.sendFile(ctx, roomID, event.RoomMessageImage, file, false)
 }
 
 // SendFile uploads the provided file to the server and sends a message containing it to the designated room.
+// The file is encrypted before being uploaded if the room is encrypted.
 func (c *Client) SendFile(roomID matrix.RoomID, file File) (matrix.EventID, error) {
-	return c.sendFile(roomID, event.RoomMessageFile, file)
+	return c.SendFileContext(c.Context(), roomID, file)
+}
+
+// SendFileContext is the same as SendFile but takes a context.
+func (c *Client) SendFileContext(ctx context.Context, roomID matrix.RoomID, file File) (matrix.EventID, error) {
+	return c.sendFile(ctx, roomID, event.RoomMessageFile, file, false)
 }
 
 // SendAudio uploads the provided audio file to the server and sends a message containing it to the designated room.
+// The audio file is encrypted before being uploaded if the room is encrypted.
 func (c *Client) SendAudio(roomID matrix.RoomID, file File) (matrix.EventID, error) {
-	return c.sendFile(roomID, event.RoomMessageAudio, file)
+	return c.SendAudioContext(c.Context(), roomID, file)
+}
+
+// SendAudioContext is the same as SendAudio but takes a context.
+func (c *Client) SendAudioContext(ctx context.Context, roomID matrix.RoomID, file File) (matrix.EventID, error) {
+	return c.sendFile(ctx, roomID, event.RoomMessageAudio, file, false)
 }
 
 // SendLocation sends the provided location to the provided room ID.
 func (c *Client) SendLocation(roomID matrix.RoomID, geoURI matrix.GeoURI, caption string) (matrix.EventID, error) {
-	return c.RoomEventSend(roomID, event.TypeRoomMessage, event.RoomMessageEvent{
+	return c.SendLocationContext(c.Context(), roomID, geoURI, caption)
+}
+
+// SendLocationContext is the same as SendLocation but takes a context.
+func (c *Client) SendLocationContext(ctx context.Context, roomID matrix.RoomID,
+	geoURI matrix.GeoURI, caption string) (matrix.EventID, error) {
+	return c.RoomEventSendContext(ctx, roomID, event.TypeRoomMessage, event.RoomMessageEvent{
 		MessageType: event.RoomMessageLocation,
 		Body:        caption,
 		GeoURI:      geoURI,
@@ -66,58 +112,165 @@ func (c *Client) SendLocation(roomID matrix.RoomID, geoURI matrix.GeoURI, captio
 }
 
 // SendVideo uploads the provided video file to the server and sends a message containing it to the designated room.
+// The video file is encrypted before being uploaded if the room is encrypted.
 func (c *Client) SendVideo(roomID matrix.RoomID, file File) (matrix.EventID, error) {
-	return c.sendFile(roomID, event.RoomMessageVideo, file)
+	return c.SendVideoContext(c.Context(), roomID, file)
+}
+
+// SendVideoContext is the same as SendVideo but takes a context.
+func (c *Client) SendVideoContext(ctx context.Context, roomID matrix.RoomID, file File) (matrix.EventID, error) {
+	return c.sendFile(ctx, roomID, event.RoomMessageVideo, file, false)
+}
+
+// SendEncryptedImage encrypts and uploads the provided image to the server and sends a message containing it
+// to the designated room. The key to decrypt the image is included in the message so the message itself should
+// be sent in an encrypted room.
+func (c *Client) SendEncryptedImage(roomID matrix.RoomID, file File) (matrix.EventID, error) {
+	return c.SendEncryptedImageContext(c.Context(), roomID, file)
+}
+
+// SendEncryptedImageContext is the same as SendEncryptedImage but takes a context.
+func (c *Client) SendEncryptedImageContext(ctx context.Context, roomID matrix.RoomID,
+	file File) (matrix.EventID, error) {
+	return c.sendFile(ctx, roomID, event.RoomMessageImage, file, true)
+}
+
+// SendEncryptedFile encrypts and uploads the provided file to the server and sends a message containing it
+// to the designated room. The key to decrypt the file is included in the message so the message itself should
+// be sent in an encrypted room.
+func (c *Client) SendEncryptedFile(roomID matrix.RoomID, file File) (matrix.EventID, error) {
+	return c.SendEncryptedFileContext(c.Context(), roomID, file)
+}
+
+// SendEncryptedFileContext is the same as SendEncryptedFile but takes a context.
+func (c *Client) SendEncryptedFileContext(ctx context.Context, roomID matrix.RoomID,
+	file File) (matrix.EventID, error) {
+	return c.sendFile(ctx, roomID, event.RoomMessageFile, file, true)
+}
+
+// SendEncryptedAudio encrypts and uploads the provided audio file to the server and sends a message containing it
+// to the designated room. The key to decrypt the audio file is included in the message so the message itself should
+// be sent in an encrypted room.
+func (c *Client) SendEncryptedAudio(roomID matrix.RoomID, file File) (matrix.EventID, error) {
+	return c.SendEncryptedAudioContext(c.Context(), roomID, file)
+}
+
+// SendEncryptedAudioContext is the same as SendEncryptedAudio but takes a context.
+func (c *Client) SendEncryptedAudioContext(ctx context.Context, roomID matrix.RoomID,
+	file File) (matrix.EventID, error) {
+	return c.sendFile(ctx, roomID, event.RoomMessageAudio, file, true)
+}
+
+// SendEncryptedVideo encrypts and uploads the provided video file to the server and sends a message containing it
+// to the designated room. The key to decrypt the video file is included in the message so the message itself should
+// be sent in an encrypted room.
+func (c *Client) SendEncryptedVideo(roomID matrix.RoomID, file File) (matrix.EventID, error) {
+	return c.SendEncryptedVideoContext(c.Context(), roomID, file)
 }
 
-func (c *Client) sendMessage(roomID matrix.RoomID, msgType event.MessageType, content string) (matrix.EventID, error) {
-	return c.RoomEventSend(roomID, event.TypeRoomMessage, event.RoomMessageEvent{
+// SendEncryptedVideoContext is the same as SendEncryptedVideo but takes a context.
+func (c *Client) SendEncryptedVideoContext(ctx context.Context, roomID matrix.RoomID,
+	file File) (matrix.EventID, error) {
+	return c.sendFile(ctx, roomID, event.RoomMessageVideo, file, true)
+}
+
+func (c *Client) sendMessage(ctx context.Context, roomID matrix.RoomID, msgType event.MessageType,
+	content string) (matrix.EventID, error) {
+	return c.RoomEventSendContext(ctx, roomID, event.TypeRoomMessage, event.RoomMessageEvent{
 		MessageType: msgType,
 		Body:        content,
 	})
 }
 
-// sendFile sends the image to the provided room ID with the provided content.
-func (c *Client) sendFile(roomID matrix.RoomID, msgType event.MessageType, file File) (matrix.EventID, error) {
-	url, err := c.MediaUpload(file.MIMEType, file.Name, file.Content)
+// sendFile uploads the file and sends it to the provided room ID.
+// If encrypted is true or the room is encrypted, the file is encrypted before being uploaded.
+func (c *Client) sendFile(ctx context.Context, roomID matrix.RoomID, msgType event.MessageType,
+	file File, encrypted bool) (matrix.EventID, error) {
+	settings, err := c.RoomEncryptionContext(ctx, roomID)
 	if err != nil {
+		_ = file.Content.Close()
 		return "", err
 	}
-
-	if file.Caption == "" {
-		file.Caption = file.Name
+	if settings != nil {
+		// Check everything that would stop the message from being sent before uploading anything.
+		if c.Crypto == nil {
+			_ = file.Content.Close()
+			return "", ErrEncryptionNotEnabled
+		}
+		if file.hasPlaintextThumbnail() {
+			_ = file.Content.Close()
+			return "", ErrPlaintextThumbnail
+		}
+		encrypted = true
 	}
 
-	var additionalInfo json.RawMessage
-	if file.AudioInfo != nil {
-		additionalInfo, err = json.Marshal(file.AudioInfo)
+	var url matrix.URL
+	var encryptedFile *encrypt.File
+	if encrypted {
+		var r io.Reader
+		r, encryptedFile, err = encrypt.EncryptFile(file.Content)
 		if err != nil {
 			return "", err
 		}
+		// The homeserver should not know what the file is.
+		url, err = c.MediaUploadContext(ctx, "application/octet-stream", "", readCloser{r, file.Content})
+		encryptedFile.URL = url
+		url = ""
+	} else {
+		url, err = c.MediaUploadContext(ctx, file.MIMEType, file.Name, file.Content)
 	}
-	if file.FileInfo != nil {
-		additionalInfo, err = json.Marshal(file.FileInfo)
-		if err != nil {
-			return "", err
-		}
+	if err != nil {
+		return "", err
 	}
-	if file.ImageInfo != nil {
-		additionalInfo, err = json.Marshal(file.ImageInfo)
-		if err != nil {
-			return "", err
-		}
+
+	if file.Caption == "" {
+		file.Caption = file.Name
 	}
-	if file.VideoInfo != nil {
-		additionalInfo, err = json.Marshal(file.VideoInfo)
-		if err != nil {
-			return "", err
-		}
+
+	additionalInfo, err := file.additionalInfo()
+	if err != nil {
+		return "", err
 	}
 
-	return c.RoomEventSend(roomID, event.TypeRoomMessage, event.RoomMessageEvent{
+	return c.RoomEventSendContext(ctx, roomID, event.TypeRoomMessage, event.RoomMessageEvent{
 		MessageType:    msgType,
 		Body:           file.Caption,
 		URL:            url,
+		File:           encryptedFile,
 		AdditionalInfo: additionalInfo,
 	})
 }
+
+// additionalInfo returns the info of the file to be put in the message.
+func (f File) additionalInfo() (json.RawMessage, error) {
+	switch {
+	case f.VideoInfo != nil:
+		return json.Marshal(f.VideoInfo)
+	case f.ImageInfo != nil:
+		return json.Marshal(f.ImageInfo)
+	case f.FileInfo != nil:
+		return json.Marshal(f.FileInfo)
+	case f.AudioInfo != nil:
+		return json.Marshal(f.AudioInfo)
+	}
+	return nil, nil
+}
+
+// hasPlaintextThumbnail returns true if the info sent with the file has a thumbnail that is not encrypted.
+func (f File) hasPlaintextThumbnail() bool {
+	switch {
+	case f.VideoInfo != nil:
+		return f.VideoInfo.ThumbnailURL != ""
+	case f.ImageInfo != nil:
+		return f.ImageInfo.ThumbnailURL != ""
+	case f.FileInfo != nil:
+		return f.FileInfo.ThumbnailURL != ""
+	}
+	return false
+}
+
+// readCloser reads from Reader and closes Closer.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
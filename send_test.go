@@ -0,0 +1,172 @@
+package gotrix
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/chanbakjsd/gotrix/encrypt"
+	"github.com/chanbakjsd/gotrix/encrypt/e2ee"
+	"github.com/chanbakjsd/gotrix/event"
+)
+
+// encryptedRoomServer is a homeserver with an encrypted room whose only member is the client.
+type encryptedRoomServer struct {
+	mu       sync.Mutex
+	uploads  [][]byte
+	sent     []json.RawMessage
+	sentType []string
+}
+
+func (s *encryptedRoomServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := r.URL.Path
+	switch {
+	case strings.HasSuffix(path, "/state/m.room.encryption/"):
+		writeJSON(w, http.StatusOK, map[string]string{"algorithm": e2ee.AlgorithmMegolm})
+	case strings.HasSuffix(path, "/members"):
+		writeJSON(w, http.StatusOK, map[string]interface{}{"chunk": []interface{}{}})
+	case strings.HasSuffix(path, "/upload"):
+		body, _ := ioutil.ReadAll(r.Body)
+		s.uploads = append(s.uploads, body)
+		writeJSON(w, http.StatusOK, map[string]string{"content_uri": "mxc://example.org/media"})
+	case strings.Contains(path, "/send/"):
+		body, _ := ioutil.ReadAll(r.Body)
+		parts := strings.Split(path, "/")
+		s.sent = append(s.sent, body)
+		s.sentType = append(s.sentType, parts[len(parts)-2])
+		writeJSON(w, http.StatusOK, map[string]string{"event_id": "$event"})
+	default:
+		writeJSON(w, http.StatusNotFound, notFound)
+	}
+}
+
+func TestSendFileEncryptedRoom(t *testing.T) {
+	srv := &encryptedRoomServer{}
+	c := newTestClient(t, srv.ServeHTTP)
+	machine, err := e2ee.NewMachine(c.Client, e2ee.NewMemoryStore())
+	if err != nil {
+		t.Fatalf("unexpected error creating machine: %v", err)
+	}
+	c.Crypto = machine
+
+	plaintext := []byte("this should never reach the homeserver unencrypted")
+	_, err = c.SendFile(testRoomID, File{
+		Name:     "secret.txt",
+		MIMEType: "text/plain",
+		Content:  ioutil.NopCloser(bytes.NewReader(plaintext)),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error sending file: %v", err)
+	}
+
+	if len(srv.uploads) != 1 || len(srv.sent) != 1 {
+		t.Fatalf("expected 1 upload and 1 event, got %d and %d", len(srv.uploads), len(srv.sent))
+	}
+	if bytes.Contains(srv.uploads[0], plaintext) {
+		t.Errorf("uploaded content is not encrypted")
+	}
+	if srv.sentType[0] != string(event.TypeRoomEncrypted) {
+		t.Fatalf("expected event to be sent as %s, got %s", event.TypeRoomEncrypted, srv.sentType[0])
+	}
+
+	raw, err := json.Marshal(map[string]interface{}{
+		"type":     event.TypeRoomEncrypted,
+		"event_id": "$event",
+		"room_id":  testRoomID,
+		"sender":   c.UserID,
+		"content":  srv.sent[0],
+	})
+	if err != nil {
+		t.Fatalf("unexpected error encoding event: %v", err)
+	}
+	parsed, err := event.Parse(raw)
+	if err != nil {
+		t.Fatalf("unexpected error parsing event: %v", err)
+	}
+	encrypted, ok := parsed.(*event.RoomEncryptedEvent)
+	if !ok {
+		t.Fatalf("expected *event.RoomEncryptedEvent, got %T", parsed)
+	}
+	e, err := c.Crypto.DecryptRoomEvent(encrypted)
+	if err != nil {
+		t.Fatalf("unexpected error decrypting event: %v", err)
+	}
+	msg, ok := e.(*event.RoomMessageEvent)
+	if !ok {
+		t.Fatalf("expected *event.RoomMessageEvent, got %T", e)
+	}
+	if msg.URL != "" || msg.File == nil {
+		t.Fatalf("expected file to be sent encrypted, got URL %q and file %v", msg.URL, msg.File)
+	}
+	if msg.File.URL != "mxc://example.org/media" {
+		t.Errorf("unexpected file URL %q", msg.File.URL)
+	}
+
+	r, err := encrypt.DecryptFile(msg.File, bytes.NewReader(srv.uploads[0]))
+	if err != nil {
+		t.Fatalf("unexpected error decrypting file: %v", err)
+	}
+	decrypted, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("unexpected error decrypting file: %v", err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("expected %q, got %q", plaintext, decrypted)
+	}
+}
+
+func TestSendFileEncryptedRoomRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		crypto  bool
+		info    *event.ImageInfo
+		wantErr error
+	}{
+		{
+			name:    "encryption not enabled",
+			wantErr: ErrEncryptionNotEnabled,
+		},
+		{
+			name:   "plaintext thumbnail",
+			crypto: true,
+			info: &event.ImageInfo{
+				FileInfo: event.FileInfo{ThumbnailURL: "mxc://example.org/thumbnail"},
+			},
+			wantErr: ErrPlaintextThumbnail,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv := &encryptedRoomServer{}
+			c := newTestClient(t, srv.ServeHTTP)
+			if test.crypto {
+				machine, err := e2ee.NewMachine(c.Client, e2ee.NewMemoryStore())
+				if err != nil {
+					t.Fatalf("unexpected error creating machine: %v", err)
+				}
+				c.Crypto = machine
+			}
+
+			_, err := c.SendImage(testRoomID, File{
+				Name:      "image.png",
+				Content:   ioutil.NopCloser(strings.NewReader("image")),
+				ImageInfo: test.info,
+			})
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("expected %v, got %v", test.wantErr, err)
+			}
+			if len(srv.uploads) != 0 || len(srv.sent) != 0 {
+				t.Errorf("expected nothing to be uploaded or sent, got %d uploads and %d events",
+					len(srv.uploads), len(srv.sent))
+			}
+		})
+	}
+}
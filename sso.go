@@ -1,6 +1,7 @@
 package gotrix
 
 import (
+	"context"
 	// Embedding success HTML to be displayed to the user.
 	_ "embed"
 	"net"
@@ -36,6 +37,12 @@ func handleSSOResult(src string, success chan string) http.HandlerFunc {
 // The returned function blocks until the login finishes or is canceled and returns an error if the login was
 // unsuccessful.
 func (c *Client) LoginSSO() (string, func() error, error) {
+	return c.LoginSSOContext(c.Context())
+}
+
+// LoginSSOContext is the same as LoginSSO but the HTTP server is cleaned up when the provided context expires
+// instead.
+func (c *Client) LoginSSOContext(ctx context.Context) (string, func() error, error) {
 	// Manually create a listener so we know what the port is.
 	listener, err := net.Listen("tcp", ":0")
 	if err != nil {
@@ -59,18 +66,14 @@ func (c *Client) LoginSSO() (string, func() error, error) {
 		defer srv.Close()
 
 		var token string
-		if c.ctx != nil {
-			select {
-			case <-c.ctx.Done():
-				errChannel <- c.ctx.Err()
-				return
-			case token = <-success:
-			}
-		} else {
-			token = <-success
+		select {
+		case <-ctx.Done():
+			errChannel <- ctx.Err()
+			return
+		case token = <-success:
 		}
 
-		errChannel <- c.LoginToken(token)
+		errChannel <- c.LoginTokenContext(ctx, token)
 	}()
 
 	listenResult := func() error {
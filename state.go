@@ -1,6 +1,7 @@
 package gotrix
 
 import (
+	"context"
 	"errors"
 
 	"github.com/chanbakjsd/gotrix/api"
@@ -40,12 +41,23 @@ type State interface {
 // RoomState queries the internal State for the given RoomEvent.
 // If the State does not have that event, it queries the homeserver directly.
 func (c *Client) RoomState(roomID matrix.RoomID, eventType event.Type, key string) (event.StateEvent, error) {
+	return c.RoomStateContext(c.Context(), roomID, eventType, key)
+}
+
+// RoomStateContext is the same as RoomState but takes a context.
+func (c *Client) RoomStateContext(ctx context.Context, roomID matrix.RoomID, eventType event.Type,
+	key string) (event.StateEvent, error) {
 	e, err := c.State.RoomState(roomID, eventType, key)
 	if err == nil {
 		return e, nil
 	}
+	return c.roomStateFromServer(ctx, roomID, eventType, key)
+}
 
-	raw, err := c.Client.RoomState(roomID, eventType, key)
+// roomStateFromServer queries the homeserver for the given state event, bypassing the State.
+func (c *Client) roomStateFromServer(ctx context.Context, roomID matrix.RoomID, eventType event.Type,
+	key string) (event.StateEvent, error) {
+	raw, err := c.Client.RoomStateContext(ctx, roomID, eventType, key)
 	if err != nil {
 		return nil, err
 	}
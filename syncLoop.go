@@ -3,11 +3,11 @@ package gotrix
 import (
 	"context"
 	"errors"
-	"fmt"
 	"time"
 
 	"github.com/chanbakjsd/gotrix/api"
 	"github.com/chanbakjsd/gotrix/debug"
+	"github.com/chanbakjsd/gotrix/encrypt/e2ee"
 	"github.com/chanbakjsd/gotrix/event"
 	"github.com/chanbakjsd/gotrix/matrix"
 )
@@ -52,9 +52,14 @@ func (c *Client) Next() string {
 	}
 }
 
-// Open starts the event loop of the client with a background context.
+// Open starts the event loop of the client with the context of the client.
 func (c *Client) Open() error {
-	return c.OpenWithNext("")
+	return c.OpenContext(c.Context())
+}
+
+// OpenContext is the same as Open but takes a context. The event loop stops when the context is cancelled.
+func (c *Client) OpenContext(ctx context.Context) error {
+	return c.OpenWithNextContext(ctx, "")
 }
 
 // syncOpts is the internal copy of the sync states.
@@ -67,13 +72,22 @@ type syncOpts struct {
 // OpenWithNext starts the event loop with the given next string that resumes the sync loop.
 // If next is empty, then an initial sync will be done.
 func (c *Client) OpenWithNext(next string) error {
-	ctx, cancel := context.WithCancel(context.Background())
+	return c.OpenWithNextContext(c.Context(), next)
+}
+
+// OpenWithNextContext is the same as OpenWithNext but takes a context. The event loop stops when the context
+// is cancelled.
+func (c *Client) OpenWithNextContext(ctx context.Context, next string) error {
+	ctx, cancel := context.WithCancel(ctx)
 
 	c.closeDone = make(chan struct{})
 	c.cancelFunc = cancel
 
-	filterID, err := c.FilterAdd(c.SyncOpts.Filter)
+	filterID, err := c.FilterAddContext(ctx, c.SyncOpts.Filter)
 	if err != nil {
+		// Let Close return immediately as the event loop is never started.
+		cancel()
+		close(c.closeDone)
 		return err
 	}
 
@@ -94,21 +108,30 @@ func (c *Client) Close() error {
 	return nil
 }
 
-func (c *Client) handleWithRoomID(e []event.RawEvent, roomID matrix.RoomID, isHistorical bool) {
+func (c *Client) handleWithRoomID(ctx context.Context, e []event.RawEvent, roomID matrix.RoomID,
+	isHistorical bool) {
+	log := c.Logger.With(debug.UserID(c.UserID))
+	if roomID != "" {
+		log = log.With(debug.RoomID(roomID))
+	}
+
 	for _, v := range e {
 		v := v
 		concrete, err := event.Parse(v)
 		if w, ok := concrete.(event.RoomEvent); ok {
 			w.RoomInfo().RoomID = roomID
 		}
+		if encrypted, ok := concrete.(*event.RoomEncryptedEvent); ok {
+			concrete = c.decrypt(log, encrypted, isHistorical)
+		}
 
 		var unknownErr event.UnknownEventTypeError
 		// Print out warnings.
 		switch {
 		case errors.As(err, &unknownErr):
-			debug.Warn(fmt.Sprintf("unknown event type: %s", unknownErr.Found))
+			log.Warn("unknown event type", debug.Any("type", unknownErr.Found), eventIDField(v))
 		case err != nil:
-			debug.Warn(fmt.Errorf("error unmarshalling content: %w", err))
+			log.Warn("error unmarshalling content", debug.Err(err), eventIDField(v))
 		}
 
 		// Don't call handlers on historical events.
@@ -120,20 +143,60 @@ func (c *Client) handleWithRoomID(e []event.RawEvent, roomID matrix.RoomID, isHi
 		if err != nil {
 			continue
 		}
+		if c.Crypto != nil {
+			if err := c.Crypto.HandleEvent(ctx, concrete); err != nil {
+				log.Warn("error handling encryption event", debug.Err(err), eventIDField(v))
+			}
+		}
 		c.Handler.Handle(c, concrete)
 	}
 }
 
+// decrypt decrypts the event if encryption is enabled, returning an event.UndecryptableEvent if it fails.
+// Olm encrypted to-device events are always decrypted as they may contain room keys, but historical room events
+// are left encrypted.
+func (c *Client) decrypt(log *debug.StructuredLogger, e *event.RoomEncryptedEvent, isHistorical bool) event.Event {
+	if c.Crypto == nil {
+		return e
+	}
+
+	var (
+		decrypted event.Event
+		err       error
+	)
+	switch {
+	case e.Algorithm == e2ee.AlgorithmOlm:
+		decrypted, err = c.Crypto.DecryptToDeviceEvent(e)
+	case isHistorical:
+		return e
+	default:
+		decrypted, err = c.Crypto.DecryptRoomEvent(e)
+	}
+	if err != nil {
+		log.Warn("error decrypting event", debug.Err(err), debug.EventID(e.ID), debug.Any("sender", e.Sender))
+		return &event.UndecryptableEvent{
+			RoomEncryptedEvent: *e,
+			Err:                err,
+		}
+	}
+	return decrypted
+}
+
+// eventIDField returns a log field containing the ID of the raw event, if it has one.
+func eventIDField(raw event.RawEvent) debug.Field {
+	partial, err := event.ParsePartial(raw)
+	if err != nil {
+		return debug.EventID("")
+	}
+	return debug.EventID(partial.ID)
+}
+
 func (c *Client) readLoop(ctx context.Context, opts syncOpts) {
-	client := c.WithContext(ctx)
+	log := c.Logger.With(debug.UserID(c.UserID))
 
 	timeout := int(opts.Timeout / time.Millisecond)
 	next := opts.next
 
-	handle := func(e []event.RawEvent) {
-		c.handleWithRoomID(e, "", next == "")
-	}
-
 	var nextRetryTime time.Duration
 
 	timer := time.NewTimer(0)
@@ -145,8 +208,8 @@ func (c *Client) readLoop(ctx context.Context, opts syncOpts) {
 
 	for {
 		// Fetch next set of events.
-		debug.Debug("Fetching new events. Next: " + next)
-		resp, err := client.Sync(api.SyncArg{
+		log.Debug("fetching new events", debug.Any("next", next))
+		resp, err := c.SyncContext(ctx, api.SyncArg{
 			Filter:  opts.filterID,
 			Since:   next,
 			Timeout: timeout,
@@ -165,7 +228,7 @@ func (c *Client) readLoop(ctx context.Context, opts syncOpts) {
 				nextRetryTime = opts.MaxBackoffTime
 			}
 
-			debug.Error(fmt.Errorf("error in event loop (retrying in %s): %w", nextRetryTime, err))
+			log.Error("error in event loop", debug.Err(err), debug.Any("retry_in", nextRetryTime))
 			timer.Reset(nextRetryTime)
 			select {
 			case <-timer.C:
@@ -176,29 +239,42 @@ func (c *Client) readLoop(ctx context.Context, opts syncOpts) {
 		}
 
 		if err := c.State.AddEvents(resp); err != nil {
-			debug.Debug(fmt.Errorf("error adding sync events to state: %w", err))
+			log.Debug("error adding sync events to state", debug.Err(err))
+		}
+		if c.Crypto != nil {
+			if err := c.Crypto.ProcessSync(ctx, next, resp); err != nil {
+				log.Error("error processing sync for encryption", debug.Err(err))
+			}
 		}
 
-		handle(resp.Presence.Events)
-		handle(resp.AccountData.Events)
-		handle(resp.ToDevice.Events)
+		c.handleWithRoomID(ctx, resp.Presence.Events, "", next == "")
+		c.handleWithRoomID(ctx, resp.AccountData.Events, "", next == "")
+		// To-device events are never sent again so they are not historical even in the initial sync.
+		c.handleWithRoomID(ctx, resp.ToDevice.Events, "", false)
 		for k, v := range resp.Rooms.Joined {
-			c.handleWithRoomID(v.State.Events, k, next == "")
-			c.handleWithRoomID(v.Timeline.Events, k, next == "")
-			c.handleWithRoomID(v.Ephemeral.Events, k, next == "")
-			c.handleWithRoomID(v.AccountData.Events, k, next == "")
+			c.handleWithRoomID(ctx, v.State.Events, k, next == "")
+			c.handleWithRoomID(ctx, v.Timeline.Events, k, next == "")
+			c.handleWithRoomID(ctx, v.Ephemeral.Events, k, next == "")
+			c.handleWithRoomID(ctx, v.AccountData.Events, k, next == "")
 		}
 		for k, v := range resp.Rooms.Invited {
 			events := make([]event.RawEvent, len(v.State.Events))
 			for k, v := range v.State.Events {
 				events[k] = event.RawEvent(v)
 			}
-			c.handleWithRoomID(events, k, next == "")
+			c.handleWithRoomID(ctx, events, k, next == "")
 		}
 		for k, v := range resp.Rooms.Left {
-			c.handleWithRoomID(v.State.Events, k, next == "")
-			c.handleWithRoomID(v.Timeline.Events, k, next == "")
-			c.handleWithRoomID(v.AccountData.Events, k, next == "")
+			c.handleWithRoomID(ctx, v.State.Events, k, next == "")
+			c.handleWithRoomID(ctx, v.Timeline.Events, k, next == "")
+			c.handleWithRoomID(ctx, v.AccountData.Events, k, next == "")
+		}
+
+		// Room keys received during this sync are uploaded to the key backup once they have been handled.
+		if c.Crypto != nil {
+			if err := c.Crypto.BackupRoomKeys(ctx); err != nil {
+				log.Warn("error backing up room keys", debug.Err(err))
+			}
 		}
 
 		next = resp.NextBatch
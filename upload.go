@@ -0,0 +1,400 @@
+package gotrix
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	// Registering GIF decoder for PrepareImage.
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/chanbakjsd/gotrix/debug"
+	"github.com/chanbakjsd/gotrix/encrypt"
+	"github.com/chanbakjsd/gotrix/event"
+	"github.com/chanbakjsd/gotrix/matrix"
+	"github.com/chanbakjsd/gotrix/media"
+)
+
+// ErrFileTooLarge is returned when a file is larger than the upload size limit of the homeserver.
+var ErrFileTooLarge = errors.New("file is larger than the upload size limit of the homeserver")
+
+// ErrAsyncUploadEncrypted is returned by SendFileAsync when the room is encrypted. The hash of an encrypted file
+// is only known once all of it has been read, so the message cannot be sent before the upload.
+var ErrAsyncUploadEncrypted = errors.New("asynchronous uploads cannot be sent to encrypted rooms")
+
+// ThumbnailWidth and ThumbnailHeight are the maximum size of thumbnails generated by PrepareImage.
+var (
+	ThumbnailWidth  = 800
+	ThumbnailHeight = 600
+)
+
+// PrepareImage reads the image in file and fills in its ImageInfo with the MIME type, size, dimensions and
+// BlurHash of the image. If the image is larger than ThumbnailWidth x ThumbnailHeight, a thumbnail is
+// generated and uploaded as well. If encrypted is true, the thumbnail is encrypted before being uploaded and
+// the returned file should be sent using SendEncryptedImage. Sending an image with an unencrypted thumbnail to an
+// encrypted room fails with ErrPlaintextThumbnail. PrepareRoomImage can be used to pick based on the room.
+//
+// ErrFileTooLarge is returned if the image is larger than the upload size limit of the homeserver.
+// Only formats with a decoder registered in the image package (JPEG, PNG and GIF by default) are supported.
+func (c *Client) PrepareImage(file File, encrypted bool) (File, error) {
+	return c.PrepareImageContext(c.Context(), file, encrypted)
+}
+
+// PrepareImageContext is the same as PrepareImage but takes a context.
+func (c *Client) PrepareImageContext(ctx context.Context, file File, encrypted bool) (File, error) {
+	data, err := ioutil.ReadAll(file.Content)
+	_ = file.Content.Close()
+	if err != nil {
+		return file, fmt.Errorf("error reading image: %w", err)
+	}
+	file.Content = ioutil.NopCloser(bytes.NewReader(data))
+
+	if err := c.checkUploadSize(ctx, len(data)); err != nil {
+		return file, err
+	}
+
+	if file.MIMEType == "" || file.MIMEType == "application/octet-stream" {
+		file.MIMEType = http.DetectContentType(data)
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return file, fmt.Errorf("error decoding image: %w", err)
+	}
+
+	bounds := img.Bounds()
+	info := &event.ImageInfo{
+		FileInfo: event.FileInfo{
+			MimeType: file.MIMEType,
+			Size:     len(data),
+		},
+		Width:  bounds.Dx(),
+		Height: bounds.Dy(),
+	}
+
+	info.BlurHash, err = media.BlurHash(img, 4, 3)
+	if err != nil {
+		return file, fmt.Errorf("error computing blurhash: %w", err)
+	}
+
+	if info.Width > ThumbnailWidth || info.Height > ThumbnailHeight {
+		if err := c.uploadThumbnail(ctx, img, format, encrypted, &info.FileInfo); err != nil {
+			return file, err
+		}
+	}
+
+	file.ImageInfo = info
+	return file, nil
+}
+
+// PrepareRoomImage is the same as PrepareImage but encrypts the thumbnail if the room is encrypted.
+func (c *Client) PrepareRoomImage(roomID matrix.RoomID, file File) (File, error) {
+	return c.PrepareRoomImageContext(c.Context(), roomID, file)
+}
+
+// PrepareRoomImageContext is the same as PrepareRoomImage but takes a context.
+func (c *Client) PrepareRoomImageContext(ctx context.Context, roomID matrix.RoomID, file File) (File, error) {
+	settings, err := c.RoomEncryptionContext(ctx, roomID)
+	if err != nil {
+		_ = file.Content.Close()
+		return file, err
+	}
+	return c.PrepareImageContext(ctx, file, settings != nil)
+}
+
+// uploadThumbnail generates and uploads a thumbnail of img, filling in the thumbnail fields of info.
+func (c *Client) uploadThumbnail(ctx context.Context, img image.Image, format string, encrypted bool,
+	info *event.FileInfo) error {
+	thumbnail := media.Thumbnail(img, ThumbnailWidth, ThumbnailHeight)
+
+	// Keep transparency unless the original is a JPEG.
+	var buf bytes.Buffer
+	mimeType := "image/png"
+	if format == "jpeg" {
+		mimeType = "image/jpeg"
+		if err := jpeg.Encode(&buf, thumbnail, nil); err != nil {
+			return fmt.Errorf("error encoding thumbnail: %w", err)
+		}
+	} else if err := png.Encode(&buf, thumbnail); err != nil {
+		return fmt.Errorf("error encoding thumbnail: %w", err)
+	}
+
+	info.ThumbnailInfo = event.ThumbnailInfo{
+		Width:    thumbnail.Rect.Dx(),
+		Height:   thumbnail.Rect.Dy(),
+		MimeType: mimeType,
+		Size:     buf.Len(),
+	}
+
+	if !encrypted {
+		url, err := c.MediaUploadContext(ctx, mimeType, "thumbnail", ioutil.NopCloser(&buf))
+		if err != nil {
+			return fmt.Errorf("error uploading thumbnail: %w", err)
+		}
+		info.ThumbnailURL = url
+		return nil
+	}
+
+	r, file, err := encrypt.EncryptFile(&buf)
+	if err != nil {
+		return fmt.Errorf("error encrypting thumbnail: %w", err)
+	}
+	url, err := c.MediaUploadContext(ctx, "application/octet-stream", "", ioutil.NopCloser(r))
+	if err != nil {
+		return fmt.Errorf("error uploading thumbnail: %w", err)
+	}
+	file.URL = url
+	info.ThumbnailFile = file
+	return nil
+}
+
+// checkUploadSize returns ErrFileTooLarge if size is larger than the upload size limit of the homeserver.
+// The check is skipped if the homeserver does not report a limit or the limit cannot be fetched.
+func (c *Client) checkUploadSize(ctx context.Context, size int) error {
+	config, err := c.MediaConfigContext(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// The homeserver rejects the upload itself if it is too large.
+		c.Logger.Warn("error fetching media config, skipping upload size check", debug.Err(err))
+		return nil
+	}
+	if config.UploadSize > 0 && size > config.UploadSize {
+		return fmt.Errorf("%w (%d > %d bytes)", ErrFileTooLarge, size, config.UploadSize)
+	}
+	return nil
+}
+
+// UploadOptions are the options of MediaUploadAsync.
+type UploadOptions struct {
+	ContentType string
+	FileName    string
+	// Size is the size of the content in bytes. It is passed to OnProgress and may be 0 if it is unknown.
+	Size int64
+	// OnProgress is called with the number of bytes uploaded so far every time more of the content is read.
+	// It is called from the goroutine doing the upload.
+	OnProgress func(sent, total int64)
+	// Retries is the number of times the upload is retried after a network error or a server error.
+	Retries int
+	// RetryBackoff is the time to wait before the first retry. It is doubled after each retry.
+	// One second is used if it is zero.
+	RetryBackoff time.Duration
+}
+
+// AsyncUpload is a media upload happening in the background.
+type AsyncUpload struct {
+	// URL is the MXC URI the content will be available at once the upload finishes.
+	URL matrix.URL
+
+	done  chan struct{}
+	err   error
+	sent  int64 // Accessed atomically.
+	total int64
+}
+
+// Done returns a channel that is closed when the upload finishes.
+func (u *AsyncUpload) Done() <-chan struct{} {
+	return u.done
+}
+
+// Wait blocks until the upload finishes and returns the error that caused the upload to fail, if any.
+func (u *AsyncUpload) Wait() error {
+	<-u.done
+	return u.err
+}
+
+// Progress returns the number of bytes uploaded so far and UploadOptions.Size.
+func (u *AsyncUpload) Progress() (sent, total int64) {
+	return atomic.LoadInt64(&u.sent), u.total
+}
+
+// MediaUploadAsync creates a MXC URI and uploads the content to it in the background. The returned
+// AsyncUpload contains the URI so that it can be used right away, such as to send a message containing it
+// while the content is still being uploaded.
+//
+// The content is seeked back to the start before each retry. If a previous attempt turns out to have
+// succeeded, the upload is considered successful.
+func (c *Client) MediaUploadAsync(content io.ReadSeeker, opts UploadOptions) (*AsyncUpload, error) {
+	return c.MediaUploadAsyncContext(c.Context(), content, opts)
+}
+
+// MediaUploadAsyncContext is the same as MediaUploadAsync but takes a context. The upload is cancelled when
+// the context is cancelled.
+func (c *Client) MediaUploadAsyncContext(ctx context.Context, content io.ReadSeeker,
+	opts UploadOptions) (*AsyncUpload, error) {
+	created, err := c.MediaCreateContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	upload := &AsyncUpload{
+		URL:   created.ContentURI,
+		done:  make(chan struct{}),
+		total: opts.Size,
+	}
+	go func() {
+		defer close(upload.done)
+		upload.err = c.uploadWithRetry(ctx, upload, content, opts)
+	}()
+	return upload, nil
+}
+
+// uploadWithRetry uploads the content to upload.URL, retrying as specified in opts.
+func (c *Client) uploadWithRetry(ctx context.Context, upload *AsyncUpload, content io.ReadSeeker,
+	opts UploadOptions) error {
+	log := c.Logger.With(debug.Any("url", upload.URL))
+	backoff := opts.RetryBackoff
+	if backoff == 0 {
+		backoff = time.Second
+	}
+
+	for attempt := 0; ; attempt++ {
+		if _, err := content.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("error rewinding content: %w", err)
+		}
+		atomic.StoreInt64(&upload.sent, 0)
+
+		body := &progressReader{
+			r: content,
+			onRead: func(n int) {
+				sent := atomic.AddInt64(&upload.sent, int64(n))
+				if opts.OnProgress != nil {
+					opts.OnProgress(sent, opts.Size)
+				}
+			},
+		}
+		err := c.MediaUploadToContext(ctx, upload.URL, opts.ContentType, opts.FileName, ioutil.NopCloser(body))
+		switch {
+		case err == nil:
+			return nil
+		case attempt > 0 && errors.Is(err, matrix.CodeCannotOverwriteMedia):
+			// An earlier attempt succeeded even though we did not get the response.
+			return nil
+		case attempt >= opts.Retries || ctx.Err() != nil || !isRetryableUploadError(err):
+			return err
+		}
+
+		log.Warn("error uploading media, retrying", debug.Err(err), debug.Any("retry_in", backoff))
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+// isRetryableUploadError returns true if the error is a network error or a server error.
+func isRetryableUploadError(err error) bool {
+	code := matrix.StatusCode(err)
+	return code == -1 || code >= http.StatusInternalServerError
+}
+
+// progressReader calls onRead with the number of bytes read on every read.
+type progressReader struct {
+	r      io.Reader
+	onRead func(n int)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.onRead(n)
+	}
+	return n, err
+}
+
+// SendFileAsync sends a message containing the provided file to the designated room before uploading it.
+// The file is uploaded in the background using MediaUploadAsync with the MIME type and the name of the file.
+// Clients that see the message before the upload finishes will get M_NOT_YET_UPLOADED when downloading it.
+//
+// file.Content must implement io.Seeker for the upload to be retried.
+//
+// ErrAsyncUploadEncrypted is returned if the room is encrypted as the file would be uploaded unencrypted.
+// SendEncryptedFile should be used instead.
+func (c *Client) SendFileAsync(roomID matrix.RoomID, msgType event.MessageType, file File,
+	opts UploadOptions) (matrix.EventID, *AsyncUpload, error) {
+	return c.SendFileAsyncContext(c.Context(), roomID, msgType, file, opts)
+}
+
+// SendFileAsyncContext is the same as SendFileAsync but takes a context. The upload is cancelled when the
+// context is cancelled.
+func (c *Client) SendFileAsyncContext(ctx context.Context, roomID matrix.RoomID, msgType event.MessageType,
+	file File, opts UploadOptions) (matrix.EventID, *AsyncUpload, error) {
+	settings, err := c.RoomEncryptionContext(ctx, roomID)
+	if err != nil {
+		_ = file.Content.Close()
+		return "", nil, err
+	}
+	if settings != nil {
+		_ = file.Content.Close()
+		return "", nil, ErrAsyncUploadEncrypted
+	}
+
+	content, ok := file.Content.(io.ReadSeeker)
+	if !ok {
+		content = &nonSeekableReader{Reader: file.Content}
+		opts.Retries = 0
+	}
+	if opts.ContentType == "" {
+		opts.ContentType = file.MIMEType
+	}
+	if opts.FileName == "" {
+		opts.FileName = file.Name
+	}
+
+	upload, err := c.MediaUploadAsyncContext(ctx, content, opts)
+	if err != nil {
+		_ = file.Content.Close()
+		return "", nil, err
+	}
+	go func() {
+		<-upload.Done()
+		_ = file.Content.Close()
+	}()
+
+	info, err := file.additionalInfo()
+	if err != nil {
+		return "", upload, err
+	}
+	if file.Caption == "" {
+		file.Caption = file.Name
+	}
+
+	id, err := c.RoomEventSendContext(ctx, roomID, event.TypeRoomMessage, event.RoomMessageEvent{
+		MessageType:    msgType,
+		Body:           file.Caption,
+		URL:            upload.URL,
+		AdditionalInfo: info,
+	})
+	return id, upload, err
+}
+
+// nonSeekableReader is a reader that can only be "seeked" to the start before it is read.
+type nonSeekableReader struct {
+	io.Reader
+	read bool
+}
+
+func (n *nonSeekableReader) Read(b []byte) (int, error) {
+	n.read = true
+	return n.Reader.Read(b)
+}
+
+func (n *nonSeekableReader) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekStart || n.read {
+		return 0, errors.New("content cannot be seeked")
+	}
+	return 0, nil
+}
@@ -0,0 +1,170 @@
+package gotrix
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCheckUploadSize(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		response interface{}
+		size     int
+		wantErr  error
+	}{
+		{
+			name:     "within limit",
+			status:   http.StatusOK,
+			response: map[string]int{"m.upload.size": 10},
+			size:     10,
+		},
+		{
+			name:     "over limit",
+			status:   http.StatusOK,
+			response: map[string]int{"m.upload.size": 10},
+			size:     11,
+			wantErr:  ErrFileTooLarge,
+		},
+		{
+			name:     "no limit",
+			status:   http.StatusOK,
+			response: map[string]int{},
+			size:     11,
+		},
+		{
+			name:     "config not supported",
+			status:   http.StatusNotFound,
+			response: notFound,
+			size:     11,
+		},
+		{
+			name:     "server error",
+			status:   http.StatusInternalServerError,
+			response: map[string]string{"errcode": "M_UNKNOWN", "error": "unknown"},
+			size:     11,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				if !strings.HasSuffix(r.URL.Path, "/config") {
+					writeJSON(w, http.StatusNotFound, notFound)
+					return
+				}
+				writeJSON(w, test.status, test.response)
+			})
+
+			err := c.checkUploadSize(context.Background(), test.size)
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("expected %v, got %v", test.wantErr, err)
+			}
+		})
+	}
+
+	t.Run("cancelled", func(t *testing.T) {
+		c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, map[string]int{"m.upload.size": 10})
+		})
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if err := c.checkUploadSize(ctx, 1); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected %v, got %v", context.Canceled, err)
+		}
+	})
+}
+
+func TestMediaUploadAsyncRetry(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 16*1024)
+
+	var mu sync.Mutex
+	var bodies [][]byte
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/create"):
+			writeJSON(w, http.StatusOK, map[string]string{"content_uri": "mxc://example.org/media"})
+		case strings.HasSuffix(r.URL.Path, "/upload/example.org/media"):
+			body, _ := ioutil.ReadAll(r.Body)
+			mu.Lock()
+			bodies = append(bodies, body)
+			attempt := len(bodies)
+			mu.Unlock()
+			if attempt == 1 {
+				// The content is received but the response is lost.
+				writeJSON(w, http.StatusBadGateway, map[string]string{"errcode": "M_UNKNOWN", "error": "bad gateway"})
+				return
+			}
+			writeJSON(w, http.StatusConflict, map[string]string{
+				"errcode": "M_CANNOT_OVERWRITE_MEDIA", "error": "media already uploaded",
+			})
+		default:
+			writeJSON(w, http.StatusNotFound, notFound)
+		}
+	})
+
+	var progress []int64
+	upload, err := c.MediaUploadAsync(bytes.NewReader(content), UploadOptions{
+		ContentType: "application/octet-stream",
+		Size:        int64(len(content)),
+		OnProgress: func(sent, total int64) {
+			mu.Lock()
+			defer mu.Unlock()
+			if total != int64(len(content)) {
+				t.Errorf("expected total %d, got %d", len(content), total)
+			}
+			progress = append(progress, sent)
+		},
+		Retries:      1,
+		RetryBackoff: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error starting upload: %v", err)
+	}
+	if err := upload.Wait(); err != nil {
+		t.Fatalf("expected upload to succeed, got %v", err)
+	}
+	if upload.URL != "mxc://example.org/media" {
+		t.Errorf("unexpected URL %q", upload.URL)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(bodies) != 2 {
+		t.Fatalf("expected 2 upload attempts, got %d", len(bodies))
+	}
+	for i, body := range bodies {
+		if !bytes.Equal(body, content) {
+			t.Errorf("attempt %d: expected the whole content to be uploaded, got %d bytes", i+1, len(body))
+		}
+	}
+
+	// The progress goes up to the size of the content once per attempt, restarting from 0 for the retry.
+	var restarts int
+	for i, sent := range progress {
+		switch {
+		case sent > int64(len(content)):
+			t.Fatalf("progress %d exceeds content size %d", sent, len(content))
+		case i > 0 && sent < progress[i-1]:
+			restarts++
+			if progress[i-1] != int64(len(content)) {
+				t.Errorf("expected first attempt to finish before restarting, got %d", progress[i-1])
+			}
+		}
+	}
+	if restarts != 1 {
+		t.Errorf("expected progress to restart once, got %d restarts: %v", restarts, progress)
+	}
+	if len(progress) == 0 || progress[len(progress)-1] != int64(len(content)) {
+		t.Errorf("expected progress to finish at %d, got %v", len(content), progress)
+	}
+	if sent, _ := upload.Progress(); sent != int64(len(content)) {
+		t.Errorf("expected %d bytes sent, got %d", len(content), sent)
+	}
+}